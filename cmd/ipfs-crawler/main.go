@@ -2,11 +2,21 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
 	"gopkg.in/yaml.v3"
@@ -15,6 +25,10 @@ import (
 
 	// Plugins
 	_ "ipfs-crawler/plugins/bsprobe"
+
+	// Database drivers
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // Config is the configuration for the ipfs-crawler executable.
@@ -22,20 +36,48 @@ type Config struct {
 	// Path to output directory.
 	OutputDirectoryPath string `yaml:"output_directory_path"`
 
+	// Settings for writing output files.
+	Output crawlLib.OutputConfig `yaml:"output"`
+
 	// File where the nodes between crawls are cached (if caching is enabled).
 	CacheFilePath *string `yaml:"cache_file_path"`
 
 	// Settings for the crawler.
 	CrawlOptions crawlLib.CrawlManagerConfig `yaml:"crawler"`
+
+	// Settings for reporting summary statistics to StatsD, if enabled.
+	StatsD *crawlLib.StatsDConfig `yaml:"statsd"`
+
+	// Connection string of a PostgreSQL database to additionally write the
+	// results to, if set, see crawlLib.PostgresSink.
+	PostgresDSN string `yaml:"postgres_dsn"`
 }
 
 func main() {
 	var debug bool
 	var configFilePath string
 	var help bool
+	var singlePeer string
+	var listenAddr string
+	var resume bool
+	var recrawlUnreachable string
+	var seedFrom string
+	var findProviders string
+	var lookup string
+	var networks []string
+	var dryRun bool
 
 	flag.BoolVar(&debug, "debug", false, "enable debug logging")
 	flag.StringVar(&configFilePath, "config", "dist/config_ipfs.yaml", "path to the configuration file")
+	flag.StringSliceVar(&networks, "network", nil, "crawl the given networks configured under networks instead of the default one, concurrently if more than one is given")
+	flag.StringVar(&singlePeer, "single-peer", "", "crawl only the given peer, specified as a multiaddress with a /p2p/ component")
+	flag.BoolVar(&dryRun, "dry-run", false, "only log the peers the crawl would start with and the effective config, without dialing any peer")
+	flag.BoolVar(&resume, "resume", false, "resume the crawl from the configured checkpoint")
+	flag.StringVar(&recrawlUnreachable, "recrawl-unreachable", "", "crawl only the peers which were unreachable in the given output of a previous crawl")
+	flag.StringVar(&seedFrom, "seed-from", "", "additionally seed the crawl with the peers which were reachable in the given output of a previous crawl")
+	flag.StringVar(&findProviders, "find-providers", "", "look up providers of the given CID in the DHT instead of crawling, and print them to stdout")
+	flag.StringVar(&lookup, "lookup", "", "walk the DHT toward the given peer ID or CID instead of crawling, and print the closest peers and all queries to stdout")
+	flag.StringVar(&listenAddr, "listen", "", "run as a service, serving an HTTP API to trigger and monitor crawls on the given address")
 	flag.BoolVar(&help, "help", false, "print usage")
 	flag.Parse()
 
@@ -58,6 +100,27 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(networks) == 1 {
+		config.CrawlOptions, err = config.CrawlOptions.WithNetwork(networks[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.WithField("network", networks[0]).Info("crawling network")
+	}
+	if dryRun {
+		config.CrawlOptions.DryRun = true
+	}
+	opts := cliOptions{
+		networks:      networks,
+		singlePeer:    singlePeer,
+		findProviders: findProviders,
+		lookup:        lookup,
+		crawl:         crawlOptions{resume: resume, recrawlUnreachable: recrawlUnreachable, seedFrom: seedFrom},
+	}
+	err = config.validate(opts)
+	if err != nil {
+		log.Fatal(fmt.Errorf("invalid config: %w", err))
+	}
 
 	// Let's go!
 	log.Info("Thank you for running our IPFS Crawler!")
@@ -78,16 +141,131 @@ func main() {
 	}
 	log.WithField("path", config.OutputDirectoryPath).Info("writing results to")
 
+	// Stop the crawl on SIGINT or SIGTERM, but keep the partial results.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if len(listenAddr) != 0 {
+		log.WithField("address", listenAddr).Info("serving HTTP API")
+		err = serve(ctx, listenAddr, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(findProviders) != 0 {
+		err = lookupProviders(ctx, config, findProviders)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(lookup) != 0 {
+		err = lookupKey(ctx, config, lookup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(singlePeer) != 0 {
+		err = crawlSinglePeer(ctx, config, singlePeer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(networks) > 1 {
+		err = crawlNetworks(ctx, config, networks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.CrawlOptions.CrawlInterval > 0 && !resume && len(recrawlUnreachable) == 0 {
+		err = crawlPeriodically(ctx, config, seedFrom)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err = crawl(ctx, config, opts.crawl)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// cliOptions are the command line options which select what to do.
+type cliOptions struct {
+	// The networks to crawl, see CrawlManagerConfig.WithNetwork.
+	networks []string
+
+	// If not empty, only this peer is crawled.
+	singlePeer string
+
+	// If not empty, providers of this CID are looked up instead of crawling.
+	findProviders string
+
+	// If not empty, the DHT is walked toward this key instead of crawling.
+	lookup string
+
+	// Options for a single crawl.
+	crawl crawlOptions
+}
+
+// crawlOptions modify a single crawl.
+type crawlOptions struct {
+	// Whether to resume the crawl from the configured checkpoint.
+	resume bool
+
+	// If not empty, only the peers which were unreachable in the output of
+	// a previous crawl at this path are crawled.
+	recrawlUnreachable string
+
+	// If not empty, the peers which were reachable in the output of a
+	// previous crawl at this path are crawled, too.
+	seedFrom string
+
+	// If not nil, this is called with the crawl manager right before the
+	// crawl starts.
+	onStart func(*crawlLib.CrawlManager)
+}
+
+// crawl performs a crawl and writes the results.
+// If the context is cancelled, the crawl is stopped and the partial results
+// are written.
+func crawl(ctx context.Context, config *Config, opts crawlOptions) error {
+	managerConfig := config.CrawlOptions
+	var unreachablePeers []peer.AddrInfo
+	if len(opts.recrawlUnreachable) != 0 {
+		var err error
+		unreachablePeers, err = crawlLib.LoadUnreachablePeers(opts.recrawlUnreachable)
+		if err != nil {
+			return fmt.Errorf("unable to load unreachable peers: %w", err)
+		}
+		managerConfig.BootstrapPeers = nil
+		managerConfig.BootstrapPeersFile = ""
+		managerConfig.UseDefaultBootstrapPeers = false
+		managerConfig.DisableExpansion = true
+	}
+
 	// Create crawl manager
-	cm, err := crawlLib.NewCrawlManager(config.CrawlOptions)
+	cm, err := newCrawlManager(ctx, managerConfig)
 	if err != nil {
-		log.Fatal(fmt.Errorf("unable to set up crawler: %w", err))
+		return fmt.Errorf("unable to set up crawler: %w", err)
 	}
 	log.Info("created crawl manager")
 
 	// Add cached nodes if we have them
-	if config.CacheFilePath != nil {
-		cachedNodes, err := crawlLib.RestoreNodeCache(*config.CacheFilePath)
+	cacheFilePath := config.CacheFilePath
+	if len(opts.recrawlUnreachable) != 0 {
+		log.WithField("num", len(unreachablePeers)).Info("re-crawling previously unreachable peers, not using node cache")
+		cm.AddPeersToCrawl(unreachablePeers)
+		cacheFilePath = nil
+	} else if cacheFilePath != nil {
+		cachedNodes, err := crawlLib.RestoreNodeCache(*cacheFilePath)
 		if err != nil {
 			// First time may fail
 			log.WithError(err).Warn("unable to load cached peers, ignoring")
@@ -98,11 +276,47 @@ func main() {
 	} else {
 		log.Info("node caching disabled")
 	}
+	if len(opts.seedFrom) != 0 {
+		seeds, err := crawlLib.LoadSeedPeersFromOutput(opts.seedFrom)
+		if err != nil {
+			_ = cm.Stop()
+			return fmt.Errorf("unable to load seed peers: %w", err)
+		}
+		log.WithField("num", len(seeds)).Info("loaded peers reachable in previous crawl, adding to queue")
+		cm.AddPeersToCrawl(seeds)
+	}
+	if managerConfig.DryRun {
+		// Don't overwrite the node cache with nothing.
+		cacheFilePath = nil
+	}
+
+	// Restore state from checkpoint
+	if opts.resume {
+		err = cm.ResumeFrom(config.CrawlOptions.CheckpointPath)
+		if err != nil {
+			_ = cm.Stop()
+			return fmt.Errorf("unable to resume crawl: %w", err)
+		}
+	}
 
 	// Start the crawl
+	if opts.onStart != nil {
+		opts.onStart(cm)
+	}
 	before := time.Now()
-	beforeString := before.UTC().Format("2006-01-02_15-04-05_UTC")
-	report := cm.CrawlNetwork()
+	report, err := cm.CrawlNetwork(ctx)
+	if errors.Is(err, crawlLib.ErrNoReachablePeers) {
+		// Don't overwrite the node cache with nothing.
+		log.WithError(err).Warn("crawl did not reach any peers")
+		cacheFilePath = nil
+	} else if errors.Is(err, context.Canceled) || errors.Is(err, crawlLib.ErrCrawlStopped) {
+		// Don't overwrite the node cache with partial results.
+		log.WithError(err).Warn("crawl interrupted, writing partial results")
+		cacheFilePath = nil
+	} else if err != nil {
+		_ = cm.Stop()
+		return fmt.Errorf("unable to crawl: %w", err)
+	}
 	after := time.Now()
 
 	// Stop libp2p nodes etc.
@@ -114,26 +328,313 @@ func main() {
 	log.Info("stopped crawl manager")
 
 	// Write output
-	log.Debug("writing node metadata")
-	err = report.WriteMetadata(before, after, path.Join(config.OutputDirectoryPath, fmt.Sprintf("visitedPeers_%s.json", beforeString)))
+	log.Debug("writing results")
+	err = crawlLib.NewFileSink(config.OutputDirectoryPath, config.Output).Write(&report)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	log.Info("wrote results")
+
+	if len(config.PostgresDSN) != 0 {
+		db, sink, err := openPostgresSink(config.PostgresDSN)
+		if err != nil {
+			return err
+		}
+		err = sink.Write(&report)
+		_ = db.Close()
+		if err != nil {
+			return fmt.Errorf("unable to write results to PostgreSQL: %w", err)
+		}
+		log.Info("wrote results to PostgreSQL")
+	}
+
+	// Report statistics
+	if config.StatsD != nil {
+		reporter, err := crawlLib.NewStatsDReporter(*config.StatsD)
+		if err != nil {
+			return fmt.Errorf("unable to set up StatsD reporting: %w", err)
+		}
+		err = reporter.Report(&report, after.Sub(before))
+		if err != nil {
+			log.WithError(err).Warn("unable to report statistics to StatsD")
+		} else {
+			log.WithField("address", config.StatsD.Address).Info("reported statistics to StatsD")
+		}
+		_ = reporter.Close()
+	}
+
+	// Write node cache
+	if cacheFilePath != nil {
+		err = report.SaveNodeCache(*cacheFilePath)
+		if err != nil {
+			return fmt.Errorf("unable to save online nodes to cache: %w", err)
+		}
+		log.WithField("path", *cacheFilePath).Info("saved online nodes to cache")
+	}
+
+	return nil
+}
+
+// newCrawlManager creates a crawl manager, see crawlLib.NewCrawlManager, but
+// gives up once the context is cancelled.
+// Setting up a crawl manager, e.g., creating its workers and loading the
+// preimages, can take a while, during which SIGINT and SIGTERM would otherwise
+// be ignored, since we handle them via the context.
+func newCrawlManager(ctx context.Context, config crawlLib.CrawlManagerConfig) (*crawlLib.CrawlManager, error) {
+	type result struct {
+		cm  *crawlLib.CrawlManager
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		cm, err := crawlLib.NewCrawlManager(config)
+		done <- result{cm: cm, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.cm, res.err
+	case <-ctx.Done():
+		// Clean up once the crawl manager is set up.
+		go func() {
+			res := <-done
+			if res.cm != nil {
+				_ = res.cm.Stop()
+			}
+		}()
+		return nil, ctx.Err()
 	}
-	log.Debug("writing peer graph")
-	err = report.WritePeergraph(path.Join(config.OutputDirectoryPath, fmt.Sprintf("peerGraph_%s.csv", beforeString)))
+}
+
+// openPostgresSink opens the PostgreSQL database with the given connection
+// string and creates a sink writing to it.
+// The database must be closed by the caller once the sink is no longer used.
+func openPostgresSink(dsn string) (*sql.DB, *crawlLib.PostgresSink, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, fmt.Errorf("unable to open PostgreSQL database: %w", err)
+	}
+
+	sink, err := crawlLib.NewPostgresSink(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("unable to set up PostgreSQL sink: %w", err)
+	}
+
+	return db, sink, nil
+}
+
+// crawlNetworks crawls the given networks concurrently, writing the results of
+// each to a subdirectory of the output directory named after the network.
+// The node cache is not used.
+func crawlNetworks(ctx context.Context, config *Config, networks []string) error {
+	var managers []*crawlLib.CrawlManager
+	stopAll := func() {
+		for _, cm := range managers {
+			_ = cm.Stop()
+		}
+	}
+	for _, network := range networks {
+		managerConfig, err := config.CrawlOptions.WithNetwork(network)
+		if err != nil {
+			stopAll()
+			return err
+		}
+		dir := filepath.Join(config.OutputDirectoryPath, network)
+		err = os.MkdirAll(dir, 0o777)
+		if err != nil {
+			stopAll()
+			return fmt.Errorf("unable to create output directory: %w", err)
+		}
+
+		cm, err := newCrawlManager(ctx, managerConfig)
+		if err != nil {
+			stopAll()
+			return fmt.Errorf("unable to set up crawler for network %s: %w", network, err)
+		}
+		cm.AddOutputSinks(crawlLib.NewFileSink(dir, config.Output))
+		managers = append(managers, cm)
+		log.WithField("network", network).WithField("path", dir).Info("created crawl manager")
+	}
+
+	_, err := crawlLib.CrawlNetworks(ctx, managers...)
+	if err != nil {
+		// Partial results have been written anyway.
+		return fmt.Errorf("not all crawls succeeded: %w", err)
 	}
 	log.Info("wrote results")
 
-	// Write node cache
+	return nil
+}
+
+// crawlPeriodically crawls the network at the configured interval and writes
+// the results of each crawl, until the context is cancelled.
+// The node cache, if configured, and the peers reachable in the output of a
+// previous crawl at seedFrom, if not empty, are used to seed the first crawl.
+func crawlPeriodically(ctx context.Context, config *Config, seedFrom string) error {
+	sinks := []crawlLib.OutputSink{crawlLib.NewFileSink(config.OutputDirectoryPath, config.Output)}
+	if len(config.PostgresDSN) != 0 {
+		db, sink, err := openPostgresSink(config.PostgresDSN)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = db.Close() }()
+		sinks = append(sinks, sink)
+	}
+	if config.StatsD != nil {
+		reporter, err := crawlLib.NewStatsDReporter(*config.StatsD)
+		if err != nil {
+			return fmt.Errorf("unable to set up StatsD reporting: %w", err)
+		}
+		sinks = append(sinks, reporter)
+	}
+
+	scheduler, err := crawlLib.NewScheduler(config.CrawlOptions, sinks...)
+	if err != nil {
+		for _, sink := range sinks {
+			_ = sink.Close()
+		}
+		return fmt.Errorf("unable to set up scheduler: %w", err)
+	}
+
 	if config.CacheFilePath != nil {
-		err = report.SaveNodeCache(*config.CacheFilePath)
+		cachedNodes, err := crawlLib.RestoreNodeCache(*config.CacheFilePath)
+		if err != nil {
+			log.WithError(err).Warn("unable to load cached peers, ignoring")
+		} else {
+			log.WithField("num", len(cachedNodes)).Info("loaded cached peers, adding to queue")
+			scheduler.AddPeersToCrawl(cachedNodes)
+		}
+	}
+	if len(seedFrom) != 0 {
+		seeds, err := crawlLib.LoadSeedPeersFromOutput(seedFrom)
+		if err != nil {
+			return fmt.Errorf("unable to load seed peers: %w", err)
+		}
+		log.WithField("num", len(seeds)).Info("loaded peers reachable in previous crawl, adding to queue")
+		scheduler.AddPeersToCrawl(seeds)
+	}
+
+	log.WithField("interval", config.CrawlOptions.CrawlInterval).Info("crawling periodically")
+	err = scheduler.Start(ctx)
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+
+	log.Info("stopping scheduler")
+	return scheduler.Stop()
+}
+
+// lookupProviders looks up providers of the given CID, starting at the
+// configured bootstrap peers, and prints them to stdout as JSON Lines.
+func lookupProviders(ctx context.Context, config *Config, cidStr string) error {
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return fmt.Errorf("unable to parse CID: %w", err)
+	}
+
+	cm, err := newCrawlManager(ctx, config.CrawlOptions)
+	if err != nil {
+		return fmt.Errorf("unable to set up crawler: %w", err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	providers, err := cm.FindProvidersForCID(ctx, c, nil)
+	if errors.Is(err, crawlLib.ErrDryRun) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to find providers: %w", err)
+	}
+	log.WithField("num", len(providers)).Info("found providers")
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, p := range providers {
+		err = enc.Encode(p)
 		if err != nil {
-			log.Fatal(fmt.Errorf("unable to save online nodes to cache: %w", err))
+			return fmt.Errorf("unable to write providers: %w", err)
 		}
-		log.WithField("path", config.CacheFilePath).Info("saved online nodes to cache")
 	}
+
+	return nil
+}
+
+// crawlSinglePeer crawls the peer at the given multiaddress, without following
+// its neighbors, and prints the result to stdout as JSON.
+// The node cache is not used.
+func crawlSinglePeer(ctx context.Context, config *Config, addr string) error {
+	pinfo, err := peer.AddrInfoFromString(addr)
+	if err != nil {
+		return fmt.Errorf("unable to parse peer address: %w", err)
+	}
+
+	cm, err := newCrawlManager(ctx, config.CrawlOptions)
+	if err != nil {
+		return fmt.Errorf("unable to set up crawler: %w", err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	node, err := cm.CrawlSinglePeer(ctx, *pinfo)
+	if errors.Is(err, crawlLib.ErrDryRun) {
+		return nil
+	}
+	if node == nil {
+		return fmt.Errorf("unable to crawl peer: %w", err)
+	}
+	if err != nil {
+		log.WithError(err).Warn("unable to crawl peer")
+	}
+
+	err = json.NewEncoder(os.Stdout).Encode(node)
+	if err != nil {
+		return fmt.Errorf("unable to write result: %w", err)
+	}
+
+	return nil
+}
+
+// lookupKey walks the DHT toward the given peer ID or CID, starting at the
+// configured bootstrap peers, and prints the result to stdout as JSON.
+func lookupKey(ctx context.Context, config *Config, keyStr string) error {
+	var key []byte
+	if id, err := peer.Decode(keyStr); err == nil {
+		key = []byte(id)
+	} else if c, err := cid.Decode(keyStr); err == nil {
+		key = c.Hash()
+	} else {
+		return fmt.Errorf("unable to parse %q as peer ID or CID", keyStr)
+	}
+
+	cm, err := newCrawlManager(ctx, config.CrawlOptions)
+	if err != nil {
+		return fmt.Errorf("unable to set up crawler: %w", err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	out, err := cm.LookupKey(ctx, key, nil)
+	if errors.Is(err, crawlLib.ErrDryRun) {
+		return nil
+	}
+	if out == nil {
+		return fmt.Errorf("unable to look up key: %w", err)
+	}
+	if err != nil {
+		log.WithError(err).Warn("lookup was interrupted")
+	}
+	log.WithFields(log.Fields{
+		"closest":   len(out.Closest),
+		"rounds":    out.Rounds,
+		"queries":   len(out.Queries),
+		"converged": out.Converged,
+	}).Info("finished lookup")
+
+	err = json.NewEncoder(os.Stdout).Encode(out)
+	if err != nil {
+		return fmt.Errorf("unable to write lookup result: %w", err)
+	}
+
+	return nil
 }
 
 func parseConfig(configFilePath string) (*Config, error) {
@@ -143,10 +644,96 @@ func parseConfig(configFilePath string) (*Config, error) {
 	}
 
 	var config Config
-	err = yaml.NewDecoder(f).Decode(&config)
+	dec := yaml.NewDecoder(f)
+	// Catch misspelled settings, which would otherwise be ignored silently.
+	dec.KnownFields(true)
+	err = dec.Decode(&config)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal: %w", err)
 	}
 
+	err = applyEnv(&config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
+
+// Environment variables which override settings of the configuration file,
+// e.g., to run a container without mounting a configuration file.
+const (
+	// A comma-separated list of protocols, which overrides
+	// crawler_config.protocol_strings.
+	envProtocolStrings = "WORKER_PROTOCOLSTRINGS"
+	// Overrides worker_config.user_agent.
+	envUserAgent = "WORKER_USERAGENT"
+)
+
+// applyEnv overrides settings of the config with the values of the
+// environment variables that are set.
+// Protocols configured for a network selected via --network still take
+// precedence.
+func applyEnv(config *Config) error {
+	if v, ok := os.LookupEnv(envProtocolStrings); ok {
+		var protocols []protocol.ID
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if len(p) != 0 {
+				protocols = append(protocols, protocol.ID(p))
+			}
+		}
+		if len(protocols) == 0 {
+			return fmt.Errorf("invalid %s: no protocols", envProtocolStrings)
+		}
+		config.CrawlOptions.CrawlerConfig.ProtocolStrings = protocols
+		log.WithField("protocols", protocols).Infof("using protocols from %s", envProtocolStrings)
+	}
+	if v, ok := os.LookupEnv(envUserAgent); ok {
+		config.CrawlOptions.WorkerConfig.UserAgent = v
+		log.WithField("user_agent", v).Infof("using user agent from %s", envUserAgent)
+	}
+
+	return nil
+}
+
+// validate checks the config for missing or invalid values, and whether it can
+// be used with the given command line options.
+func (c *Config) validate(opts cliOptions) error {
+	if len(c.OutputDirectoryPath) == 0 {
+		return fmt.Errorf("missing output_directory_path")
+	}
+	if c.CacheFilePath != nil && len(*c.CacheFilePath) == 0 {
+		return fmt.Errorf("invalid cache_file_path")
+	}
+	if c.StatsD != nil && len(c.StatsD.Address) == 0 {
+		return fmt.Errorf("missing statsd address")
+	}
+	if len(opts.crawl.seedFrom) != 0 && len(opts.crawl.recrawlUnreachable) != 0 {
+		return fmt.Errorf("--seed-from and --recrawl-unreachable are mutually exclusive")
+	}
+	if len(opts.networks) > 1 {
+		// Crawling multiple networks only writes the results of a single
+		// crawl of each to files.
+		for _, option := range []struct {
+			name string
+			set  bool
+		}{
+			{"--single-peer", len(opts.singlePeer) != 0},
+			{"--find-providers", len(opts.findProviders) != 0},
+			{"--lookup", len(opts.lookup) != 0},
+			{"--resume", opts.crawl.resume},
+			{"--recrawl-unreachable", len(opts.crawl.recrawlUnreachable) != 0},
+			{"--seed-from", len(opts.crawl.seedFrom) != 0},
+			{"crawl_interval", c.CrawlOptions.CrawlInterval > 0},
+			{"statsd", c.StatsD != nil},
+			{"postgres_dsn", len(c.PostgresDSN) != 0},
+		} {
+			if option.set {
+				return fmt.Errorf("%s cannot be used when crawling more than one network", option.name)
+			}
+		}
+	}
+
+	return c.CrawlOptions.Validate()
+}
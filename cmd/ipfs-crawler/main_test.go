@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	crawlLib "ipfs-crawler/crawling"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+func TestParseConfigEnvironment(t *testing.T) {
+	t.Setenv(envProtocolStrings, " /a/kad/1.0.0, ,/b/kad/1.0.0,")
+	t.Setenv(envUserAgent, "crawler/test")
+
+	config, err := parseConfig("../../dist/config_ipfs.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protocols := config.CrawlOptions.CrawlerConfig.ProtocolStrings
+	if len(protocols) != 2 || protocols[0] != protocol.ID("/a/kad/1.0.0") || protocols[1] != protocol.ID("/b/kad/1.0.0") {
+		t.Errorf("unexpected protocols %v", protocols)
+	}
+	if ua := config.CrawlOptions.WorkerConfig.UserAgent; ua != "crawler/test" {
+		t.Errorf("unexpected user agent %q", ua)
+	}
+
+	t.Setenv(envProtocolStrings, " , ")
+	_, err = parseConfig("../../dist/config_ipfs.yaml")
+	if err == nil || !strings.Contains(err.Error(), envProtocolStrings) {
+		t.Errorf("expected empty protocol list to be rejected, got %v", err)
+	}
+}
+
+func TestValidateMultipleNetworks(t *testing.T) {
+	networks := []string{"ipfs", "internal"}
+	for name, test := range map[string]struct {
+		opts   cliOptions
+		modify func(*Config)
+		flag   string
+	}{
+		"plain":               {opts: cliOptions{}},
+		"single peer":         {opts: cliOptions{singlePeer: "/ip4/1.2.3.4/tcp/4001"}, flag: "--single-peer"},
+		"find providers":      {opts: cliOptions{findProviders: "bafkqaaa"}, flag: "--find-providers"},
+		"lookup":              {opts: cliOptions{lookup: "bafkqaaa"}, flag: "--lookup"},
+		"resume":              {opts: cliOptions{crawl: crawlOptions{resume: true}}, flag: "--resume"},
+		"recrawl unreachable": {opts: cliOptions{crawl: crawlOptions{recrawlUnreachable: "out.json"}}, flag: "--recrawl-unreachable"},
+		"seed from":           {opts: cliOptions{crawl: crawlOptions{seedFrom: "out.json"}}, flag: "--seed-from"},
+		"crawl interval":      {modify: func(c *Config) { c.CrawlOptions.CrawlInterval = time.Hour }, flag: "crawl_interval"},
+		"statsd":              {modify: func(c *Config) { c.StatsD = &crawlLib.StatsDConfig{Address: "localhost:8125"} }, flag: "statsd"},
+		"postgres":            {modify: func(c *Config) { c.PostgresDSN = "postgres://localhost/crawls" }, flag: "postgres_dsn"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config, err := parseConfig("../../dist/config_ipfs.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.modify != nil {
+				test.modify(config)
+			}
+
+			// Any option is fine for a single network.
+			opts := test.opts
+			opts.networks = networks[:1]
+			err = config.validate(opts)
+			if err != nil {
+				t.Fatalf("single network: %v", err)
+			}
+
+			opts.networks = networks
+			err = config.validate(opts)
+			switch {
+			case len(test.flag) == 0 && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case len(test.flag) != 0 && (err == nil || !strings.HasPrefix(err.Error(), test.flag+" ")):
+				t.Errorf("expected %s to be rejected, got %v", test.flag, err)
+			}
+		})
+	}
+}
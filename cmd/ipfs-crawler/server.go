@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+
+	crawlLib "ipfs-crawler/crawling"
+)
+
+// serverShutdownTimeout is how long we wait for open HTTP requests when
+// shutting down the server.
+const serverShutdownTimeout = 5 * time.Second
+
+// A server triggers crawls and reports on their status via HTTP.
+// At most one crawl runs at a time.
+type server struct {
+	config *Config
+
+	// Crawls are started with this context, which is cancelled when the
+	// server shuts down.
+	ctx context.Context
+	// runCrawl performs a crawl, see crawl.
+	runCrawl func(context.Context, *Config, crawlOptions) error
+	// Tracks the running crawl, if any.
+	wg sync.WaitGroup
+
+	m        sync.Mutex
+	crawling bool
+	cm       *crawlLib.CrawlManager
+}
+
+// statusResponse is the response to a status request.
+type statusResponse struct {
+	Running bool                  `json:"running"`
+	Status  *crawlLib.CrawlStatus `json:"status,omitempty"`
+}
+
+// newServer creates a new server, whose crawls are stopped once the given
+// context is cancelled.
+func newServer(ctx context.Context, config *Config) *server {
+	return &server{
+		config:   config,
+		ctx:      ctx,
+		runCrawl: crawl,
+	}
+}
+
+// handler returns the HTTP handler of the server.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/crawl", s.handleCrawl)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return mux
+}
+
+// serve runs an HTTP server on the given address, until it fails or the
+// context is cancelled.
+// Cancelling the context also stops the running crawl, if any, whose partial
+// results are written before serve returns.
+func serve(ctx context.Context, addr string, config *Config) error {
+	s := newServer(ctx, config)
+	httpServer := &http.Server{Addr: addr, Handler: s.handler()}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	err := httpServer.Shutdown(shutdownCtx)
+	s.wg.Wait()
+
+	return err
+}
+
+// handleCrawl starts a new crawl, unless one is already running.
+func (s *server) handleCrawl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.ctx.Err() != nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.crawling {
+		http.Error(w, "crawl already in progress", http.StatusConflict)
+		return
+	}
+	s.crawling = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := s.runCrawl(s.ctx, s.config, crawlOptions{onStart: s.setCrawlManager})
+		if err != nil {
+			log.WithError(err).Error("crawl failed")
+		}
+
+		s.m.Lock()
+		defer s.m.Unlock()
+		s.crawling = false
+		s.cm = nil
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *server) setCrawlManager(cm *crawlLib.CrawlManager) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.cm = cm
+}
+
+// handleStatus reports the status of the currently running crawl, if any.
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.m.Lock()
+	resp := statusResponse{Running: s.crawling}
+	cm := s.cm
+	s.m.Unlock()
+
+	// We must not hold the lock while waiting for the crawl manager.
+	if cm != nil {
+		status, ok := cm.Status()
+		if ok {
+			resp.Status = &status
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.WithError(err).Debug("unable to write status response")
+	}
+}
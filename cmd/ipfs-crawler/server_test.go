@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerCrawl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newServer(ctx, &Config{})
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	s.runCrawl = func(ctx context.Context, _ *Config, _ crawlOptions) error {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return ctx.Err()
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/crawl", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	<-started
+
+	resp, err = http.Post(ts.URL+"/crawl", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d during a crawl, got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	if status := getStatus(t, ts.URL); !status.Running {
+		t.Error("expected the crawl to be reported as running")
+	}
+
+	// Shutting down stops the crawl.
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("expected the crawl to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl not stopped on shutdown")
+	}
+	s.wg.Wait()
+	if status := getStatus(t, ts.URL); status.Running {
+		t.Error("expected no crawl to be reported as running")
+	}
+
+	resp, err = http.Post(ts.URL+"/crawl", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after shutdown, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestServerStatusIdle(t *testing.T) {
+	s := newServer(context.Background(), &Config{})
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	status := getStatus(t, ts.URL)
+	if status.Running || status.Status != nil {
+		t.Errorf("expected no crawl to be running, got %+v", status)
+	}
+
+	resp, err := http.Get(ts.URL + "/crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+// getStatus requests the status of the server at the given URL.
+func getStatus(t *testing.T, url string) statusResponse {
+	t.Helper()
+
+	resp, err := http.Get(url + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %q", ct)
+	}
+	var status statusResponse
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return status
+}
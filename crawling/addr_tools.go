@@ -3,6 +3,8 @@
 package crawling
 
 import (
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -49,13 +51,98 @@ func filterOutOldAddresses(old []ma.Multiaddr, new []ma.Multiaddr) []ma.Multiadd
 	return newAddrs
 }
 
-// stripLocalAddrs removes local addresses from the given set of addresses.
+// canonicalAddrs canonicalizes the given addresses of the given peer and
+// removes duplicates, see canonicalAddr.
 // Returns a copy of the slice.
-func stripLocalAddrs(mas []ma.Multiaddr) []ma.Multiaddr {
+func canonicalAddrs(id peer.ID, mas []ma.Multiaddr) []ma.Multiaddr {
 	out := make([]ma.Multiaddr, 0, len(mas))
+	seen := make(map[string]struct{}, len(mas))
 
 	for _, maddr := range mas {
-		if manet.IsPrivateAddr(maddr) || manet.IsIPLoopback(maddr) {
+		maddr = canonicalAddr(id, maddr)
+		if maddr == nil {
+			continue
+		}
+		if _, ok := seen[string(maddr.Bytes())]; ok {
+			continue
+		}
+		seen[string(maddr.Bytes())] = struct{}{}
+		out = append(out, maddr)
+	}
+
+	return out
+}
+
+// canonicalAddr returns the canonical form of the given address of the given
+// peer:
+//   - a trailing /p2p component with the peer's own ID is removed,
+//   - IPv4-mapped IPv6 addresses are converted to IPv4, and
+//   - DNS names are lowercased.
+//
+// Returns nil if nothing remains of the address.
+func canonicalAddr(id peer.ID, maddr ma.Multiaddr) ma.Multiaddr {
+	rest, last := ma.SplitLast(maddr)
+	if last != nil && last.Protocol().Code == ma.P_P2P {
+		if lastID, err := peer.IDFromBytes(last.RawValue()); err == nil && lastID == id {
+			if rest == nil {
+				return nil
+			}
+			maddr = rest
+		}
+	}
+
+	var parts []ma.Multiaddr
+	changed := false
+	ma.ForEach(maddr, func(c ma.Component) bool {
+		var canonical *ma.Component
+		switch c.Protocol().Code {
+		case ma.P_IP6:
+			if ip4 := net.IP(c.RawValue()).To4(); ip4 != nil {
+				canonical, _ = ma.NewComponent("ip4", ip4.String())
+			}
+		case ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+			if lower := strings.ToLower(c.Value()); lower != c.Value() {
+				canonical, _ = ma.NewComponent(c.Protocol().Name, lower)
+			}
+		}
+		if canonical != nil {
+			changed = true
+			parts = append(parts, canonical)
+		} else {
+			parts = append(parts, &c)
+		}
+		return true
+	})
+	if !changed {
+		return maddr
+	}
+
+	return ma.Join(parts...)
+}
+
+// An addrFilter decides which addresses of peers we keep.
+// By default, private and loopback addresses are removed, which also removes
+// relayed addresses via relays on such addresses.
+type addrFilter struct {
+	// Whether to keep private and loopback addresses.
+	keepLocal bool
+	// Whether to keep relayed (p2p-circuit) addresses, regardless of the
+	// address of the relay.
+	keepRelay bool
+}
+
+// filter removes addresses that should not be kept from the given set of
+// addresses.
+// Returns a copy of the slice.
+func (f addrFilter) filter(mas []ma.Multiaddr) []ma.Multiaddr {
+	out := make([]ma.Multiaddr, 0, len(mas))
+
+	for _, maddr := range mas {
+		if f.keepRelay && isRelayAddr(maddr) {
+			out = append(out, maddr)
+			continue
+		}
+		if !f.keepLocal && (manet.IsPrivateAddr(maddr) || manet.IsIPLoopback(maddr)) {
 			continue
 		}
 		out = append(out, maddr)
@@ -63,3 +150,119 @@ func stripLocalAddrs(mas []ma.Multiaddr) []ma.Multiaddr {
 
 	return out
 }
+
+// isPublicAddr returns whether the given address is publicly routable without
+// a relay.
+func isPublicAddr(maddr ma.Multiaddr) bool {
+	return !isRelayAddr(maddr) && manet.IsPublicAddr(maddr)
+}
+
+// hasPublicAddr returns whether any of the given addresses is publicly
+// routable without a relay.
+func hasPublicAddr(mas []ma.Multiaddr) bool {
+	for _, maddr := range mas {
+		if isPublicAddr(maddr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRelayAddr returns whether the given address is a relayed address, i.e.,
+// whether it contains a p2p-circuit component.
+func isRelayAddr(maddr ma.Multiaddr) bool {
+	_, err := maddr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// addrFamily returns the address family of the remote address of a
+// connection: ip4 or ip6, relay for relayed connections, or unknown.
+func addrFamily(maddr ma.Multiaddr) string {
+	if maddr == nil {
+		return "unknown"
+	}
+	if isRelayAddr(maddr) {
+		return "relay"
+	}
+
+	switch maddr.Protocols()[0].Code {
+	case ma.P_IP4:
+		return "ip4"
+	case ma.P_IP6:
+		return "ip6"
+	default:
+		return "unknown"
+	}
+}
+
+// ipAndPort extracts the IP address and TCP or UDP port an address starts
+// with, e.g., 1.2.3.4 and 4001 for /ip4/1.2.3.4/udp/4001/quic-v1.
+// Returns false if the address does not start with an IP address and a port.
+func ipAndPort(maddr ma.Multiaddr) (net.IP, int, bool) {
+	first, rest := ma.SplitFirst(maddr)
+	if first == nil || rest == nil {
+		return nil, 0, false
+	}
+	if first.Protocol().Code != ma.P_IP4 && first.Protocol().Code != ma.P_IP6 {
+		return nil, 0, false
+	}
+	second, _ := ma.SplitFirst(rest)
+	if second.Protocol().Code != ma.P_TCP && second.Protocol().Code != ma.P_UDP {
+		return nil, 0, false
+	}
+	port, err := strconv.Atoi(second.Value())
+	if err != nil {
+		return nil, 0, false
+	}
+
+	return net.IP(first.RawValue()), port, true
+}
+
+// addrTransportLabel classifies an address by its transport for the output.
+// This is the transport returned by addrTransport, except that secure
+// WebSocket addresses are labeled "wss", and addresses of unknown transports
+// "other".
+func addrTransportLabel(maddr ma.Multiaddr) string {
+	t := addrTransport(maddr)
+	switch t {
+	case "":
+		return "other"
+	case TransportWebsocket:
+		if isSecureWebsocketAddr(maddr) {
+			return "wss"
+		}
+	}
+	return t
+}
+
+// isSecureWebsocketAddr returns whether the address is a WebSocket address
+// secured with TLS, i.e., /wss or /tls/ws.
+func isSecureWebsocketAddr(maddr ma.Multiaddr) bool {
+	secure := false
+	ma.ForEach(maddr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_WSS, ma.P_TLS:
+			secure = true
+		case ma.P_CIRCUIT:
+			// We dial the relay, which precedes this.
+			return false
+		}
+		return true
+	})
+	return secure
+}
+
+// isBrowserDialable returns whether browsers can dial the address directly,
+// i.e., whether it is publicly routable and uses secure WebSockets,
+// WebTransport, or WebRTC.
+func isBrowserDialable(maddr ma.Multiaddr) bool {
+	if !isPublicAddr(maddr) {
+		return false
+	}
+	switch addrTransportLabel(maddr) {
+	case "wss", TransportWebTransport, TransportWebRTCDirect:
+		return true
+	default:
+		return false
+	}
+}
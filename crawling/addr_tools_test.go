@@ -0,0 +1,91 @@
+package crawling
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrFilter(t *testing.T) {
+	const relay = "/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN"
+	public := "/ip4/1.2.3.4/tcp/4001"
+	private := "/ip4/10.0.0.1/tcp/4001"
+	loopback := "/ip6/::1/udp/4001/quic-v1"
+	publicRelay := public + relay + "/p2p-circuit"
+	privateRelay := private + relay + "/p2p-circuit"
+	all := []string{public, private, loopback, publicRelay, privateRelay}
+
+	for _, test := range []struct {
+		name     string
+		filter   addrFilter
+		addrs    []string
+		expected []string
+	}{
+		{"default", addrFilter{}, all, []string{public, publicRelay}},
+		{"keep local", addrFilter{keepLocal: true}, all, all},
+		{"keep relay", addrFilter{keepRelay: true}, all, []string{public, publicRelay, privateRelay}},
+		{"keep both", addrFilter{keepLocal: true, keepRelay: true}, all, all},
+		// A peer reachable only via a relay on a private address is kept
+		// with keepRelay, and dropped without it.
+		{"relay only", addrFilter{keepRelay: true}, []string{privateRelay}, []string{privateRelay}},
+		{"relay only dropped", addrFilter{}, []string{privateRelay}, nil},
+		{"none", addrFilter{}, nil, nil},
+	} {
+		var addrs []ma.Multiaddr
+		for _, a := range test.addrs {
+			addrs = append(addrs, ma.StringCast(a))
+		}
+		filtered := test.filter.filter(addrs)
+		if len(filtered) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, filtered)
+			continue
+		}
+		for i, a := range filtered {
+			if a.String() != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, filtered)
+				break
+			}
+		}
+	}
+}
+
+func TestIsRelayAddr(t *testing.T) {
+	for addr, relayed := range map[string]bool{
+		"/ip4/1.2.3.4/tcp/4001": false,
+		"/ip4/1.2.3.4/tcp/4001/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN":                           false,
+		"/ip4/1.2.3.4/tcp/4001/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN/p2p-circuit":               true,
+		"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN/p2p-circuit":       true,
+		"/dns4/relay.example.com/tcp/443/wss/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN/p2p-circuit": true,
+	} {
+		if r := isRelayAddr(ma.StringCast(addr)); r != relayed {
+			t.Errorf("%s: expected relayed %t, got %t", addr, relayed, r)
+		}
+	}
+}
+
+func TestAddrTransportLabel(t *testing.T) {
+	const certhash = "uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+	for _, test := range []struct {
+		addr            string
+		label           string
+		browserDialable bool
+	}{
+		{"/ip4/1.2.3.4/tcp/4001", TransportTCP, false},
+		{"/ip4/1.2.3.4/udp/4001/quic-v1", TransportQUIC, false},
+		{"/ip4/1.2.3.4/tcp/4002/ws", TransportWebsocket, false},
+		{"/ip4/1.2.3.4/tcp/4002/wss", "wss", true},
+		{"/dns4/example.com/tcp/443/tls/ws", "wss", true},
+		{"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport/certhash/" + certhash, TransportWebTransport, true},
+		{"/ip4/1.2.3.4/udp/4001/webrtc-direct/certhash/" + certhash, TransportWebRTCDirect, true},
+		{"/ip4/10.0.0.1/tcp/4002/wss", "wss", false},
+		{"/ip4/1.2.3.4/udp/4001/utp", "other", false},
+	} {
+		addr := ma.StringCast(test.addr)
+		if label := addrTransportLabel(addr); label != test.label {
+			t.Errorf("%s: expected transport %s, got %s", test.addr, test.label, label)
+		}
+		if dialable := isBrowserDialable(addr); dialable != test.browserDialable {
+			t.Errorf("%s: expected browser dialable %t, got %t", test.addr, test.browserDialable, dialable)
+		}
+	}
+}
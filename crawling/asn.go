@@ -0,0 +1,79 @@
+package crawling
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// asInfo is the autonomous system a node was connected via, see
+// enrichASNs.
+type asInfo struct {
+	number uint
+	org    string
+}
+
+var uniqueASNs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ipfs_crawler_cmanager_unique_asns",
+	Help: "The number of distinct autonomous systems of reachable nodes in the most recent crawl.",
+}, []string{"network"})
+
+// openASNDatabase opens the MaxMind ASN database at path, e.g., GeoLite2-ASN.
+func openASNDatabase(path string) (*geoip2.Reader, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// Looking up an ASN fails for databases of other types.
+	_, err = db.ASN(net.IPv4zero)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("not an ASN database: %w", err)
+	}
+	return db, nil
+}
+
+// enrichASNs looks up the autonomous system of the address each reachable
+// node was crawled over.
+// Nodes crawled over relayed or non-public addresses, and addresses not
+// contained in the database, are left as they are.
+func enrichASNs(db *geoip2.Reader, nodes map[peer.ID]nodeCrawlStatus) {
+	for id, state := range nodes {
+		if state.err != nil || state.result == nil {
+			continue
+		}
+		state.result.as = nil
+		ip, ok := connectedPublicIP(state.result)
+		if !ok {
+			continue
+		}
+		rec, err := db.ASN(ip)
+		if err != nil {
+			log.WithError(err).WithField("peer", id).Debug("unable to look up ASN")
+			continue
+		}
+		if rec.AutonomousSystemNumber == 0 {
+			continue
+		}
+		state.result.as = &asInfo{
+			number: rec.AutonomousSystemNumber,
+			org:    rec.AutonomousSystemOrganization,
+		}
+	}
+}
+
+// connectedPublicIP returns the IP address of the connection a node was
+// crawled over, unless that was relayed or not publicly routable.
+func connectedPublicIP(info *nodeInformation) (net.IP, bool) {
+	addr := info.info.ConnectedAddr
+	if addr == nil || !isPublicAddr(addr) {
+		return nil, false
+	}
+	ip, _, ok := ipAndPort(addr)
+	return ip, ok
+}
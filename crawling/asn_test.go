@@ -0,0 +1,92 @@
+package crawling
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Fixture databases of the evaluation scripts, see eval/geoipDBs.
+const (
+	testASNDatabase     = "../eval/geoipDBs/GeoLite2-ASN.mmdb"
+	testCountryDatabase = "../eval/geoipDBs/GeoLite2-Country.mmdb"
+)
+
+// testNodesConnectedVia returns a reachable node for each of the given
+// addresses, connected via that address, and an unreachable node.
+func testNodesConnectedVia(t *testing.T, addrs ...string) (map[peer.ID]nodeCrawlStatus, []peer.ID) {
+	t.Helper()
+
+	nodes := make(map[peer.ID]nodeCrawlStatus)
+	var ids []peer.ID
+	for _, addr := range addrs {
+		id, _ := newTestPeer(t)
+		nodes[id] = nodeCrawlStatus{result: &nodeInformation{info: peerMetadata{ConnectedAddr: ma.StringCast(addr)}}}
+		ids = append(ids, id)
+	}
+	id, _ := newTestPeer(t)
+	nodes[id] = nodeCrawlStatus{err: errors.New("unreachable")}
+
+	return nodes, ids
+}
+
+func TestOpenASNDatabase(t *testing.T) {
+	db, err := openASNDatabase(testASNDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	_, err = openASNDatabase(testCountryDatabase)
+	if err == nil {
+		t.Error("expected country database to be rejected")
+	}
+}
+
+func TestEnrichASNs(t *testing.T) {
+	db, err := openASNDatabase(testASNDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	relay, _ := newTestPeer(t)
+	nodes, ids := testNodesConnectedVia(t,
+		"/ip4/8.8.8.8/tcp/4001",
+		"/ip6/2001:4860:4860::8888/udp/4001/quic-v1",
+		"/ip4/1.1.1.1/tcp/4001",
+		"/ip4/10.0.0.1/tcp/4001",
+		"/ip4/1.1.1.1/tcp/4001/p2p/"+relay.String()+"/p2p-circuit",
+	)
+	enrichASNs(db, nodes)
+
+	expected := []*asInfo{
+		{number: 15169, org: "Google LLC"},
+		{number: 15169, org: "Google LLC"},
+		{number: 13335, org: "Cloudflare, Inc."},
+		// Private and relayed addresses are not looked up.
+		nil,
+		nil,
+	}
+	for i, id := range ids {
+		as := nodes[id].result.as
+		switch {
+		case expected[i] == nil && as != nil:
+			t.Errorf("%s: expected no ASN, got %+v", nodes[id].result.info.ConnectedAddr, *as)
+		case expected[i] != nil && (as == nil || *as != *expected[i]):
+			t.Errorf("%s: expected %+v, got %+v", nodes[id].result.info.ConnectedAddr, *expected[i], as)
+		}
+	}
+
+	node := nodes[ids[0]].toCrawledNode(nil, nil, ids[0], 0)
+	if node.ASN != 15169 || node.ASOrg != "Google LLC" {
+		t.Errorf("expected AS15169 in the output, got %d %q", node.ASN, node.ASOrg)
+	}
+
+	stats := computeStats(nodes, nil, 0)
+	if stats.UniqueASNs != 2 || stats.ASNHistogram[15169] != 2 || stats.ASNHistogram[13335] != 1 {
+		t.Errorf("unexpected ASN statistics %d %v", stats.UniqueASNs, stats.ASNHistogram)
+	}
+}
@@ -0,0 +1,101 @@
+package crawling
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// SanityResult is the result of checking a single canary peer after a crawl.
+// Canaries are known-good peers, which should be found in the routing tables
+// of crawled peers. A canary which is reachable, but was not found in any
+// routing table, indicates that the crawl was partitioned or eclipsed.
+type SanityResult struct {
+	ID peer.ID `json:"id"`
+
+	// Whether we were able to connect to the canary, either during the crawl
+	// or directly afterwards.
+	Reachable bool `json:"reachable"`
+
+	// The number of crawled peers which had the canary in their routing
+	// tables.
+	ReferencedBy int `json:"referenced_by"`
+
+	// Whether the canary was reachable but not referenced by any crawled
+	// peer.
+	Warning bool `json:"warning"`
+}
+
+// checkCanaries checks whether the configured canaries were found in the
+// routing tables of crawled peers.
+// Canaries which were not probed during the crawl are probed directly, without
+// recording the results in the crawl. Like crawls, probes take a token from
+// the token bucket, so they run concurrently, but within the configured
+// limits.
+func (cm *CrawlManager) checkCanaries(ctx context.Context) []SanityResult {
+	if len(cm.canaries) == 0 {
+		return nil
+	}
+
+	referencedBy := make(map[peer.ID]int, len(cm.canaries))
+	for _, c := range cm.canaries {
+		referencedBy[c.ID] = 0
+	}
+	for _, node := range cm.crawled {
+		if node.err != nil || node.result.crawlDataError != nil {
+			continue
+		}
+		for _, neighbor := range node.result.crawlNeighbors {
+			if n, ok := referencedBy[neighbor]; ok {
+				referencedBy[neighbor] = n + 1
+			}
+		}
+	}
+
+	reachable := make([]bool, len(cm.canaries))
+	var wg sync.WaitGroup
+probes:
+	for i, c := range cm.canaries {
+		if state, ok := cm.crawled[c.ID]; ok && state.err == nil {
+			reachable[i] = true
+			continue
+		}
+
+		var id int
+		select {
+		case id = <-cm.tokenBucket:
+			id = cm.takeToken(id)
+		case <-ctx.Done():
+			break probes
+		}
+		wg.Add(1)
+		go func(i int, c peer.AddrInfo, id int) {
+			defer wg.Done()
+			_, err := cm.workers[id].crawlPeer(ctx, c)
+			cm.returnToken(id)
+			if err != nil {
+				log.WithError(err).WithField("peer", c.ID).Debug("unable to probe canary")
+			}
+			reachable[i] = err == nil
+		}(i, c, id)
+	}
+	wg.Wait()
+
+	var results []SanityResult
+	for i, c := range cm.canaries {
+		res := SanityResult{
+			ID:           c.ID,
+			Reachable:    reachable[i],
+			ReferencedBy: referencedBy[c.ID],
+		}
+		res.Warning = res.Reachable && res.ReferencedBy == 0
+		if res.Warning {
+			log.WithField("peer", c.ID).Warn("canary is reachable but was not found in any routing table, the crawl may be partitioned or eclipsed")
+		}
+		results = append(results, res)
+	}
+
+	return results
+}
@@ -0,0 +1,78 @@
+package crawling
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestCheckCanaries(t *testing.T) {
+	a, _ := newTestPeer(t)
+	// Referenced by a.
+	b, _ := newTestPeer(t)
+	// Reachable, but not referenced.
+	c, _ := newTestPeer(t)
+	// Unreachable.
+	d, _ := newTestPeer(t)
+
+	path := filepath.Join(t.TempDir(), "canaries.txt")
+	var canaries string
+	for _, id := range []peer.ID{b, c, d} {
+		canaries += "/ip4/1.2.3.5/tcp/4001/p2p/" + id.String() + "\n"
+	}
+	err := os.WriteFile(path, []byte(canaries), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{
+		CanaryFilePath:     path,
+		ConcurrentRequests: 2,
+	}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: addr}}},
+		b: {},
+		c: {},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SanityResult{
+		{ID: b, Reachable: true, ReferencedBy: 1},
+		{ID: c, Reachable: true, Warning: true},
+		{ID: d},
+	}
+	if len(out.sanity) != len(expected) {
+		t.Fatalf("expected %d results, got %v", len(expected), out.sanity)
+	}
+	for i, res := range out.sanity {
+		if res != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], res)
+		}
+	}
+	// b was crawled, the others were probed once.
+	for _, id := range []peer.ID{b, c} {
+		if w.Requests(id) != 1 {
+			t.Errorf("expected one request to %s, got %d", id, w.Requests(id))
+		}
+	}
+	if _, ok := out.nodes[c]; ok {
+		t.Error("probed canary recorded in crawl")
+	}
+	// Workers return their tokens right after handing over their results.
+	deadline := time.Now().Add(time.Second)
+	for len(cm.tokenBucket) != cap(cm.tokenBucket) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(cm.tokenBucket) != cap(cm.tokenBucket) {
+		t.Errorf("expected %d tokens, got %d", cap(cm.tokenBucket), len(cm.tokenBucket))
+	}
+}
@@ -0,0 +1,152 @@
+package crawling
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/ugorji/go/codec"
+)
+
+// cborOutput is the CBOR form of the report written by WriteMetadata.
+// Like msgpack, CBOR uses the binary form of peer IDs and multiaddresses, so we carry
+// them as strings instead, like the JSON form does. All other fields are
+// encoded as they are, using the field names of the JSON form.
+type cborOutput struct {
+	crawlOutputJSON
+	CrawlerIdentities  []string           `json:"crawler_identities"`
+	SkippedNodes       []string           `json:"skipped_nodes"`
+	QueueOverflowNodes []string           `json:"queue_overflow_nodes,omitempty"`
+	Sanity             []cborSanityResult `json:"sanity,omitempty"`
+	Nodes              []cborNode         `json:"found_nodes"`
+}
+
+// cborSanityResult is the CBOR form of a SanityResult.
+type cborSanityResult struct {
+	SanityResult
+	ID string `json:"id"`
+}
+
+// cborNode is the CBOR form of a CrawledNode.
+type cborNode struct {
+	CrawledNode
+	ID             string        `json:"id"`
+	MultiAddrs     []string      `json:"multiaddrs"`
+	CertifiedAddrs []string      `json:"certified_multiaddrs,omitempty"`
+	CrawlerID      string        `json:"crawler_id,omitempty"`
+	Result         *cborNodeData `json:"result"`
+}
+
+// cborNodeData is the CBOR form of a CrawledNodeData.
+type cborNodeData struct {
+	CrawledNodeData
+	ConnectedVia string         `json:"connected_via,omitempty"`
+	Neighbors    []cborAddrInfo `json:"neighbors,omitempty"`
+}
+
+// cborAddrInfo is the CBOR form of a peer.AddrInfo, with the same field names
+// as its JSON form.
+type cborAddrInfo struct {
+	ID    string   `json:"ID"`
+	Addrs []string `json:"Addrs"`
+}
+
+// newCBORHandle creates the CBOR handle used to encode the report.
+func newCBORHandle() *codec.CborHandle {
+	h := &codec.CborHandle{TimeRFC3339: true}
+	h.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	h.Canonical = true
+	return h
+}
+
+func toCBOROutput(out crawlOutputJSON) cborOutput {
+	c := cborOutput{
+		crawlOutputJSON:    out,
+		CrawlerIdentities:  peerIDStrings(out.CrawlerIdentities),
+		SkippedNodes:       peerIDStrings(out.SkippedNodes),
+		QueueOverflowNodes: peerIDStrings(out.QueueOverflowNodes),
+	}
+	for _, s := range out.Sanity {
+		c.Sanity = append(c.Sanity, cborSanityResult{SanityResult: s, ID: s.ID.String()})
+	}
+	for _, node := range out.Nodes {
+		c.Nodes = append(c.Nodes, toCBORNode(node))
+	}
+
+	return c
+}
+
+func toCBORNode(node CrawledNode) cborNode {
+	c := cborNode{
+		CrawledNode:    node,
+		ID:             node.ID.String(),
+		MultiAddrs:     multiaddrStrings(node.MultiAddrs),
+		CertifiedAddrs: multiaddrStrings(node.CertifiedAddrs),
+	}
+	if len(node.CrawlerID) != 0 {
+		c.CrawlerID = node.CrawlerID.String()
+	}
+	if node.Result != nil {
+		c.Result = &cborNodeData{CrawledNodeData: *node.Result}
+		if node.Result.ConnectedVia != nil {
+			c.Result.ConnectedVia = node.Result.ConnectedVia.String()
+		}
+		for _, n := range node.Result.Neighbors {
+			c.Result.Neighbors = append(c.Result.Neighbors, cborAddrInfo{ID: n.ID.String(), Addrs: multiaddrStrings(n.Addrs)})
+		}
+	}
+
+	return c
+}
+
+func peerIDStrings(ids []peer.ID) []string {
+	if ids == nil {
+		return nil
+	}
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, id.String())
+	}
+	return s
+}
+
+func multiaddrStrings(addrs []ma.Multiaddr) []string {
+	if addrs == nil {
+		return nil
+	}
+	s := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		s = append(s, addr.String())
+	}
+	return s
+}
+
+// WriteCBOR writes the report written by WriteMetadata to w, encoded as
+// CBOR rather than JSON, e.g., for use with DAG-CBOR tooling.
+// The structure is identical to the JSON report, including the encoding of
+// peer IDs, multiaddresses, and timestamps as strings, except that timestamps
+// carry the standard date/time tag and integer map keys, e.g., of histograms,
+// are encoded as integers. Map keys are sorted canonically, but nodes are
+// written in no particular order.
+func (report *CrawlOutput) WriteCBOR(w io.Writer) error {
+	return report.writeCBOR(w, false)
+}
+
+// writeCBOR implements WriteCBOR, optionally omitting nodes we were unable to
+// connect to.
+func (report *CrawlOutput) writeCBOR(w io.Writer, reachableOnly bool) error {
+	bw := bufio.NewWriter(w)
+	err := codec.NewEncoder(bw, newCBORHandle()).Encode(toCBOROutput(report.metadata(reachableOnly)))
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	return nil
+}
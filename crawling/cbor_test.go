@@ -0,0 +1,85 @@
+package crawling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/ugorji/go/codec"
+)
+
+func TestWriteCBORRoundTrip(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001"), ma.StringCast("/ip6/2001:db8::1/udp/4001/quic-v1")}},
+		}},
+		b: {Neighbors: []peer.AddrInfo{{ID: a}, {ID: c}}},
+		c: {Err: errors.New("unreachable")},
+	}, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = out.WriteCBOR(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded cborOutput
+	err = codec.NewDecoderBytes(buf.Bytes(), newCBORHandle()).Decode(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string][]string)
+	for id := range out.nodes {
+		expected[id.String()] = multiaddrStrings(out.addrInfo[id])
+	}
+	written := make(map[string][]string)
+	for _, node := range decoded.Nodes {
+		sort.Strings(node.MultiAddrs)
+		written[node.ID] = node.MultiAddrs
+		if (node.Result == nil) != (node.ID == c.String()) {
+			t.Errorf("unexpected result of %s: %+v", node.ID, node.Result)
+		}
+	}
+	for _, addrs := range expected {
+		sort.Strings(addrs)
+	}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected nodes %v, got %v", expected, written)
+	}
+	// The decoder rounds timestamps to microseconds.
+	startTs, endTs := out.startTs.Round(time.Microsecond), out.endTs.Round(time.Microsecond)
+	if !decoded.StartDate.Equal(startTs) || !decoded.EndDate.Equal(endTs) {
+		t.Errorf("expected timestamps %v and %v, got %v and %v", startTs, endTs, decoded.StartDate, decoded.EndDate)
+	}
+
+	// Floats stay floats, even if they are integral, like the mean
+	// out-degree of two here.
+	var generic map[string]interface{}
+	err = codec.NewDecoderBytes(buf.Bytes(), &codec.CborHandle{}).Decode(&generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := generic["stats"].(map[interface{}]interface{})
+	degrees := stats["degree_stats"].(map[interface{}]interface{})
+	mean, ok := degrees["mean_out_degree"].(float64)
+	if !ok || mean != out.Stats.Degrees.MeanOutDegree {
+		t.Errorf("expected mean out-degree %v, got %#v", out.Stats.Degrees.MeanOutDegree, degrees["mean_out_degree"])
+	}
+	if _, ok := generic["crawler_identities"].([]interface{})[0].(string); !ok {
+		t.Errorf("expected crawler identities as strings, got %#v", generic["crawler_identities"])
+	}
+}
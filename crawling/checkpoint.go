@@ -0,0 +1,384 @@
+package crawling
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkpointVersion is the version of the checkpoint file format.
+// This must be incremented whenever the format changes.
+const checkpointVersion = 15
+
+// checkpoint is the state of a crawl, as persisted to disk.
+// Errors are stored as their messages, connection and crawl errors together
+// with their categories, see errorCategory. Plugin results are stored as
+// JSON.
+type checkpoint struct {
+	Queue      []peer.ID
+	InProgress []peer.ID
+	AddrInfo   map[peer.ID][][]byte
+	FirstSeen  map[peer.ID]time.Time
+	Crawled    map[peer.ID]checkpointNode
+	PublicKeys map[peer.ID][]byte
+	Skipped    []peer.ID
+	Overflow   []peer.ID
+	Retries    []peer.ID
+}
+
+// checkpointNode is a nodeCrawlStatus, as persisted to disk.
+type checkpointNode struct {
+	StartTs     time.Time
+	EndTs       time.Time
+	Err         *string
+	ErrCategory string
+	Attempts    int
+	LastCrawled time.Time
+	Crawler     peer.ID
+
+	HasResult            bool
+	AgentVersion         string
+	SupportedProtocols   []protocol.ID
+	ListedProtocols      []protocol.ID
+	ConnectionState      network.ConnectionState
+	ConnectedAddr        []byte
+	RTT                  time.Duration
+	AddrReachability     map[string]bool
+	DialedAddrs          map[string]bool
+	Certified            bool
+	CertifiedAddrs       [][]byte
+	PluginResults        map[string]checkpointPluginResult
+	CrawlDataErr         *string
+	CrawlDataErrCategory string
+	CrawlDataBeginTs     time.Time
+	CrawlDataEndTs       time.Time
+	CrawlNeighbors       []peer.ID
+	NeighborCPLs         []int
+	BucketFill           []int
+	NovelNeighbors       []bool
+	ConflictingKeys      bool
+	PrefixLimitReached   bool
+	DHTProtocol          protocol.ID
+	ConnectionDropped    bool
+}
+
+// checkpointPluginResult is a pluginResult, as persisted to disk.
+type checkpointPluginResult struct {
+	BeginTs time.Time
+	EndTs   time.Time
+	Err     *string
+	Result  []byte
+}
+
+func errToString(err error) *string {
+	if err == nil {
+		return nil
+	}
+	tmp := err.Error()
+	return &tmp
+}
+
+func stringToErr(s *string) error {
+	if s == nil {
+		return nil
+	}
+	return errors.New(*s)
+}
+
+// A restoredError is an error restored from a checkpoint, which retains only
+// its message and its category, see errorCategory.
+type restoredError struct {
+	msg      string
+	category string
+}
+
+func (e *restoredError) Error() string {
+	return e.msg
+}
+
+// errToCategory returns the category of the error, or an empty string if it
+// is nil.
+func errToCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	return errorCategory(err)
+}
+
+// restoreErr restores an error persisted with errToString and errToCategory.
+func restoreErr(s *string, category string) error {
+	if s == nil {
+		return nil
+	}
+	return &restoredError{msg: *s, category: category}
+}
+
+// checkpoint writes the state of the crawl to the given path.
+// The file is replaced atomically.
+func (cm *CrawlManager) checkpoint(path string) error {
+	cp := checkpoint{
+		Queue:      cm.toCrawl.queue,
+		AddrInfo:   make(map[peer.ID][][]byte, len(cm.toCrawl.addrInfo)),
+		FirstSeen:  cm.toCrawl.firstSeen,
+		Crawled:    make(map[peer.ID]checkpointNode, len(cm.crawled)),
+		PublicKeys: make(map[peer.ID][]byte, len(cm.publicKeys)),
+	}
+	for id := range cm.crawlsInProgress {
+		cp.InProgress = append(cp.InProgress, id)
+	}
+	for id := range cm.skipped {
+		cp.Skipped = append(cp.Skipped, id)
+	}
+	for id := range cm.overflow {
+		cp.Overflow = append(cp.Overflow, id)
+	}
+	for _, entry := range cm.retries {
+		cp.Retries = append(cp.Retries, entry.id)
+	}
+	for id, addrs := range cm.toCrawl.addrInfo {
+		encoded := make([][]byte, 0, len(addrs))
+		for _, addr := range addrs {
+			encoded = append(encoded, addr.Bytes())
+		}
+		cp.AddrInfo[id] = encoded
+	}
+	for id, key := range cm.publicKeys {
+		encoded, err := crypto.MarshalPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("unable to encode public key: %w", err)
+		}
+		cp.PublicKeys[id] = encoded
+	}
+	for id, status := range cm.crawled {
+		node := checkpointNode{
+			StartTs:     status.startTs,
+			EndTs:       status.endTs,
+			Err:         errToString(status.err),
+			ErrCategory: errToCategory(status.err),
+			Attempts:    status.attempts,
+			LastCrawled: status.lastCrawled,
+			Crawler:     status.crawler,
+		}
+		if status.result != nil {
+			node.HasResult = true
+			node.AgentVersion = status.result.info.AgentVersion
+			node.SupportedProtocols = status.result.info.SupportedProtocols
+			node.ListedProtocols = status.result.info.ListedProtocols
+			node.ConnectionState = status.result.info.ConnectionState
+			if status.result.info.ConnectedAddr != nil {
+				node.ConnectedAddr = status.result.info.ConnectedAddr.Bytes()
+			}
+			node.RTT = status.result.info.RTT
+			node.AddrReachability = status.result.info.AddrReachability
+			node.DialedAddrs = status.result.info.DialedAddrs
+			node.Certified = status.result.info.Certified
+			for _, addr := range status.result.info.CertifiedAddrs {
+				node.CertifiedAddrs = append(node.CertifiedAddrs, addr.Bytes())
+			}
+			node.CrawlDataErr = errToString(status.result.crawlDataError)
+			node.CrawlDataErrCategory = errToCategory(status.result.crawlDataError)
+			node.CrawlDataBeginTs = status.result.crawlDataBeginTs
+			node.CrawlDataEndTs = status.result.crawlDataEndTs
+			node.CrawlNeighbors = status.result.crawlNeighbors
+			node.NeighborCPLs = status.result.neighborCPLs
+			node.BucketFill = status.result.bucketFill
+			node.NovelNeighbors = status.result.novelNeighbors
+			node.ConflictingKeys = status.result.conflictingKeys
+			node.PrefixLimitReached = status.result.prefixLimitReached
+			node.DHTProtocol = status.result.dhtProtocol
+			node.ConnectionDropped = status.result.connectionDropped
+			node.PluginResults = make(map[string]checkpointPluginResult, len(status.result.pluginResults))
+			for name, res := range status.result.pluginResults {
+				encoded, err := json.Marshal(res.result)
+				if err != nil {
+					return fmt.Errorf("unable to encode result of plugin %s: %w", name, err)
+				}
+				node.PluginResults[name] = checkpointPluginResult{
+					BeginTs: res.beginTimestamp,
+					EndTs:   res.endTimestamp,
+					Err:     errToString(res.err),
+					Result:  encoded,
+				}
+			}
+		}
+		cp.Crawled[id] = node
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("unable to create checkpoint file: %w", err)
+	}
+
+	enc := gob.NewEncoder(f)
+	err = enc.Encode(checkpointVersion)
+	if err == nil {
+		err = enc.Encode(cp)
+	}
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// ResumeFrom restores the state of a previous crawl from the checkpoint at the
+// given path.
+// Peers that were successfully crawled before will not be crawled again, but
+// the backlog of peers to crawl is continued.
+// This must be called before CrawlNetwork.
+func (cm *CrawlManager) ResumeFrom(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open checkpoint file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := gob.NewDecoder(f)
+	var version int
+	err = dec.Decode(&version)
+	if err != nil {
+		return fmt.Errorf("unable to read checkpoint version: %w", err)
+	}
+	if version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d, expected %d", version, checkpointVersion)
+	}
+	var cp checkpoint
+	err = dec.Decode(&cp)
+	if err != nil {
+		return fmt.Errorf("unable to decode checkpoint: %w", err)
+	}
+
+	for id, encoded := range cp.AddrInfo {
+		addrs := make([]ma.Multiaddr, 0, len(encoded))
+		for _, b := range encoded {
+			addr, err := ma.NewMultiaddrBytes(b)
+			if err != nil {
+				return fmt.Errorf("unable to decode address: %w", err)
+			}
+			addrs = append(addrs, addr)
+		}
+		// Checkpoints written by older versions may contain non-canonical
+		// addresses.
+		addrs = canonicalAddrs(id, addrs)
+		cm.toCrawl.addrInfo[id] = append(cm.toCrawl.addrInfo[id], filterOutOldAddresses(cm.toCrawl.addrInfo[id], addrs)...)
+	}
+
+	for id, ts := range cp.FirstSeen {
+		if old, ok := cm.toCrawl.firstSeen[id]; !ok || ts.Before(old) {
+			cm.toCrawl.firstSeen[id] = ts
+		}
+	}
+
+	for id, encoded := range cp.PublicKeys {
+		key, err := crypto.UnmarshalPublicKey(encoded)
+		if err != nil {
+			return fmt.Errorf("unable to decode public key: %w", err)
+		}
+		cm.publicKeys[id] = key
+	}
+
+	for id, node := range cp.Crawled {
+		status := nodeCrawlStatus{
+			startTs:     node.StartTs,
+			endTs:       node.EndTs,
+			err:         restoreErr(node.Err, node.ErrCategory),
+			attempts:    node.Attempts,
+			lastCrawled: node.LastCrawled,
+			crawler:     node.Crawler,
+		}
+		if node.HasResult {
+			status.result = &nodeInformation{
+				info: peerMetadata{
+					AgentVersion:       node.AgentVersion,
+					SupportedProtocols: node.SupportedProtocols,
+					ListedProtocols:    node.ListedProtocols,
+					ConnectionState:    node.ConnectionState,
+					RTT:                node.RTT,
+					AddrReachability:   node.AddrReachability,
+					DialedAddrs:        node.DialedAddrs,
+					Certified:          node.Certified,
+				},
+				pluginResults:      make(map[string]pluginResult, len(node.PluginResults)),
+				crawlDataError:     restoreErr(node.CrawlDataErr, node.CrawlDataErrCategory),
+				crawlDataBeginTs:   node.CrawlDataBeginTs,
+				crawlDataEndTs:     node.CrawlDataEndTs,
+				crawlNeighbors:     node.CrawlNeighbors,
+				neighborCPLs:       node.NeighborCPLs,
+				bucketFill:         node.BucketFill,
+				novelNeighbors:     node.NovelNeighbors,
+				conflictingKeys:    node.ConflictingKeys,
+				prefixLimitReached: node.PrefixLimitReached,
+				dhtProtocol:        node.DHTProtocol,
+				connectionDropped:  node.ConnectionDropped,
+			}
+			if node.ConnectedAddr != nil {
+				addr, err := ma.NewMultiaddrBytes(node.ConnectedAddr)
+				if err != nil {
+					return fmt.Errorf("unable to decode connected address: %w", err)
+				}
+				status.result.info.ConnectedAddr = addr
+			}
+			for _, b := range node.CertifiedAddrs {
+				addr, err := ma.NewMultiaddrBytes(b)
+				if err != nil {
+					return fmt.Errorf("unable to decode certified address: %w", err)
+				}
+				status.result.info.CertifiedAddrs = append(status.result.info.CertifiedAddrs, addr)
+			}
+			for name, res := range node.PluginResults {
+				status.result.pluginResults[name] = pluginResult{
+					beginTimestamp: res.BeginTs,
+					endTimestamp:   res.EndTs,
+					err:            stringToErr(res.Err),
+					result:         json.RawMessage(res.Result),
+				}
+			}
+		}
+		cm.crawled[id] = status
+	}
+
+	for _, id := range cp.Skipped {
+		cm.skipped[id] = struct{}{}
+	}
+	for _, id := range cp.Overflow {
+		cm.overflow[id] = struct{}{}
+		if cm.config.QueueOverflowPolicy == QueueOverflowBlock {
+			cm.deferred = append(cm.deferred, id)
+		}
+	}
+
+	// Crawls that were in progress and pending retries are simply re-queued.
+	for _, ids := range [][]peer.ID{cp.Queue, cp.InProgress, cp.Retries} {
+		for _, id := range ids {
+			if _, ok := cm.toCrawl.inQueue[id]; ok {
+				continue
+			}
+			cm.toCrawl.queue = append(cm.toCrawl.queue, id)
+			cm.toCrawl.inQueue[id] = struct{}{}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"path":    path,
+		"crawled": len(cp.Crawled),
+		"queued":  cm.toCrawl.len(),
+	}).Info("resumed crawl from checkpoint")
+
+	return nil
+}
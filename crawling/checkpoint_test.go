@@ -0,0 +1,36 @@
+package crawling
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestResumeFromCanonicalizesAddresses(t *testing.T) {
+	a, _ := newTestPeer(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, nil, a)
+	cm.toCrawl.addrInfo[a] = []ma.Multiaddr{
+		addr,
+		addr,
+		ma.StringCast("/ip6/::ffff:1.2.3.4/tcp/4001"),
+		addr.Encapsulate(ma.StringCast("/p2p/" + a.String())),
+	}
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	err := cm.checkpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{}, a)
+	err = resumed.ResumeFrom(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs := resumed.toCrawl.addrInfo[a]; len(addrs) != 1 || !addrs[0].Equal(addr) {
+		t.Errorf("expected only %s, got %v", addr, addrs)
+	}
+}
@@ -0,0 +1,111 @@
+package crawling
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ConnectedComponents computes the connected components of the peer graph
+// between crawlable nodes, i.e., the nodes whose routing tables we obtained.
+// Edges are treated as undirected.
+// Peers we did not crawl are left out, even if they connect crawlable nodes,
+// since we don't know their routing tables. Otherwise, every peer we only
+// learned about would form a component of its own.
+// Components are sorted by size, largest first, and the peers of each
+// component by ID.
+func ConnectedComponents(out *CrawlOutput) [][]peer.ID {
+	return connectedComponents(out.nodes)
+}
+
+// connectedComponents implements ConnectedComponents.
+func connectedComponents(nodes map[peer.ID]nodeCrawlStatus) [][]peer.ID {
+	crawlable := func(id peer.ID) bool {
+		node, ok := nodes[id]
+		return ok && node.err == nil && node.result.crawlDataError == nil
+	}
+
+	uf := newUnionFind()
+	for id, node := range nodes {
+		if !crawlable(id) {
+			continue
+		}
+		uf.add(id)
+		for _, n := range node.result.crawlNeighbors {
+			if crawlable(n) {
+				uf.union(id, n)
+			}
+		}
+	}
+
+	byRoot := make(map[peer.ID][]peer.ID)
+	for id := range uf.parent {
+		root := uf.find(id)
+		byRoot[root] = append(byRoot[root], id)
+	}
+
+	components := make([][]peer.ID, 0, len(byRoot))
+	for _, component := range byRoot {
+		sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+		components = append(components, component)
+	}
+	sort.Slice(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+
+	return components
+}
+
+// unionFind is a disjoint-set forest over peer IDs, with path compression and
+// union by size.
+type unionFind struct {
+	parent map[peer.ID]peer.ID
+	size   map[peer.ID]int
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{
+		parent: make(map[peer.ID]peer.ID),
+		size:   make(map[peer.ID]int),
+	}
+}
+
+// add adds the peer as a set of its own, unless it is known already.
+func (u *unionFind) add(id peer.ID) {
+	if _, ok := u.parent[id]; ok {
+		return
+	}
+	u.parent[id] = id
+	u.size[id] = 1
+}
+
+// find returns the representative of the peer's set.
+func (u *unionFind) find(id peer.ID) peer.ID {
+	root := id
+	for u.parent[root] != root {
+		root = u.parent[root]
+	}
+	for id != root {
+		id, u.parent[id] = u.parent[id], root
+	}
+	return root
+}
+
+// union merges the sets of the two peers, adding them if necessary.
+func (u *unionFind) union(a, b peer.ID) {
+	u.add(a)
+	u.add(b)
+	a, b = u.find(a), u.find(b)
+	if a == b {
+		return
+	}
+	if u.size[a] < u.size[b] {
+		a, b = b, a
+	}
+	u.parent[b] = a
+	u.size[a] += u.size[b]
+	delete(u.size, b)
+}
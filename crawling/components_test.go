@@ -0,0 +1,49 @@
+package crawling
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestConnectedComponents(t *testing.T) {
+	ids := make([]peer.ID, 6)
+	for i := range ids {
+		ids[i], _ = newTestPeer(t)
+	}
+	a, b, x, y, unreachable, uncrawlable := ids[0], ids[1], ids[2], ids[3], ids[4], ids[5]
+	addrInfo := func(id peer.ID) peer.AddrInfo {
+		return peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}}
+	}
+
+	// Two partitions, a-b and x-y, which both know peers we can't crawl.
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a:           {Neighbors: []peer.AddrInfo{addrInfo(b), addrInfo(unreachable), addrInfo(uncrawlable)}},
+		b:           {Neighbors: []peer.AddrInfo{addrInfo(a)}},
+		x:           {Neighbors: []peer.AddrInfo{addrInfo(y), addrInfo(unreachable), addrInfo(uncrawlable)}},
+		y:           {},
+		uncrawlable: {CrawlErr: ErrStreamFailed},
+	}, a, x)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]peer.ID{{a, b}, {x, y}}
+	for _, component := range expected {
+		sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i][0] < expected[j][0] })
+	components := ConnectedComponents(&out)
+	if !reflect.DeepEqual(components, expected) {
+		t.Errorf("expected components %v, got %v", expected, components)
+	}
+	if out.Stats.Components != 2 || out.Stats.LargestComponentSize != 2 {
+		t.Errorf("expected two components of size two, got %d of size %d", out.Stats.Components, out.Stats.LargestComponentSize)
+	}
+}
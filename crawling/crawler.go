@@ -1,9 +1,16 @@
 package crawling
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
@@ -13,7 +20,12 @@ import (
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-msgio"
 	"github.com/libp2p/go-msgio/protoio"
+	"github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // CrawlerConfig contains the configuration for the crawler.
@@ -22,6 +34,45 @@ type CrawlerConfig struct {
 
 	InteractionTimeout  time.Duration `yaml:"interaction_timeout"`
 	InteractionAttempts uint          `yaml:"interaction_attempts"`
+
+	// The maximum size of a DHT response to read, in bytes.
+	// Larger responses are skipped, see crawler.oversizedResponse.
+	// Defaults to network.MessageSizeMax.
+	MaxMessageSize int `yaml:"max_message_size"`
+
+	// Whether to record the number of peers returned for each bucket, see
+	// crawlData.bucketFill.
+	RecordBucketFill bool `yaml:"record_bucket_fill"`
+
+	// Whether to record, for each neighbor, the CPL of the first request
+	// that returned it, see crawlData.neighborCPLs.
+	RecordNeighborCPL bool `yaml:"record_neighbor_cpl"`
+
+	// Whether to ask peers which support none of the protocols for the
+	// protocols they do support, using the multistream-select ls command.
+	ProbeUnsupportedProtocols bool `yaml:"probe_unsupported_protocols"`
+
+	// Whether to measure the round-trip time to each connectable peer using
+	// the libp2p ping protocol, see Libp2pWorker.measureLatency.
+	MeasureLatency bool `yaml:"measure_latency"`
+
+	// Whether to dial each known address of each connectable peer
+	// separately, to check which of them are reachable, see
+	// Libp2pWorker.probeAddrs.
+	ProbeAllAddresses bool `yaml:"probe_all_addresses"`
+
+	// Directory to write the FIND_NODE responses of each crawled peer to,
+	// for debugging, if set.
+	// Each worker writes one JSON object per crawled peer to
+	// findnode_<worker ID>.jsonl in this directory, see findNodeRecord.
+	DebugDumpPath string `yaml:"debug_dump_path"`
+
+	// The name of the network being crawled, to label metrics with.
+	// This is set by the CrawlManager, see CrawlManagerConfig.Network.
+	network string
+	// The tracer to record spans with, or nil if tracing is disabled.
+	// This is set by the CrawlManager, see CrawlManagerConfig.Tracing.
+	tracer trace.Tracer
 }
 
 func (c CrawlerConfig) check() error {
@@ -34,65 +85,239 @@ func (c CrawlerConfig) check() error {
 	if c.InteractionTimeout <= time.Duration(0) {
 		return fmt.Errorf("missing interaction timeout")
 	}
+	if c.MaxMessageSize < 0 {
+		return fmt.Errorf("invalid max message size")
+	}
 
 	return nil
 }
 
+// maxMessageSize returns the configured maximum size of DHT responses, or the
+// default.
+func (c CrawlerConfig) maxMessageSize() int {
+	if c.MaxMessageSize == 0 {
+		return network.MessageSizeMax
+	}
+	return c.MaxMessageSize
+}
+
 type crawler struct {
 	config CrawlerConfig
 
 	h               host.Host
 	preimageHandler *PreimageHandler
 
+	// The debug dump of FIND_NODE responses, if enabled.
+	dump *findNodeDump
+
+	// The number of FIND_NODE queries sent.
+	queries atomic.Uint64
+
 	shutdownM sync.Mutex
 	shutdown  chan struct{}
 }
 
+var (
+	negotiatedProtocols = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_crawler_crawler_negotiated_protocols_total",
+		Help: "The number of DHT streams opened to crawl peers, by the negotiated protocol.",
+	}, []string{"network", "protocol"})
+	protocolNegotiationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_crawler_crawler_protocol_negotiation_failures_total",
+		Help: "The number of connected peers which support none of our DHT protocols.",
+	}, []string{"network"})
+	findNodeResponsePeers = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ipfs_crawler_crawler_find_node_response_peers",
+		Help:    "The number of peers returned in successful FIND_NODE responses.",
+		Buckets: prometheus.LinearBuckets(0, 4, 6),
+	}, []string{"network"})
+	streamResets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_crawler_worker_stream_resets_total",
+		Help: "The number of DHT streams reset by the remote peer while crawling it.",
+	}, []string{"network"})
+	oversizedResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_crawler_crawler_oversized_responses_total",
+		Help: "The number of DHT responses skipped because they exceeded the maximum message size.",
+	}, []string{"network"})
+)
+
 func newCrawler(h host.Host, c CrawlerConfig, ph *PreimageHandler) (*crawler, error) {
 	err := c.check()
 	if err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
+	var dump *findNodeDump
+	if len(c.DebugDumpPath) != 0 {
+		dump, err = newFindNodeDump(c.DebugDumpPath, h.ID())
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &crawler{
 		config:          c,
 		h:               h,
 		preimageHandler: ph,
+		dump:            dump,
 		shutdown:        make(chan struct{}),
 	}, nil
 }
 
-// HandlePeer (almost) implements Plugin, except for the return type.
-func (c *crawler) HandlePeer(p peer.AddrInfo) (*crawlData, error) {
-	// Roadmap:
-	// 1) Start a new stream = subprotocol exchange
-	// 2) Send FindNode(s)
-	// 3) Parse responses
-
-	// Create a new stream
+// openStream opens a new stream to the peer, using any of the configured DHT
+// protocols.
+func (c *crawler) openStream(ctx context.Context, p peer.ID) (network.Stream, error) {
 	var dhtStream network.Stream
 	var err error
 	for i := uint(0); i < c.config.InteractionAttempts; i++ {
-		ctx, cancel := context.WithTimeout(context.Background(), c.config.InteractionTimeout)
+		ctx, cancel := context.WithTimeout(ctx, c.config.InteractionTimeout)
 		defer cancel()
-		dhtStream, err = c.h.NewStream(ctx, p.ID, c.config.ProtocolStrings...)
+		dhtStream, err = c.h.NewStream(ctx, p, c.config.ProtocolStrings...)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"err":    err,
 				"try":    i + 1,
-				"peerID": p.ID,
+				"peerID": p,
 			}).Debug("could not open stream")
 		} else {
 			break
 		}
 	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to open stream: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrStreamFailed, err)
+	}
+
+	return dhtStream, nil
+}
+
+// reopenStream replaces a stream which was reset by the peer.
+// The old stream is closed via its reader.
+func (c *crawler) reopenStream(ctx context.Context, p peer.ID, old msgio.ReadCloser) (network.Stream, msgio.ReadCloser, error) {
+	_ = old.Close()
+
+	s, err := c.openStream(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	negotiatedProtocols.WithLabelValues(c.config.network, string(s.Protocol())).Inc()
+
+	return s, msgio.NewVarintReaderSize(s, c.config.maxMessageSize()), nil
+}
+
+// oversizedResponse reports whether the error is due to a response exceeding
+// the maximum message size, in which case it is logged and counted.
+// The response is still unread, so the stream can't be used anymore. Asking
+// again on a new stream would likely return the same response.
+func (c *crawler) oversizedResponse(err error, p peer.ID) bool {
+	if !errors.Is(err, msgio.ErrMsgTooLarge) {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"peer":             p,
+		"max_message_size": c.config.maxMessageSize(),
+	}).Warn("skipping oversized response")
+	oversizedResponses.WithLabelValues(c.config.network).Inc()
+	return true
+}
+
+// findProviders asks the peer for providers of the content with the given
+// key, i.e., the multihash of its CID.
+// Returns the providers and the peers closer to the key the peer knows of.
+func (c *crawler) findProviders(ctx context.Context, p peer.ID, key []byte) ([]peer.AddrInfo, []peer.AddrInfo, error) {
+	s, err := c.openStream(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = s.Close() }()
+
+	recvReader := msgio.NewVarintReaderSize(s, c.config.maxMessageSize())
+	defer recvReader.Close()
+
+	var providers, closer []peer.AddrInfo
+	for i := uint(0); i < c.config.InteractionAttempts; i++ {
+		ctx, cancel := context.WithTimeout(ctx, c.config.InteractionTimeout)
+		defer cancel()
+		c.queries.Add(1)
+		providers, closer, err = sendGetProviders(ctx, recvReader, key, s)
+		if err == nil || c.oversizedResponse(err, p) {
+			break
+		}
+		log.WithFields(log.Fields{
+			"err":      err,
+			"try":      i + 1,
+			"destAddr": p,
+		}).Debug("failed to send GET_PROVIDERS")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get providers: %w", err)
+	}
+
+	return providers, closer, nil
+}
+
+// findNode asks the peer for the peers closest to the given key it knows of.
+func (c *crawler) findNode(ctx context.Context, p peer.ID, key []byte) ([]peer.AddrInfo, error) {
+	s, err := c.openStream(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = s.Close() }()
+
+	recvReader := msgio.NewVarintReaderSize(s, c.config.maxMessageSize())
+	defer recvReader.Close()
+
+	var closer []peer.AddrInfo
+	for i := uint(0); i < c.config.InteractionAttempts; i++ {
+		ctx, cancel := context.WithTimeout(ctx, c.config.InteractionTimeout)
+		defer cancel()
+		c.queries.Add(1)
+		closer, err = sendFindNode(ctx, recvReader, key, s)
+		if err == nil || c.oversizedResponse(err, p) {
+			break
+		}
+		log.WithFields(log.Fields{
+			"err":      err,
+			"try":      i + 1,
+			"destAddr": p,
+		}).Debug("failed to send FIND_NODE")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find closer peers: %w", err)
+	}
+
+	return closer, nil
+}
+
+// HandlePeer (almost) implements Plugin, except for the context and the return
+// type.
+func (c *crawler) HandlePeer(ctx context.Context, p peer.AddrInfo) (*crawlData, error) {
+	// Roadmap:
+	// 1) Start a new stream = subprotocol exchange
+	// 2) Send FindNode(s)
+	// 3) Parse responses
+
+	// Create a new stream
+	dhtStream, err := c.openStream(ctx, p.ID)
+	if err != nil {
+		if !errors.Is(err, multistream.ErrNotSupported[protocol.ID]{}) {
+			return nil, err
+		}
+		protocolNegotiationFailures.WithLabelValues(c.config.network).Inc()
+		if c.config.ProbeUnsupportedProtocols {
+			protocols, lsErr := c.listProtocols(ctx, p.ID)
+			if lsErr != nil {
+				log.WithError(lsErr).WithField("peerID", p.ID).Debug("unable to list supported protocols")
+			} else {
+				return nil, &unsupportedProtocolsError{err: err, protocols: protocols}
+			}
+		}
+		return nil, err
 	}
 	defer func() { _ = dhtStream.Close() }()
+	negotiatedProtocols.WithLabelValues(c.config.network, string(dhtStream.Protocol())).Inc()
 
 	crawlStartedTs := time.Now()
-	neighbors, err := c.fullNeighborCrawl(dhtStream, p.ID)
+	neighbors, neighborCPLs, bucketFill, prefixLimitReached, err := c.fullNeighborCrawl(ctx, dhtStream, p.ID)
 	if err != nil {
 		if len(neighbors) == 0 {
 			// We got nothing and a lot of things went wrong, might as well report that...
@@ -104,25 +329,156 @@ func (c *crawler) HandlePeer(p peer.AddrInfo) (*crawlData, error) {
 	// TODO maybe this is not optimal
 	return &crawlData{
 		neighbors:              neighbors,
+		neighborCPLs:           neighborCPLs,
+		bucketFill:             bucketFill,
+		prefixLimitReached:     prefixLimitReached,
+		protocol:               dhtStream.Protocol(),
 		crawlStartedTimestamp:  crawlStartedTs,
 		crawlFinishedTimestamp: time.Now(),
 	}, nil
 }
 
+// ErrStreamFailed is returned if we connected to a peer, but were unable to
+// open a stream using any of the DHT protocols.
+// It wraps the underlying error.
+var ErrStreamFailed = errors.New("unable to open stream")
+
+// unsupportedProtocolsError is returned by HandlePeer if the peer supports none
+// of the configured protocols, but told us which protocols it does support.
+type unsupportedProtocolsError struct {
+	err       error
+	protocols []protocol.ID
+}
+
+func (e *unsupportedProtocolsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unsupportedProtocolsError) Unwrap() error {
+	return e.err
+}
+
+// listProtocols asks the peer for the protocols it supports, using the ls
+// command of multistream-select.
+// This requires an existing connection to the peer.
+// Note that many implementations no longer support ls.
+func (c *crawler) listProtocols(ctx context.Context, p peer.ID) ([]protocol.ID, error) {
+	conns := c.h.Network().ConnsToPeer(p)
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.InteractionTimeout)
+	defer cancel()
+	// We need a raw stream, because the host would negotiate a protocol.
+	s, err := conns[0].NewStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open stream: %w", err)
+	}
+	defer func() { _ = s.Reset() }()
+	_ = s.SetDeadline(time.Now().Add(c.config.InteractionTimeout))
+
+	var msg []byte
+	msg = appendDelimited(msg, []byte(multistream.ProtocolID))
+	msg = appendDelimited(msg, []byte("ls"))
+	_, err = s.Write(msg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send ls: %w", err)
+	}
+
+	r := bufio.NewReader(s)
+	header, err := readDelimited(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+	if string(header) != multistream.ProtocolID {
+		return nil, fmt.Errorf("unexpected header: %q", header)
+	}
+	response, err := readDelimited(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response: %w", err)
+	}
+	if string(response) == "na" {
+		return nil, fmt.Errorf("ls not supported")
+	}
+
+	// The response is itself a list of delimited protocol IDs.
+	var protocols []protocol.ID
+	br := bytes.NewReader(response)
+	for br.Len() > 0 {
+		proto, err := readDelimited(br)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse response: %w", err)
+		}
+		protocols = append(protocols, protocol.ID(proto))
+	}
+
+	return protocols, nil
+}
+
+// appendDelimited appends a length-prefixed, newline-terminated
+// multistream-select message to buf.
+func appendDelimited(buf []byte, msg []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(msg)+1))
+	buf = append(buf, msg...)
+	return append(buf, '\n')
+}
+
+// readDelimited reads a length-prefixed, newline-terminated
+// multistream-select message, without the newline.
+func readDelimited(r interface {
+	io.Reader
+	io.ByteReader
+}) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > network.MessageSizeMax {
+		return nil, fmt.Errorf("message too large")
+	}
+
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 || buf[length-1] != '\n' {
+		return nil, fmt.Errorf("message did not have trailing newline")
+	}
+
+	return buf[:length-1], nil
+}
+
 // fullNeighborCrawl systematically reads the dht buckets from remote node.
 //
 // Asks the remote node for the closest peers to a given prefix the remote knows.
 // Iterates through the prefixes until no new peers are learned.
+// If configured, also returns the CPL at which each neighbor was first
+// returned and the number of peers received for each bucket, indexed by CPL.
+// Also returns whether we were still learning new peers at the maximum CPL.
+// The remote node itself and our own ID are never returned as neighbors.
+// If the peer resets the stream, the request is retried on a new stream.
+// Responses exceeding the maximum message size are skipped, continuing with the
+// next bucket on a new stream.
 // Returns an error if connecting fails, or message passing fails entirely.
-func (c *crawler) fullNeighborCrawl(s network.Stream, p peer.ID) ([]peer.AddrInfo, error) {
+func (c *crawler) fullNeighborCrawl(ctx context.Context, s network.Stream, p peer.ID) ([]peer.AddrInfo, []int, []int, bool, error) {
 	// Start with a common prefix length of 0 and successively move to closer IDs until we either
 	// learn no new peers or our hard cap for the CPL pre-computation is reached.
 	var neighbors []peer.AddrInfo
+	var neighborCPLs []int
+	var bucketFill []int
+	var prefixLimitReached bool
 	var err error
 	seenIDs := make(map[peer.ID]struct{})
+	var record *findNodeRecord
+	if c.dump != nil {
+		record = &findNodeRecord{Peer: p, Ts: time.Now()}
+	}
 
-	recvReader := msgio.NewVarintReaderSize(s, network.MessageSizeMax)
-	defer recvReader.Close()
+	recvReader := msgio.NewVarintReaderSize(s, c.config.maxMessageSize())
+	// The reader closes the stream, which may have been replaced after a reset.
+	defer func() { _ = recvReader.Close() }()
 
 	// We ask at least four times, or until we learn no new peers.
 	// TODO we could create parallel streams, one per CPL, and ask concurrently.
@@ -136,17 +492,52 @@ func (c *crawler) fullNeighborCrawl(s network.Stream, p peer.ID) ([]peer.AddrInf
 		}).Trace("Sending FindNode.")
 
 		var peerResponse []peer.AddrInfo
+		streamLost := false
+		cpl := i
 		for i := uint(0); i < c.config.InteractionAttempts; i++ {
-			ctx, cancel := context.WithTimeout(context.Background(), c.config.InteractionTimeout)
+			reqCtx, cancel := context.WithTimeout(ctx, c.config.InteractionTimeout)
 			defer cancel()
-			peerResponse, err = sendFindNode(ctx, recvReader, target, s)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"err":      err,
-					"try":      i + 1,
-					"destAddr": p,
-				}).Debug("failed to send FIND_NODE")
-			} else {
+			reqCtx, span := startSpan(reqCtx, c.config.tracer, "find_node")
+			c.queries.Add(1)
+			peerResponse, err = sendFindNode(reqCtx, recvReader, target, s)
+			if span.IsRecording() {
+				span.SetAttributes(
+					attribute.String("peer.id", p.String()),
+					attribute.String("dht.protocol", string(s.Protocol())),
+					attribute.Int("dht.cpl", cpl),
+					attribute.Int("dht.peers_returned", len(peerResponse)),
+					attribute.Int("attempt", int(i+1)),
+				)
+			}
+			spanError(span, err)
+			span.End()
+			if err == nil {
+				break
+			}
+			log.WithFields(log.Fields{
+				"err":      err,
+				"try":      i + 1,
+				"destAddr": p,
+			}).Debug("failed to send FIND_NODE")
+
+			reset := errors.Is(err, network.ErrReset)
+			if reset {
+				// A reset stream can't be used anymore, but the peer might
+				// still answer on a new one.
+				streamResets.WithLabelValues(c.config.network).Inc()
+			}
+			oversized := c.oversizedResponse(err, p)
+			if reset || oversized {
+				newStream, newReader, openErr := c.reopenStream(ctx, p, recvReader)
+				if openErr != nil {
+					log.WithError(openErr).WithField("peer", p).Debug("unable to reopen stream")
+					streamLost = true
+					break
+				}
+				s, recvReader = newStream, newReader
+			}
+			if oversized {
+				// We skip this bucket and continue with the next one.
 				break
 			}
 		}
@@ -154,26 +545,62 @@ func (c *crawler) fullNeighborCrawl(s network.Stream, p peer.ID) ([]peer.AddrInf
 			log.WithError(err).WithField("peer", p).WithField("bucket", i).Debug("failed to crawl bucket")
 		} else {
 			log.WithField("bucket", i).WithField("peers", peerResponse).WithField("peer", p).Debug("crawled bucket")
+			findNodeResponsePeers.WithLabelValues(c.config.network).Observe(float64(len(peerResponse)))
+		}
+		if c.config.RecordBucketFill {
+			bucketFill = append(bucketFill, len(peerResponse))
 		}
 
-		for _, p := range peerResponse {
-			if _, ok := seenIDs[p.ID]; ok {
+		seenBefore := len(seenIDs)
+		for _, ai := range peerResponse {
+			// Some implementations return themselves, and we might be in
+			// the peer's routing table, too. Neither is a neighbor.
+			if ai.ID == p || ai.ID == c.h.ID() {
 				continue
 			}
-			seenIDs[p.ID] = struct{}{}
-			neighbors = append(neighbors, p)
+			if _, ok := seenIDs[ai.ID]; ok {
+				continue
+			}
+			seenIDs[ai.ID] = struct{}{}
+			neighbors = append(neighbors, ai)
+			if c.config.RecordNeighborCPL {
+				neighborCPLs = append(neighborCPLs, i)
+			}
 			anyNewPeers = true
 		}
+		if record != nil {
+			entry := findNodeRecordCPL{
+				CPL:                i,
+				Target:             hex.EncodeToString(target),
+				Peers:              len(peerResponse),
+				NewPeers:           len(seenIDs) - seenBefore,
+				CumulativeNewPeers: len(seenIDs),
+				Error:              errToString(err),
+			}
+			record.Requests = append(record.Requests, entry)
+		}
+		if streamLost {
+			// Without a stream, the remaining buckets would fail, too.
+			break
+		}
 		if anyNewPeers && i == 23 {
 			// This is not always an error: if we're too slow and the peer
 			// concurrently modifies its routing table, this will be triggered,
 			// too.
+			prefixLimitReached = true
 			log.WithField("peer", p).Debug("prefix limit reached during crawling. Closer buckets are not dumped. Please report this via Github")
 		}
 	}
 
+	if record != nil {
+		dumpErr := c.dump.write(*record)
+		if dumpErr != nil {
+			log.WithError(dumpErr).Warn("unable to write debug dump")
+		}
+	}
+
 	// Everything went well (enough)
-	return neighbors, err
+	return neighbors, neighborCPLs, bucketFill, prefixLimitReached, err
 }
 
 // sendFindNode probes the remote node for neighborhood nodes.
@@ -183,8 +610,45 @@ func (c *crawler) fullNeighborCrawl(s network.Stream, p peer.ID) ([]peer.AddrInf
 // :param remotePeerStream: Connection to remote node
 // :return: list of received peer adresses
 func sendFindNode(ctx context.Context, recvReader msgio.Reader, target []byte, s network.Stream) ([]peer.AddrInfo, error) {
+	response, err := sendRequest(ctx, recvReader, pb.NewMessage(pb.Message_FIND_NODE, target, 0), s)
+	if err != nil {
+		return nil, err
+	}
+
+	return derefAddrInfos(pb.PBPeersToPeerInfos(response.GetCloserPeers())), nil
+}
+
+// sendGetProviders asks the remote node for providers of some content.
+// :param ctx: controlling context
+// :param recvReader: Reader/parser for the responses
+// :param key: the multihash of the CID of the content
+// :param s: Connection to remote node
+// :return: list of received provider adresses, list of received closer peers
+func sendGetProviders(ctx context.Context, recvReader msgio.Reader, key []byte, s network.Stream) ([]peer.AddrInfo, []peer.AddrInfo, error) {
+	response, err := sendRequest(ctx, recvReader, pb.NewMessage(pb.Message_GET_PROVIDERS, key, 0), s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	providers := derefAddrInfos(pb.PBPeersToPeerInfos(response.GetProviderPeers()))
+	closer := derefAddrInfos(pb.PBPeersToPeerInfos(response.GetCloserPeers()))
+	return providers, closer, nil
+}
+
+// derefAddrInfos converts the peer infos of a response to values.
+func derefAddrInfos(infos []*peer.AddrInfo) []peer.AddrInfo {
+	var pi []peer.AddrInfo
+	for _, p := range infos {
+		pi = append(pi, *p)
+	}
+	return pi
+}
+
+// sendRequest sends a DHT request on the stream and waits for the response or
+// the context to expire.
+func sendRequest(ctx context.Context, recvReader msgio.Reader, msg *pb.Message, s network.Stream) (*pb.Message, error) {
 	// Send the packet to the target host and wait for the response or context timeout
-	err := protoio.NewDelimitedWriter(s).WriteMsg(pb.NewMessage(pb.Message_FIND_NODE, target, 0))
+	err := protoio.NewDelimitedWriter(s).WriteMsg(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -222,26 +686,21 @@ func sendFindNode(ctx context.Context, recvReader msgio.Reader, target []byte, s
 		// The context timed out, abort sending/receiving and return.
 		return nil, ctx.Err()
 
-	case msg := <-responseChan:
+	case msgbytes := <-responseChan:
 		// We (deliberately) introduce a race condition with the async reader, since we both listen on the context
 		// channel. We need to check for that here.
 		if ctx.Err() != nil {
-			return nil, err
+			return nil, ctx.Err()
 		}
 
 		// Parse the request and then signal that the msgbytes-buffer can be used again
-		err = response.Unmarshal(msg)
+		err = response.Unmarshal(msgbytes)
 		if err != nil {
-			log.WithError(err).Warn("unable to unmarshal FIND_NODE response")
+			log.WithError(err).WithField("type", msg.GetType()).Warn("unable to unmarshal response")
 			return nil, err
 		}
-		recvReader.ReleaseMsg(msg)
-		peerInfo := pb.PBPeersToPeerInfos(response.GetCloserPeers())
-		var pi []peer.AddrInfo
-		for _, p := range peerInfo {
-			pi = append(pi, *p)
-		}
-		return pi, nil
+		recvReader.ReleaseMsg(msgbytes)
+		return &response, nil
 
 	case err := <-errChan:
 		// We (deliberately) introduce a race condition with the async reader, since we both listen on the context
@@ -261,12 +720,11 @@ func (c *crawler) Shutdown() error {
 	select {
 	case <-c.shutdown:
 		// already shut down
+		return nil
 	default:
 		// not yet shut down
 		close(c.shutdown)
 	}
 
-	// Boilerplate, empty.
-
-	return nil
+	return c.dump.close()
 }
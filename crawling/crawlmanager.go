@@ -1,25 +1,101 @@
 package crawling
 
 import (
+	"container/heap"
+	"context"
+	crand "crypto/rand"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multistream"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // CrawlOutput is the output of a crawl.
 type CrawlOutput struct {
+	// Summary statistics about the crawl.
+	Stats CrawlStats
+	// The name of the network crawled, see CrawlManagerConfig.WithNetwork,
+	// or empty.
+	Network string
+	// A unique ID of the crawl, formatted as a UUID, see
+	// CrawlManagerConfig.CrawlIDSeed.
+	CrawlID string
+	// The configuration used for the crawl.
+	Config CrawlManagerConfig
+
 	nodes    map[peer.ID]nodeCrawlStatus
 	addrInfo map[peer.ID][]ma.Multiaddr
+	// When we first learned about each peer.
+	firstSeen map[peer.ID]time.Time
+
+	// When the crawl started and finished.
+	startTs time.Time
+	endTs   time.Time
+
+	// The peer IDs of the workers used for the crawl.
+	crawlerIDs []peer.ID
+
+	// Whether we recorded for each edge whether the target was novel.
+	edgeNovelty bool
+	// Whether we recorded for each edge the CPL at which it was found.
+	edgeCPL bool
+
+	// The maximum number of addresses to output per node, or zero for no
+	// limit.
+	maxAddrs uint
+
+	// The results of checking the configured canaries, if any.
+	sanity []SanityResult
+
+	// Why the crawl was stopped before all peers were crawled, or empty.
+	stopReason string
+
+	// The number of reachable nodes per agent version.
+	agentVersionCounts map[string]int
+
+	// Peers we did not probe, because they had no address for any of the
+	// enabled transports.
+	skipped map[peer.ID]struct{}
+	// Peers we did not queue, because the queue was full.
+	overflow map[peer.ID]struct{}
 }
 
+// Policies for peers found while the crawl queue is full, see
+// CrawlManagerConfig.MaxQueueDepth.
+const (
+	// QueueOverflowDrop does not queue new peers, but remembers them as
+	// known, so that they appear in the peer graph.
+	QueueOverflowDrop = "drop"
+	// QueueOverflowBlock holds back new peers and further results until
+	// there is room in the queue.
+	QueueOverflowBlock = "block"
+)
+
 // CrawlManagerConfig contains configuration for the crawl manager.
 type CrawlManagerConfig struct {
 	// Path to the preimage file.
 	PreimageFilePath string `yaml:"preimage_file_path"`
+	// Path to a preimage cache, see NewPreimageHandlerFromFile.
+	// Exactly one of PreimageFilePath and PreimageCachePath must be set.
+	PreimageCachePath string `yaml:"preimage_cache_path"`
 
 	NumWorkers         uint           `yaml:"num_workers"`
 	BootstrapPeers     []string       `yaml:"bootstrap_peers"`
@@ -27,32 +103,354 @@ type CrawlManagerConfig struct {
 	WorkerConfig       WorkerConfig   `yaml:"worker_config"`
 	Plugins            []PluginConfig `yaml:"plugins"`
 	CrawlerConfig      CrawlerConfig  `yaml:"crawler_config"`
+
+	// Path to a file listing additional bootstrap peers, see LoadSeedPeers.
+	BootstrapPeersFile string `yaml:"bootstrap_peers_file"`
+
+	// Whether to add the public IPFS bootstrap nodes to the bootstrap peers,
+	// see DefaultBootstrapPeers.
+	// At least one of BootstrapPeers, BootstrapPeersFile, and this must be
+	// set, unless DisableExpansion is set.
+	UseDefaultBootstrapPeers bool `yaml:"use_default_bootstrap_peers"`
+
+	// The number of workers to create concurrently at startup.
+	// Defaults to one, i.e., workers are created one after another.
+	HostInitConcurrency uint `yaml:"host_init_concurrency"`
+
+	// Whether to keep private and loopback addresses of peers.
+	// This is useful when crawling a private overlay network.
+	KeepLocalAddrs bool `yaml:"keep_local_addrs"`
+	// Whether to keep relayed (p2p-circuit) addresses of peers, even if the
+	// relay itself is on a private or loopback address.
+	KeepRelayAddrs bool `yaml:"keep_relay_addrs"`
+
+	// Path to periodically write checkpoints of the crawl to, if set.
+	// A crawl can be resumed from a checkpoint via ResumeFrom.
+	CheckpointPath string `yaml:"checkpoint_path"`
+	// The interval at which checkpoints are written.
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+
+	// The maximum number of bytes to transfer during a crawl, summed over
+	// all workers, or zero for no limit.
+	// Once this is exceeded, no new requests are dispatched, and the crawl
+	// stops after all requests in flight have finished.
+	CrawlBudgetBytes uint64 `yaml:"crawl_budget_bytes"`
+	// The maximum number of DHT queries to send during a crawl, summed over
+	// all workers, or zero for no limit.
+	// This is enforced in the same way as CrawlBudgetBytes.
+	CrawlBudgetQueries uint64 `yaml:"crawl_budget_queries"`
+	// The maximum number of peers to probe during a crawl, or zero for no
+	// limit, e.g., for bounded experiments on large networks.
+	// This is enforced in the same way as CrawlBudgetBytes, so the results
+	// of requests in flight once the limit is reached are still recorded.
+	MaxNodes int `yaml:"max_nodes"`
+	// How long to wait for requests in flight once the crawl is stopped
+	// early, i.e., because the budget was exceeded, MaxNodes or
+	// MaxCrawlDuration was reached, or the crawl was cancelled.
+	// Results of requests still in flight after that are discarded.
+	// Defaults to waiting until all requests have finished.
+	DrainTimeout *time.Duration `yaml:"drain_timeout"`
+	// The maximum duration of a crawl, or zero for no limit.
+	// Once this is exceeded, no new requests are dispatched, even if the
+	// crawl is still making progress, see DrainTimeout.
+	MaxCrawlDuration time.Duration `yaml:"max_crawl_duration"`
+	// The interval at which a Scheduler starts crawls, or zero to crawl only
+	// once.
+	CrawlInterval time.Duration `yaml:"crawl_interval"`
+
+	// Whether to record, for each neighbor of a crawled node, whether we
+	// learned about the neighbor for the first time from that node.
+	RecordEdgeNovelty bool `yaml:"record_edge_novelty"`
+
+	// The maximum number of addresses to output per node.
+	// The most recently learned addresses are kept, the total number of
+	// addresses is output regardless.
+	// Defaults to zero, which disables the limit.
+	MaxStoredAddrsPerNode uint `yaml:"max_stored_addrs_per_node"`
+
+	// Whether to only crawl the bootstrap peers and peers added via
+	// AddPeersToCrawl, without queueing the peers found in their routing
+	// tables.
+	// If this is set, bootstrap peers may be empty.
+	DisableExpansion bool `yaml:"disable_expansion"`
+
+	// Whether to only log the peers a crawl would start with and the
+	// effective config, without dialing any peer.
+	// CrawlNetwork then returns an empty report, and CrawlSinglePeer,
+	// LookupKey, and FindProvidersForCID return ErrDryRun.
+	// No hosts are created by NewCrawlManager.
+	DryRun bool `yaml:"dry_run"`
+
+	// Whether to record OpenTelemetry spans for crawling each peer, with
+	// child spans for connecting to it and for each FIND_NODE request, see
+	// setUpTracing.
+	Tracing bool `yaml:"tracing"`
+	// The provider of the tracer to record spans with, if Tracing is
+	// enabled. This can only be set programmatically.
+	// Defaults to the global provider, see otel.GetTracerProvider.
+	TracerProvider trace.TracerProvider `yaml:"-"`
+
+	// The number of times to retry probing a peer we were unable to connect
+	// to, to avoid false negatives due to transient network issues.
+	// Each retry is again made up of up to WorkerConfig.ConnectionAttempts
+	// connection attempts.
+	// Defaults to zero, which disables retries.
+	MaxRetries uint `yaml:"max_retries"`
+	// The delay before the first retry, which doubles with every retry.
+	RetryBaseDelay time.Duration `yaml:"retry_base_delay"`
+
+	// The fraction of peers found during the crawl to crawl, to quickly
+	// take an approximate snapshot of a large network.
+	// Whether a peer is crawled is derived from its ID and SampleSeed, see
+	// inSample. Peers which are not crawled still appear in the peer graph.
+	// Bootstrap peers and peers added via AddPeersToCrawl are always crawled,
+	// so a rate of zero crawls only those.
+	// If unset, sampling is disabled, i.e., all peers are crawled.
+	SampleRate *float64 `yaml:"sample_rate"`
+	SampleSeed int64    `yaml:"sample_seed"`
+
+	// The maximum number of peers waiting to be crawled, to bound memory
+	// usage when crawling large networks.
+	// Once the queue is full, peers found in routing tables are handled
+	// according to QueueOverflowPolicy. Bootstrap peers, peers added via
+	// AddPeersToCrawl, and retries are always queued.
+	// Must be at least NumWorkers * ConcurrentRequests, so that the queue can
+	// keep all workers busy.
+	// Defaults to zero, which disables the limit.
+	MaxQueueDepth int `yaml:"max_queue_depth"`
+	// What to do with peers found while the queue is full, one of "drop" or
+	// "block", see QueueOverflowDrop and QueueOverflowBlock.
+	// Dropped peers are crawled if they are found again once there is room.
+	// When blocking, peers found while the queue is full are queued as soon
+	// as there is room, and further results are held back until then.
+	// Crawling continues while results are held back, so these results take
+	// up memory instead.
+	// Peers which were never queued are logged at the end of the crawl and
+	// listed in the output.
+	// Defaults to "drop".
+	QueueOverflowPolicy string `yaml:"queue_overflow_policy"`
+
+	// The relative weights of the workers, one per worker, e.g., to prefer
+	// workers with more bandwidth.
+	// If set, the concurrent requests are distributed among the workers in
+	// proportion to their weights, and if several workers are idle, requests
+	// are dispatched to the one with the highest weight.
+	// Defaults to distributing requests evenly, in round-robin order.
+	WorkerWeights []uint `yaml:"worker_weights"`
+
+	// Settings of individual workers, one entry per worker, which override
+	// those of WorkerConfig and CrawlerConfig, e.g., to compare how peers
+	// respond to different user agents or DHT protocols within one crawl.
+	// They also take precedence over the protocols of a network, see
+	// WithNetwork.
+	// Defaults to all workers using the same settings.
+	WorkerOverrides []WorkerOverride `yaml:"worker_overrides"`
+
+	// Whether all concurrent requests share a single libp2p host, rather
+	// than distributing them among NumWorkers hosts.
+	// This saves memory and the time to generate a key per host, but all
+	// requests originate from the same peer ID, which peers may rate-limit,
+	// and the host's peerstore and resource limits are shared.
+	// The connection manager watermarks, which apply per worker, are scaled
+	// by NumWorkers, so that they cover the combined concurrency.
+	SharedHost bool `yaml:"shared_host"`
+
+	// Path to a file listing canary peers, one multiaddress per line.
+	// If set, we check after each crawl whether the canaries were found in
+	// the routing tables of crawled peers, see SanityResult.
+	CanaryFilePath string `yaml:"canary_file_path"`
+
+	// Path to a file listing peer IDs not to dial, see LoadDenyList.
+	DenyListPath string `yaml:"deny_list_path"`
+	// If set, only the neighbors of peers whose agent version starts with
+	// any of these prefixes are crawled, see AgentVersionPrefixFilter.
+	AgentVersionPrefixes []string `yaml:"agent_version_prefixes"`
+
+	// Whether to strip commit hashes and everything after them from agent
+	// versions when counting them for the report, see
+	// normalizeAgentVersion.
+	NormalizeAgentVersions bool `yaml:"normalize_agent_versions"`
+
+	// Path to a MaxMind ASN database, e.g., GeoLite2-ASN, to look up the
+	// autonomous system of each reachable node in, see CrawledNode.ASN.
+	// If not set, no lookups are done.
+	ASNDatabasePath string `yaml:"asn_database_path"`
+	// Path to a MaxMind country or city database, e.g., GeoLite2-Country,
+	// to look up the location of each reachable node in, see
+	// CrawledNode.Country.
+	// If not set, no lookups are done.
+	GeoIPDatabasePath string `yaml:"geoip_database_path"`
+
+	// Named networks, which can be selected via WithNetwork instead of
+	// editing the settings above.
+	Networks map[string]NetworkConfig `yaml:"networks"`
+	// The name of the network selected via WithNetwork, if any.
+	Network string `yaml:"-"`
+
+	// A seed to derive crawl IDs from, see CrawlOutput.CrawlID.
+	// If this is set, all crawls get the same ID, which is useful for
+	// reproducible tests.
+	// Defaults to random crawl IDs.
+	CrawlIDSeed *int64 `yaml:"crawl_id_seed"`
 }
 
 func (c *CrawlManagerConfig) check() error {
-	if len(c.PreimageFilePath) == 0 {
+	if len(c.PreimageFilePath) == 0 && len(c.PreimageCachePath) == 0 {
 		return fmt.Errorf("missing preimage file path")
 	}
+	if len(c.PreimageFilePath) != 0 && len(c.PreimageCachePath) != 0 {
+		return fmt.Errorf("both preimage file path and preimage cache path set")
+	}
+	return c.checkManager()
+}
+
+// Validate checks the config for missing or invalid values, including the
+// settings of workers, the crawler, named networks, and plugins.
+// NewCrawlManager performs the same checks, but this makes it possible to
+// fail early, e.g., before loading the preimages.
+func (c CrawlManagerConfig) Validate() error {
+	err := c.check()
+	if err != nil {
+		return err
+	}
+	err = c.WorkerConfig.check()
+	if err != nil {
+		return fmt.Errorf("invalid worker_config: %w", err)
+	}
+	err = c.CrawlerConfig.check()
+	if err != nil {
+		return fmt.Errorf("invalid crawler_config: %w", err)
+	}
+	for _, p := range c.Plugins {
+		if !pluginRegistered(p.Name) {
+			return fmt.Errorf("plugin %s: %w", p.Name, ErrPluginDoesNotExist)
+		}
+	}
+	for name := range c.Networks {
+		nc, _ := c.WithNetwork(name)
+		err = nc.WorkerConfig.check()
+		if err != nil {
+			return fmt.Errorf("invalid worker_config for network %s: %w", name, err)
+		}
+		err = nc.CrawlerConfig.check()
+		if err != nil {
+			return fmt.Errorf("invalid crawler_config for network %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkManager checks the settings of the manager itself, i.e., everything
+// but the settings required to create workers.
+func (c *CrawlManagerConfig) checkManager() error {
 	if c.NumWorkers == 0 {
 		return fmt.Errorf("missing or invalid num_workers")
 	}
-	if len(c.BootstrapPeers) == 0 {
+	if len(c.BootstrapPeers) == 0 && len(c.BootstrapPeersFile) == 0 && !c.UseDefaultBootstrapPeers && !c.DisableExpansion {
 		return fmt.Errorf("missing bootstrap peers")
 	}
 	if c.ConcurrentRequests == 0 {
 		return fmt.Errorf("missing or invalid concurrent_requests")
 	}
+	if len(c.CheckpointPath) != 0 && c.CheckpointInterval <= time.Duration(0) {
+		return fmt.Errorf("missing or invalid checkpoint_interval")
+	}
+	if c.MaxNodes < 0 {
+		return fmt.Errorf("invalid max_nodes")
+	}
+	if c.DrainTimeout != nil && *c.DrainTimeout < time.Duration(0) {
+		return fmt.Errorf("invalid drain_timeout")
+	}
+	if c.MaxCrawlDuration < time.Duration(0) {
+		return fmt.Errorf("invalid max_crawl_duration")
+	}
+	if c.CrawlInterval < time.Duration(0) {
+		return fmt.Errorf("invalid crawl_interval")
+	}
+	if c.DryRun && c.CrawlInterval > 0 {
+		return fmt.Errorf("dry_run cannot be used with crawl_interval")
+	}
+	if c.MaxRetries != 0 && c.RetryBaseDelay <= time.Duration(0) {
+		return fmt.Errorf("missing or invalid retry_base_delay")
+	}
+	if c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1) {
+		return fmt.Errorf("invalid sample_rate")
+	}
+	if c.MaxQueueDepth < 0 {
+		return fmt.Errorf("invalid max_queue_depth")
+	}
+	if c.MaxQueueDepth != 0 && uint(c.MaxQueueDepth) < c.NumWorkers*c.ConcurrentRequests {
+		return fmt.Errorf("max_queue_depth must be at least num_workers * concurrent_requests")
+	}
+	switch c.QueueOverflowPolicy {
+	case "", QueueOverflowDrop, QueueOverflowBlock:
+	default:
+		return fmt.Errorf("invalid queue_overflow_policy: %s", c.QueueOverflowPolicy)
+	}
+	if len(c.WorkerOverrides) != 0 {
+		if c.SharedHost {
+			return fmt.Errorf("worker_overrides cannot be used with shared_host")
+		}
+		if len(c.WorkerOverrides) != int(c.NumWorkers) {
+			return fmt.Errorf("worker_overrides must have num_workers entries")
+		}
+	}
+	if len(c.WorkerWeights) != 0 {
+		if c.SharedHost {
+			return fmt.Errorf("worker_weights cannot be used with shared_host")
+		}
+		if len(c.WorkerWeights) != int(c.NumWorkers) {
+			return fmt.Errorf("worker_weights must have num_workers entries")
+		}
+		for _, w := range c.WorkerWeights {
+			if w == 0 {
+				return fmt.Errorf("invalid worker_weights")
+			}
+		}
+	}
 	return nil
 }
 
+// WorkerOverride holds settings of a single worker, see
+// CrawlManagerConfig.WorkerOverrides.
+// Unset fields leave the worker at the settings of the CrawlManagerConfig.
+type WorkerOverride struct {
+	UserAgent       string        `yaml:"user_agent"`
+	ProtocolStrings []protocol.ID `yaml:"protocol_strings"`
+}
+
+// workerConfigs returns the worker and crawler config of the i-th worker, with
+// its overrides applied, if any.
+func (c CrawlManagerConfig) workerConfigs(i int) (WorkerConfig, CrawlerConfig) {
+	workerConfig, crawlerConfig := c.WorkerConfig, c.CrawlerConfig
+	if i >= len(c.WorkerOverrides) {
+		return workerConfig, crawlerConfig
+	}
+
+	o := c.WorkerOverrides[i]
+	if len(o.UserAgent) != 0 {
+		workerConfig.UserAgent = o.UserAgent
+	}
+	if len(o.ProtocolStrings) != 0 {
+		crawlerConfig.ProtocolStrings = o.ProtocolStrings
+	}
+	return workerConfig, crawlerConfig
+}
+
 // toCrawlQueue keeps track of which peers we need to crawl and what addresses
 // they have.
 // It also knows if we should potentially re-crawl a peer because of address
 // changes since the last time we crawled.
 type toCrawlQueue struct {
-	queue    []peer.ID
-	inQueue  map[peer.ID]struct{}
-	addrInfo map[peer.ID][]ma.Multiaddr
+	queue     []peer.ID
+	inQueue   map[peer.ID]struct{}
+	addrInfo  map[peer.ID][]ma.Multiaddr
+	firstSeen map[peer.ID]time.Time
+	filter    addrFilter
+	// Peers all of whose addresses were removed by the filter, i.e., which
+	// only have private or loopback addresses.
+	localOnly map[peer.ID]struct{}
 }
 
 // numPeers returns the number of peers we know about.
@@ -66,6 +464,8 @@ func (q *toCrawlQueue) len() int {
 }
 
 // pop removes the next item from the queue.
+// The returned addresses are all addresses known at the time of the call, so
+// addresses learned while the peer was queued are dialed, too.
 // panics if the queue is empty.
 func (q *toCrawlQueue) pop() peer.AddrInfo {
 	if q.len() == 0 {
@@ -83,15 +483,53 @@ func (q *toCrawlQueue) pop() peer.AddrInfo {
 	}
 }
 
+// remember adds the peer's addresses to the cache, without queueing it.
+func (q *toCrawlQueue) remember(p peer.AddrInfo) {
+	if _, ok := q.firstSeen[p.ID]; !ok {
+		q.firstSeen[p.ID] = time.Now()
+	}
+	p.Addrs = canonicalAddrs(p.ID, p.Addrs)
+
+	_, known := q.addrInfo[p.ID]
+	newAddrs := filterOutOldAddresses(q.addrInfo[p.ID], q.filter.filter(p.Addrs))
+	q.addAddrs(p, newAddrs, known)
+}
+
+// addAddrs adds the given new, filtered addresses of the peer to the cache,
+// and keeps track of whether the peer only has filtered addresses.
+func (q *toCrawlQueue) addAddrs(p peer.AddrInfo, newAddrs []ma.Multiaddr, known bool) {
+	q.addrInfo[p.ID] = append(q.addrInfo[p.ID], newAddrs...)
+	switch {
+	case len(newAddrs) != 0:
+		delete(q.localOnly, p.ID)
+	case !known && len(p.Addrs) != 0:
+		q.localOnly[p.ID] = struct{}{}
+	}
+}
+
+// isLocalOnly returns whether we only know private or loopback addresses of
+// the peer, which are filtered out, see addrFilter.
+func (q *toCrawlQueue) isLocalOnly(id peer.ID) bool {
+	_, ok := q.localOnly[id]
+	return ok && len(q.addrInfo[id]) == 0
+}
+
 // push adds the peer's addresses to the cache and, if necessary, to the crawl
 // queue.
+// Addresses are canonicalized and deduplicated, see canonicalAddr.
 func (q *toCrawlQueue) push(p peer.AddrInfo, force bool) {
+	if _, ok := q.firstSeen[p.ID]; !ok {
+		q.firstSeen[p.ID] = time.Now()
+	}
+	p.Addrs = canonicalAddrs(p.ID, p.Addrs)
+
 	if force {
 		// Just add it
 		q.queue = append(q.queue, p.ID)
 		q.inQueue[p.ID] = struct{}{}
-		newAddrs := filterOutOldAddresses(q.addrInfo[p.ID], stripLocalAddrs(p.Addrs))
-		q.addrInfo[p.ID] = append(q.addrInfo[p.ID], newAddrs...)
+		_, known := q.addrInfo[p.ID]
+		newAddrs := filterOutOldAddresses(q.addrInfo[p.ID], q.filter.filter(p.Addrs))
+		q.addAddrs(p, newAddrs, known)
 		return
 	}
 
@@ -100,19 +538,19 @@ func (q *toCrawlQueue) push(p peer.AddrInfo, force bool) {
 		// Not known at all, just add
 		q.queue = append(q.queue, p.ID)
 		q.inQueue[p.ID] = struct{}{}
-		q.addrInfo[p.ID] = p.Addrs
+		q.addAddrs(p, q.filter.filter(p.Addrs), false)
 		return
 	}
 
 	// Already in the queue or previously crawled, but maybe new addresses
-	newAddrs := filterOutOldAddresses(oldAddrs, stripLocalAddrs(p.Addrs))
+	newAddrs := filterOutOldAddresses(oldAddrs, q.filter.filter(p.Addrs))
 	if len(newAddrs) == 0 {
 		// No new addresses, nothing to do
 		return
 	}
 
 	// Add new addresses
-	q.addrInfo[p.ID] = append(q.addrInfo[p.ID], newAddrs...)
+	q.addAddrs(p, newAddrs, true)
 
 	// If not in queue, re-add (with new addresses)
 	if _, ok := q.inQueue[p.ID]; !ok {
@@ -121,15 +559,56 @@ func (q *toCrawlQueue) push(p peer.AddrInfo, force bool) {
 	}
 }
 
+// retryQueue holds peers to probe again once a delay has passed.
+// It implements heap.Interface, ordered by when the retries are due.
+type retryQueue []retryEntry
+
+// retryEntry is a scheduled retry.
+type retryEntry struct {
+	id  peer.ID
+	due time.Time
+}
+
+func (q retryQueue) Len() int           { return len(q) }
+func (q retryQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q retryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *retryQueue) Push(x interface{}) {
+	*q = append(*q, x.(retryEntry))
+}
+
+func (q *retryQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
 // A worker is a libp2p host which is used to crawl the network.
 // It should support concurrent crawls.
 // It should also execute any plugins on connectable nodes.
 type worker interface {
 	// crawlPeer crawls the given peer.
-	crawlPeer(peer.AddrInfo) (*rawNodeInformation, error)
+	// The crawl should be aborted if the context is cancelled.
+	crawlPeer(context.Context, peer.AddrInfo) (*rawNodeInformation, error)
+
+	// findProviders asks the given peer for providers of the content with
+	// the given key, returning the providers and closer peers.
+	findProviders(context.Context, peer.AddrInfo, []byte) ([]peer.AddrInfo, []peer.AddrInfo, error)
+
+	// findNode asks the given peer for the peers closest to the given key
+	// it knows of.
+	findNode(context.Context, peer.AddrInfo, []byte) ([]peer.AddrInfo, error)
 
 	// stop shuts down the worker cleanly.
 	stop() error
+
+	// id returns the peer ID of the worker.
+	id() peer.ID
+
+	// usage returns the total number of bytes transferred and DHT queries
+	// sent by the worker.
+	usage() (uint64, uint64)
 }
 
 // nodeCrawlResult is the result of probing a peer.
@@ -140,6 +619,9 @@ type nodeCrawlResult struct {
 	endTs   time.Time
 	err     error
 	node    *rawNodeInformation
+
+	// The ID of the worker which probed the peer, if any.
+	crawler peer.ID
 }
 
 // rawNodeInformation stores all information from probing a peer
@@ -156,6 +638,10 @@ type crawlResult struct {
 	endTimestamp   time.Time
 	err            error
 	result         *crawlData
+
+	// Whether all connections to the peer were closed before we finished
+	// crawling it.
+	connectionDropped bool
 }
 
 // crawlData contains the data obtained through crawling a peer, notably its
@@ -164,6 +650,19 @@ type crawlData struct {
 	neighbors              []peer.AddrInfo
 	crawlStartedTimestamp  time.Time
 	crawlFinishedTimestamp time.Time
+
+	// For each entry of neighbors, the CPL of the first request that
+	// returned it.
+	// This is only recorded if enabled in the CrawlerConfig.
+	neighborCPLs []int
+	// The number of peers returned for each bucket, indexed by CPL.
+	// This is only recorded if enabled in the CrawlerConfig.
+	bucketFill []int
+	// Whether the peer still returned new peers at the maximum CPL, i.e.,
+	// closer buckets were not dumped.
+	prefixLimitReached bool
+	// The DHT protocol negotiated with the peer.
+	protocol protocol.ID
 }
 
 // pluginResult encapsulates the result of calling a plugin on a peer.
@@ -183,6 +682,14 @@ type nodeCrawlStatus struct {
 	endTs   time.Time
 	err     error
 	result  *nodeInformation
+
+	// The number of times we've probed the peer.
+	attempts int
+	// The end of the most recent probe which was able to connect, or the zero
+	// time if none was.
+	lastCrawled time.Time
+	// The ID of the worker which made the most recent probe, if any.
+	crawler peer.ID
 }
 
 // nodeInformation holds any information we know about a node.
@@ -198,81 +705,459 @@ type nodeInformation struct {
 	crawlDataBeginTs time.Time
 	crawlDataEndTs   time.Time
 	crawlNeighbors   []peer.ID
+	neighborCPLs     []int
+	bucketFill       []int
+	// For each entry of crawlNeighbors, whether it was unknown to us before
+	// we crawled this node. Only recorded if enabled in the
+	// CrawlManagerConfig.
+	novelNeighbors []bool
+
+	// Whether we've seen more than one public key for this peer ID during
+	// the crawl, which is a strong indication of spoofing.
+	conflictingKeys bool
+
+	// Whether the peer still returned new peers at the maximum CPL.
+	prefixLimitReached bool
+
+	// The DHT protocol negotiated with the peer, if we were able to open a
+	// stream.
+	dhtProtocol protocol.ID
+
+	// Whether all connections to the peer were closed before we finished
+	// crawling it, in which case its neighbors may be incomplete.
+	connectionDropped bool
+
+	// The autonomous system of the address we crawled the peer over, if
+	// looked up, see enrichASNs.
+	as *asInfo
+	// The location of that address, if looked up, see enrichGeoIP.
+	geo *geoInfo
 }
 
 type peerMetadata struct {
 	AgentVersion string
 
 	SupportedProtocols []protocol.ID
+
+	// The protocols the peer listed via multistream-select, if it supports
+	// none of the DHT protocols and we asked.
+	ListedProtocols []protocol.ID
+
+	// The transport, security protocol, and stream multiplexer of the
+	// connection.
+	ConnectionState network.ConnectionState
+
+	// The remote address of the connection we crawled the peer over, if
+	// known.
+	ConnectedAddr ma.Multiaddr
+
+	// The minimum round-trip time of a few pings, or zero if not measured.
+	RTT time.Duration
+
+	// For each probed address, whether it was reachable, if probing all
+	// addresses is enabled.
+	AddrReachability map[string]bool
+
+	// For each address dialed to connect to the peer, whether the dial
+	// succeeded, if addresses are dialed sequentially.
+	DialedAddrs map[string]bool
+
+	// Whether the peer sent a signed peer record via identify, which we were
+	// able to verify, and the addresses contained in it.
+	Certified      bool
+	CertifiedAddrs []ma.Multiaddr
+
+	// The public key the peer used in the handshake of the connection.
+	publicKey crypto.PubKey
+	// The public keys the peer used in the handshakes of other connections
+	// we had to it, e.g., to probe its addresses.
+	connectionKeys []crypto.PubKey
 }
 
 // A CrawlManager manages crawling the network.
 // It contains multiple workers, with a libp2p node each, which are used to
 // execute requests concurrently.
 type CrawlManager struct {
+	config      CrawlManagerConfig
 	resultChan  chan nodeCrawlResult
 	tokenBucket chan int
-	workers     []worker
+	// With configured weights, the work tokens of each worker, and the
+	// workers in the order they are preferred, see takeToken.
+	workerTokens    []chan struct{}
+	workersByWeight []int
+	workers         []worker
+
+	// Creates a worker for CrawlSinglePeer, which is stopped afterwards.
+	newSingleWorker func() (worker, error)
 
 	crawlsInProgress map[peer.ID]struct{}
 	crawled          map[peer.ID]nodeCrawlStatus
 	toCrawl          *toCrawlQueue
+
+	// Peers to probe again, because we were unable to connect to them.
+	retries retryQueue
+
+	// Known-good peers to check for after a crawl.
+	canaries []peer.AddrInfo
+
+	// The configured bootstrap peers.
+	bootstrapPeers []peer.AddrInfo
+
+	// The ASN database to enrich reports with, if configured.
+	asnDB *geoip2.Reader
+	// The GeoIP database to enrich reports with, if configured.
+	geoDB *geoip2.Reader
+
+	// Filters deciding which peers to crawl.
+	filters []PeerFilter
+
+	// Peers we did not probe, because they had no address for any of the
+	// enabled transports. We might still learn a usable address later.
+	skipped map[peer.ID]struct{}
+
+	// Peers we did not queue, because the queue was full, see
+	// QueueOverflowDrop.
+	overflow map[peer.ID]struct{}
+	// Peers to queue once there is room, in order, see QueueOverflowBlock.
+	// Each of them is in overflow until queued.
+	deferred []peer.ID
+
+	// The first public key we've seen for each peer.
+	publicKeys map[peer.ID]crypto.PubKey
+
+	// Requests for the status of the crawl, answered by CrawlNetwork.
+	statusRequests chan chan CrawlStatus
+	// Closed once CrawlNetwork returns.
+	done chan struct{}
+
+	subscribersM sync.Mutex
+	subscribers  []chan *CrawledNode
+
+	// Sinks to write the results of each crawl to.
+	sinks []OutputSink
+
+	// Handlers for events emitted during the crawl.
+	events *EventManager
+
+	// The number of crawl requests completed during the crawl.
+	crawlsCompleted uint64
+}
+
+// CrawlStatus is a snapshot of the status of a running crawl.
+type CrawlStatus struct {
+	DiscoveredNodes  int `json:"discovered_nodes"`
+	ConnectableNodes int `json:"connectable_nodes"`
+	CrawlableNodes   int `json:"crawlable_nodes"`
+	AvailableWorkers int `json:"available_workers"`
+	RequestsInFlight int `json:"requests_in_flight"`
+	ToCrawlQueue     int `json:"to_crawl_queue"`
+	RetryQueue       int `json:"retry_queue"`
 }
 
 // NewCrawlManager creates a new CrawlManager.
 // This attempts to create the specified number of workers and plugins, which
 // may fail.
 func NewCrawlManager(config CrawlManagerConfig) (*CrawlManager, error) {
-	err := config.check()
+	err := config.Validate()
 	if err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	config.WorkerConfig.restrictToProxy()
+	config.labelNetwork()
+	config.setUpTracing()
+	if config.SharedHost {
+		// The watermarks are per worker, so we scale them to the combined
+		// concurrency of all workers.
+		config.WorkerConfig.ConnMgrLow *= config.NumWorkers
+		config.WorkerConfig.ConnMgrHigh *= config.NumWorkers
+		config.NumWorkers = 1
+	}
+
+	preimageHandler, err := loadPreimageHandler(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := newCrawlManager(config, func(events *EventManager) ([]worker, error) {
+		if config.DryRun {
+			// A dry run never dials, so don't bother creating hosts.
+			return nil, nil
+		}
+		return createWorkers(config, preimageHandler, events)
+	})
+	if err != nil {
+		return nil, err
+	}
+	cm.newSingleWorker = func() (worker, error) {
+		workerConfig, crawlerConfig := config.workerConfigs(0)
+		w, err := newLibp2pWorker(workerConfig, config.Plugins, preimageHandler, crawlerConfig, cm.events)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	return cm, nil
+}
 
-	// Load preimageHandler
-	preimageHandler, err := LoadPreimages(config.PreimageFilePath)
+// NewCrawlManagerWithHost creates a new CrawlManager which crawls using the
+// given host, which must be a *basichost.BasicHost, as created by libp2p.New.
+// All concurrent requests share the host, i.e., NumWorkers is set to one,
+// see CrawlManagerConfig.SharedHost for the trade-offs. The host should not
+// limit the number of connections below ConcurrentRequests.
+// Options which configure the host are ignored, see NewLibp2pWorkerWithHost.
+// The manager takes ownership of the host and closes it when stopped.
+func NewCrawlManagerWithHost(config CrawlManagerConfig, h host.Host) (*CrawlManager, error) {
+	config.NumWorkers = 1
+	err := config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	config.labelNetwork()
+	config.setUpTracing()
+
+	if mgr, ok := h.ConnManager().(interface{ GetInfo() connmgr.CMInfo }); ok {
+		if info := mgr.GetInfo(); info.HighWater < int(config.ConcurrentRequests) {
+			log.WithFields(log.Fields{
+				"high_water":          info.HighWater,
+				"concurrent_requests": config.ConcurrentRequests,
+			}).Warn("connection manager of the host may close connections of peers being crawled")
+		}
+	}
+
+	preimageHandler, err := loadPreimageHandler(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := newCrawlManager(config, func(events *EventManager) ([]worker, error) {
+		w, err := newLibp2pWorkerWithHost(h, config.WorkerConfig, config.Plugins, preimageHandler, config.CrawlerConfig, events)
+		if err != nil {
+			return nil, err
+		}
+		return []worker{w}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// We only have the one host, so single peers are crawled with the shared
+	// worker, which must not be stopped afterwards.
+	cm.newSingleWorker = func() (worker, error) {
+		return sharedWorker{cm.workers[0]}, nil
+	}
+
+	return cm, nil
+}
+
+// A sharedWorker is a worker which is owned by someone else and is therefore
+// not stopped.
+type sharedWorker struct {
+	worker
+}
+
+// stop implements worker.
+func (sharedWorker) stop() error {
+	return nil
+}
+
+// loadPreimageHandler loads the preimages from the configured file or cache.
+func loadPreimageHandler(config CrawlManagerConfig) (*PreimageHandler, error) {
+	preimagePath := config.PreimageFilePath
+	var preimageHandler *PreimageHandler
+	var err error
+	if len(preimagePath) != 0 {
+		preimageHandler, err = LoadPreimages(preimagePath)
+	} else {
+		preimagePath = config.PreimageCachePath
+		preimageHandler, err = NewPreimageHandlerFromFile(preimagePath)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to load preimages: %w", err)
 	}
-	log.WithField("path", config.PreimageFilePath).WithField("num", len(preimageHandler.preimages)).Info("loaded preimages")
+	log.WithField("path", preimagePath).WithField("num", len(preimageHandler.preimages)).Info("loaded preimages")
+
+	return preimageHandler, nil
+}
 
+// newCrawlManager creates a new CrawlManager, using the given function to
+// create its workers, which emit events to the given EventManager.
+// The config must have been checked.
+func newCrawlManager(config CrawlManagerConfig, newWorkers func(*EventManager) ([]worker, error)) (*CrawlManager, error) {
+	var err error
 	cm := &CrawlManager{
+		config:           config,
 		resultChan:       make(chan nodeCrawlResult),
 		tokenBucket:      make(chan int, config.NumWorkers*config.ConcurrentRequests),
 		crawled:          make(map[peer.ID]nodeCrawlStatus),
 		crawlsInProgress: make(map[peer.ID]struct{}),
+		skipped:          make(map[peer.ID]struct{}),
+		overflow:         make(map[peer.ID]struct{}),
+		publicKeys:       make(map[peer.ID]crypto.PubKey),
+		statusRequests:   make(chan chan CrawlStatus),
+		done:             make(chan struct{}),
 		toCrawl: &toCrawlQueue{
-			queue:    nil,
-			addrInfo: make(map[peer.ID][]ma.Multiaddr),
-			inQueue:  make(map[peer.ID]struct{}),
+			queue:     nil,
+			addrInfo:  make(map[peer.ID][]ma.Multiaddr),
+			firstSeen: make(map[peer.ID]time.Time),
+			inQueue:   make(map[peer.ID]struct{}),
+			localOnly: make(map[peer.ID]struct{}),
+			filter: addrFilter{
+				keepLocal: config.KeepLocalAddrs,
+				keepRelay: config.KeepRelayAddrs,
+			},
 		},
 	}
 
-	// Create workers
-	for i := uint(0); i < config.NumWorkers; i++ {
-		worker, err := NewLibp2pWorker(config.WorkerConfig, config.Plugins, preimageHandler, config.CrawlerConfig)
+	// Load bootstrap peers and canaries before creating workers, which is
+	// expensive.
+	bootstrapPeers, err := parseSeedPeers(config.BootstrapPeers)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse bootstrap peers: %w", err)
+	}
+	if len(config.BootstrapPeersFile) != 0 {
+		filePeers, err := LoadSeedPeers(config.BootstrapPeersFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load bootstrap peers: %w", err)
+		}
+		bootstrapPeers = append(bootstrapPeers, filePeers...)
+	}
+	if config.UseDefaultBootstrapPeers {
+		bootstrapPeers = append(bootstrapPeers, DefaultBootstrapPeers()...)
+	}
+	if len(config.DenyListPath) != 0 {
+		denied, err := LoadDenyList(config.DenyListPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load deny list: %w", err)
+		}
+		cm.filters = append(cm.filters, DenyListFilter(denied))
+	}
+	if len(config.AgentVersionPrefixes) != 0 {
+		cm.filters = append(cm.filters, AgentVersionPrefixFilter(config.AgentVersionPrefixes...))
+	}
+	if len(config.CanaryFilePath) != 0 {
+		cm.canaries, err = LoadSeedPeers(config.CanaryFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load canaries: %w", err)
+		}
+	}
+	if len(config.ASNDatabasePath) != 0 {
+		cm.asnDB, err = openASNDatabase(config.ASNDatabasePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open ASN database: %w", err)
+		}
+	}
+	if len(config.GeoIPDatabasePath) != 0 {
+		cm.geoDB, err = openGeoIPDatabase(config.GeoIPDatabasePath)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create worker: %w", err)
+			if cm.asnDB != nil {
+				_ = cm.asnDB.Close()
+			}
+			return nil, fmt.Errorf("unable to open GeoIP database: %w", err)
 		}
-		cm.workers = append(cm.workers, worker)
 	}
 
-	// Create concurrent work tokens, round-robin assign the workers by ID
-	for i := uint(0); i < config.ConcurrentRequests; i++ {
-		cm.tokenBucket <- int(i % config.NumWorkers)
+	// Create workers
+	cm.events = NewEventManager()
+	cm.events.network = config.Network
+	workers, err := newWorkers(cm.events)
+	if err != nil {
+		cm.events.Close()
+		if cm.asnDB != nil {
+			_ = cm.asnDB.Close()
+		}
+		if cm.geoDB != nil {
+			_ = cm.geoDB.Close()
+		}
+		return nil, fmt.Errorf("unable to create worker: %w", err)
 	}
+	cm.workers = workers
 
-	// Parse and add bootstrap peers to queue
-	for _, maddr := range config.BootstrapPeers {
-		pinfo, err := parsePeerString(maddr)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse bootstrap peer address: %w", err)
+	// Create concurrent work tokens, round-robin assign the workers by ID,
+	// in proportion to their weights, if configured.
+	switch {
+	case len(workers) == 0:
+		// A dry run has no workers, and hence no tokens.
+	case len(config.WorkerWeights) != 0:
+		tokens := weightedRoundRobin(config.ConcurrentRequests, config.WorkerWeights)
+		cm.workerTokens = make([]chan struct{}, len(workers))
+		for i := range cm.workerTokens {
+			cm.workerTokens[i] = make(chan struct{}, len(tokens))
+		}
+		cm.workersByWeight = workersByWeight(config.WorkerWeights)
+		for _, id := range tokens {
+			cm.returnToken(id)
 		}
-		cm.toCrawl.push(*pinfo, false)
+	default:
+		for i := uint(0); i < config.ConcurrentRequests; i++ {
+			cm.tokenBucket <- int(i % config.NumWorkers)
+		}
+	}
+
+	// Add bootstrap peers to queue
+	cm.bootstrapPeers = bootstrapPeers
+	for _, p := range bootstrapPeers {
+		cm.toCrawl.push(p, false)
 	}
 
 	return cm, nil
 }
 
+// createWorkers creates the configured number of workers, at most
+// HostInitConcurrency at a time.
+// If creating any worker fails, all others are stopped.
+// The workers emit events to the given EventManager.
+func createWorkers(config CrawlManagerConfig, preimageHandler *PreimageHandler, events *EventManager) ([]worker, error) {
+	return createWorkersConcurrently(config.NumWorkers, config.HostInitConcurrency, func(i int) (worker, error) {
+		workerConfig, crawlerConfig := config.workerConfigs(i)
+		return newLibp2pWorker(workerConfig, config.Plugins, preimageHandler, crawlerConfig, events)
+	})
+}
+
+// createWorkersConcurrently creates n workers by calling newWorker with the
+// index of each, with at most the given number of calls running at a time,
+// or one if that is zero.
+// If any call fails, the workers created so far are stopped.
+func createWorkersConcurrently(n, concurrency uint, newWorker func(int) (worker, error)) ([]worker, error) {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	workers := make([]worker, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			w, err := newWorker(i)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			workers[i] = w
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		for _, w := range workers {
+			if w != nil {
+				_ = w.stop()
+			}
+		}
+		return nil, err
+	}
+
+	return workers, nil
+}
+
 // AddPeersToCrawl adds peers to the end of the queue.
 // This must be called before CrawlNetwork.
 func (cm *CrawlManager) AddPeersToCrawl(peers []peer.AddrInfo) {
@@ -281,7 +1166,29 @@ func (cm *CrawlManager) AddPeersToCrawl(peers []peer.AddrInfo) {
 	}
 }
 
-// Stop shuts down all workers cleanly.
+// AddPeerFilters adds filters deciding which peers to crawl, in addition to
+// those configured.
+// This must be called before CrawlNetwork.
+func (cm *CrawlManager) AddPeerFilters(filters ...PeerFilter) {
+	cm.filters = append(cm.filters, filters...)
+}
+
+// AddOutputSinks adds sinks to which the results of each crawl are written,
+// in addition to being returned.
+// This must be called before CrawlNetwork.
+// The sinks are closed by Stop.
+func (cm *CrawlManager) AddOutputSinks(sinks ...OutputSink) {
+	cm.sinks = append(cm.sinks, sinks...)
+}
+
+// Events returns the EventManager to register handlers for events emitted
+// during the crawl on.
+func (cm *CrawlManager) Events() *EventManager {
+	return cm.events
+}
+
+// Stop shuts down all workers cleanly and closes all output sinks.
+// No more events are emitted after this returns.
 func (cm *CrawlManager) Stop() error {
 	for _, worker := range cm.workers {
 		err := worker.stop()
@@ -290,15 +1197,103 @@ func (cm *CrawlManager) Stop() error {
 		}
 	}
 
+	for _, sink := range cm.sinks {
+		err := sink.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to close output sink")
+		}
+	}
+
+	cm.events.Close()
+
+	if cm.asnDB != nil {
+		err := cm.asnDB.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to close ASN database")
+		}
+	}
+	if cm.geoDB != nil {
+		err := cm.geoDB.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to close GeoIP database")
+		}
+	}
+
 	return nil
 }
 
+// writeToSinks writes the results of a crawl to all output sinks.
+// Errors are logged, so that one failing sink does not affect the others.
+func (cm *CrawlManager) writeToSinks(report *CrawlOutput) {
+	for _, sink := range cm.sinks {
+		err := sink.Write(report)
+		if err != nil {
+			log.WithError(err).Error("unable to write results to output sink")
+		}
+	}
+}
+
+// ErrNoWorkers is returned by CrawlNetwork if the CrawlManager has no
+// workers to crawl with.
+var ErrNoWorkers = errors.New("no workers")
+
+// ErrOnlyLocalAddrs is the error recorded for peers which only have private or
+// loopback addresses, which are not dialed unless KeepLocalAddrs is set.
+var ErrOnlyLocalAddrs = errors.New("only private or loopback addresses")
+
+// ErrNoReachablePeers is returned by CrawlNetwork if no peer could be
+// connected to, e.g., because all bootstrap peers were unreachable.
+// The results are still written to the output sinks and returned.
+var ErrNoReachablePeers = errors.New("no reachable peers")
+
+// ErrCrawlStopped is returned by CrawlNetwork, wrapped together with the
+// reason, if the crawl was stopped before all peers were crawled, because
+// MaxCrawlDuration was reached, the budget was exceeded, or MaxNodes peers
+// were probed.
+// The partial results are still written to the output sinks and returned.
+var ErrCrawlStopped = errors.New("crawl stopped early")
+
+// prometheusInterval is the interval at which the throughput metrics are
+// updated.
+const prometheusInterval = 10 * time.Second
+
+var (
+	nodesPerSecond = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipfs_crawler_cmanager_nodes_per_second",
+		Help: "The number of nodes probed per second during the current crawl, or zero if none is running.",
+	}, []string{"network"})
+	crawlsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_crawler_cmanager_crawls_completed_total",
+		Help: "The number of crawl requests which completed, successfully or not.",
+	}, []string{"network"})
+	queueOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_crawler_cmanager_queue_overflows_total",
+		Help: "The number of peers which were not queued when found, because the crawl queue was full.",
+	}, []string{"network"})
+	toCrawlQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipfs_crawler_cmanager_to_crawl_queue_length",
+		Help: "The number of peers waiting to be crawled.",
+	}, []string{"network"})
+)
+
 // CrawlNetwork crawls the network, starting at the configured bootstrap nodes.
 // If any peers were added with AddPeersToCrawl, those will be asked, too.
 // Apart from that, all nodes learned during the crawl will be contacted.
 // Nodes are contacted only once, unless a previous connection attempt failed
 // and new addresses have been learned since.
-func (cm *CrawlManager) CrawlNetwork() CrawlOutput {
+// If configured, no new requests are dispatched once the crawl has been
+// running for longer than MaxCrawlDuration, the budget is exceeded, or
+// MaxNodes peers have been probed, and the partial results are returned
+// together with ErrCrawlStopped.
+// If DryRun is set, no peer is dialed, and an empty report is returned.
+// If no peer could be connected to, the results are returned together with
+// ErrNoReachablePeers.
+// If the context is cancelled, no new requests are dispatched either. The
+// partial results are written to the output sinks and returned together with
+// the context's error.
+// In all of these cases, requests in flight are waited for up to
+// DrainTimeout, after which they are cancelled and their results discarded.
+func (cm *CrawlManager) CrawlNetwork(ctx context.Context) (CrawlOutput, error) {
 	// Plan of action
 	// 1. Add bootstraps to overflow
 	// 2. Start dispatch loop
@@ -306,35 +1301,132 @@ func (cm *CrawlManager) CrawlNetwork() CrawlOutput {
 	//  2.2 if we can dispatch a crawl: dispatch from toCrawl
 	//  2.3 break loop: idleTimer fired | (toCrawl empty && no request are out && knowQueue empty)
 	//  return data
-	log.Info("Starting crawl...")
+	defer cm.finish()
+	crawlID := newCrawlID(cm.config.CrawlIDSeed)
+	startTs := time.Now()
+	if cm.config.DryRun {
+		report := cm.dryRun(crawlID, startTs)
+		cm.writeToSinks(&report)
+		return report, nil
+	}
+	if len(cm.workers) < 1 {
+		return CrawlOutput{}, ErrNoWorkers
+	}
+	log.WithField("crawl_id", crawlID).Info("Starting crawl...")
 
 	infoTicker := time.NewTicker(20 * time.Second)
 	defer infoTicker.Stop()
 
-	for cm.toCrawl.len() != 0 ||
+	// Only checkpoint if configured to.
+	var checkpointTicks <-chan time.Time
+	if len(cm.config.CheckpointPath) != 0 {
+		checkpointTicker := time.NewTicker(cm.config.CheckpointInterval)
+		defer checkpointTicker.Stop()
+		checkpointTicks = checkpointTicker.C
+	}
+
+	// Throughput is computed from the number of crawled nodes since the last
+	// tick.
+	prometheusTicker := time.NewTicker(prometheusInterval)
+	defer prometheusTicker.Stop()
+	lastNodes, lastTick := len(cm.crawled), startTs
+
+	// Only enforce a deadline if configured to.
+	var deadline <-chan time.Time
+	if cm.config.MaxCrawlDuration > 0 {
+		deadlineTimer := time.NewTimer(cm.config.MaxCrawlDuration)
+		defer deadlineTimer.Stop()
+		deadline = deadlineTimer.C
+	}
+
+	// Requests in flight are not cancelled with the context, so that we can
+	// wait for them, see DrainTimeout. They are cancelled once we stop
+	// waiting.
+	crawlCtx, cancelCrawls := context.WithCancel(detachedContext{ctx})
+	defer cancelCrawls()
+	ctxDone := ctx.Done()
+
+	// Once we stop dispatching new requests, we set this to nil.
+	tokenBucket := cm.tokenBucket
+	stopping := false
+	// Why we stopped dispatching new requests, if we did.
+	var stopReason string
+	// Fires once we stop waiting for requests in flight, if configured.
+	var drainTimeout <-chan time.Time
+	cancelled := false
+	// Whether we popped a peer which was being crawled already since the
+	// last dispatch or result, see QueueOverflowBlock.
+	stalled := false
+	stop := func(reason string) {
+		stopping = true
+		stopReason = reason
+		tokenBucket = nil
+		if cm.config.DrainTimeout != nil {
+			drainTimeout = time.After(*cm.config.DrainTimeout)
+		}
+	}
+
+loop:
+	for (!stopping && (cm.toCrawl.len() != 0 || cm.retries.Len() != 0 || len(cm.deferred) != 0)) ||
 		len(cm.crawlsInProgress) != 0 {
 
+		// Pending retries do not need a case of their own: while they are
+		// the only thing left to do, the token bucket case below polls.
+		if !stopping {
+			cm.requeueRetries(time.Now())
+			cm.queueDeferred()
+		}
+
+		// Hold back results while peers are waiting for room in the queue,
+		// unless the queue is stalled on peers that are being crawled
+		// already.
+		results := cm.resultChan
+		if !stopping && !stalled && len(cm.deferred) != 0 {
+			results = nil
+		}
+
 		select {
-		case report := <-cm.resultChan:
+		case report := <-results:
 			// We have new information incoming
 			if _, ok := cm.crawlsInProgress[report.id]; !ok {
 				panic("received result for untracked crawl")
 			}
 			delete(cm.crawlsInProgress, report.id)
+			stalled = false
+			cm.crawlsCompleted++
+			crawlsCompleted.WithLabelValues(cm.config.Network).Inc()
 
 			// Insert into our "database"
 			cm.upsertCrawlResult(report)
+			cm.publish(report.id)
+
+			if !stopping && cm.budgetExceeded() {
+				log.WithField("requests in flight", len(cm.crawlsInProgress)).Warn("crawl budget exceeded, stopping crawl")
+				stop("crawl budget exceeded")
+			}
+			if !stopping && cm.config.MaxNodes != 0 && len(cm.crawled) >= cm.config.MaxNodes {
+				log.WithField("requests in flight", len(cm.crawlsInProgress)).Info("maximum number of nodes reached, stopping crawl")
+				stop("maximum number of nodes reached")
+			}
 
 			if report.err != nil {
 				log.WithFields(log.Fields{"Error": report.err}).Debug("Error while crawling")
+				cm.scheduleRetry(report.id)
 				continue
 			}
 
-			// Add new peers to queue
-			if report.node.crawlData.result != nil {
+			// Add new peers to queue, unless filtered
+			if !cm.allowPeer(report.id, report.node.info.AgentVersion) {
+				log.WithFields(log.Fields{"node": report.id}).Debug("filtered, not queueing neighbors")
+			} else if report.node.crawlData.result != nil {
+				var novel []bool
 				for _, addrInfo := range report.node.crawlData.result.neighbors {
-					cm.handleNewNode(addrInfo)
+					isNovel := cm.handleNewNode(addrInfo)
+					if cm.config.RecordEdgeNovelty {
+						novel = append(novel, isNovel)
+					}
 				}
+				cm.crawled[report.id].result.novelNeighbors = novel
 			}
 
 			log.WithFields(log.Fields{
@@ -343,8 +1435,9 @@ func (cm *CrawlManager) CrawlNetwork() CrawlOutput {
 				"Reports":         len(cm.resultChan),
 			}).Debug("Status of Manager")
 
-		case id := <-cm.tokenBucket:
+		case id := <-tokenBucket:
 			// We have an available worker
+			id = cm.takeToken(id)
 			if cm.toCrawl.len() > 0 {
 				node := cm.toCrawl.pop()
 
@@ -354,57 +1447,388 @@ func (cm *CrawlManager) CrawlNetwork() CrawlOutput {
 
 					// Return to queue, maybe the crawl fails
 					cm.toCrawl.push(node, true)
-					cm.tokenBucket <- id
+					cm.returnToken(id)
+					stalled = true
 				} else {
 					// Check if we crawled the node already
 					if state, ok := cm.crawled[node.ID]; !ok || (ok && state.err != nil) || (ok && state.err == nil && state.result.crawlDataError != nil) {
-						log.WithFields(log.Fields{"node": node.ID}).Debug("dispatching crawl request")
-						cm.crawlsInProgress[node.ID] = struct{}{}
-						go cm.dispatch(node, id)
+						if !cm.allowPeer(node.ID, "") {
+							log.WithFields(log.Fields{"node": node.ID}).Debug("filtered, not dispatching crawl request")
+							cm.returnToken(id)
+						} else if cm.toCrawl.isLocalOnly(node.ID) {
+							log.WithFields(log.Fields{"node": node.ID}).Debug("only local addresses, not dispatching crawl request")
+							cm.recordLocalOnly(node)
+							cm.returnToken(id)
+						} else if cm.config.WorkerConfig.canDial(node) {
+							log.WithFields(log.Fields{"node": node.ID}).Debug("dispatching crawl request")
+							delete(cm.skipped, node.ID)
+							stalled = false
+							cm.crawlsInProgress[node.ID] = struct{}{}
+							go cm.dispatch(crawlCtx, node, id)
+						} else {
+							log.WithFields(log.Fields{"node": node.ID}).Debug("no address for enabled transports, skipping")
+							cm.skipped[node.ID] = struct{}{}
+							cm.returnToken(id)
+						}
 					} else {
 						log.WithFields(log.Fields{"node": node.ID}).Debug("already crawled, not dispatching crawl request")
-						cm.tokenBucket <- id
+						cm.returnToken(id)
 					}
 				}
 			} else {
 				// nothing to do; return token
-				cm.tokenBucket <- id
+				cm.returnToken(id)
 				// Sleep a bit, because we're probably at the end of the crawl and not much is happening.
 				time.Sleep(10 * time.Millisecond)
 			}
 
-		case <-infoTicker.C:
-			numConnectable := 0
-			numCrawlable := 0
-			for _, status := range cm.crawled {
-				if status.err == nil {
-					numConnectable++
-					if status.result.crawlDataError == nil {
-						numCrawlable++
-					}
-				}
+		case <-checkpointTicks:
+			err := cm.checkpoint(cm.config.CheckpointPath)
+			if err != nil {
+				log.WithError(err).Warn("unable to write checkpoint")
+			} else {
+				log.WithField("path", cm.config.CheckpointPath).Debug("wrote checkpoint")
 			}
+
+		case reply := <-cm.statusRequests:
+			reply <- cm.status()
+
+		case <-drainTimeout:
+			log.WithField("requests in flight", len(cm.crawlsInProgress)).Warn("drain timeout reached, discarding results of requests in flight")
+			break loop
+
+		case now := <-prometheusTicker.C:
+			nodesPerSecond.WithLabelValues(cm.config.Network).Set(float64(len(cm.crawled)-lastNodes) / now.Sub(lastTick).Seconds())
+			toCrawlQueueLength.WithLabelValues(cm.config.Network).Set(float64(cm.toCrawl.len()))
+			lastNodes, lastTick = len(cm.crawled), now
+
+		case <-deadline:
+			log.WithField("requests in flight", len(cm.crawlsInProgress)).Warn("maximum crawl duration reached, stopping crawl")
+			deadline = nil
+			if !stopping {
+				stop("maximum crawl duration reached")
+			}
+
+		case <-ctxDone:
+			log.WithError(ctx.Err()).WithField("requests in flight", len(cm.crawlsInProgress)).Warn("crawl cancelled, stopping crawl")
+			cancelled = true
+			ctxDone = nil
+			if !stopping {
+				stop("crawl cancelled")
+			}
+
+		case <-infoTicker.C:
+			status := cm.status()
 			log.WithFields(log.Fields{
-				"discovered nodes":            cm.toCrawl.numPeers(),
-				"available workers":           len(cm.tokenBucket),
-				"requests in flight":          len(cm.crawlsInProgress),
-				"to-crawl-queue":              cm.toCrawl.len(),
-				"connectable nodes":           numConnectable,
-				"connectable+crawlable nodes": numCrawlable,
+				"discovered nodes":            status.DiscoveredNodes,
+				"available workers":           status.AvailableWorkers,
+				"requests in flight":          status.RequestsInFlight,
+				"to-crawl-queue":              status.ToCrawlQueue,
+				"retry-queue":                 status.RetryQueue,
+				"queue-overflow":              len(cm.overflow),
+				"connectable nodes":           status.ConnectableNodes,
+				"connectable+crawlable nodes": status.CrawlableNodes,
 			}).Info("Periodic info on crawl status")
 		}
 	}
 
-	return cm.createReport()
+	cancelCrawls()
+	// The gauge describes the current crawl, of which there is none now.
+	nodesPerSecond.WithLabelValues(cm.config.Network).Set(0)
+
+	report := cm.createReport(crawlID, startTs)
+	if n := len(report.overflowNodes()); n != 0 {
+		log.WithFields(log.Fields{
+			"peers":  n,
+			"policy": cm.config.QueueOverflowPolicy,
+		}).Warn("crawl queue overflowed, some peers were never crawled, see queue_overflow_nodes")
+	}
+	if cancelled {
+		// This takes precedence over other reasons to stop.
+		stopReason = "crawl cancelled"
+	} else {
+		report.sanity = cm.checkCanaries(ctx)
+	}
+	report.stopReason = stopReason
+	cm.writeToSinks(&report)
+
+	if cancelled {
+		return report, fmt.Errorf("crawl cancelled: %w", ctx.Err())
+	}
+	if summarize(cm.crawled).numConnectable == 0 {
+		return report, ErrNoReachablePeers
+	}
+	if len(stopReason) != 0 {
+		return report, fmt.Errorf("%w: %s", ErrCrawlStopped, stopReason)
+	}
+
+	return report, nil
+}
+
+// A detachedContext carries the values of its parent, but is not cancelled with
+// it.
+type detachedContext struct {
+	context.Context
+}
+
+// Deadline implements context.Context.
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context.
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context.
+func (detachedContext) Err() error {
+	return nil
+}
+
+// budgetExceeded checks whether the configured crawl budget, if any, has been
+// exceeded.
+func (cm *CrawlManager) budgetExceeded() bool {
+	if cm.config.CrawlBudgetBytes == 0 && cm.config.CrawlBudgetQueries == 0 {
+		return false
+	}
+
+	var bytes, queries uint64
+	for _, w := range cm.workers {
+		b, q := w.usage()
+		bytes += b
+		queries += q
+	}
+
+	return (cm.config.CrawlBudgetBytes != 0 && bytes > cm.config.CrawlBudgetBytes) ||
+		(cm.config.CrawlBudgetQueries != 0 && queries > cm.config.CrawlBudgetQueries)
+}
+
+// Status returns a snapshot of the status of the crawl performed by
+// CrawlNetwork.
+// This blocks until CrawlNetwork is running.
+// Returns false if CrawlNetwork has already returned.
+func (cm *CrawlManager) Status() (CrawlStatus, bool) {
+	reply := make(chan CrawlStatus, 1)
+	select {
+	case cm.statusRequests <- reply:
+		return <-reply, true
+	case <-cm.done:
+		return CrawlStatus{}, false
+	}
+}
+
+// subscriptionBufferSize is the capacity of channels returned by Subscribe.
+const subscriptionBufferSize = 1024
+
+// Subscribe returns a channel on which the results of probing nodes are
+// published as they are processed by CrawlNetwork.
+// Each subscriber receives every result, but if a subscriber does not keep up,
+// results are dropped for that subscriber.
+// The channel is closed once CrawlNetwork returns.
+func (cm *CrawlManager) Subscribe() <-chan *CrawledNode {
+	c := make(chan *CrawledNode, subscriptionBufferSize)
+
+	cm.subscribersM.Lock()
+	defer cm.subscribersM.Unlock()
+
+	select {
+	case <-cm.done:
+		// Crawl is already over.
+		close(c)
+	default:
+		cm.subscribers = append(cm.subscribers, c)
+	}
+
+	return c
+}
+
+// publish sends our current knowledge of the given peer to all subscribers.
+func (cm *CrawlManager) publish(id peer.ID) {
+	cm.subscribersM.Lock()
+	defer cm.subscribersM.Unlock()
+
+	if len(cm.subscribers) == 0 {
+		return
+	}
+
+	node := cm.crawled[id].toCrawledNode(cm.toCrawl.addrInfo, cm.toCrawl.firstSeen, id, cm.config.MaxStoredAddrsPerNode)
+	for _, c := range cm.subscribers {
+		// Every subscriber gets their own copy.
+		tmp := node
+		select {
+		case c <- &tmp:
+		default:
+			log.WithField("peer", id).Debug("subscriber too slow, dropping result")
+		}
+	}
+}
+
+// finish marks the crawl as done and closes all subscriptions.
+func (cm *CrawlManager) finish() {
+	cm.subscribersM.Lock()
+	defer cm.subscribersM.Unlock()
+
+	close(cm.done)
+	for _, c := range cm.subscribers {
+		close(c)
+	}
+	cm.subscribers = nil
+}
+
+func (cm *CrawlManager) status() CrawlStatus {
+	summary := summarize(cm.crawled)
+	return CrawlStatus{
+		DiscoveredNodes:  cm.toCrawl.numPeers(),
+		ConnectableNodes: summary.numConnectable,
+		CrawlableNodes:   summary.numCrawlable,
+		AvailableWorkers: len(cm.tokenBucket),
+		RequestsInFlight: len(cm.crawlsInProgress),
+		ToCrawlQueue:     cm.toCrawl.len(),
+		RetryQueue:       cm.retries.Len(),
+	}
+}
+
+// CrawlSinglePeer crawls the given peer with a new worker, without following
+// any of its neighbors, which are returned as part of the result.
+// This is useful for on-demand probing of single peers.
+// If we were unable to connect to the peer, the result describes the failure,
+// and the error is returned, too.
+// Otherwise, errors are only returned if we failed to create the worker.
+// If DryRun is set, the peer is not dialed, and ErrDryRun is returned.
+// It is safe to call this concurrently, also with CrawlNetwork.
+func (cm *CrawlManager) CrawlSinglePeer(ctx context.Context, p peer.AddrInfo) (*CrawledNode, error) {
+	if cm.config.DryRun {
+		return nil, cm.dryRunOperation("single peer", []peer.AddrInfo{p})
+	}
+	w, err := cm.newSingleWorker()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create worker: %w", err)
+	}
+	defer func() {
+		err := w.stop()
+		if err != nil {
+			log.WithError(err).Warn("unable to stop worker")
+		}
+	}()
+
+	before := time.Now()
+	result, err := w.crawlPeer(ctx, p)
+	after := time.Now()
+
+	nodes := map[peer.ID]nodeCrawlStatus{
+		p.ID: newNodeCrawlStatus(nodeCrawlResult{
+			id:      p.ID,
+			node:    result,
+			startTs: before,
+			endTs:   after,
+			err:     err,
+			crawler: w.id(),
+		}),
+	}
+	if cm.asnDB != nil {
+		enrichASNs(cm.asnDB, nodes)
+	}
+	if cm.geoDB != nil {
+		enrichGeoIP(cm.geoDB, nodes)
+	}
+	addrInfo := map[peer.ID][]ma.Multiaddr{p.ID: canonicalAddrs(p.ID, p.Addrs)}
+	node := nodes[p.ID].toCrawledNode(addrInfo, map[peer.ID]time.Time{p.ID: before}, p.ID, 0)
+	if err != nil {
+		return &node, fmt.Errorf("unable to connect: %w", err)
+	}
+
+	if result.crawlData.result != nil {
+		node.Result.Neighbors = make([]peer.AddrInfo, 0, len(result.crawlData.result.neighbors))
+		for _, n := range result.crawlData.result.neighbors {
+			node.Result.Neighbors = append(node.Result.Neighbors, peer.AddrInfo{
+				ID:    n.ID,
+				Addrs: canonicalAddrs(n.ID, n.Addrs),
+			})
+		}
+	}
+
+	return &node, nil
 }
 
 func (cm *CrawlManager) upsertCrawlResult(report nodeCrawlResult) {
 	// TODO maybe modify existing entry with new information?
+	ncs := newNodeCrawlStatus(report)
+
+	// Keep track of public keys across connections: the handshake key of
+	// every connection is compared to the first key we've seen for the peer,
+	// including those of previous crawls of it.
+	if ncs.result != nil && ncs.result.info.publicKey != nil {
+		if old, ok := cm.crawled[report.id]; ok && old.err == nil && old.result.conflictingKeys {
+			ncs.result.conflictingKeys = true
+		}
+		first, ok := cm.publicKeys[report.id]
+		if !ok {
+			first = ncs.result.info.publicKey
+			cm.publicKeys[report.id] = first
+		}
+		keys := append([]crypto.PubKey{ncs.result.info.publicKey}, ncs.result.info.connectionKeys...)
+		for _, key := range keys {
+			if key != nil && !first.Equals(key) {
+				log.WithField("peer", report.id).Warn("encountered conflicting public keys for peer")
+				ncs.result.conflictingKeys = true
+				break
+			}
+		}
+	}
+
+	if old, ok := cm.crawled[report.id]; ok {
+		ncs.attempts += old.attempts
+		if ncs.lastCrawled.IsZero() {
+			ncs.lastCrawled = old.lastCrawled
+		}
+	}
+
+	cm.crawled[report.id] = ncs
+}
+
+// scheduleRetry schedules probing a peer we were unable to connect to again,
+// if it has retries left.
+// The delay doubles with every attempt.
+func (cm *CrawlManager) scheduleRetry(id peer.ID) {
+	attempts := cm.crawled[id].attempts
+	if uint(attempts) > cm.config.MaxRetries {
+		return
+	}
+
+	delay := cm.config.RetryBaseDelay << (attempts - 1)
+	log.WithFields(log.Fields{"node": id, "attempts": attempts, "delay": delay}).Debug("scheduling retry")
+	heap.Push(&cm.retries, retryEntry{
+		id:  id,
+		due: time.Now().Add(delay),
+	})
+}
+
+// requeueRetries moves peers whose retries are due to the crawl queue.
+func (cm *CrawlManager) requeueRetries(now time.Time) {
+	for cm.retries.Len() != 0 && !cm.retries[0].due.After(now) {
+		entry := heap.Pop(&cm.retries).(retryEntry)
+		if _, ok := cm.toCrawl.inQueue[entry.id]; ok {
+			// Re-queued in the meantime, e.g., because of new addresses.
+			continue
+		}
+		cm.toCrawl.push(peer.AddrInfo{ID: entry.id}, true)
+	}
+}
+
+// newNodeCrawlStatus converts the result of probing a peer to our knowledge
+// about that peer.
+func newNodeCrawlStatus(report nodeCrawlResult) nodeCrawlStatus {
 	ncs := nodeCrawlStatus{
-		result:  nil,
-		startTs: report.startTs,
-		endTs:   report.endTs,
-		err:     report.err,
+		result:   nil,
+		startTs:  report.startTs,
+		endTs:    report.endTs,
+		err:      report.err,
+		attempts: 1,
+		crawler:  report.crawler,
+	}
+	if report.err == nil {
+		ncs.lastCrawled = report.endTs
 	}
 	if report.node != nil {
 		ncs.result = new(nodeInformation)
@@ -413,73 +1837,556 @@ func (cm *CrawlManager) upsertCrawlResult(report nodeCrawlResult) {
 		ncs.result.crawlDataError = report.node.crawlData.err
 		ncs.result.crawlDataBeginTs = report.node.crawlData.beginTimestamp
 		ncs.result.crawlDataEndTs = report.node.crawlData.endTimestamp
+		ncs.result.connectionDropped = report.node.crawlData.connectionDropped
 		if report.node.crawlData.result != nil {
 			for _, p := range report.node.crawlData.result.neighbors {
 				ncs.result.crawlNeighbors = append(ncs.result.crawlNeighbors, p.ID)
 			}
+			ncs.result.neighborCPLs = report.node.crawlData.result.neighborCPLs
+			ncs.result.bucketFill = report.node.crawlData.result.bucketFill
+			ncs.result.prefixLimitReached = report.node.crawlData.result.prefixLimitReached
+			ncs.result.dhtProtocol = report.node.crawlData.result.protocol
 		}
 	}
-	cm.crawled[report.id] = ncs
+	return ncs
 }
 
-func (cm *CrawlManager) dispatch(node peer.AddrInfo, id int) {
+func (cm *CrawlManager) dispatch(ctx context.Context, node peer.AddrInfo, id int) {
 	worker := cm.workers[id]
 	before := time.Now()
-	result, err := worker.crawlPeer(node)
+	result, err := worker.crawlPeer(ctx, node)
 	after := time.Now()
 	if err != nil {
 		log.WithError(err).WithField("peer", node).Debug("unable to crawl node")
 	} else {
 		log.WithField("Result", result).Debug("crawled node")
 	}
-
-	cm.resultChan <- nodeCrawlResult{
+	res := nodeCrawlResult{
 		id:      node.ID,
 		node:    result,
 		startTs: before,
 		endTs:   after,
 		err:     err,
+		crawler: worker.id(),
+	}
+
+	// Queue events before returning the token. Handlers run asynchronously,
+	// so they may be called after the crawl finished, see EventManager.
+	switch {
+	case err != nil:
+		cm.events.Emit(EventCrawlError, node, err)
+	case result.crawlData.err != nil:
+		cm.events.Emit(EventCrawlError, node, result.crawlData.err)
+	case cm.events.hasHandlers(EventCrawlSuccess):
+		crawled := newNodeCrawlStatus(res).toCrawledNode(nil, nil, node.ID, 0)
+		cm.events.Emit(EventCrawlSuccess, node, crawled.Result)
+	}
+
+	// If CrawlNetwork may hold back results, see QueueOverflowBlock, we
+	// return the token first, so that crawls continue and drain the queue.
+	// Otherwise, the token is returned once the result is handed over, so
+	// that we don't pop peers before their neighbors are queued.
+	block := cm.config.QueueOverflowPolicy == QueueOverflowBlock
+	if block {
+		cm.returnToken(id)
+	}
+
+	// CrawlNetwork may have stopped waiting for us.
+	select {
+	case cm.resultChan <- res:
+	case <-cm.done:
+	}
+
+	if !block {
+		cm.returnToken(id)
 	}
-	cm.tokenBucket <- id
 }
 
-func (cm *CrawlManager) handleNewNode(node peer.AddrInfo) {
+// recordLocalOnly records a failed crawl of a peer which we do not dial,
+// because we only know private or loopback addresses of it.
+func (cm *CrawlManager) recordLocalOnly(node peer.AddrInfo) {
+	now := time.Now()
+	cm.upsertCrawlResult(nodeCrawlResult{
+		id:      node.ID,
+		startTs: now,
+		endTs:   now,
+		err:     ErrOnlyLocalAddrs,
+	})
+	cm.publish(node.ID)
+	cm.events.Emit(EventCrawlError, node, ErrOnlyLocalAddrs)
+}
+
+// handleNewNode queues a peer learned during the crawl, if necessary.
+// Returns whether the peer was previously unknown.
+func (cm *CrawlManager) handleNewNode(node peer.AddrInfo) bool {
+	// We keep addresses of every peer we've ever learned about.
+	_, known := cm.toCrawl.addrInfo[node.ID]
+	if !known {
+		cm.events.Emit(EventNodeDiscovered, node)
+	}
+
 	state, ok := cm.crawled[node.ID]
 	if ok {
 		if state.err == nil && state.result.crawlDataError == nil {
 			// We've crawled the node successfully before, no need to try again.
-			return
+			return false
 		}
 	}
 
+	if cm.config.DisableExpansion {
+		// Keep the addresses, but only crawl the peers we were given.
+		cm.toCrawl.remember(node)
+		return !known
+	}
+
+	if cm.config.SampleRate != nil && !inSample(node.ID, *cm.config.SampleRate, cm.config.SampleSeed) {
+		// Keep the addresses, but never crawl the peer.
+		cm.toCrawl.remember(node)
+		return !known
+	}
+
+	_, queued := cm.toCrawl.inQueue[node.ID]
+	if !queued && cm.queueFull() {
+		// Keep the addresses, maybe we find the peer again once there is room.
+		log.WithFields(log.Fields{"node": node.ID}).Debug("queue full, not queueing")
+		cm.toCrawl.remember(node)
+		if _, ok := cm.overflow[node.ID]; !ok {
+			cm.overflow[node.ID] = struct{}{}
+			queueOverflows.WithLabelValues(cm.config.Network).Inc()
+			if cm.config.QueueOverflowPolicy == QueueOverflowBlock {
+				cm.deferred = append(cm.deferred, node.ID)
+			}
+		}
+		return !known
+	}
+	if _, ok := cm.overflow[node.ID]; ok && !queued {
+		// Dropped before, so the queue won't know to crawl it.
+		delete(cm.overflow, node.ID)
+		cm.toCrawl.push(node, true)
+		return !known
+	}
+
 	// We've either not crawled the node or failed before.
 	// The queue will decide whether we have new addresses and should retry.
 	cm.toCrawl.push(node, false)
+
+	return !known
+}
+
+// queueDeferred queues peers held back while the queue was full, as long as
+// there is room, see QueueOverflowBlock.
+func (cm *CrawlManager) queueDeferred() {
+	for len(cm.deferred) != 0 && !cm.queueFull() {
+		id := cm.deferred[0]
+		cm.deferred = cm.deferred[1:]
+		if _, ok := cm.overflow[id]; !ok {
+			// Queued in the meantime, because we found it again.
+			continue
+		}
+		delete(cm.overflow, id)
+		// We remembered its addresses already.
+		cm.toCrawl.push(peer.AddrInfo{ID: id}, true)
+	}
+}
+
+// queueFull returns whether the crawl queue has reached MaxQueueDepth.
+func (cm *CrawlManager) queueFull() bool {
+	return cm.config.MaxQueueDepth != 0 && cm.toCrawl.len() >= cm.config.MaxQueueDepth
 }
 
-func (cm *CrawlManager) createReport() CrawlOutput {
-	numNodes := 0
-	numConnectable := 0
-	numCrawlable := 0
+// crawlSummary contains summary statistics about a crawl.
+type crawlSummary struct {
+	numNodes       int
+	numConnectable int
+	numCrawlable   int
+}
+
+// summarize computes summary statistics over the given crawl results.
+func summarize(nodes map[peer.ID]nodeCrawlStatus) crawlSummary {
+	var s crawlSummary
 
-	for _, state := range cm.crawled {
-		numNodes++
+	for _, state := range nodes {
+		s.numNodes++
 		if state.err == nil {
-			numConnectable++
+			s.numConnectable++
 			if state.result.crawlDataError == nil {
-				numCrawlable++
+				s.numCrawlable++
 			}
 		}
 	}
 
+	return s
+}
+
+// CrawlStats are summary statistics about a crawl.
+type CrawlStats struct {
+	// The number of nodes we tried to connect to.
+	TotalNodes int `json:"total_nodes"`
+	// The number of nodes we were able to connect to.
+	ReachableNodes int `json:"reachable_nodes"`
+	// The number of nodes we were unable to connect to.
+	UnreachableNodes int `json:"unreachable_nodes"`
+	// The number of edges of the peer graph, i.e., the total number of
+	// neighbors found in routing tables.
+	TotalEdges int `json:"total_edges"`
+	// The duration of the crawl, serialized in nanoseconds.
+	Duration time.Duration `json:"duration_ns"`
+	// The number of nodes which still returned new peers at the maximum CPL,
+	// i.e., whose closest buckets were not dumped.
+	PrefixLimitedNodes int `json:"prefix_limited_nodes"`
+	// The number of reachable nodes whose connections were all closed before
+	// we finished crawling them.
+	DroppedConnectionNodes int `json:"dropped_connection_nodes"`
+	// The number of nodes with at least one publicly routable address,
+	// excluding relayed addresses.
+	PublicAddrNodes int `json:"public_addr_nodes"`
+	// The number of nodes with only private or relayed addresses.
+	NonPublicAddrNodes int `json:"non_public_addr_nodes"`
+	// The number of errors by category, counting connection errors of
+	// unreachable nodes and crawl errors of reachable nodes.
+	// See errorCategory for the categories.
+	ErrorsByCategory map[string]int `json:"errors_by_category"`
+	// The number of crawlable nodes by the DHT protocol negotiated with
+	// them.
+	NodesByDHTProtocol map[protocol.ID]int `json:"nodes_by_dht_protocol"`
+	// Statistics about the addresses of reachable nodes.
+	Addrs AddrStats `json:"address_stats"`
+	// The number of reachable nodes by the address family of the connection
+	// we crawled them over, see addrFamily.
+	ReachableByFamily map[string]int `json:"reachable_by_family"`
+	// The number of connected components of the peer graph between
+	// crawlable nodes and the number of nodes in the largest one, see
+	// ConnectedComponents.
+	Components           int `json:"components"`
+	LargestComponentSize int `json:"largest_component_size"`
+	// Statistics about the degrees of the nodes of the peer graph.
+	Degrees DegreeStats `json:"degree_stats"`
+	// The number of reachable nodes by the autonomous system of the
+	// connection we crawled them over, and the number of distinct
+	// autonomous systems, if an ASN database is configured.
+	ASNHistogram map[uint]int `json:"asn_histogram,omitempty"`
+	UniqueASNs   int          `json:"unique_asns,omitempty"`
+	// The number of reachable nodes by the country of the connection we
+	// crawled them over, if a GeoIP database is configured.
+	CountryHistogram map[string]int `json:"country_histogram,omitempty"`
+}
+
+// AddrStats are statistics about the publicly routable addresses of reachable
+// nodes, excluding relayed addresses.
+type AddrStats struct {
+	// The number of distinct IP addresses.
+	DistinctIPs int `json:"distinct_ips"`
+	// The number of distinct /24 prefixes of IPv4 addresses.
+	DistinctIPv4Prefixes24 int `json:"distinct_ipv4_prefixes_24"`
+	// The number of nodes with at least one address on each TCP or UDP
+	// port.
+	PortHistogram map[int]int `json:"port_histogram"`
+	// The number of nodes with at least one address of each transport, see
+	// addrTransportLabel.
+	TransportHistogram map[string]int `json:"transport_histogram"`
+	// The number of nodes browsers can dial directly, see
+	// isBrowserDialable.
+	BrowserDialableNodes int `json:"browser_dialable_nodes"`
+}
+
+// computeAddrStats computes statistics about the addresses of the reachable
+// nodes.
+func computeAddrStats(nodes map[peer.ID]nodeCrawlStatus, addrInfo map[peer.ID][]ma.Multiaddr) AddrStats {
+	ips := make(map[string]struct{})
+	prefixes := make(map[[3]byte]struct{})
+	ports := make(map[int]int)
+	transports := make(map[string]int)
+	browserDialable := 0
+	for id, state := range nodes {
+		if state.err != nil {
+			continue
+		}
+		nodePorts := make(map[int]struct{})
+		nodeTransports := make(map[string]struct{})
+		nodeBrowserDialable := false
+		for _, maddr := range addrInfo[id] {
+			if !isPublicAddr(maddr) {
+				continue
+			}
+			nodeTransports[addrTransportLabel(maddr)] = struct{}{}
+			nodeBrowserDialable = nodeBrowserDialable || isBrowserDialable(maddr)
+			ip, port, ok := ipAndPort(maddr)
+			if !ok {
+				continue
+			}
+			ips[string(ip)] = struct{}{}
+			if ip4 := ip.To4(); ip4 != nil {
+				prefixes[[3]byte{ip4[0], ip4[1], ip4[2]}] = struct{}{}
+			}
+			nodePorts[port] = struct{}{}
+		}
+		for port := range nodePorts {
+			ports[port]++
+		}
+		for t := range nodeTransports {
+			transports[t]++
+		}
+		if nodeBrowserDialable {
+			browserDialable++
+		}
+	}
+
+	return AddrStats{
+		DistinctIPs:            len(ips),
+		DistinctIPv4Prefixes24: len(prefixes),
+		PortHistogram:          ports,
+		TransportHistogram:     transports,
+		BrowserDialableNodes:   browserDialable,
+	}
+}
+
+// computeStats computes summary statistics over the given crawl results and
+// the addresses of the nodes.
+func computeStats(nodes map[peer.ID]nodeCrawlStatus, addrInfo map[peer.ID][]ma.Multiaddr, duration time.Duration) CrawlStats {
+	s := CrawlStats{
+		Duration:           duration,
+		ErrorsByCategory:   make(map[string]int),
+		NodesByDHTProtocol: make(map[protocol.ID]int),
+		ReachableByFamily:  make(map[string]int),
+	}
+
+	for id, state := range nodes {
+		s.TotalNodes++
+		if hasPublicAddr(addrInfo[id]) {
+			s.PublicAddrNodes++
+		} else {
+			s.NonPublicAddrNodes++
+		}
+		if state.err != nil {
+			s.UnreachableNodes++
+			s.ErrorsByCategory[errorCategory(state.err)]++
+			continue
+		}
+		s.ReachableNodes++
+		s.ReachableByFamily[addrFamily(state.result.info.ConnectedAddr)]++
+		if state.result.as != nil {
+			if s.ASNHistogram == nil {
+				s.ASNHistogram = make(map[uint]int)
+			}
+			s.ASNHistogram[state.result.as.number]++
+		}
+		if state.result.geo != nil {
+			if s.CountryHistogram == nil {
+				s.CountryHistogram = make(map[string]int)
+			}
+			s.CountryHistogram[state.result.geo.country]++
+		}
+		if state.result.connectionDropped {
+			s.DroppedConnectionNodes++
+		}
+		if state.result.crawlDataError != nil {
+			s.ErrorsByCategory[errorCategory(state.result.crawlDataError)]++
+			continue
+		}
+		s.TotalEdges += len(state.result.crawlNeighbors)
+		if state.result.prefixLimitReached {
+			s.PrefixLimitedNodes++
+		}
+		s.NodesByDHTProtocol[state.result.dhtProtocol]++
+	}
+	s.UniqueASNs = len(s.ASNHistogram)
+	s.Addrs = computeAddrStats(nodes, addrInfo)
+
+	s.Degrees = computeDegreeStats(nodes)
+
+	components := connectedComponents(nodes)
+	s.Components = len(components)
+	if len(components) != 0 {
+		s.LargestComponentSize = len(components[0])
+	}
+
+	return s
+}
+
+// errorCategory assigns an error to a coarse category, based on the
+// sentinel errors and types it wraps.
+// The categories are only_local_addresses, no_addresses, connection_refused,
+// protocol_not_supported, stream_reset, timeout, and canceled, and, for other
+// errors, connect_failed and stream_failed if we were unable to connect to a
+// peer or to open a DHT stream, respectively, or other.
+// Errors restored from a checkpoint retain their category.
+func errorCategory(err error) string {
+	var restored *restoredError
+	if errors.As(err, &restored) {
+		return restored.category
+	}
+
+	// Dials fail with the errors of the individual addresses.
+	var dialErr *swarm.DialError
+	if errors.As(err, &dialErr) && dialErr.Cause == nil {
+		for _, te := range dialErr.DialErrors {
+			if category := specificErrorCategory(te.Cause); len(category) != 0 {
+				return category
+			}
+		}
+	}
+	if category := specificErrorCategory(err); len(category) != 0 {
+		return category
+	}
+
+	switch {
+	case errors.Is(err, ErrConnectFailed):
+		return "connect_failed"
+	case errors.Is(err, ErrStreamFailed):
+		return "stream_failed"
+	default:
+		return "other"
+	}
+}
+
+// specificErrorCategory returns the category of an error, see errorCategory,
+// or an empty string if it falls into none of the specific categories.
+func specificErrorCategory(err error) string {
+	var unsupported *unsupportedProtocolsError
+	var timeout interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, ErrOnlyLocalAddrs):
+		return "only_local_addresses"
+	case errors.Is(err, swarm.ErrNoAddresses) || errors.Is(err, swarm.ErrNoGoodAddresses):
+		return "no_addresses"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.As(err, &unsupported) || errors.Is(err, multistream.ErrNotSupported[protocol.ID]{}):
+		return "protocol_not_supported"
+	case errors.Is(err, network.ErrReset):
+		return "stream_reset"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return ""
+	}
+}
+
+// countAgentVersions counts the reachable nodes per agent version, optionally
+// normalized.
+// Nodes without an agent version are counted as unknownAgentVersion.
+func countAgentVersions(nodes map[peer.ID]nodeCrawlStatus, normalize bool) map[string]int {
+	counts := make(map[string]int)
+	for _, node := range nodes {
+		if node.err != nil {
+			continue
+		}
+		agentVersion := node.result.info.AgentVersion
+		if normalize {
+			agentVersion = normalizeAgentVersion(agentVersion)
+		}
+		if len(agentVersion) == 0 {
+			agentVersion = unknownAgentVersion
+		}
+		counts[agentVersion]++
+	}
+	return counts
+}
+
+// unknownAgentVersion is the key under which countAgentVersions counts nodes
+// without an agent version.
+const unknownAgentVersion = "unknown"
+
+// normalizeAgentVersion strips the commit hash and everything after it from
+// an agent version, e.g., kubo/0.18.1/675f8bd/docker becomes kubo/0.18.1.
+// Commit hashes are components of at least seven hexadecimal characters,
+// including at least one letter.
+func normalizeAgentVersion(agentVersion string) string {
+	components := strings.Split(agentVersion, "/")
+	for i, c := range components {
+		if i != 0 && isCommitHash(c) {
+			return strings.Join(components[:i], "/")
+		}
+	}
+	return agentVersion
+}
+
+// isCommitHash checks whether s looks like an abbreviated git commit hash.
+// Purely numeric components, e.g., dates, are not considered hashes.
+func isCommitHash(s string) bool {
+	if len(s) < 7 {
+		return false
+	}
+	letter := false
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+		letter = letter || r >= 'a'
+	}
+	return letter
+}
+
+// newCrawlID generates a random crawl ID, formatted as a version 4 UUID.
+// If a seed is given, the ID is derived from it instead.
+func newCrawlID(seed *int64) string {
+	var b [16]byte
+	if seed != nil {
+		_, _ = rand.New(rand.NewSource(*seed)).Read(b[:])
+	} else {
+		_, err := crand.Read(b[:])
+		if err != nil {
+			// This does not happen on any supported platform.
+			panic(fmt.Sprintf("unable to generate crawl ID: %s", err))
+		}
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// createReport collects the results of the crawl.
+// This does not copy any data, but serializing the report via
+// CrawlOutput.WriteMetadata does. For huge crawls, use
+// CrawlManager.WriteReportStreaming instead.
+func (cm *CrawlManager) createReport(crawlID string, startTs time.Time) CrawlOutput {
+	summary := summarize(cm.crawled)
+	endTs := time.Now()
+
 	log.WithFields(log.Fields{
-		"number of nodes":   numNodes,
-		"connectable nodes": numConnectable,
-		"crawlable nodes":   numCrawlable,
+		"number of nodes":   summary.numNodes,
+		"connectable nodes": summary.numConnectable,
+		"crawlable nodes":   summary.numCrawlable,
+		"crawls completed":  cm.crawlsCompleted,
+		"nodes per second":  float64(summary.numNodes) / endTs.Sub(startTs).Seconds(),
 	}).Info("Crawl finished. Summary of results.")
 
+	var crawlerIDs []peer.ID
+	for _, w := range cm.workers {
+		crawlerIDs = append(crawlerIDs, w.id())
+	}
+
+	if cm.asnDB != nil {
+		enrichASNs(cm.asnDB, cm.crawled)
+	}
+	if cm.geoDB != nil {
+		enrichGeoIP(cm.geoDB, cm.crawled)
+	}
+	stats := computeStats(cm.crawled, cm.toCrawl.addrInfo, endTs.Sub(startTs))
+	if cm.asnDB != nil {
+		uniqueASNs.WithLabelValues(cm.config.Network).Set(float64(stats.UniqueASNs))
+	}
+
 	return CrawlOutput{
-		nodes:    cm.crawled,
-		addrInfo: cm.toCrawl.addrInfo,
+		Stats:   stats,
+		Network: cm.config.Network,
+		CrawlID: crawlID,
+		Config:  cm.config,
+
+		nodes:       cm.crawled,
+		addrInfo:    cm.toCrawl.addrInfo,
+		firstSeen:   cm.toCrawl.firstSeen,
+		startTs:     startTs,
+		endTs:       endTs,
+		skipped:     cm.skipped,
+		overflow:    cm.overflow,
+		crawlerIDs:  crawlerIDs,
+		edgeNovelty: cm.config.RecordEdgeNovelty,
+		edgeCPL:     cm.config.CrawlerConfig.RecordNeighborCPL,
+		maxAddrs:    cm.config.MaxStoredAddrsPerNode,
+
+		agentVersionCounts: countAgentVersions(cm.crawled, cm.config.NormalizeAgentVersions),
 	}
 }
@@ -0,0 +1,1165 @@
+package crawling
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestCrawlManager creates a CrawlManager with a single MockWorker with the
+// given responses, which crawls starting at the given bootstrap peers.
+func newTestCrawlManager(t *testing.T, config CrawlManagerConfig, responses map[peer.ID]MockResponse, bootstrap ...peer.ID) (*CrawlManager, *MockWorker) {
+	t.Helper()
+
+	w, err := NewMockWorker(responses, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ConcurrentRequests == 0 {
+		config.ConcurrentRequests = 1
+	}
+	for _, id := range bootstrap {
+		config.BootstrapPeers = append(config.BootstrapPeers, "/ip4/1.2.3.4/tcp/4001/p2p/"+id.String())
+	}
+	cm, err := NewCrawlManagerWithMockWorkers(config, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cm.Stop() })
+
+	return cm, w
+}
+
+// newTestPeer generates a new key pair and returns the derived peer ID and
+// public key.
+func newTestPeer(t testing.TB) (peer.ID, crypto.PubKey) {
+	t.Helper()
+
+	_, pub, err := crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, pub
+}
+
+func TestCrawlManagerConfigBootstrapPeers(t *testing.T) {
+	for _, test := range []struct {
+		config CrawlManagerConfig
+		valid  bool
+	}{
+		{CrawlManagerConfig{}, false},
+		{CrawlManagerConfig{BootstrapPeers: []string{"/ip4/1.2.3.4/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"}}, true},
+		{CrawlManagerConfig{BootstrapPeersFile: "bootstrappeers.txt"}, true},
+		{CrawlManagerConfig{UseDefaultBootstrapPeers: true}, true},
+		{CrawlManagerConfig{DisableExpansion: true}, true},
+	} {
+		test.config.NumWorkers = 1
+		test.config.ConcurrentRequests = 1
+		err := test.config.checkManager()
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%+v: expected valid %t, got error %v", test.config, test.valid, err)
+		}
+	}
+}
+
+func TestNormalizeAgentVersion(t *testing.T) {
+	for agentVersion, expected := range map[string]string{
+		"kubo/0.18.1/675f8bd/docker": "kubo/0.18.1",
+		"go-ipfs/0.12.0/abc1234":     "go-ipfs/0.12.0",
+		"go-ipfs/0.12.0/":            "go-ipfs/0.12.0/",
+		// Dates are not commit hashes.
+		"storm/20230101":      "storm/20230101",
+		"abc1234":             "abc1234",
+		"kubo/0.18.1/ABC1234": "kubo/0.18.1/ABC1234",
+	} {
+		if normalized := normalizeAgentVersion(agentVersion); normalized != expected {
+			t.Errorf("%s: expected %s, got %s", agentVersion, expected, normalized)
+		}
+	}
+}
+
+func TestCountAgentVersions(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	d, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{NormalizeAgentVersions: true}, map[peer.ID]MockResponse{
+		a: {AgentVersion: "kubo/0.18.1/675f8bd", Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001")}},
+			{ID: d, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.7/tcp/4001")}},
+		}},
+		b: {AgentVersion: "kubo/0.18.1/abc1234"},
+		c: {},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"kubo/0.18.1": 2, unknownAgentVersion: 1}
+	if !reflect.DeepEqual(out.agentVersionCounts, expected) {
+		t.Errorf("expected %v, got %v", expected, out.agentVersionCounts)
+	}
+}
+
+func TestCrawlNetworkDeduplicatesAddresses(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{addr, addr}}}},
+		b: {},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs := out.addrInfo[b]; len(addrs) != 1 || !addrs[0].Equal(addr) {
+		t.Errorf("expected only %s, got %v", addr, addrs)
+	}
+}
+
+func TestCrawlStats(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	d, _ := newTestPeer(t)
+	public := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{KeepLocalAddrs: true}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: public},
+			{ID: c, Addrs: public},
+			{ID: d, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/10.0.0.1/tcp/4001")}},
+		}},
+		b: {CrawlErr: fmt.Errorf("%w: %w", ErrStreamFailed, network.ErrReset)},
+		c: {Err: fmt.Errorf("%w: %w", ErrConnectFailed, syscall.ECONNREFUSED)},
+		d: {Err: ErrConnectFailed},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := out.Stats
+	for name, test := range map[string]struct{ actual, expected int }{
+		"total nodes":           {s.TotalNodes, len(out.nodes)},
+		"reachable nodes":       {s.ReachableNodes, 2},
+		"unreachable nodes":     {s.UnreachableNodes, 2},
+		"total edges":           {s.TotalEdges, 3},
+		"public addr nodes":     {s.PublicAddrNodes, 3},
+		"non-public addr nodes": {s.NonPublicAddrNodes, 1},
+	} {
+		if test.actual != test.expected {
+			t.Errorf("expected %d %s, got %d", test.expected, name, test.actual)
+		}
+	}
+	expected := map[string]int{"stream_reset": 1, "connection_refused": 1, "connect_failed": 1}
+	if !reflect.DeepEqual(s.ErrorsByCategory, expected) {
+		t.Errorf("expected errors by category %v, got %v", expected, s.ErrorsByCategory)
+	}
+
+	// The stats are serialized in the header.
+	encoded, err := json.Marshal(out.metadata(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header struct {
+		Stats struct {
+			TotalNodes         int            `json:"total_nodes"`
+			PublicAddrNodes    int            `json:"public_addr_nodes"`
+			NonPublicAddrNodes int            `json:"non_public_addr_nodes"`
+			ErrorsByCategory   map[string]int `json:"errors_by_category"`
+		} `json:"stats"`
+	}
+	err = json.Unmarshal(encoded, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Stats.TotalNodes != s.TotalNodes ||
+		header.Stats.PublicAddrNodes != s.PublicAddrNodes ||
+		header.Stats.NonPublicAddrNodes != s.NonPublicAddrNodes ||
+		!reflect.DeepEqual(header.Stats.ErrorsByCategory, expected) {
+		t.Errorf("stats not serialized, got %+v", header.Stats)
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	for _, test := range []struct {
+		err      error
+		category string
+	}{
+		{ErrOnlyLocalAddrs, "only_local_addresses"},
+		{fmt.Errorf("%w: %w", ErrConnectFailed, &swarm.DialError{
+			DialErrors: []swarm.TransportError{
+				{Cause: errors.New("some error")},
+				{Cause: syscall.ECONNREFUSED},
+			},
+		}), "connection_refused"},
+		{fmt.Errorf("%w: %w", ErrConnectFailed, swarm.ErrNoAddresses), "no_addresses"},
+		{fmt.Errorf("%w: %w", ErrConnectFailed, context.DeadlineExceeded), "timeout"},
+		{fmt.Errorf("%w: %w", ErrConnectFailed, errors.New("peer id mismatch")), "connect_failed"},
+		{fmt.Errorf("%w: %w", ErrStreamFailed, multistream.ErrNotSupported[protocol.ID]{}), "protocol_not_supported"},
+		{fmt.Errorf("%w: %w", ErrStreamFailed, context.Canceled), "canceled"},
+		{fmt.Errorf("%w: %w", ErrStreamFailed, errors.New("some error")), "stream_failed"},
+		{errors.New("timeout"), "other"},
+		{&restoredError{msg: "timeout", category: "timeout"}, "timeout"},
+	} {
+		if category := errorCategory(test.err); category != test.category {
+			t.Errorf("%v: expected %s, got %s", test.err, test.category, category)
+		}
+	}
+}
+
+func TestUpsertCrawlResultConflictingKeys(t *testing.T) {
+	a, keyA := newTestPeer(t)
+	_, keyB := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, nil, a)
+
+	crawled := func(keys ...crypto.PubKey) nodeCrawlResult {
+		return nodeCrawlResult{
+			id: a,
+			node: &rawNodeInformation{
+				info: peerMetadata{
+					publicKey:      keys[0],
+					connectionKeys: keys[1:],
+				},
+			},
+			startTs: time.Now(),
+			endTs:   time.Now(),
+		}
+	}
+
+	cm.upsertCrawlResult(crawled(keyA, keyA))
+	if cm.crawled[a].result.conflictingKeys {
+		t.Fatal("identical keys flagged as conflicting")
+	}
+
+	// A second connection with a different key in the same crawl.
+	cm.upsertCrawlResult(crawled(keyA, keyB))
+	if !cm.crawled[a].result.conflictingKeys {
+		t.Fatal("conflicting keys of two connections not flagged")
+	}
+
+	// The flag sticks for later crawls of the peer, which are compared to
+	// the first key.
+	cm.upsertCrawlResult(crawled(keyA))
+	if !cm.crawled[a].result.conflictingKeys {
+		t.Fatal("conflicting keys of earlier crawl not kept")
+	}
+}
+
+func TestUpsertCrawlResultConflictingKeysAcrossCrawls(t *testing.T) {
+	a, keyA := newTestPeer(t)
+	_, keyB := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, nil, a)
+
+	for _, key := range []crypto.PubKey{keyA, keyB} {
+		cm.upsertCrawlResult(nodeCrawlResult{
+			id:   a,
+			node: &rawNodeInformation{info: peerMetadata{publicKey: key}},
+		})
+	}
+	if !cm.crawled[a].result.conflictingKeys {
+		t.Fatal("conflicting keys of two crawls not flagged")
+	}
+}
+
+func TestCrawlNetworkDrain(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	addr := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}
+	responses := map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: addr}, {ID: c, Addrs: addr}}},
+		b: {},
+		c: {},
+	}
+
+	// a is crawled after 200ms, b and c are in flight when the crawl is
+	// stopped after 300ms, and finish after 400ms.
+	stops := map[string]func(*CrawlManagerConfig) (context.Context, context.CancelFunc){
+		"deadline": func(config *CrawlManagerConfig) (context.Context, context.CancelFunc) {
+			config.MaxCrawlDuration = 300 * time.Millisecond
+			return context.WithCancel(context.Background())
+		},
+		"cancel": func(config *CrawlManagerConfig) (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 300*time.Millisecond)
+		},
+	}
+	for name, stop := range stops {
+		for _, drain := range []struct {
+			timeout time.Duration
+			nodes   int
+		}{
+			{10 * time.Millisecond, 1},
+			{5 * time.Second, 3},
+		} {
+			t.Run(fmt.Sprintf("%s/%s", name, drain.timeout), func(t *testing.T) {
+				config := CrawlManagerConfig{
+					ConcurrentRequests: 2,
+					DrainTimeout:       &drain.timeout,
+				}
+				ctx, cancel := stop(&config)
+				defer cancel()
+				w, err := NewMockWorker(responses, 200*time.Millisecond)
+				if err != nil {
+					t.Fatal(err)
+				}
+				config.BootstrapPeers = []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + a.String()}
+				cm, err := NewCrawlManagerWithMockWorkers(config, w)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer cm.Stop()
+
+				out, _ := cm.CrawlNetwork(ctx)
+				if len(out.nodes) != drain.nodes {
+					t.Errorf("expected %d nodes, got %d", drain.nodes, len(out.nodes))
+				}
+				for id, node := range out.nodes {
+					if node.err != nil {
+						t.Errorf("node %s failed: %v", id, node.err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCrawlNetworkStoppedEarly(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	responses := map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}}}},
+		b: {},
+	}
+
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{MaxNodes: 1}, responses, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if !errors.Is(err, ErrCrawlStopped) {
+		t.Fatalf("expected ErrCrawlStopped, got %v", err)
+	}
+	if len(out.nodes) != 1 {
+		t.Errorf("expected partial results, got %d nodes", len(out.nodes))
+	}
+	header := out.metadata(false)
+	if !header.StoppedEarly || header.StopReason != "maximum number of nodes reached" {
+		t.Errorf("expected stop to be recorded, got %t, %q", header.StoppedEarly, header.StopReason)
+	}
+
+	// Crawls which finish are not stopped early.
+	cm, _ = newTestCrawlManager(t, CrawlManagerConfig{}, responses, a)
+	out, err = cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := out.metadata(false); header.StoppedEarly || len(header.StopReason) != 0 {
+		t.Errorf("expected no stop to be recorded, got %t, %q", header.StoppedEarly, header.StopReason)
+	}
+}
+
+func TestCrawlNetworkResetsNodesPerSecond(t *testing.T) {
+	a, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{Network: "test-nodes-per-second"}, map[peer.ID]MockResponse{
+		a: {},
+	}, a)
+	gauge := nodesPerSecond.WithLabelValues("test-nodes-per-second")
+	gauge.Set(42)
+
+	_, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(gauge); v != 0 {
+		t.Errorf("expected nodes per second to be reset, got %f", v)
+	}
+}
+
+func TestRecrawlUnreachablePeers(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	addrB := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	addrC := ma.StringCast("/ip4/1.2.3.6/tcp/4001")
+
+	// In the first crawl, b is unreachable.
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{addrB}}}},
+		b: {Err: errors.New("unreachable")},
+	}, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "visitedPeers.json")
+	err = out.WriteMetadata(path, OutputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unreachable, err := LoadUnreachablePeers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreachable) != 1 || unreachable[0].ID != b || len(unreachable[0].Addrs) != 1 || !unreachable[0].Addrs[0].Equal(addrB) {
+		t.Fatalf("expected only %s at %s to be unreachable, got %v", b, addrB, unreachable)
+	}
+
+	// In the second crawl, b is reachable, but we don't crawl its neighbors.
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{DisableExpansion: true}, map[peer.ID]MockResponse{
+		a: {},
+		b: {Neighbors: []peer.AddrInfo{{ID: c, Addrs: []ma.Multiaddr{addrC}}}},
+		c: {},
+	})
+	cm.AddPeersToCrawl(unreachable)
+	out, err = cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, n := range map[peer.ID]int{a: 0, b: 1, c: 0} {
+		if w.Requests(id) != n {
+			t.Errorf("expected %d requests to %s, got %d", n, id, w.Requests(id))
+		}
+	}
+	if len(out.nodes) != 1 || out.nodes[b].err != nil {
+		t.Errorf("expected only %s to be crawled successfully", b)
+	}
+	// The neighbors are known, though.
+	if addrs := out.addrInfo[c]; len(addrs) != 1 || !addrs[0].Equal(addrC) {
+		t.Errorf("expected address %s of neighbor, got %v", addrC, addrs)
+	}
+	if out.firstSeen[c].IsZero() {
+		t.Error("first sighting of neighbor not recorded")
+	}
+}
+
+func TestCrawlNetworkRetries(t *testing.T) {
+	for _, test := range []struct {
+		maxRetries uint
+		attempts   int
+		reachable  bool
+	}{
+		{0, 1, false},
+		{1, 2, false},
+		{2, 3, true},
+		{5, 3, true},
+	} {
+		t.Run(fmt.Sprintf("max_retries=%d", test.maxRetries), func(t *testing.T) {
+			a, _ := newTestPeer(t)
+			cm, w := newTestCrawlManager(t, CrawlManagerConfig{
+				MaxRetries:     test.maxRetries,
+				RetryBaseDelay: 10 * time.Millisecond,
+			}, map[peer.ID]MockResponse{
+				a: {FailFirst: 2},
+			}, a)
+
+			out, _ := cm.CrawlNetwork(context.Background())
+			if w.Requests(a) != test.attempts {
+				t.Errorf("expected %d requests, got %d", test.attempts, w.Requests(a))
+			}
+			node := out.nodes[a].toCrawledNode(out.addrInfo, out.firstSeen, a, 0)
+			if node.ConnectionAttempts != test.attempts {
+				t.Errorf("expected %d connection attempts, got %d", test.attempts, node.ConnectionAttempts)
+			}
+			if reachable := node.ConnectionError == nil; reachable != test.reachable {
+				t.Errorf("expected reachable %t, got %t", test.reachable, reachable)
+			}
+		})
+	}
+}
+
+func TestCrawlNetworkRetriesKnownAddresses(t *testing.T) {
+	a, _ := newTestPeer(t)
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{
+		MaxRetries:     1,
+		RetryBaseDelay: 10 * time.Millisecond,
+	}, map[peer.ID]MockResponse{
+		a: {FailFirst: 1},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// We learn no new addresses of the peer, so the retry uses the
+	// address of the first attempt.
+	attempts := w.CrawlAddrs(a)
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(attempts))
+	}
+	bootstrapAddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	for i, addrs := range attempts {
+		if len(addrs) != 1 || !addrs[0].Equal(bootstrapAddr) {
+			t.Errorf("expected attempt %d to use %s, got %v", i+1, bootstrapAddr, addrs)
+		}
+	}
+	node := out.nodes[a].toCrawledNode(out.addrInfo, out.firstSeen, a, 0)
+	if node.ConnectionError != nil || node.ConnectionAttempts != 2 {
+		t.Errorf("expected the second attempt to succeed, got error %v after %d attempts", node.ConnectionError, node.ConnectionAttempts)
+	}
+}
+
+func TestCrawlNetworkDialsUpdatedAddresses(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	oldAddr := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	newAddr := ma.StringCast("/ip4/1.2.3.6/tcp/4001")
+	// With one request at a time, c is crawled before b, while b is queued,
+	// and reports a new address of b.
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.7/tcp/4001")}},
+			{ID: b, Addrs: []ma.Multiaddr{oldAddr}},
+		}},
+		b: {},
+		c: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{newAddr}}}},
+	}, a)
+
+	_, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := w.CrawlAddrs(b)
+	if len(attempts) != 1 {
+		t.Fatalf("expected b to be crawled once, got %d attempts", len(attempts))
+	}
+	expected := []ma.Multiaddr{oldAddr, newAddr}
+	if !sameAddrs(attempts[0], expected) {
+		t.Errorf("expected b to be dialed on %v, got %v", expected, attempts[0])
+	}
+}
+
+func TestCrawlNetworkConcurrencyLimit(t *testing.T) {
+	a, _ := newTestPeer(t)
+	responses := make(map[peer.ID]MockResponse)
+	var neighbors []peer.AddrInfo
+	for i := 0; i < 30; i++ {
+		id, _ := newTestPeer(t)
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast(fmt.Sprintf("/ip4/1.2.4.%d/tcp/4001", i))}})
+		// Every peer fails once, so retries compete with first attempts.
+		responses[id] = MockResponse{FailFirst: 1}
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+
+	w, err := NewMockWorker(responses, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewCrawlManagerWithMockWorkers(CrawlManagerConfig{
+		ConcurrentRequests: 3,
+		MaxRetries:         1,
+		RetryBaseDelay:     time.Millisecond,
+		BootstrapPeers:     []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + a.String()},
+	}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	_, err = cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range neighbors {
+		if r := w.Requests(n.ID); r != 2 {
+			t.Errorf("expected 2 requests to %s, got %d", n.ID, r)
+		}
+	}
+	if n := w.MaxInFlight(); n < 2 || n > 3 {
+		t.Errorf("expected up to 3 concurrent requests, got %d", n)
+	}
+}
+
+func TestCreateWorkersConcurrently(t *testing.T) {
+	const n = 10
+	for _, limit := range []uint{0, 1, 2} {
+		var m sync.Mutex
+		inFlight, peak := 0, 0
+		workers, err := createWorkersConcurrently(n, limit, func(int) (worker, error) {
+			m.Lock()
+			inFlight++
+			if inFlight > peak {
+				peak = inFlight
+			}
+			m.Unlock()
+			defer func() {
+				m.Lock()
+				inFlight--
+				m.Unlock()
+			}()
+
+			time.Sleep(5 * time.Millisecond)
+			return NewMockWorker(nil, 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(workers) != n {
+			t.Fatalf("limit %d: expected %d workers, got %d", limit, n, len(workers))
+		}
+		ids := make(map[peer.ID]struct{})
+		for _, w := range workers {
+			ids[w.id()] = struct{}{}
+		}
+		if len(ids) != n {
+			t.Errorf("limit %d: expected %d distinct workers, got %d", limit, n, len(ids))
+		}
+		expected := int(limit)
+		if limit == 0 {
+			expected = 1
+		}
+		if peak > expected {
+			t.Errorf("limit %d: expected at most %d workers to be created at a time, got %d", limit, expected, peak)
+		}
+	}
+
+	_, err := createWorkersConcurrently(n, 2, func(i int) (worker, error) {
+		if i == 3 {
+			return nil, errors.New("no such transport")
+		}
+		return NewMockWorker(nil, 0)
+	})
+	if err == nil {
+		t.Error("expected an error if creating a worker fails")
+	}
+}
+
+func TestMockWorkerCapacity(t *testing.T) {
+	a, _ := newTestPeer(t)
+	responses := map[peer.ID]MockResponse{a: {}}
+	var neighbors []peer.AddrInfo
+	for i := 0; i < 20; i++ {
+		id, _ := newTestPeer(t)
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast(fmt.Sprintf("/ip4/1.2.4.%d/tcp/4001", i))}})
+		responses[id] = MockResponse{}
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+
+	w1, err := NewMockWorker(responses, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w1.SetCapacity(1)
+	w2, err := NewMockWorker(responses, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2.SetCapacity(3)
+	cm, err := NewCrawlManagerWithMockWorkers(CrawlManagerConfig{
+		BootstrapPeers: []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + a.String()},
+	}, w1, w2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	_, err = cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := w1.MaxInFlight(); n != 1 {
+		t.Errorf("expected at most 1 concurrent request to the first worker, got %d", n)
+	}
+	if n := w2.MaxInFlight(); n < 2 || n > 3 {
+		t.Errorf("expected up to 3 concurrent requests to the second worker, got %d", n)
+	}
+}
+
+func TestCrawledNodeRecordsCrawler(t *testing.T) {
+	a, _ := newTestPeer(t)
+	responses := map[peer.ID]MockResponse{}
+	var neighbors []peer.AddrInfo
+	for i := 0; i < 10; i++ {
+		id, _ := newTestPeer(t)
+		responses[id] = MockResponse{}
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}})
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+	w1, err := NewMockWorker(responses, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2, err := NewMockWorker(responses, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewCrawlManagerWithMockWorkers(CrawlManagerConfig{
+		BootstrapPeers:     []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + a.String()},
+		ConcurrentRequests: 2,
+	}, w1, w2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Crawlers are listed in the order of the workers, i.e., of
+	// WorkerOverrides.
+	if len(out.crawlerIDs) != 2 || out.crawlerIDs[0] != w1.id() || out.crawlerIDs[1] != w2.id() {
+		t.Fatalf("expected crawlers %s and %s, got %v", w1.id(), w2.id(), out.crawlerIDs)
+	}
+	crawled := make(map[peer.ID]int)
+	for id, status := range out.nodes {
+		node := status.toCrawledNode(out.addrInfo, out.firstSeen, id, 0)
+		crawled[node.CrawlerID]++
+		switch node.CrawlerID {
+		case w1.id():
+			if w1.Requests(id) != 1 || w2.Requests(id) != 0 {
+				t.Errorf("expected %s to be crawled by the first worker only", id)
+			}
+		case w2.id():
+			if w2.Requests(id) != 1 || w1.Requests(id) != 0 {
+				t.Errorf("expected %s to be crawled by the second worker only", id)
+			}
+		default:
+			t.Errorf("unexpected crawler %q of %s", node.CrawlerID, id)
+		}
+	}
+	if crawled[w1.id()] == 0 || crawled[w2.id()] == 0 {
+		t.Errorf("expected both workers to crawl nodes, got %v", crawled)
+	}
+}
+
+func TestCrawlNetworkEdgeNovelty(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	bInfo := peer.AddrInfo{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}}
+	// b is first found through a, and then again through c.
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{RecordEdgeNovelty: true}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001")}}, bInfo}},
+		b: {},
+		c: {Neighbors: []peer.AddrInfo{bInfo}},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if novel := out.nodes[a].result.novelNeighbors; !reflect.DeepEqual(novel, []bool{true, true}) {
+		t.Errorf("expected both edges of a to be novel, got %v", novel)
+	}
+	if novel := out.nodes[c].result.novelNeighbors; !reflect.DeepEqual(novel, []bool{false}) {
+		t.Errorf("expected the edge from c to b to be known, got %v", novel)
+	}
+
+	path := filepath.Join(t.TempDir(), "peergraph.csv")
+	err = out.WritePeergraph(path, OutputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	novel := make(map[[2]string]string)
+	for _, record := range records[1:] {
+		novel[[2]string{record[0], record[1]}] = record[4]
+	}
+	if records[0][4] != "target_novel" || novel[[2]string{a.String(), b.String()}] != "true" || novel[[2]string{c.String(), b.String()}] != "false" {
+		t.Errorf("unexpected peer graph %v", records)
+	}
+}
+
+func TestCrawlNetworkKeepRelayAddrs(t *testing.T) {
+	for _, keepRelay := range []bool{false, true} {
+		a, _ := newTestPeer(t)
+		b, _ := newTestPeer(t)
+		relay, _ := newTestPeer(t)
+		circuitAddr := ma.StringCast("/ip4/10.0.0.1/tcp/4001/p2p/" + relay.String() + "/p2p-circuit")
+		cm, w := newTestCrawlManager(t, CrawlManagerConfig{KeepRelayAddrs: keepRelay}, map[peer.ID]MockResponse{
+			a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{circuitAddr}}}},
+			b: {},
+		}, a)
+
+		out, err := cm.CrawlNetwork(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, ok := out.nodes[b]
+		if !ok {
+			t.Fatalf("keep relay %t: expected b to be recorded", keepRelay)
+		}
+		attempts := w.CrawlAddrs(b)
+		if keepRelay {
+			if len(attempts) != 1 || !sameAddrs(attempts[0], []ma.Multiaddr{circuitAddr}) {
+				t.Errorf("expected b to be dialed via %s, got %v", circuitAddr, attempts)
+			}
+			if status.err != nil {
+				t.Errorf("expected b to be reachable, got %v", status.err)
+			}
+		} else {
+			if len(attempts) != 0 {
+				t.Errorf("expected b not to be dialed, got %v", attempts)
+			}
+			if !errors.Is(status.err, ErrOnlyLocalAddrs) {
+				t.Errorf("expected b to only have local addresses, got %v", status.err)
+			}
+		}
+	}
+}
+
+func TestCrawlNetworkSkipsUndialablePeers(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{
+		WorkerConfig: WorkerConfig{Transports: []string{TransportTCP}},
+	}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/udp/4001/quic-v1")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/udp/4001/quic-v1"), ma.StringCast("/ip4/1.2.3.6/tcp/4001")}},
+		}},
+		b: {},
+		c: {},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b only has a QUIC address, so it is neither probed nor counted as
+	// unreachable.
+	if r := w.Requests(b); r != 0 {
+		t.Errorf("expected no requests to b, got %d", r)
+	}
+	if _, ok := out.nodes[b]; ok {
+		t.Errorf("expected b not to be recorded as probed, got %+v", out.nodes[b])
+	}
+	metadata := out.metadata(false)
+	if len(metadata.SkippedNodes) != 1 || metadata.SkippedNodes[0] != b {
+		t.Errorf("expected only b to be skipped, got %v", metadata.SkippedNodes)
+	}
+	if r := w.Requests(c); r != 1 {
+		t.Errorf("expected c to be probed via TCP, got %d requests", r)
+	}
+	if metadata.Stats.ReachableNodes != 2 {
+		t.Errorf("expected 2 reachable nodes, got %d", metadata.Stats.ReachableNodes)
+	}
+}
+
+func TestCrawlNetworkQueryBudget(t *testing.T) {
+	a, _ := newTestPeer(t)
+	responses := map[peer.ID]MockResponse{}
+	var neighbors []peer.AddrInfo
+	for i := 0; i < 20; i++ {
+		id, _ := newTestPeer(t)
+		responses[id] = MockResponse{}
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}})
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+	// The mock worker counts one query per request, so with one request at
+	// a time, the crawl stops after one more request than the budget.
+	const budget = 5
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{CrawlBudgetQueries: budget}, responses, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if !errors.Is(err, ErrCrawlStopped) {
+		t.Fatalf("expected ErrCrawlStopped, got %v", err)
+	}
+	if _, queries := w.usage(); queries != budget+1 {
+		t.Errorf("expected %d queries, got %d", budget+1, queries)
+	}
+	if n := len(out.nodes); n != budget+1 {
+		t.Errorf("expected %d crawled nodes, got %d", budget+1, n)
+	}
+	metadata := out.metadata(false)
+	if !metadata.StoppedEarly || metadata.StopReason != "crawl budget exceeded" {
+		t.Errorf("expected the crawl to be reported as stopped by the budget, got %q", metadata.StopReason)
+	}
+	// All peers found so far are still reported.
+	if n := len(out.addrInfo); n != len(neighbors)+1 {
+		t.Errorf("expected %d known peers, got %d", len(neighbors)+1, n)
+	}
+}
+
+func TestCrawlNetworkMaxNodes(t *testing.T) {
+	a, _ := newTestPeer(t)
+	responses := map[peer.ID]MockResponse{}
+	var neighbors []peer.AddrInfo
+	for i := 0; i < 50; i++ {
+		id, _ := newTestPeer(t)
+		responses[id] = MockResponse{}
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}})
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+	const maxNodes, concurrency = 10, 3
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{
+		MaxNodes:           maxNodes,
+		ConcurrentRequests: concurrency,
+	}, responses, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if !errors.Is(err, ErrCrawlStopped) {
+		t.Fatalf("expected ErrCrawlStopped, got %v", err)
+	}
+	// Requests in flight once the cap is reached are still recorded.
+	if n := len(out.nodes); n < maxNodes || n > maxNodes+concurrency-1 {
+		t.Errorf("expected %d to %d nodes, got %d", maxNodes, maxNodes+concurrency-1, n)
+	}
+	requests := w.Requests(a)
+	for _, p := range neighbors {
+		requests += w.Requests(p.ID)
+	}
+	if requests != len(out.nodes) {
+		t.Errorf("expected %d requests, one per recorded node, got %d", len(out.nodes), requests)
+	}
+
+	// All workers finished and returned their tokens.
+	deadline := time.Now().Add(time.Second)
+	for len(cm.tokenBucket) != cap(cm.tokenBucket) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(cm.tokenBucket) != cap(cm.tokenBucket) {
+		t.Errorf("expected %d tokens, got %d", cap(cm.tokenBucket), len(cm.tokenBucket))
+	}
+}
+
+func TestDryRunDoesNotDial(t *testing.T) {
+	bootstrapID, _ := newTestPeer(t)
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{DryRun: true}, map[peer.ID]MockResponse{}, bootstrapID)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.nodes) != 0 {
+		t.Errorf("expected an empty report, got %d nodes", len(out.nodes))
+	}
+
+	bootstrap := peer.AddrInfo{ID: bootstrapID, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	_, err = cm.CrawlSinglePeer(context.Background(), bootstrap)
+	if !errors.Is(err, ErrDryRun) {
+		t.Errorf("CrawlSinglePeer: expected ErrDryRun, got %v", err)
+	}
+	_, err = cm.LookupKey(context.Background(), []byte(bootstrapID), nil)
+	if !errors.Is(err, ErrDryRun) {
+		t.Errorf("LookupKey: expected ErrDryRun, got %v", err)
+	}
+	c, err := cid.Decode("bafkqaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cm.FindProvidersForCID(context.Background(), c, nil)
+	if !errors.Is(err, ErrDryRun) {
+		t.Errorf("FindProvidersForCID: expected ErrDryRun, got %v", err)
+	}
+
+	if n := w.Requests(bootstrapID); n != 0 {
+		t.Errorf("expected no requests in a dry run, got %d", n)
+	}
+}
+
+func TestCrawlNetworkDoesNotDialLocalOnlyPeers(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	local := ma.StringCast("/ip4/10.0.0.1/tcp/4001")
+	public := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{local}},
+			{ID: c, Addrs: []ma.Multiaddr{local, public}},
+		}},
+	}, a)
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := w.Requests(b); n != 0 {
+		t.Errorf("expected no request to %s, got %d", b, n)
+	}
+	if state, ok := out.nodes[b]; !ok || !errors.Is(state.err, ErrOnlyLocalAddrs) {
+		t.Errorf("expected %s to be recorded with ErrOnlyLocalAddrs, got %+v", b, state)
+	}
+	if addrs := out.addrInfo[b]; len(addrs) != 0 {
+		t.Errorf("expected no addresses of %s, got %v", b, addrs)
+	}
+
+	// Only the public address of c is kept and dialed.
+	if n := w.Requests(c); n != 1 {
+		t.Errorf("expected one request to %s, got %d", c, n)
+	}
+	if addrs := out.addrInfo[c]; len(addrs) != 1 || !addrs[0].Equal(public) {
+		t.Errorf("expected only %s for %s, got %v", public, c, addrs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() CrawlManagerConfig {
+		workerConfig, crawlerConfig := testWorkerConfigs()
+		return CrawlManagerConfig{
+			PreimageFilePath:   "preimages.csv",
+			NumWorkers:         1,
+			ConcurrentRequests: 1,
+			BootstrapPeers:     []string{"/ip4/1.2.3.4/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+			WorkerConfig:       workerConfig,
+			CrawlerConfig:      crawlerConfig,
+		}
+	}
+	err := valid().Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swarmKey := "swarm.key"
+	for expected, modify := range map[string]func(*CrawlManagerConfig){
+		"missing preimage file path":                                    func(c *CrawlManagerConfig) { c.PreimageFilePath = "" },
+		"missing or invalid num_workers":                                func(c *CrawlManagerConfig) { c.NumWorkers = 0 },
+		"missing or invalid concurrent_requests":                        func(c *CrawlManagerConfig) { c.ConcurrentRequests = 0 },
+		"missing bootstrap peers":                                       func(c *CrawlManagerConfig) { c.BootstrapPeers = nil },
+		"invalid max_queue_depth":                                       func(c *CrawlManagerConfig) { c.MaxQueueDepth = -1 },
+		"invalid worker_config: missing connection timeout":             func(c *CrawlManagerConfig) { c.WorkerConfig.ConnectTimeout = 0 },
+		"invalid worker_config: invalid or missing connection attempts": func(c *CrawlManagerConfig) { c.WorkerConfig.ConnectionAttempts = 0 },
+		"invalid worker_config: missing user agent":                     func(c *CrawlManagerConfig) { c.WorkerConfig.UserAgent = "" },
+		"invalid crawler_config: missing protocol strings":              func(c *CrawlManagerConfig) { c.CrawlerConfig.ProtocolStrings = nil },
+		"invalid crawler_config: missing interaction timeout":           func(c *CrawlManagerConfig) { c.CrawlerConfig.InteractionTimeout = -time.Second },
+		"invalid crawler_config: missing or invalid interaction attempts": func(c *CrawlManagerConfig) {
+			c.CrawlerConfig.InteractionAttempts = 0
+		},
+		"plugin does-not-exist: " + ErrPluginDoesNotExist.Error(): func(c *CrawlManagerConfig) {
+			c.Plugins = []PluginConfig{{Name: "does-not-exist"}}
+		},
+		"invalid worker_config for network private: transport quic does not support private networks": func(c *CrawlManagerConfig) {
+			c.WorkerConfig.Transports = []string{TransportTCP, TransportQUIC}
+			c.Networks = map[string]NetworkConfig{"private": {SwarmKeyPath: &swarmKey}}
+		},
+	} {
+		c := valid()
+		modify(&c)
+		err := c.Validate()
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestCrawlNetworkBoundsQueueUnderFlood(t *testing.T) {
+	const maxQueueDepth = 10
+
+	// The bootstrap peer knows 100 peers, each of which knows 5 more.
+	bootstrap, _ := newTestPeer(t)
+	responses := make(map[peer.ID]MockResponse)
+	var first []peer.AddrInfo
+	for i := 0; i < 100; i++ {
+		id, _ := newTestPeer(t)
+		var second []peer.AddrInfo
+		for j := 0; j < 5; j++ {
+			leaf, _ := newTestPeer(t)
+			responses[leaf] = MockResponse{}
+			second = append(second, peer.AddrInfo{ID: leaf, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}})
+		}
+		responses[id] = MockResponse{Neighbors: second}
+		first = append(first, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}})
+	}
+	responses[bootstrap] = MockResponse{Neighbors: first}
+	total := len(responses)
+
+	for _, policy := range []string{QueueOverflowDrop, QueueOverflowBlock} {
+		t.Run(policy, func(t *testing.T) {
+			w, err := NewMockWorker(responses, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			network := "test-flood-" + policy
+			cm, err := NewCrawlManagerWithMockWorkers(CrawlManagerConfig{
+				Network:             network,
+				ConcurrentRequests:  4,
+				MaxQueueDepth:       maxQueueDepth,
+				QueueOverflowPolicy: policy,
+				BootstrapPeers:      []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + bootstrap.String()},
+			}, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = cm.Stop() }()
+
+			// Watch the queue while crawling.
+			maxQueue := make(chan int)
+			go func() {
+				observed := 0
+				for {
+					status, ok := cm.Status()
+					if !ok {
+						break
+					}
+					if status.ToCrawlQueue > observed {
+						observed = status.ToCrawlQueue
+					}
+				}
+				maxQueue <- observed
+			}()
+
+			out, err := cm.CrawlNetwork(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if observed := <-maxQueue; observed > maxQueueDepth {
+				t.Errorf("expected at most %d queued peers, observed %d", maxQueueDepth, observed)
+			}
+
+			// Every peer found is either crawled or reported.
+			overflow := out.overflowNodes()
+			if len(out.nodes)+len(overflow) != len(out.addrInfo) {
+				t.Errorf("expected %d peers crawled or dropped, got %d crawled and %d dropped", len(out.addrInfo), len(out.nodes), len(overflow))
+			}
+			dropped := testutil.ToFloat64(queueOverflows.WithLabelValues(network))
+			switch policy {
+			case QueueOverflowDrop:
+				if len(overflow) == 0 || dropped < float64(len(overflow)) {
+					t.Errorf("expected dropped peers to be reported, got %d in the output and %v in metrics", len(overflow), dropped)
+				}
+			case QueueOverflowBlock:
+				// Every peer is queued eventually.
+				if len(out.nodes) != total || dropped == 0 {
+					t.Errorf("expected all %d peers to be crawled, got %d, %v of which were held back", total, len(out.nodes), dropped)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package crawling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A findNodeRecord describes the FIND_NODE requests made to crawl a peer, as
+// written to the debug dump, see CrawlerConfig.DebugDumpPath.
+type findNodeRecord struct {
+	Peer     peer.ID             `json:"peer"`
+	Ts       time.Time           `json:"timestamp"`
+	Requests []findNodeRecordCPL `json:"requests"`
+}
+
+// A findNodeRecordCPL describes the FIND_NODE request for a single CPL.
+type findNodeRecordCPL struct {
+	CPL int `json:"cpl"`
+	// The hex-encoded target of the request.
+	Target string `json:"target"`
+	// The number of peers returned.
+	Peers int `json:"peers"`
+	// The number of peers returned which were not returned for lower CPLs.
+	NewPeers int `json:"new_peers"`
+	// The number of distinct peers returned up to and including this CPL.
+	CumulativeNewPeers int     `json:"cumulative_new_peers"`
+	Error              *string `json:"error,omitempty"`
+}
+
+// A findNodeDump writes findNodeRecords to a file as JSON Lines.
+// A nil *findNodeDump ignores all records.
+type findNodeDump struct {
+	m   sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// newFindNodeDump creates the file findnode_<id>.jsonl in the given
+// directory, creating the directory if it does not exist.
+// Each worker writes to its own file, named after its peer ID.
+func newFindNodeDump(dir string, id peer.ID) (*findNodeDump, error) {
+	err := os.MkdirAll(dir, 0o777)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create debug dump directory: %w", err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("findnode_%s.jsonl", id)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create debug dump file: %w", err)
+	}
+
+	return &findNodeDump{
+		f:   f,
+		enc: json.NewEncoder(f),
+	}, nil
+}
+
+// write writes a record.
+func (d *findNodeDump) write(rec findNodeRecord) error {
+	if d == nil {
+		return nil
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.enc.Encode(rec)
+}
+
+// close closes the file.
+func (d *findNodeDump) close() error {
+	if d == nil {
+		return nil
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.f.Close()
+}
@@ -0,0 +1,87 @@
+package crawling
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DegreeStats are statistics about the degrees of the nodes of the peer graph.
+// The out-degree of a crawlable node is the number of neighbors found in its
+// routing table, the in-degree of a node the number of crawlable nodes which
+// had it as a neighbor.
+type DegreeStats struct {
+	// The number of crawlable nodes per out-degree.
+	OutDegreeHistogram map[int]int `json:"out_degree_histogram"`
+	MeanOutDegree      float64     `json:"mean_out_degree"`
+	MedianOutDegree    float64     `json:"median_out_degree"`
+	// The number of nodes per in-degree, over the crawled nodes and their
+	// neighbors.
+	InDegreeHistogram map[int]int `json:"in_degree_histogram"`
+	MeanInDegree      float64     `json:"mean_in_degree"`
+	MedianInDegree    float64     `json:"median_in_degree"`
+}
+
+// computeDegreeStats computes the degree distributions of the peer graph.
+func computeDegreeStats(nodes map[peer.ID]nodeCrawlStatus) DegreeStats {
+	var outDegrees []int
+	inDegree := make(map[peer.ID]int)
+	for id, node := range nodes {
+		if _, ok := inDegree[id]; !ok {
+			inDegree[id] = 0
+		}
+		if node.err != nil || node.result.crawlDataError != nil {
+			continue
+		}
+		outDegrees = append(outDegrees, len(node.result.crawlNeighbors))
+		for _, n := range node.result.crawlNeighbors {
+			inDegree[n]++
+		}
+	}
+
+	inDegrees := make([]int, 0, len(inDegree))
+	for _, d := range inDegree {
+		inDegrees = append(inDegrees, d)
+	}
+
+	s := DegreeStats{
+		OutDegreeHistogram: histogram(outDegrees),
+		InDegreeHistogram:  histogram(inDegrees),
+	}
+	s.MeanOutDegree, s.MedianOutDegree = meanAndMedian(outDegrees)
+	s.MeanInDegree, s.MedianInDegree = meanAndMedian(inDegrees)
+
+	return s
+}
+
+// histogram counts the occurrences of each value.
+func histogram(values []int) map[int]int {
+	h := make(map[int]int)
+	for _, v := range values {
+		h[v]++
+	}
+	return h
+}
+
+// meanAndMedian computes the mean and median of the values, sorting them in
+// place. Both are zero if there are no values.
+func meanAndMedian(values []int) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	mean := float64(sum) / float64(len(values))
+
+	sort.Ints(values)
+	mid := len(values) / 2
+	median := float64(values[mid])
+	if len(values)%2 == 0 {
+		median = float64(values[mid-1]+values[mid]) / 2
+	}
+
+	return mean, median
+}
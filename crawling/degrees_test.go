@@ -0,0 +1,56 @@
+package crawling
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestComputeDegreeStats(t *testing.T) {
+	ids := make([]peer.ID, 5)
+	for i := range ids {
+		ids[i], _ = newTestPeer(t)
+	}
+	a, b, c, d, e := ids[0], ids[1], ids[2], ids[3], ids[4]
+
+	// d is unreachable, e was found but not crawled.
+	nodes := map[peer.ID]nodeCrawlStatus{
+		a: {result: &nodeInformation{crawlNeighbors: []peer.ID{b, c, d}}},
+		b: {result: &nodeInformation{crawlNeighbors: []peer.ID{a, e}}},
+		c: {result: &nodeInformation{crawlNeighbors: []peer.ID{b}}},
+		d: {err: errors.New("connection refused")},
+	}
+
+	s := computeDegreeStats(nodes)
+	expected := DegreeStats{
+		OutDegreeHistogram: map[int]int{3: 1, 2: 1, 1: 1},
+		MeanOutDegree:      2,
+		MedianOutDegree:    2,
+		// b is referenced by a and c, everyone else once.
+		InDegreeHistogram: map[int]int{1: 4, 2: 1},
+		MeanInDegree:      1.2,
+		MedianInDegree:    1,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %+v, got %+v", expected, s)
+	}
+}
+
+func TestMeanAndMedian(t *testing.T) {
+	for _, test := range []struct {
+		values       []int
+		mean, median float64
+	}{
+		{nil, 0, 0},
+		{[]int{4}, 4, 4},
+		{[]int{3, 1, 2}, 2, 2},
+		{[]int{10, 1, 2, 3}, 4, 2.5},
+	} {
+		mean, median := meanAndMedian(test.values)
+		if mean != test.mean || median != test.median {
+			t.Errorf("%v: expected mean %v and median %v, got %v and %v", test.values, test.mean, test.median, mean, median)
+		}
+	}
+}
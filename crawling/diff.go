@@ -0,0 +1,109 @@
+package crawling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// CrawlDiff describes the changes between two crawls.
+// All lists are sorted.
+type CrawlDiff struct {
+	// Peers found in the current, but not the previous crawl.
+	Added []peer.ID `json:"added"`
+	// Peers found in the previous, but not the current crawl.
+	Removed []peer.ID `json:"removed"`
+	// Peers found in both crawls, which were not connectable in the previous
+	// but are connectable in the current crawl.
+	BecameReachable []peer.ID `json:"became_reachable"`
+	// Peers found in both crawls, which were connectable in the previous but
+	// are not connectable in the current crawl.
+	BecameUnreachable []peer.ID `json:"became_unreachable"`
+	// Peers found in both crawls, whose set of addresses changed.
+	AddressesChanged []peer.ID `json:"addresses_changed"`
+}
+
+// Diff computes the changes between two crawls.
+// Peers are compared by ID, their addresses are compared as sets, i.e.,
+// regardless of order.
+func Diff(prev, curr *CrawlOutput) *CrawlDiff {
+	diff := &CrawlDiff{
+		Added:             []peer.ID{},
+		Removed:           []peer.ID{},
+		BecameReachable:   []peer.ID{},
+		BecameUnreachable: []peer.ID{},
+		AddressesChanged:  []peer.ID{},
+	}
+
+	for id := range prev.nodes {
+		if _, ok := curr.nodes[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	for id, currNode := range curr.nodes {
+		prevNode, ok := prev.nodes[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+			continue
+		}
+
+		if prevNode.err != nil && currNode.err == nil {
+			diff.BecameReachable = append(diff.BecameReachable, id)
+		} else if prevNode.err == nil && currNode.err != nil {
+			diff.BecameUnreachable = append(diff.BecameUnreachable, id)
+		}
+
+		if !sameAddrs(prev.addrInfo[id], curr.addrInfo[id]) {
+			diff.AddressesChanged = append(diff.AddressesChanged, id)
+		}
+	}
+
+	for _, ids := range [][]peer.ID{diff.Added, diff.Removed, diff.BecameReachable, diff.BecameUnreachable, diff.AddressesChanged} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+
+	return diff
+}
+
+// sameAddrs checks whether two lists of addresses contain the same set of
+// addresses, based on their string representation.
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	setA := make(map[string]struct{}, len(a))
+	for _, addr := range a {
+		setA[addr.String()] = struct{}{}
+	}
+	setB := make(map[string]struct{}, len(b))
+	for _, addr := range b {
+		setB[addr.String()] = struct{}{}
+	}
+
+	if len(setA) != len(setB) {
+		return false
+	}
+	for addr := range setA {
+		if _, ok := setB[addr]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes the diff to the given path, as JSON.
+func (diff *CrawlDiff) Write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to open output file: %w", err)
+	}
+
+	err = json.NewEncoder(f).Encode(diff)
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	return f.Close()
+}
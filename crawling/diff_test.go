@@ -0,0 +1,171 @@
+package crawling
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// diffTestNode is a node in a crawl built by diffTestOutput.
+type diffTestNode struct {
+	reachable bool
+	addrs     []string
+}
+
+// diffTestOutput creates the results of a crawl of the given nodes.
+func diffTestOutput(nodes map[peer.ID]diffTestNode) *CrawlOutput {
+	out := &CrawlOutput{
+		nodes:    make(map[peer.ID]nodeCrawlStatus),
+		addrInfo: make(map[peer.ID][]ma.Multiaddr),
+	}
+	for id, node := range nodes {
+		for _, addr := range node.addrs {
+			out.addrInfo[id] = append(out.addrInfo[id], ma.StringCast(addr))
+		}
+		status := nodeCrawlStatus{attempts: 1}
+		if node.reachable {
+			status.result = &nodeInformation{}
+		} else {
+			status.err = errors.New("unreachable")
+		}
+		out.nodes[id] = status
+	}
+
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	const addr1, addr2, addr3 = "/ip4/1.2.3.4/tcp/4001", "/ip4/1.2.3.4/udp/4001/quic-v1", "/ip4/1.2.3.5/tcp/4001"
+	id, _ := newTestPeer(t)
+	reachable := diffTestNode{reachable: true, addrs: []string{addr1, addr2}}
+	unreachable := diffTestNode{addrs: []string{addr1, addr2}}
+
+	for _, test := range []struct {
+		name       string
+		prev, curr map[peer.ID]diffTestNode
+		expected   CrawlDiff
+	}{
+		{
+			name:     "appeared",
+			prev:     nil,
+			curr:     map[peer.ID]diffTestNode{id: reachable},
+			expected: CrawlDiff{Added: []peer.ID{id}},
+		},
+		{
+			name:     "disappeared",
+			prev:     map[peer.ID]diffTestNode{id: reachable},
+			curr:     nil,
+			expected: CrawlDiff{Removed: []peer.ID{id}},
+		},
+		{
+			name:     "became reachable",
+			prev:     map[peer.ID]diffTestNode{id: unreachable},
+			curr:     map[peer.ID]diffTestNode{id: reachable},
+			expected: CrawlDiff{BecameReachable: []peer.ID{id}},
+		},
+		{
+			name:     "became unreachable",
+			prev:     map[peer.ID]diffTestNode{id: reachable},
+			curr:     map[peer.ID]diffTestNode{id: unreachable},
+			expected: CrawlDiff{BecameUnreachable: []peer.ID{id}},
+		},
+		{
+			name:     "changed addresses",
+			prev:     map[peer.ID]diffTestNode{id: reachable},
+			curr:     map[peer.ID]diffTestNode{id: {reachable: true, addrs: []string{addr1, addr3}}},
+			expected: CrawlDiff{AddressesChanged: []peer.ID{id}},
+		},
+		{
+			name:     "added address",
+			prev:     map[peer.ID]diffTestNode{id: reachable},
+			curr:     map[peer.ID]diffTestNode{id: {reachable: true, addrs: []string{addr1, addr2, addr3}}},
+			expected: CrawlDiff{AddressesChanged: []peer.ID{id}},
+		},
+		{
+			name:     "reordered addresses",
+			prev:     map[peer.ID]diffTestNode{id: reachable},
+			curr:     map[peer.ID]diffTestNode{id: {reachable: true, addrs: []string{addr2, addr1}}},
+			expected: CrawlDiff{},
+		},
+		{
+			name:     "unchanged",
+			prev:     map[peer.ID]diffTestNode{id: unreachable},
+			curr:     map[peer.ID]diffTestNode{id: unreachable},
+			expected: CrawlDiff{},
+		},
+		{
+			name:     "became unreachable with changed addresses",
+			prev:     map[peer.ID]diffTestNode{id: reachable},
+			curr:     map[peer.ID]diffTestNode{id: {addrs: []string{addr3}}},
+			expected: CrawlDiff{BecameUnreachable: []peer.ID{id}, AddressesChanged: []peer.ID{id}},
+		},
+	} {
+		diff := Diff(diffTestOutput(test.prev), diffTestOutput(test.curr))
+		for _, ids := range []*[]peer.ID{&test.expected.Added, &test.expected.Removed, &test.expected.BecameReachable, &test.expected.BecameUnreachable, &test.expected.AddressesChanged} {
+			if *ids == nil {
+				*ids = []peer.ID{}
+			}
+		}
+		if !reflect.DeepEqual(*diff, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, *diff)
+		}
+	}
+}
+
+func TestDiffSorted(t *testing.T) {
+	prev := make(map[peer.ID]diffTestNode)
+	curr := make(map[peer.ID]diffTestNode)
+	for i := 0; i < 10; i++ {
+		id, _ := newTestPeer(t)
+		curr[id] = diffTestNode{reachable: true}
+	}
+
+	diff := Diff(diffTestOutput(prev), diffTestOutput(curr))
+	if len(diff.Added) != len(curr) {
+		t.Fatalf("expected %d added peers, got %d", len(curr), len(diff.Added))
+	}
+	for i := 1; i < len(diff.Added); i++ {
+		if diff.Added[i-1] >= diff.Added[i] {
+			t.Fatalf("expected sorted peers, got %v", diff.Added)
+		}
+	}
+}
+
+func TestCrawlDiffWrite(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	prev := diffTestOutput(map[peer.ID]diffTestNode{a: {reachable: true, addrs: []string{"/ip4/1.2.3.4/tcp/4001"}}})
+	curr := diffTestOutput(map[peer.ID]diffTestNode{b: {}})
+	path := filepath.Join(t.TempDir(), "diff.json")
+
+	err := Diff(prev, curr).Write(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string][]string
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"added":              {b.String()},
+		"removed":            {a.String()},
+		"became_reachable":   {},
+		"became_unreachable": {},
+		"addresses_changed":  {},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %v, got %s", expected, data)
+	}
+}
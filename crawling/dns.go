@@ -0,0 +1,152 @@
+package crawling
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDNSResolutionDepth limits how often we resolve the results of resolving
+// an address again, e.g., for /dnsaddr records pointing to /dns4 addresses.
+const maxDNSResolutionDepth = 4
+
+// dnsCacheTTL is how long resolved addresses are cached.
+// This is deliberately independent of the TTLs of the DNS records, which
+// madns does not expose. It is long enough to avoid resolving the same
+// addresses repeatedly during a crawl, but short enough for long-lived
+// workers to pick up changes.
+const dnsCacheTTL = 10 * time.Minute
+
+// maxDNSCacheEntries limits the size of the DNS cache.
+// Once it is full, expired entries are removed, and if that is not enough, the
+// entries closest to expiry.
+const maxDNSCacheEntries = 10000
+
+// A dnsResolver resolves DNS multiaddresses, i.e., /dns, /dns4, /dns6, and
+// /dnsaddr.
+// This is implemented by *madns.Resolver.
+type dnsResolver interface {
+	Resolve(context.Context, ma.Multiaddr) ([]ma.Multiaddr, error)
+}
+
+// An addrResolver resolves DNS multiaddresses of peers to IP multiaddresses.
+// Results are cached for dnsCacheTTL.
+type addrResolver struct {
+	resolver dnsResolver
+	// Returns the current time, replaceable for testing.
+	now func() time.Time
+
+	cacheM sync.Mutex
+	cache  map[string]dnsCacheEntry
+}
+
+// A dnsCacheEntry is a cached result of resolving an address.
+type dnsCacheEntry struct {
+	resolved []ma.Multiaddr
+	expires  time.Time
+}
+
+// newAddrResolver creates a new addrResolver using the given resolver.
+func newAddrResolver(resolver dnsResolver) *addrResolver {
+	return &addrResolver{
+		resolver: resolver,
+		now:      time.Now,
+		cache:    make(map[string]dnsCacheEntry),
+	}
+}
+
+// resolve returns the addresses of the peer, with DNS addresses replaced by
+// the addresses they resolve to.
+// Addresses which fail to resolve are dropped. Results of /dnsaddr records
+// which belong to other peers are dropped, too.
+func (r *addrResolver) resolve(ctx context.Context, p peer.AddrInfo) []ma.Multiaddr {
+	return r.resolveDepth(ctx, p.ID, p.Addrs, 0)
+}
+
+func (r *addrResolver) resolveDepth(ctx context.Context, id peer.ID, addrs []ma.Multiaddr, depth int) []ma.Multiaddr {
+	var out []ma.Multiaddr
+	for _, addr := range addrs {
+		if !madns.Matches(addr) {
+			out = append(out, addr)
+			continue
+		}
+		if depth >= maxDNSResolutionDepth {
+			log.WithField("addr", addr).Debug("DNS resolution too deep, dropping address")
+			continue
+		}
+
+		resolved, err := r.resolveCached(ctx, addr)
+		if err != nil {
+			log.WithError(err).WithField("addr", addr).Debug("unable to resolve address")
+			continue
+		}
+
+		var own []ma.Multiaddr
+		for _, res := range resolved {
+			// /dnsaddr records contain the peer ID, possibly of other peers.
+			transport, resID := peer.SplitAddr(res)
+			if transport == nil || (len(resID) != 0 && resID != id) {
+				continue
+			}
+			own = append(own, transport)
+		}
+		out = append(out, r.resolveDepth(ctx, id, own, depth+1)...)
+	}
+
+	return out
+}
+
+// resolveCached resolves a single DNS address, using the cache.
+func (r *addrResolver) resolveCached(ctx context.Context, addr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	key := string(addr.Bytes())
+	r.cacheM.Lock()
+	entry, ok := r.cache[key]
+	r.cacheM.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.resolved, nil
+	}
+
+	resolved, err := r.resolver.Resolve(ctx, addr)
+	if err != nil {
+		// We don't cache errors, which may be transient.
+		return nil, err
+	}
+
+	r.cacheM.Lock()
+	now := r.now()
+	if _, ok := r.cache[key]; !ok && len(r.cache) >= maxDNSCacheEntries {
+		r.evict(now)
+	}
+	r.cache[key] = dnsCacheEntry{
+		resolved: resolved,
+		expires:  now.Add(dnsCacheTTL),
+	}
+	r.cacheM.Unlock()
+
+	return resolved, nil
+}
+
+// evict makes room in the full cache, by removing all expired entries, or, if
+// there are none, the entry closest to expiry.
+// The cache must be locked.
+func (r *addrResolver) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range r.cache {
+		if !now.Before(entry.expires) {
+			delete(r.cache, key)
+			continue
+		}
+		if oldest.IsZero() || entry.expires.Before(oldest) {
+			oldestKey, oldest = key, entry.expires
+		}
+	}
+	if len(r.cache) >= maxDNSCacheEntries {
+		delete(r.cache, oldestKey)
+	}
+}
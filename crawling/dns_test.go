@@ -0,0 +1,123 @@
+package crawling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// A fakeResolver resolves addresses from a static table and counts lookups.
+type fakeResolver struct {
+	records map[string][]ma.Multiaddr
+
+	m       sync.Mutex
+	lookups map[string]int
+}
+
+func (r *fakeResolver) Resolve(_ context.Context, addr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	r.m.Lock()
+	r.lookups[addr.String()]++
+	r.m.Unlock()
+
+	resolved, ok := r.records[addr.String()]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return resolved, nil
+}
+
+func TestAddrResolver(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	fake := &fakeResolver{
+		records: map[string][]ma.Multiaddr{
+			"/dnsaddr/bootstrap.example.com": {
+				ma.StringCast("/dns4/a.example.com/tcp/4001/p2p/" + a.String()),
+				ma.StringCast("/ip4/1.2.3.5/tcp/4001/p2p/" + b.String()),
+			},
+			"/dns4/a.example.com/tcp/4001": {ma.StringCast("/ip4/1.2.3.4/tcp/4001")},
+		},
+		lookups: make(map[string]int),
+	}
+	r := newAddrResolver(fake)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	p := peer.AddrInfo{ID: a, Addrs: []ma.Multiaddr{
+		ma.StringCast("/dnsaddr/bootstrap.example.com"),
+		ma.StringCast("/dns4/unknown.example.com/tcp/4001"),
+		ma.StringCast("/ip4/1.2.3.6/tcp/4001"),
+	}}
+	// The /dnsaddr record of b is dropped, as is the unresolvable address.
+	expected := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/4001"),
+		ma.StringCast("/ip4/1.2.3.6/tcp/4001"),
+	}
+	for i := 0; i < 2; i++ {
+		addrs := r.resolve(context.Background(), p)
+		if len(addrs) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, addrs)
+		}
+		for j := range addrs {
+			if !addrs[j].Equal(expected[j]) {
+				t.Errorf("expected %v, got %v", expected, addrs)
+			}
+		}
+	}
+
+	// Successful lookups are cached, failed ones are not.
+	for addr, n := range map[string]int{
+		"/dnsaddr/bootstrap.example.com":     1,
+		"/dns4/a.example.com/tcp/4001":       1,
+		"/dns4/unknown.example.com/tcp/4001": 2,
+	} {
+		if fake.lookups[addr] != n {
+			t.Errorf("expected %d lookups of %s, got %d", n, addr, fake.lookups[addr])
+		}
+	}
+
+	// Cached results expire.
+	now = now.Add(dnsCacheTTL)
+	r.resolve(context.Background(), p)
+	if n := fake.lookups["/dnsaddr/bootstrap.example.com"]; n != 2 {
+		t.Errorf("expected expired result to be resolved again, got %d lookups", n)
+	}
+}
+
+func TestAddrResolverEviction(t *testing.T) {
+	fake := &fakeResolver{
+		records: make(map[string][]ma.Multiaddr),
+		lookups: make(map[string]int),
+	}
+	r := newAddrResolver(fake)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	addr := func(i int) ma.Multiaddr {
+		return ma.StringCast(fmt.Sprintf("/dns4/host%d.example.com/tcp/4001", i))
+	}
+	for i := 0; i <= maxDNSCacheEntries; i++ {
+		fake.records[addr(i).String()] = []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	}
+	for i := 0; i <= maxDNSCacheEntries; i++ {
+		_, err := r.resolveCached(context.Background(), addr(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Nanosecond)
+	}
+
+	if len(r.cache) != maxDNSCacheEntries {
+		t.Errorf("expected %d cached entries, got %d", maxDNSCacheEntries, len(r.cache))
+	}
+	// The oldest entry was evicted.
+	if _, ok := r.cache[string(addr(0).Bytes())]; ok {
+		t.Error("oldest entry was not evicted")
+	}
+}
@@ -0,0 +1,111 @@
+package crawling
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrDryRun is returned by CrawlSinglePeer, LookupKey, and
+// FindProvidersForCID if CrawlManagerConfig.DryRun is set, after logging the
+// peers they would have dialed.
+var ErrDryRun = errors.New("dry run")
+
+// A dryRunVerdict describes whether a peer would be dialed during a dry run,
+// or why not.
+type dryRunVerdict int
+
+const (
+	dryRunDial dryRunVerdict = iota
+	dryRunFiltered
+	dryRunNoAddrs
+	dryRunUndialable
+)
+
+// dryRun logs the peers a crawl would start with and the effective config,
+// without dialing any peer, and returns an empty report.
+// See CrawlManagerConfig.DryRun.
+func (cm *CrawlManager) dryRun(crawlID string, startTs time.Time) CrawlOutput {
+	cm.logEffectiveConfig()
+
+	var seeds []peer.AddrInfo
+	for _, id := range cm.toCrawl.queue {
+		if _, ok := cm.toCrawl.inQueue[id]; !ok {
+			continue
+		}
+		seeds = append(seeds, peer.AddrInfo{ID: id, Addrs: cm.toCrawl.addrInfo[id]})
+	}
+	verdicts := cm.dryRunPeers("seed", seeds)
+
+	log.WithFields(log.Fields{
+		"crawl_id":                         crawlID,
+		"seeds":                            len(seeds),
+		"crawlable seeds":                  verdicts[dryRunDial],
+		"filtered seeds":                   verdicts[dryRunFiltered],
+		"seeds without addresses":          verdicts[dryRunNoAddrs],
+		"seeds without dialable addresses": verdicts[dryRunUndialable],
+		"protocols":                        cm.config.CrawlerConfig.ProtocolStrings,
+		"transports":                       cm.config.WorkerConfig.Transports,
+		"workers":                          cm.config.NumWorkers,
+		"concurrent requests":              cm.config.ConcurrentRequests,
+	}).Info("dry run: not crawling")
+	if verdicts[dryRunDial] == 0 {
+		log.Warn("dry run: no seed can be crawled, check the bootstrap peers, address filters, and transports")
+	}
+
+	return cm.createReport(crawlID, startTs)
+}
+
+// dryRunOperation logs the effective config and the peers the given
+// operation, e.g., a lookup, would start with, and returns ErrDryRun.
+func (cm *CrawlManager) dryRunOperation(operation string, peers []peer.AddrInfo) error {
+	cm.logEffectiveConfig()
+
+	verdicts := cm.dryRunPeers(operation, peers)
+	if verdicts[dryRunDial] == 0 {
+		log.WithField("operation", operation).Warn("dry run: no peer can be dialed, check the peers, address filters, and transports")
+	}
+
+	return ErrDryRun
+}
+
+// dryRunPeers logs, for each of the given peers, whether it would be dialed or
+// why not, and returns the number of peers per verdict.
+func (cm *CrawlManager) dryRunPeers(kind string, peers []peer.AddrInfo) map[dryRunVerdict]int {
+	verdicts := make(map[dryRunVerdict]int)
+	for _, p := range peers {
+		addrs := cm.toCrawl.filter.filter(p.Addrs)
+		entry := log.WithField("peer", p.ID).WithField("addrs", addrs).WithField("kind", kind)
+
+		switch {
+		case !cm.allowPeer(p.ID, ""):
+			verdicts[dryRunFiltered]++
+			entry.Info("dry run: peer excluded by peer filters")
+		case len(addrs) == 0:
+			verdicts[dryRunNoAddrs]++
+			entry.Warn("dry run: peer has no usable addresses")
+		case !cm.config.WorkerConfig.canDial(peer.AddrInfo{ID: p.ID, Addrs: addrs}):
+			verdicts[dryRunUndialable]++
+			entry.Warn("dry run: peer has no address for the enabled transports")
+		default:
+			verdicts[dryRunDial]++
+			entry.Info("dry run: would dial peer")
+		}
+	}
+
+	return verdicts
+}
+
+// logEffectiveConfig logs a snapshot of the effective config.
+func (cm *CrawlManager) logEffectiveConfig() {
+	snapshot, err := configSnapshot(cm.config)
+	if err != nil {
+		log.WithError(err).Warn("dry run: unable to snapshot config")
+		return
+	}
+	encoded, _ := json.Marshal(snapshot)
+	log.WithField("config", string(encoded)).Info("dry run: effective config")
+}
@@ -0,0 +1,165 @@
+package crawling
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Events emitted during a crawl, see EventManager.
+const (
+	// EventConnected is emitted when a worker opened its first connection to
+	// a peer, with the peer.ID of the peer as its argument.
+	EventConnected = "connected"
+	// EventDisconnected is emitted when the last connection of a worker to a
+	// peer was closed, by either side, with the peer.ID of the peer as its
+	// argument.
+	EventDisconnected = "disconnected"
+	// EventCrawlError is emitted when we were unable to connect to a peer or
+	// to crawl its routing table, with the peer.AddrInfo we tried and the
+	// error as its arguments.
+	EventCrawlError = "crawl_error"
+	// EventCrawlSuccess is emitted when we crawled a peer, with the
+	// peer.AddrInfo we tried and the *CrawledNodeData as its arguments.
+	EventCrawlSuccess = "crawl_success"
+	// EventNodeDiscovered is emitted when we learn about a previously unknown
+	// peer, with its peer.AddrInfo as its argument.
+	EventNodeDiscovered = "node_discovered"
+)
+
+const (
+	// eventQueueSize is the number of handler calls an EventManager queues
+	// before dropping them.
+	eventQueueSize = 1024
+	// eventWorkers is the number of goroutines an EventManager calls handlers
+	// on.
+	eventWorkers = 4
+)
+
+var eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ipfs_crawler_cmanager_events_dropped_total",
+	Help: "The number of event handler calls dropped because the handlers did not keep up.",
+}, []string{"network", "event"})
+
+// An EventHandler handles an event emitted during a crawl.
+// The arguments depend on the event.
+type EventHandler func(args ...interface{})
+
+// An EventManager calls registered handlers for events emitted during a
+// crawl.
+// Handlers are called asynchronously on a small pool of goroutines, so that
+// slow handlers do not slow down the crawl. Consequently, handlers may be
+// called concurrently and out of order. If the handlers do not keep up, calls
+// are dropped.
+// A nil *EventManager ignores all events.
+type EventManager struct {
+	m        sync.Mutex
+	handlers map[string]map[int]EventHandler
+	nextID   int
+
+	calls  chan eventCall
+	closed chan struct{}
+	once   sync.Once
+
+	// The network to label metrics with, see CrawlManagerConfig.Network.
+	network string
+}
+
+// eventCall is a pending call of a handler.
+type eventCall struct {
+	handler EventHandler
+	args    []interface{}
+}
+
+// NewEventManager creates a new EventManager and starts its goroutines.
+// It must be closed with Close.
+func NewEventManager() *EventManager {
+	em := &EventManager{
+		handlers: make(map[string]map[int]EventHandler),
+		calls:    make(chan eventCall, eventQueueSize),
+		closed:   make(chan struct{}),
+	}
+	for i := 0; i < eventWorkers; i++ {
+		go em.run()
+	}
+	return em
+}
+
+func (em *EventManager) run() {
+	for {
+		select {
+		case call := <-em.calls:
+			call.handler(call.args...)
+		case <-em.closed:
+			return
+		}
+	}
+}
+
+// On registers a handler for the given event.
+// Returns an ID which can be used to unregister the handler with Off.
+func (em *EventManager) On(event string, handler func(args ...interface{})) int {
+	em.m.Lock()
+	defer em.m.Unlock()
+
+	id := em.nextID
+	em.nextID++
+	if em.handlers[event] == nil {
+		em.handlers[event] = make(map[int]EventHandler)
+	}
+	em.handlers[event][id] = handler
+
+	return id
+}
+
+// Off unregisters the handler with the given ID for the given event.
+// Calls which are already queued are still made.
+func (em *EventManager) Off(event string, id int) {
+	em.m.Lock()
+	defer em.m.Unlock()
+
+	delete(em.handlers[event], id)
+}
+
+// hasHandlers returns whether any handlers are registered for the given
+// event, so that callers can skip building expensive arguments.
+func (em *EventManager) hasHandlers(event string) bool {
+	if em == nil {
+		return false
+	}
+
+	em.m.Lock()
+	defer em.m.Unlock()
+
+	return len(em.handlers[event]) != 0
+}
+
+// Emit queues calls of all handlers registered for the given event.
+// This never blocks.
+func (em *EventManager) Emit(event string, args ...interface{}) {
+	if em == nil {
+		return
+	}
+
+	em.m.Lock()
+	defer em.m.Unlock()
+
+	for _, handler := range em.handlers[event] {
+		select {
+		case em.calls <- eventCall{handler: handler, args: args}:
+		case <-em.closed:
+			return
+		default:
+			log.WithField("event", event).Debug("event handlers not keeping up, dropping event")
+			eventsDropped.WithLabelValues(em.network, event).Inc()
+		}
+	}
+}
+
+// Close stops calling handlers.
+// Calls which are still queued are dropped.
+func (em *EventManager) Close() {
+	em.once.Do(func() { close(em.closed) })
+}
@@ -0,0 +1,97 @@
+package crawling
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEventManagerCountsDroppedEvents(t *testing.T) {
+	em := NewEventManager()
+	defer em.Close()
+	em.network = "test-events-dropped"
+
+	// Block all workers, so that calls pile up in the queue.
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, eventWorkers)
+	em.On(EventConnected, func(args ...interface{}) {
+		started <- struct{}{}
+		<-release
+	})
+	for i := 0; i < eventWorkers; i++ {
+		em.Emit(EventConnected)
+	}
+	for i := 0; i < eventWorkers; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handlers were not called")
+		}
+	}
+
+	for i := 0; i < eventQueueSize+3; i++ {
+		em.Emit(EventConnected)
+	}
+
+	counter := eventsDropped.WithLabelValues(em.network, EventConnected)
+	if v := testutil.ToFloat64(counter); v != 3 {
+		t.Fatalf("expected 3 dropped events, got %v", v)
+	}
+}
+
+func TestCrawlNetworkEmitsCrawlEvents(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001")}},
+		}},
+		b: {Err: errors.New("unreachable")},
+		c: {CrawlErr: errors.New("no DHT")},
+	}, a)
+
+	var m sync.Mutex
+	var successes, failures []peer.ID
+	var wg sync.WaitGroup
+	wg.Add(3)
+	cm.Events().On(EventCrawlSuccess, func(args ...interface{}) {
+		defer wg.Done()
+		m.Lock()
+		defer m.Unlock()
+		successes = append(successes, args[0].(peer.AddrInfo).ID)
+		if _, ok := args[1].(*CrawledNodeData); !ok {
+			t.Errorf("expected *CrawledNodeData, got %T", args[1])
+		}
+	})
+	cm.Events().On(EventCrawlError, func(args ...interface{}) {
+		defer wg.Done()
+		m.Lock()
+		defer m.Unlock()
+		failures = append(failures, args[0].(peer.AddrInfo).ID)
+		if _, ok := args[1].(error); !ok {
+			t.Errorf("expected error, got %T", args[1])
+		}
+	})
+
+	_, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if len(successes) != 1 || successes[0] != a {
+		t.Errorf("expected success event for %s, got %v", a, successes)
+	}
+	if len(failures) != 2 {
+		t.Errorf("expected two error events, got %v", failures)
+	}
+}
@@ -0,0 +1,47 @@
+package crawling_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"ipfs-crawler/crawling"
+)
+
+func ExampleNewCrawlManagerWithMockWorkers() {
+	var ids []peer.ID
+	for i := 0; i < 3; i++ {
+		_, pub, _ := crypto.GenerateEd25519Key(crand.Reader)
+		id, _ := peer.IDFromPublicKey(pub)
+		ids = append(ids, id)
+	}
+	a, b, c := ids[0], ids[1], ids[2]
+
+	// a knows b and c, but c is unreachable.
+	w, _ := crawling.NewMockWorker(map[peer.ID]crawling.MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001")}},
+		}},
+		b: {AgentVersion: "kubo/0.18.1"},
+		c: {Err: errors.New("unreachable")},
+	}, 0)
+	w.SetCapacity(2)
+	cm, _ := crawling.NewCrawlManagerWithMockWorkers(crawling.CrawlManagerConfig{
+		BootstrapPeers: []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + a.String()},
+	}, w)
+	defer func() { _ = cm.Stop() }()
+
+	report, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%d nodes, %d reachable, %d request(s) to c\n", report.Stats.TotalNodes, report.Stats.ReachableNodes, w.Requests(c))
+	// Output: 3 nodes, 2 reachable, 1 request(s) to c
+}
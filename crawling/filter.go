@@ -0,0 +1,88 @@
+package crawling
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A PeerFilter decides whether to crawl a peer.
+// It is called with an empty agent version before we dial a peer, and with
+// the peer's agent version after we connected to it. In the latter case, the
+// result decides whether the peers found in its routing table are queued.
+// Peers which are filtered out still appear as neighbors of crawled peers.
+type PeerFilter func(id peer.ID, agentVersion string) bool
+
+// AgentVersionPrefixFilter returns a PeerFilter which only follows the
+// neighbors of peers whose agent version starts with any of the given
+// prefixes.
+// Since agent versions are only known after connecting to a peer, this does
+// not prevent dialing any peer.
+func AgentVersionPrefixFilter(prefixes ...string) PeerFilter {
+	return func(_ peer.ID, agentVersion string) bool {
+		if len(agentVersion) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(agentVersion, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// DenyListFilter returns a PeerFilter which prevents dialing any of the given
+// peers.
+func DenyListFilter(ids []peer.ID) PeerFilter {
+	denied := make(map[peer.ID]struct{}, len(ids))
+	for _, id := range ids {
+		denied[id] = struct{}{}
+	}
+	return func(id peer.ID, _ string) bool {
+		_, ok := denied[id]
+		return !ok
+	}
+}
+
+// LoadDenyList reads peer IDs from a file, which contains one peer ID per line.
+// Empty lines and lines starting with # are ignored.
+func LoadDenyList(path string) ([]peer.ID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open deny list: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var ids []peer.ID
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := peer.Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse peer ID %q: %w", line, err)
+		}
+		ids = append(ids, id)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read deny list: %w", err)
+	}
+
+	return ids, nil
+}
+
+// allowPeer checks whether all filters allow the peer.
+func (cm *CrawlManager) allowPeer(id peer.ID, agentVersion string) bool {
+	for _, f := range cm.filters {
+		if !f(id, agentVersion) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package crawling
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/oschwald/geoip2-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Coordinates are the approximate location of a node, as serialized to JSON.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// The radius around the coordinates the node is likely located in.
+	AccuracyRadiusKm uint16 `json:"accuracy_radius_km"`
+}
+
+// geoInfo is the location of the address a node was connected via, see
+// enrichGeoIP.
+type geoInfo struct {
+	// The ISO 3166-1 alpha-2 code of the country.
+	country string
+	// Only known if a city database is used, and the English name of the
+	// city is known.
+	city        string
+	coordinates *Coordinates
+}
+
+// openGeoIPDatabase opens the MaxMind country or city database at path, e.g.,
+// GeoLite2-Country or GeoLite2-City.
+func openGeoIPDatabase(path string) (*geoip2.Reader, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// Looking up a city fails for databases of other types, but succeeds
+	// for country databases, without coordinates.
+	_, err = db.City(net.IPv4zero)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("not a country or city database: %w", err)
+	}
+	return db, nil
+}
+
+// enrichGeoIP looks up the location of the address each reachable node was
+// crawled over.
+// Nodes crawled over relayed or non-public addresses, and addresses not
+// contained in the database, are left as they are.
+func enrichGeoIP(db *geoip2.Reader, nodes map[peer.ID]nodeCrawlStatus) {
+	for id, state := range nodes {
+		if state.err != nil || state.result == nil {
+			continue
+		}
+		state.result.geo = nil
+		ip, ok := connectedPublicIP(state.result)
+		if !ok {
+			continue
+		}
+		rec, err := db.City(ip)
+		if err != nil {
+			log.WithError(err).WithField("peer", id).Debug("unable to look up location")
+			continue
+		}
+		if len(rec.Country.IsoCode) == 0 {
+			continue
+		}
+		geo := &geoInfo{
+			country: rec.Country.IsoCode,
+			city:    rec.City.Names["en"],
+		}
+		// Country databases, and some entries of city databases, have no
+		// location, which is indicated by a zero accuracy radius.
+		if rec.Location.AccuracyRadius != 0 {
+			geo.coordinates = &Coordinates{
+				Latitude:         rec.Location.Latitude,
+				Longitude:        rec.Location.Longitude,
+				AccuracyRadiusKm: rec.Location.AccuracyRadius,
+			}
+		}
+		state.result.geo = geo
+	}
+}
@@ -0,0 +1,57 @@
+package crawling
+
+import "testing"
+
+func TestOpenGeoIPDatabase(t *testing.T) {
+	db, err := openGeoIPDatabase(testCountryDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	_, err = openGeoIPDatabase(testASNDatabase)
+	if err == nil {
+		t.Error("expected ASN database to be rejected")
+	}
+}
+
+func TestEnrichGeoIP(t *testing.T) {
+	db, err := openGeoIPDatabase(testCountryDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	nodes, ids := testNodesConnectedVia(t,
+		"/ip4/8.8.8.8/tcp/4001",
+		"/ip6/2001:4860:4860::8888/udp/4001/quic-v1",
+		"/ip4/81.2.69.142/tcp/4001",
+		"/ip4/192.168.1.1/tcp/4001",
+	)
+	enrichGeoIP(db, nodes)
+
+	// Private addresses are not looked up.
+	expected := []string{"US", "US", "GB", ""}
+	for i, id := range ids {
+		geo := nodes[id].result.geo
+		switch {
+		case len(expected[i]) == 0 && geo != nil:
+			t.Errorf("%s: expected no location, got %+v", nodes[id].result.info.ConnectedAddr, *geo)
+		case len(expected[i]) != 0 && (geo == nil || geo.country != expected[i]):
+			t.Errorf("%s: expected country %s, got %+v", nodes[id].result.info.ConnectedAddr, expected[i], geo)
+		case geo != nil && (len(geo.city) != 0 || geo.coordinates != nil):
+			// Country databases have no cities or coordinates.
+			t.Errorf("%s: expected no city or coordinates, got %+v", nodes[id].result.info.ConnectedAddr, *geo)
+		}
+	}
+
+	node := nodes[ids[2]].toCrawledNode(nil, nil, ids[2], 0)
+	if node.Country != "GB" || len(node.City) != 0 || node.Coordinates != nil {
+		t.Errorf("expected only the country GB in the output, got %q %q %v", node.Country, node.City, node.Coordinates)
+	}
+
+	stats := computeStats(nodes, nil, 0)
+	if len(stats.CountryHistogram) != 2 || stats.CountryHistogram["US"] != 2 || stats.CountryHistogram["GB"] != 1 {
+		t.Errorf("unexpected country histogram %v", stats.CountryHistogram)
+	}
+}
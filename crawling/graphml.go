@@ -0,0 +1,154 @@
+package crawling
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// graphmlNamespace is the XML namespace of GraphML.
+const graphmlNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+// graphmlKey declares an attribute of GraphML nodes.
+type graphmlKey struct {
+	XMLName  xml.Name `xml:"key"`
+	ID       string   `xml:"id,attr"`
+	For      string   `xml:"for,attr"`
+	AttrName string   `xml:"attr.name,attr"`
+	AttrType string   `xml:"attr.type,attr"`
+}
+
+// graphmlData is the value of an attribute of a GraphML node.
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphmlNode is a node of a GraphML graph.
+type graphmlNode struct {
+	XMLName xml.Name      `xml:"node"`
+	ID      string        `xml:"id,attr"`
+	Data    []graphmlData `xml:"data"`
+}
+
+// graphmlEdge is a directed edge of a GraphML graph.
+type graphmlEdge struct {
+	XMLName xml.Name `xml:"edge"`
+	Source  string   `xml:"source,attr"`
+	Target  string   `xml:"target,attr"`
+}
+
+// The attributes of GraphML nodes.
+var graphmlKeys = []graphmlKey{
+	{ID: "reachable", For: "node", AttrName: "reachable", AttrType: "boolean"},
+	{ID: "agentVersion", For: "node", AttrName: "agentVersion", AttrType: "string"},
+}
+
+// WriteGraphML writes the graph structure of the network as determined
+// through the crawl as GraphML.
+// Each node has the attributes reachable and agentVersion. Peers which appear
+// in routing tables but were never probed are included as unreachable nodes
+// without an agent version.
+func (report *CrawlOutput) WriteGraphML(w io.Writer) error {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	err := report.encodeGraphML(enc)
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	return enc.Flush()
+}
+
+func (report *CrawlOutput) encodeGraphML(enc *xml.Encoder) error {
+	var err error
+	root := xml.StartElement{
+		Name: xml.Name{Local: "graphml"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: graphmlNamespace}},
+	}
+	err = enc.EncodeToken(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8"`)})
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeToken(root)
+	if err != nil {
+		return err
+	}
+	for _, key := range graphmlKeys {
+		err = enc.Encode(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	graph := xml.StartElement{
+		Name: xml.Name{Local: "graph"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "edgedefault"}, Value: "directed"}},
+	}
+	err = enc.EncodeToken(graph)
+	if err != nil {
+		return err
+	}
+
+	// Nodes we probed.
+	for id, node := range report.nodes {
+		n := graphmlNode{
+			ID:   id.String(),
+			Data: []graphmlData{{Key: "reachable", Value: fmt.Sprintf("%t", node.err == nil)}},
+		}
+		if node.err == nil {
+			n.Data = append(n.Data, graphmlData{Key: "agentVersion", Value: node.result.info.AgentVersion})
+		}
+		err = enc.Encode(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Nodes we only know from routing tables, so that no edge dangles.
+	referenced := make(map[peer.ID]struct{})
+	for _, node := range report.nodes {
+		if node.err != nil || node.result.crawlDataError != nil {
+			continue
+		}
+		for _, neighbor := range node.result.crawlNeighbors {
+			if _, ok := report.nodes[neighbor]; ok {
+				continue
+			}
+			if _, ok := referenced[neighbor]; ok {
+				continue
+			}
+			referenced[neighbor] = struct{}{}
+			n := graphmlNode{
+				ID:   neighbor.String(),
+				Data: []graphmlData{{Key: "reachable", Value: "false"}},
+			}
+			err = enc.Encode(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Edges.
+	for id, node := range report.nodes {
+		if node.err != nil || node.result.crawlDataError != nil {
+			continue
+		}
+		for _, neighbor := range node.result.crawlNeighbors {
+			err = enc.Encode(graphmlEdge{Source: id.String(), Target: neighbor.String()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = enc.EncodeToken(graph.End())
+	if err != nil {
+		return err
+	}
+	return enc.EncodeToken(root.End())
+}
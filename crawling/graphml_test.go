@@ -0,0 +1,111 @@
+package crawling
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// graphmlDocument is the structure of a document written by WriteGraphML.
+type graphmlDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func TestWriteGraphML(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	responses := map[peer.ID]MockResponse{
+		a: {AgentVersion: "kubo/0.20.0", Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001")}},
+		}},
+		b: {AgentVersion: "kubo/0.21.0", Neighbors: []peer.AddrInfo{{ID: a}}},
+		c: {Err: errors.New("unreachable")},
+	}
+
+	for _, disableExpansion := range []bool{false, true} {
+		cm, _ := newTestCrawlManager(t, CrawlManagerConfig{DisableExpansion: disableExpansion}, responses, a)
+		out, err := cm.CrawlNetwork(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		err = out.WriteGraphML(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc graphmlDocument
+		err = xml.Unmarshal(buf.Bytes(), &doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(doc.Keys) != len(graphmlKeys) || doc.Graph.EdgeDefault != "directed" {
+			t.Errorf("unexpected header: %+v", doc)
+		}
+		// Without expansion, only a is probed, and b and c are only known
+		// from its routing table.
+		expected := map[string]map[string]string{
+			a.String(): {"reachable": "true", "agentVersion": "kubo/0.20.0"},
+			b.String(): {"reachable": "true", "agentVersion": "kubo/0.21.0"},
+			c.String(): {"reachable": "false"},
+		}
+		expectedEdges := map[graphmlEdge]struct{}{
+			{Source: a.String(), Target: b.String()}: {},
+			{Source: a.String(), Target: c.String()}: {},
+			{Source: b.String(), Target: a.String()}: {},
+		}
+		if disableExpansion {
+			expected[b.String()] = map[string]string{"reachable": "false"}
+			delete(expectedEdges, graphmlEdge{Source: b.String(), Target: a.String()})
+		}
+
+		if len(doc.Graph.Nodes) != len(expected) {
+			t.Errorf("expansion disabled %t: expected %d nodes, got %d", disableExpansion, len(expected), len(doc.Graph.Nodes))
+		}
+		for _, node := range doc.Graph.Nodes {
+			data := make(map[string]string)
+			for _, d := range node.Data {
+				data[d.Key] = d.Value
+			}
+			attrs, ok := expected[node.ID]
+			if !ok {
+				t.Errorf("expansion disabled %t: unexpected node %s", disableExpansion, node.ID)
+				continue
+			}
+			if len(data) != len(attrs) {
+				t.Errorf("expansion disabled %t: expected attributes %v of %s, got %v", disableExpansion, attrs, node.ID, data)
+				continue
+			}
+			for k, v := range attrs {
+				if data[k] != v {
+					t.Errorf("expansion disabled %t: expected attributes %v of %s, got %v", disableExpansion, attrs, node.ID, data)
+					break
+				}
+			}
+		}
+
+		if len(doc.Graph.Edges) != len(expectedEdges) {
+			t.Errorf("expansion disabled %t: expected %d edges, got %d", disableExpansion, len(expectedEdges), len(doc.Graph.Edges))
+		}
+		for _, edge := range doc.Graph.Edges {
+			edge.XMLName = xml.Name{}
+			if _, ok := expectedEdges[edge]; !ok {
+				t.Errorf("expansion disabled %t: unexpected edge %s -> %s", disableExpansion, edge.Source, edge.Target)
+			}
+		}
+	}
+}
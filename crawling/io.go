@@ -1,66 +1,298 @@
 package crawling
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	ma "github.com/multiformats/go-multiaddr"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
+// OutputConfig configures how the results of a crawl are written.
+type OutputConfig struct {
+	// Whether to gzip-compress output files.
+	Compress bool `yaml:"compress"`
+
+	// The gzip compression level to use, if compression is enabled.
+	// Defaults to gzip.DefaultCompression.
+	CompressionLevel *int `yaml:"compression_level"`
+
+	// Whether to write node metadata as JSON Lines, see
+	// CrawlManager.WriteReportStreaming.
+	JSONLines bool `yaml:"json_lines"`
+
+	// Whether to additionally write the peer graph as GraphML, see
+	// CrawlOutput.WriteGraphML.
+	GraphML bool `yaml:"graphml"`
+
+	// Whether to additionally write node metadata as CBOR, see
+	// CrawlOutput.WriteCBOR.
+	CBOR bool `yaml:"cbor"`
+
+	// Whether to additionally write node metadata as a stream of msgpack
+	// records, see CrawlOutput.WriteMsgpackStream.
+	Msgpack bool `yaml:"msgpack"`
+
+	// Whether to additionally write the results to a SQLite database, see
+	// WriteSQLite. This is not compressed.
+	SQLite bool `yaml:"sqlite"`
+
+	// Whether to omit nodes we were unable to connect to from the node
+	// metadata. They are still part of the peer graph and the CrawlOutput.
+	// Note that the output can then not be used with LoadUnreachablePeers.
+	ReachableOnly bool `yaml:"reachable_only"`
+}
+
+// outputFile is an output file, optionally compressed.
+type outputFile struct {
+	f  *os.File
+	gz *gzip.Writer
+	w  io.Writer
+}
+
+// CreateOutputFile creates a file to write output to.
+// If compression is enabled, .gz is appended to path.
+func CreateOutputFile(path string, config OutputConfig) (io.WriteCloser, error) {
+	if !config.Compress {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &outputFile{f: f, w: f}, nil
+	}
+
+	level := gzip.DefaultCompression
+	if config.CompressionLevel != nil {
+		level = *config.CompressionLevel
+	}
+
+	f, err := os.Create(path + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewWriterLevel(f, level)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("invalid compression level: %w", err)
+	}
+
+	return &outputFile{f: f, gz: gz, w: gz}, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+// Close flushes and closes the compressor, if any, and the underlying file.
+func (o *outputFile) Close() error {
+	if o.gz != nil {
+		err := o.gz.Close()
+		if err != nil {
+			_ = o.f.Close()
+			return fmt.Errorf("unable to flush compressor: %w", err)
+		}
+	}
+	return o.f.Close()
+}
+
 // crawlOutputJSON is a helper struct to serialize the output of a crawl to
 // JSON.
 type crawlOutputJSON struct {
-	StartDate time.Time         `json:"start_timestamp"`
-	EndDate   time.Time         `json:"end_timestamp"`
-	Nodes     []crawledNodeJSON `json:"found_nodes"`
+	CrawlID                    string                 `json:"crawl_id,omitempty"`
+	Config                     map[string]interface{} `json:"config,omitempty"`
+	Network                    string                 `json:"network,omitempty"`
+	SampleRate                 *float64               `json:"sample_rate,omitempty"`
+	Stats                      CrawlStats             `json:"stats"`
+	StartDate                  time.Time              `json:"start_timestamp"`
+	EndDate                    time.Time              `json:"end_timestamp"`
+	StoppedEarly               bool                   `json:"stopped_early"`
+	StopReason                 string                 `json:"stop_reason,omitempty"`
+	CrawlerIdentities          []peer.ID              `json:"crawler_identities"`
+	SkippedNodes               []peer.ID              `json:"skipped_nodes"`
+	QueueOverflowNodes         []peer.ID              `json:"queue_overflow_nodes,omitempty"`
+	NetworkSizeEstimate        *int                   `json:"network_size_estimate"`
+	NetworkSizeEstimateSamples int                    `json:"network_size_estimate_samples"`
+	Sanity                     []SanityResult         `json:"sanity,omitempty"`
+	AgentVersionCounts         map[string]int         `json:"agent_version_counts"`
+	Nodes                      []CrawledNode          `json:"found_nodes"`
 }
 
-// crawledNodeJSON is a helper struct to serialize the result of probing a
-// single node to JSON.
+// CrawledNode is the result of probing a single node, as serialized to JSON.
 // The fields ConnectionError and Result are mutually exclusive.
-type crawledNodeJSON struct {
+type CrawledNode struct {
 	ID         peer.ID        `json:"id"`
 	MultiAddrs []ma.Multiaddr `json:"multiaddrs"`
+	// For each entry of MultiAddrs, whether it is publicly routable without
+	// a relay.
+	PublicMultiAddrs []bool `json:"multiaddrs_public"`
+	// For each entry of MultiAddrs, its transport, see addrTransportLabel.
+	MultiAddrTransports []string `json:"multiaddrs_transport"`
+	// The total number of addresses we know for the node, which can exceed
+	// the length of MultiAddrs if their number is limited.
+	NumMultiAddrs int `json:"num_multiaddrs"`
+	// Whether the node sent a valid signed peer record via identify, and the
+	// addresses it contained, which, unlike MultiAddrs, are authenticated
+	// by the node.
+	Certified      bool           `json:"certified"`
+	CertifiedAddrs []ma.Multiaddr `json:"certified_multiaddrs,omitempty"`
+	// The autonomous system of the address the node was crawled over, if
+	// an ASN database is configured and contains it.
+	ASN   uint   `json:"asn,omitempty"`
+	ASOrg string `json:"as_org,omitempty"`
+	// The country code and, if known, the city and coordinates of the
+	// address the node was crawled over, if a GeoIP database is configured
+	// and contains it.
+	Country     string       `json:"country,omitempty"`
+	City        string       `json:"city,omitempty"`
+	Coordinates *Coordinates `json:"coordinates,omitempty"`
+
+	// When we first learned about the node, and the most recent time we
+	// were able to connect to it, if ever.
+	FirstSeen   time.Time  `json:"first_seen"`
+	LastCrawled *time.Time `json:"last_crawled"`
 
-	ConnectionError *string              `json:"connection_error"`
-	Result          *crawledNodeDataJSON `json:"result"`
+	// The number of times we probed the node, including retries, and the
+	// ID of the crawler which made the most recent probe, one of
+	// CrawlerIdentities, if any. Crawlers are listed in the order of
+	// CrawlManagerConfig.WorkerOverrides.
+	ConnectionAttempts int              `json:"connection_attempts"`
+	CrawlerID          peer.ID          `json:"crawler_id,omitempty"`
+	ConnectionError    *string          `json:"connection_error"`
+	Result             *CrawledNodeData `json:"result"`
 }
 
-// crawledNodeDataJSON is a helper struct to serialize information about a
-// single node to JSON.
+// CrawledNodeData is information about a single connectable node, as
+// serialized to JSON.
 // The field CrawlError indicates whether an error occurred during crawling.
-type crawledNodeDataJSON struct {
-	AgentVersion       string        `json:"agent_version"`
-	SupportedProtocols []protocol.ID `json:"supported_protocols"`
+type CrawledNodeData struct {
+	AgentVersion       string         `json:"agent_version"`
+	SupportedProtocols []protocol.ID  `json:"supported_protocols"`
+	ListedProtocols    []protocol.ID  `json:"listed_protocols,omitempty"`
+	ConflictingKeys    bool           `json:"conflicting_keys"`
+	Connection         ConnectionInfo `json:"connection"`
+	// The remote address of the connection the node was crawled over.
+	ConnectedVia ma.Multiaddr `json:"connected_via,omitempty"`
+	RTTMillis    int          `json:"rtt_ms,omitempty"`
+	// For each probed address, whether it was reachable, if probing all
+	// addresses is enabled.
+	ReachableMultiAddrs map[string]bool `json:"multiaddrs_reachable,omitempty"`
+	// For each address dialed to connect, whether the dial succeeded, if
+	// addresses are dialed sequentially.
+	DialedMultiAddrs map[string]bool `json:"multiaddrs_dialed,omitempty"`
 
 	CrawlBeginTs time.Time `json:"crawl_begin_ts"`
 	CrawlEndTs   time.Time `json:"crawl_end_ts"`
 	CrawlError   *string   `json:"crawl_error"`
+	// Whether all connections to the node were closed before we finished
+	// crawling it, in which case the neighbors may be incomplete.
+	ConnectionDropped bool `json:"connection_dropped,omitempty"`
+	// The DHT protocol negotiated to crawl the node.
+	DHTProtocol protocol.ID `json:"dht_protocol,omitempty"`
+	BucketFill  []int       `json:"bucket_fill,omitempty"`
+	// The peers in the node's routing table, only set when crawling a single
+	// peer, see CrawlManager.CrawlSinglePeer. Otherwise, they are written to
+	// the peer graph.
+	Neighbors []peer.AddrInfo `json:"neighbors,omitempty"`
+
+	PluginData map[string]PluginResult `json:"plugin_data"`
+}
 
-	PluginData map[string]pluginResultJSON `json:"plugin_data"`
+// ConnectionInfo describes the connection used to probe a node, as serialized
+// to JSON.
+type ConnectionInfo struct {
+	Transport             string      `json:"transport"`
+	Security              protocol.ID `json:"security"`
+	StreamMultiplexer     protocol.ID `json:"stream_multiplexer"`
+	EarlyMuxerNegotiation bool        `json:"early_muxer_negotiation"`
+	// The ALPN value negotiated in the TLS handshake, if any, as inferred by
+	// newConnectionInfo.
+	InferredALPN *string `json:"inferred_alpn"`
 }
 
-// pluginResultJSON is a helper struct to serialize information about executing
-// a plugin on a connectable node to JSON.
+// newConnectionInfo converts the state of a connection to its JSON form.
+// libp2p does not expose the ALPN value of a connection, so we infer it from
+// the rest of the state:
+//   - QUIC always negotiates "libp2p".
+//   - WebTransport always negotiates "h3".
+//   - TLS over TCP negotiates the stream multiplexer, if it was selected
+//     through early muxer negotiation, and "libp2p" otherwise.
+//   - Other combinations, e.g. Noise over TCP, do not use ALPN.
+func newConnectionInfo(state network.ConnectionState) ConnectionInfo {
+	info := ConnectionInfo{
+		Transport:             state.Transport,
+		Security:              state.Security,
+		StreamMultiplexer:     state.StreamMultiplexer,
+		EarlyMuxerNegotiation: state.UsedEarlyMuxerNegotiation,
+	}
+
+	var alpn string
+	switch {
+	case state.Transport == "quic" || state.Transport == "quic-v1":
+		alpn = "libp2p"
+	case state.Transport == "webtransport":
+		alpn = "h3"
+	case state.Security == "/tls/1.0.0" && state.UsedEarlyMuxerNegotiation:
+		alpn = string(state.StreamMultiplexer)
+	case state.Security == "/tls/1.0.0":
+		alpn = "libp2p"
+	default:
+		return info
+	}
+	info.InferredALPN = &alpn
+
+	return info
+}
+
+// PluginResult is information about executing a plugin on a connectable node,
+// as serialized to JSON.
 // The fields Error and Result are mutually exclusive.
-type pluginResultJSON struct {
+type PluginResult struct {
 	BeginTimestamp time.Time   `json:"begin_timestamp"`
 	EndTimestamp   time.Time   `json:"end_timestamp"`
 	Error          *string     `json:"error"`
 	Result         interface{} `json:"result"`
 }
 
-func (r nodeCrawlStatus) toCrawledNode(addrBook map[peer.ID][]ma.Multiaddr, id peer.ID) crawledNodeJSON {
+// toCrawledNode converts the result of probing a node to its serialized form.
+// At most maxAddrs of the most recently learned addresses are included, unless
+// maxAddrs is zero.
+func (r nodeCrawlStatus) toCrawledNode(addrBook map[peer.ID][]ma.Multiaddr, firstSeen map[peer.ID]time.Time, id peer.ID, maxAddrs uint) CrawledNode {
 	addr := addrBook[id]
-	res := crawledNodeJSON{
-		ID:         id,
-		MultiAddrs: addr,
+	numAddrs := len(addr)
+	if maxAddrs != 0 && uint(numAddrs) > maxAddrs {
+		// Addresses are appended as we learn them.
+		addr = addr[uint(numAddrs)-maxAddrs:]
+	}
+	public := make([]bool, len(addr))
+	transports := make([]string, len(addr))
+	for i, maddr := range addr {
+		public[i] = isPublicAddr(maddr)
+		transports[i] = addrTransportLabel(maddr)
+	}
+	res := CrawledNode{
+		ID:                  id,
+		MultiAddrs:          addr,
+		PublicMultiAddrs:    public,
+		MultiAddrTransports: transports,
+		NumMultiAddrs:       numAddrs,
+		FirstSeen:           firstSeen[id],
+		ConnectionAttempts:  r.attempts,
+		CrawlerID:           r.crawler,
+	}
+	if !r.lastCrawled.IsZero() {
+		lastCrawled := r.lastCrawled
+		res.LastCrawled = &lastCrawled
 	}
 	if r.err != nil {
 		tmp := r.err.Error()
@@ -68,15 +300,34 @@ func (r nodeCrawlStatus) toCrawledNode(addrBook map[peer.ID][]ma.Multiaddr, id p
 		return res
 	}
 
-	res.Result = new(crawledNodeDataJSON)
+	res.Certified = r.result.info.Certified
+	res.CertifiedAddrs = r.result.info.CertifiedAddrs
+	if r.result.as != nil {
+		res.ASN = r.result.as.number
+		res.ASOrg = r.result.as.org
+	}
+	if r.result.geo != nil {
+		res.Country = r.result.geo.country
+		res.City = r.result.geo.city
+		res.Coordinates = r.result.geo.coordinates
+	}
+
+	res.Result = new(CrawledNodeData)
 	res.Result.AgentVersion = r.result.info.AgentVersion
 	res.Result.SupportedProtocols = r.result.info.SupportedProtocols
+	res.Result.ListedProtocols = r.result.info.ListedProtocols
+	res.Result.ConflictingKeys = r.result.conflictingKeys
+	res.Result.Connection = newConnectionInfo(r.result.info.ConnectionState)
+	res.Result.ConnectedVia = r.result.info.ConnectedAddr
+	res.Result.RTTMillis = int(r.result.info.RTT.Milliseconds())
+	res.Result.ReachableMultiAddrs = r.result.info.AddrReachability
+	res.Result.DialedMultiAddrs = r.result.info.DialedAddrs
 
 	if len(r.result.pluginResults) != 0 {
-		res.Result.PluginData = make(map[string]pluginResultJSON)
+		res.Result.PluginData = make(map[string]PluginResult)
 
 		for pn, pd := range r.result.pluginResults {
-			tmp := pluginResultJSON{
+			tmp := PluginResult{
 				BeginTimestamp: pd.beginTimestamp,
 				EndTimestamp:   pd.endTimestamp,
 				Error:          nil,
@@ -92,26 +343,78 @@ func (r nodeCrawlStatus) toCrawledNode(addrBook map[peer.ID][]ma.Multiaddr, id p
 
 	res.Result.CrawlBeginTs = r.result.crawlDataBeginTs
 	res.Result.CrawlEndTs = r.result.crawlDataEndTs
+	res.Result.ConnectionDropped = r.result.connectionDropped
 	if r.result.crawlDataError != nil {
 		tmp := r.result.crawlDataError.Error()
 		res.Result.CrawlError = &tmp
 		return res
 	}
+	res.Result.BucketFill = r.result.bucketFill
+	res.Result.DHTProtocol = r.result.dhtProtocol
 
 	return res
 }
 
+// skippedNodes returns the peers we never probed, because none of their
+// addresses used an enabled transport.
+func (report *CrawlOutput) skippedNodes() []peer.ID {
+	skipped := []peer.ID{}
+	for id := range report.skipped {
+		if _, ok := report.nodes[id]; ok {
+			// We probed it at some other point.
+			continue
+		}
+		skipped = append(skipped, id)
+	}
+	return skipped
+}
+
+// overflowNodes returns the peers we never probed, because the queue was full
+// whenever we found them.
+func (report *CrawlOutput) overflowNodes() []peer.ID {
+	var overflow []peer.ID
+	for id := range report.overflow {
+		if _, ok := report.nodes[id]; ok {
+			// We probed it at some other point.
+			continue
+		}
+		overflow = append(overflow, id)
+	}
+	return overflow
+}
+
+// configSnapshot converts the config to a map with the same keys as the
+// configuration file, to record it in the output.
+func configSnapshot(config CrawlManagerConfig) (map[string]interface{}, error) {
+	buf, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal config: %w", err)
+	}
+
+	var snapshot map[string]interface{}
+	err = yaml.Unmarshal(buf, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config: %w", err)
+	}
+
+	// Make sure the snapshot can be serialized, which fails for plugin
+	// options with non-string keys.
+	_, err = json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize config: %w", err)
+	}
+
+	return snapshot, nil
+}
+
 // WriteMetadata writes a JSON report about the crawl to a file.
 // The report contains metadata about each node.
-func (report *CrawlOutput) WriteMetadata(startTs time.Time, endTs time.Time, path string) error {
-	var nodes []crawledNodeJSON
-	for id, node := range report.nodes {
-		nodes = append(nodes, node.toCrawledNode(report.addrInfo, id))
-	}
-	crawlOutput := crawlOutputJSON{StartDate: startTs, EndDate: endTs, Nodes: nodes}
+// If compression is enabled, .gz is appended to path.
+func (report *CrawlOutput) WriteMetadata(path string, config OutputConfig) error {
+	crawlOutput := report.metadata(config.ReachableOnly)
 
 	// Open output file.
-	vf, err := os.Create(path)
+	vf, err := CreateOutputFile(path, config)
 	if err != nil {
 		return fmt.Errorf("unable to open output file: %w", err)
 	}
@@ -124,17 +427,114 @@ func (report *CrawlOutput) WriteMetadata(startTs time.Time, endTs time.Time, pat
 	return vf.Close()
 }
 
+// metadata collects the report written by WriteMetadata, optionally omitting
+// nodes we were unable to connect to.
+func (report *CrawlOutput) metadata(reachableOnly bool) crawlOutputJSON {
+	var nodes []CrawledNode
+	for id, node := range report.nodes {
+		if reachableOnly && node.err != nil {
+			continue
+		}
+		nodes = append(nodes, node.toCrawledNode(report.addrInfo, report.firstSeen, id, report.maxAddrs))
+	}
+	crawlOutput := crawlOutputJSON{
+		CrawlID:            report.CrawlID,
+		Network:            report.Network,
+		SampleRate:         report.Config.SampleRate,
+		Stats:              report.Stats,
+		StartDate:          report.startTs,
+		EndDate:            report.endTs,
+		StoppedEarly:       len(report.stopReason) != 0,
+		StopReason:         report.stopReason,
+		CrawlerIdentities:  report.crawlerIDs,
+		SkippedNodes:       report.skippedNodes(),
+		QueueOverflowNodes: report.overflowNodes(),
+		Sanity:             report.sanity,
+		AgentVersionCounts: report.agentVersionCounts,
+		Nodes:              nodes,
+	}
+
+	estimate, samples, err := estimateNetworkSize(report)
+	if err != nil {
+		log.WithError(err).Warn("unable to estimate network size")
+	} else {
+		crawlOutput.NetworkSizeEstimate = &estimate
+		crawlOutput.NetworkSizeEstimateSamples = samples
+	}
+
+	crawlOutput.Config, err = configSnapshot(report.Config)
+	if err != nil {
+		log.WithError(err).Warn("unable to record configuration")
+	}
+
+	return crawlOutput
+}
+
+// WriteReportStreaming writes the result of probing each node to w as JSON
+// Lines, i.e., one CrawledNode per line.
+// Unlike CrawlOutput.WriteMetadata, this never holds the serialized form of
+// all nodes in memory at once, which makes it preferable for huge crawls.
+// This must not be called while CrawlNetwork is running.
+func (cm *CrawlManager) WriteReportStreaming(w io.Writer) error {
+	report := CrawlOutput{
+		nodes:     cm.crawled,
+		addrInfo:  cm.toCrawl.addrInfo,
+		firstSeen: cm.toCrawl.firstSeen,
+		maxAddrs:  cm.config.MaxStoredAddrsPerNode,
+	}
+	return report.WriteJSONLines(w)
+}
+
+// WriteJSONLines writes the result of probing each node to w as JSON Lines,
+// i.e., one CrawledNode per line.
+// This omits the meta information written by WriteMetadata, but never holds
+// the serialized form of all nodes in memory at once.
+func (report *CrawlOutput) WriteJSONLines(w io.Writer) error {
+	return report.writeJSONLines(w, false)
+}
+
+// writeJSONLines writes the result of probing each node to w as JSON Lines,
+// optionally omitting nodes we were unable to connect to.
+func (report *CrawlOutput) writeJSONLines(w io.Writer, reachableOnly bool) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for id, node := range report.nodes {
+		if reachableOnly && node.err != nil {
+			continue
+		}
+		err := enc.Encode(node.toCrawledNode(report.addrInfo, report.firstSeen, id, report.maxAddrs))
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+
+	err := bw.Flush()
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	return nil
+}
+
 // WritePeergraph writes the graph structure of the network as determined
 // through the crawl to a CSV file.
-func (report *CrawlOutput) WritePeergraph(path string) error {
-	f, err := os.Create(path)
+// If compression is enabled, .gz is appended to path.
+func (report *CrawlOutput) WritePeergraph(path string, config OutputConfig) error {
+	f, err := CreateOutputFile(path, config)
 	if err != nil {
 		return fmt.Errorf("unable to open output file: %w", err)
 	}
 
 	w := csv.NewWriter(f)
 
-	err = w.Write([]string{"source", "target", "target_crawlable", "source_crawl_timestamp"})
+	header := []string{"source", "target", "target_crawlable", "source_crawl_timestamp"}
+	if report.edgeNovelty {
+		header = append(header, "target_novel")
+	}
+	if report.edgeCPL {
+		header = append(header, "target_cpl")
+	}
+	err = w.Write(header)
 	if err != nil {
 		return fmt.Errorf("unable to write output: %w", err)
 	}
@@ -143,9 +543,20 @@ func (report *CrawlOutput) WritePeergraph(path string) error {
 			continue
 		}
 		ts := node.result.crawlDataEndTs.Format(time.RFC3339)
-		for _, neighbour := range node.result.crawlNeighbors {
+		for i, neighbour := range node.result.crawlNeighbors {
 			crawlable := fmt.Sprintf("%t", report.nodes[neighbour].err == nil && report.nodes[neighbour].result.crawlDataError == nil)
-			err = w.Write([]string{id.String(), neighbour.String(), crawlable, ts})
+			record := []string{id.String(), neighbour.String(), crawlable, ts}
+			if report.edgeNovelty {
+				record = append(record, fmt.Sprintf("%t", i < len(node.result.novelNeighbors) && node.result.novelNeighbors[i]))
+			}
+			if report.edgeCPL {
+				cpl := ""
+				if i < len(node.result.neighborCPLs) {
+					cpl = fmt.Sprintf("%d", node.result.neighborCPLs[i])
+				}
+				record = append(record, cpl)
+			}
+			err = w.Write(record)
 			if err != nil {
 				return fmt.Errorf("unable to write output: %w", err)
 			}
@@ -178,19 +589,123 @@ func RestoreNodeCache(path string) ([]peer.AddrInfo, error) {
 	return result, nil
 }
 
-// SaveNodeCache saves a list of peer addresses to file.
-func (report *CrawlOutput) SaveNodeCache(cacheFile string) error {
-	var nodesSave []peer.AddrInfo
+// priorCrawledNode is the subset of a CrawledNode read from the output of a
+// previous crawl.
+type priorCrawledNode struct {
+	ID              peer.ID  `json:"id"`
+	MultiAddrs      []string `json:"multiaddrs"`
+	ConnectionError *string  `json:"connection_error"`
+}
+
+// LoadUnreachablePeers reads the output of a previous crawl, as written by
+// WriteMetadata, WriteJSONLines, or WriteMsgpackStream, and returns the peers
+// which were not connectable, with their addresses.
+// The format is determined by the extension of the path, and files ending in
+// .gz are decompressed.
+// Together with CrawlManagerConfig.DisableExpansion, this can be used to
+// re-crawl only the previously unreachable peers.
+func LoadUnreachablePeers(path string) ([]peer.AddrInfo, error) {
+	peers, connectable, err := loadPriorCrawl(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var unreachable []peer.AddrInfo
+	for i, p := range peers {
+		if !connectable[i] {
+			unreachable = append(unreachable, p)
+		}
+	}
+
+	return unreachable, nil
+}
+
+// loadPriorCrawl reads the output of a previous crawl, see
+// LoadUnreachablePeers, and returns all peers with their addresses, and
+// whether each was connectable.
+func loadPriorCrawl(path string) ([]peer.AddrInfo, []bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open previous crawl: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to decompress previous crawl: %w", err)
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+		path = strings.TrimSuffix(path, ".gz")
+	}
+
+	if strings.HasSuffix(path, ".msgpack") {
+		return loadPriorCrawlMsgpack(r)
+	}
+
+	var nodes []priorCrawledNode
+	dec := json.NewDecoder(bufio.NewReader(r))
+	if strings.HasSuffix(path, ".jsonl") {
+		for dec.More() {
+			var node priorCrawledNode
+			err = dec.Decode(&node)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to decode previous crawl: %w", err)
+			}
+			nodes = append(nodes, node)
+		}
+	} else {
+		var out struct {
+			Nodes []priorCrawledNode `json:"found_nodes"`
+		}
+		err = dec.Decode(&out)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to decode previous crawl: %w", err)
+		}
+		nodes = out.Nodes
+	}
+
+	peers := make([]peer.AddrInfo, 0, len(nodes))
+	connectable := make([]bool, 0, len(nodes))
+	for _, node := range nodes {
+		p := peer.AddrInfo{ID: node.ID}
+		for _, addr := range node.MultiAddrs {
+			maddr, err := ma.NewMultiaddr(addr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid address of peer %s: %w", node.ID, err)
+			}
+			p.Addrs = append(p.Addrs, maddr)
+		}
+		peers = append(peers, p)
+		connectable = append(connectable, node.ConnectionError == nil)
+	}
+
+	return peers, connectable, nil
+}
+
+// reachablePeers returns the IDs and addresses of all peers which were
+// successfully crawled.
+func (report *CrawlOutput) reachablePeers() []peer.AddrInfo {
+	var peers []peer.AddrInfo
 	for id, node := range report.nodes {
 		if node.err != nil || node.result.crawlDataError != nil {
 			continue
 		}
-		nodesSave = append(nodesSave, peer.AddrInfo{
+		peers = append(peers, peer.AddrInfo{
 			ID:    id,
 			Addrs: report.addrInfo[id],
 		})
 	}
 
+	return peers
+}
+
+// SaveNodeCache saves a list of peer addresses to file.
+func (report *CrawlOutput) SaveNodeCache(cacheFile string) error {
+	nodesSave := report.reachablePeers()
+
 	f, err := os.Create(cacheFile)
 	if err != nil {
 		return fmt.Errorf("unable to create node cache file: %w", err)
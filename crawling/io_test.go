@@ -0,0 +1,137 @@
+package crawling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestNewConnectionInfoInferredALPN(t *testing.T) {
+	tests := []struct {
+		state network.ConnectionState
+		alpn  string
+	}{
+		{network.ConnectionState{Transport: "quic-v1"}, "libp2p"},
+		{network.ConnectionState{Transport: "webtransport"}, "h3"},
+		{network.ConnectionState{Transport: "tcp", Security: "/tls/1.0.0", StreamMultiplexer: "/yamux/1.0.0", UsedEarlyMuxerNegotiation: true}, "/yamux/1.0.0"},
+		{network.ConnectionState{Transport: "tcp", Security: "/tls/1.0.0", StreamMultiplexer: "/yamux/1.0.0"}, "libp2p"},
+		{network.ConnectionState{Transport: "tcp", Security: "/noise", StreamMultiplexer: "/yamux/1.0.0"}, ""},
+	}
+
+	for _, test := range tests {
+		info := newConnectionInfo(test.state)
+		switch {
+		case len(test.alpn) == 0 && info.InferredALPN != nil:
+			t.Errorf("%+v: expected no ALPN, got %q", test.state, *info.InferredALPN)
+		case len(test.alpn) != 0 && (info.InferredALPN == nil || *info.InferredALPN != test.alpn):
+			t.Errorf("%+v: expected ALPN %q, got %v", test.state, test.alpn, info.InferredALPN)
+		}
+	}
+}
+
+func TestToCrawledNodeMaxAddrs(t *testing.T) {
+	id, _ := newTestPeer(t)
+	var addrs []ma.Multiaddr
+	for i := 0; i < 10; i++ {
+		addrs = append(addrs, ma.StringCast(fmt.Sprintf("/ip4/1.2.3.%d/tcp/4001", i)))
+	}
+	status := nodeCrawlStatus{err: errors.New("unreachable"), attempts: 1}
+	addrBook := map[peer.ID][]ma.Multiaddr{id: addrs}
+
+	node := status.toCrawledNode(addrBook, nil, id, 3)
+	if node.NumMultiAddrs != len(addrs) {
+		t.Errorf("expected %d addresses in total, got %d", len(addrs), node.NumMultiAddrs)
+	}
+	// The most recently learned addresses are kept.
+	if len(node.MultiAddrs) != 3 || len(node.PublicMultiAddrs) != 3 || len(node.MultiAddrTransports) != 3 {
+		t.Fatalf("expected 3 addresses, got %v", node.MultiAddrs)
+	}
+	for i, addr := range node.MultiAddrs {
+		if !addr.Equal(addrs[7+i]) {
+			t.Errorf("expected address %s, got %s", addrs[7+i], addr)
+		}
+	}
+
+	node = status.toCrawledNode(addrBook, nil, id, 0)
+	if len(node.MultiAddrs) != len(addrs) || node.NumMultiAddrs != len(addrs) {
+		t.Errorf("expected all %d addresses without a limit, got %d", len(addrs), len(node.MultiAddrs))
+	}
+}
+
+func TestToCrawledNodePublicAddrs(t *testing.T) {
+	id, _ := newTestPeer(t)
+	relay, _ := newTestPeer(t)
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/4001"),
+		ma.StringCast("/ip4/10.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/1.2.3.5/tcp/4001/p2p/" + relay.String() + "/p2p-circuit"),
+	}
+	status := nodeCrawlStatus{err: errors.New("unreachable"), attempts: 1}
+
+	node := status.toCrawledNode(map[peer.ID][]ma.Multiaddr{id: addrs}, nil, id, 0)
+	expected := []bool{true, false, false}
+	if !reflect.DeepEqual(node.PublicMultiAddrs, expected) {
+		t.Errorf("expected public flags %v, got %v", expected, node.PublicMultiAddrs)
+	}
+}
+
+func TestWriteMetadataReachableOnly(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}}}},
+		b: {Err: errors.New("unreachable")},
+	}, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "visitedPeers.json")
+	err = out.WriteMetadata(path, OutputConfig{ReachableOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var written struct {
+		Nodes []struct {
+			ID peer.ID `json:"id"`
+		} `json:"found_nodes"`
+	}
+	err = json.NewDecoder(f).Decode(&written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Nodes) != 1 || written.Nodes[0].ID != a {
+		t.Errorf("expected only %s to be written, got %v", a, written.Nodes)
+	}
+
+	var buf bytes.Buffer
+	err = out.writeJSONLines(&buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 1 {
+		t.Errorf("expected one JSON line, got %d", lines)
+	}
+
+	// The results themselves are not filtered.
+	if len(out.nodes) != 2 {
+		t.Errorf("expected both nodes in the results, got %d", len(out.nodes))
+	}
+}
@@ -0,0 +1,35 @@
+package crawling
+
+import (
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// KBucketFor computes the k-bucket other falls into in the routing table of
+// self, i.e., the common prefix length of the SHA-256 hashes of their IDs,
+// which make up the Kademlia key space.
+func KBucketFor(self, other peer.ID) int {
+	return kb.CommonPrefixLen(kb.ConvertPeerID(self), kb.ConvertPeerID(other))
+}
+
+// ReconstructBuckets groups the neighbors of a node by the k-bucket they fall
+// into in the routing table of the node, see KBucketFor.
+func ReconstructBuckets(self peer.ID, neighbors []peer.ID) map[int][]peer.ID {
+	buckets := make(map[int][]peer.ID)
+	for _, n := range neighbors {
+		cpl := KBucketFor(self, n)
+		buckets[cpl] = append(buckets[cpl], n)
+	}
+	return buckets
+}
+
+// Buckets reconstructs the k-buckets of the routing table of the given node
+// from the neighbors found during the crawl, see ReconstructBuckets.
+// Returns false if the node's routing table was not crawled.
+func (report *CrawlOutput) Buckets(id peer.ID) (map[int][]peer.ID, bool) {
+	node, ok := report.nodes[id]
+	if !ok || node.err != nil || node.result.crawlDataError != nil {
+		return nil, false
+	}
+	return ReconstructBuckets(id, node.result.crawlNeighbors), true
+}
@@ -0,0 +1,56 @@
+package crawling
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// The SHA-256 hashes of the IDs below start with
+//
+//	a: 0xca97 = 1100 1010 1001 0111
+//	b: 0x3e23 = 0011 1110 0010 0011
+//	g: 0xcd0a = 1100 1101 0000 1010
+//	h: 0xaaa9 = 1010 1010 1010 1001
+//	i: 0xde7d = 1101 1110 0111 1101
+var (
+	kbucketA = peer.ID("a")
+	kbucketB = peer.ID("b")
+	kbucketG = peer.ID("g")
+	kbucketH = peer.ID("h")
+	kbucketI = peer.ID("i")
+)
+
+func TestKBucketFor(t *testing.T) {
+	for _, test := range []struct {
+		other peer.ID
+		cpl   int
+	}{
+		{kbucketB, 0},
+		{kbucketH, 1},
+		{kbucketI, 3},
+		{kbucketG, 5},
+		{kbucketA, 256},
+	} {
+		if cpl := KBucketFor(kbucketA, test.other); cpl != test.cpl {
+			t.Errorf("expected CPL %d for %q, got %d", test.cpl, test.other, cpl)
+		}
+		if cpl := KBucketFor(test.other, kbucketA); cpl != test.cpl {
+			t.Errorf("expected symmetric CPL %d for %q, got %d", test.cpl, test.other, cpl)
+		}
+	}
+}
+
+func TestReconstructBuckets(t *testing.T) {
+	buckets := ReconstructBuckets(kbucketA, []peer.ID{kbucketB, kbucketG, kbucketH, kbucketI, peer.ID("c")})
+	expected := map[int][]peer.ID{
+		0: {kbucketB, peer.ID("c")},
+		1: {kbucketH},
+		3: {kbucketI},
+		5: {kbucketG},
+	}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("expected %v, got %v", expected, buckets)
+	}
+}
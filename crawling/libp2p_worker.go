@@ -2,30 +2,161 @@ package crawling
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/record"
 	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// DesyncMillisMax sets a limit on the random backoff performed before each
-// request to de-sync.
+// DesyncMillisMax sets the default limit on the random backoff performed
+// before each request to de-sync.
 const DesyncMillisMax = 500
 
+// Strategies to back off before each request.
+const (
+	// BackoffRandom waits for a random duration up to the maximum backoff.
+	BackoffRandom = "random"
+	// BackoffFixed always waits for the maximum backoff.
+	BackoffFixed = "fixed"
+	// BackoffNone does not wait at all.
+	BackoffNone = "none"
+)
+
+// connMgrSilencePeriod is how often the connection manager trims connections
+// once the high watermark is exceeded.
+// We hang up on peers after crawling them, so there is no point in keeping
+// idle connections around for long.
+const connMgrSilencePeriod = 5 * time.Second
+
+// latencyPings is the number of pings sent to measure the round-trip time to
+// a peer.
+const latencyPings = 3
+
+// latencyTimeout bounds the time spent measuring the round-trip time to a
+// peer.
+const latencyTimeout = 5 * time.Second
+
+// addrProbeTimeout is the timeout for dialing a single address when probing
+// all addresses of a peer.
+const addrProbeTimeout = 5 * time.Second
+
+// addrProbeConcurrency is the number of addresses of a peer dialed
+// concurrently when probing all its addresses.
+const addrProbeConcurrency = 8
+
+// maxAddrProbeDuration bounds the total time spent probing all addresses of a
+// peer.
+const maxAddrProbeDuration = 15 * time.Second
+
+// Transports that can be enabled.
+const (
+	TransportTCP          = "tcp"
+	TransportQUIC         = "quic"
+	TransportWebsocket    = "ws"
+	TransportWebTransport = "webtransport"
+)
+
+// TransportWebRTCDirect is the transport of WebRTC addresses which browsers
+// can dial directly.
+// Such addresses are recognized, but the transport cannot be enabled, because
+// this version of go-libp2p cannot dial it.
+const TransportWebRTCDirect = "webrtc-direct"
+
 // The WorkerConfig configures a single worker.
 type WorkerConfig struct {
 	ConnectTimeout     time.Duration `yaml:"connect_timeout"`
 	ConnectionAttempts uint          `yaml:"connection_attempts"`
 	UserAgent          string        `yaml:"user_agent"`
+
+	// Whether to dial the addresses of a peer one at a time, in order, and
+	// stop at the first one that works, rather than letting libp2p dial them
+	// in parallel. The connection, and hence CrawledNodeData.ConnectedVia,
+	// is then over the first working address. The outcome of each dialed
+	// address is recorded in CrawledNodeData.DialedMultiAddrs.
+	// This is slower, since each address is dialed with ConnectTimeout.
+	StopOnFirstAddr bool `yaml:"stop_on_first_addr"`
+
+	// The strategy to back off with before each request, one of "random",
+	// "fixed", or "none". Defaults to "random".
+	BackoffStrategy string `yaml:"backoff_strategy"`
+	// The maximum duration to back off for before each request.
+	// Defaults to DesyncMillisMax milliseconds. A value of zero disables
+	// backing off.
+	MaxBackoff *time.Duration `yaml:"max_backoff"`
+
+	// The transports to enable, any of "tcp", "quic", "ws", and
+	// "webtransport". Defaults to all of them.
+	// Peers without an address for any of the enabled transports are
+	// skipped.
+	Transports []string `yaml:"transports"`
+
+	// Path to a swarm key file, to crawl a private network.
+	// Peers outside the private network will fail to connect.
+	// This is incompatible with the QUIC and WebTransport transports, which
+	// are disabled by default if this is set.
+	SwarmKeyPath *string `yaml:"swarm_key_path"`
+
+	// The URL of a SOCKS5 proxy to dial all connections through, e.g.,
+	// socks5://localhost:1080.
+	// Only TCP can be proxied, so all other transports are disabled if this
+	// is set. The hosts do not listen for incoming connections.
+	// DNS names are resolved locally before dialing, so socks5h is not
+	// supported.
+	DialProxy string `yaml:"dial_proxy"`
+
+	// The watermarks of the libp2p connection manager.
+	// Once a host has more than ConnMgrHigh connections, connections older
+	// than ConnMgrGracePeriod are closed until ConnMgrLow connections remain.
+	// Each worker has at most concurrent_requests/num_workers connections to
+	// peers being crawled, so ConnMgrHigh should be above that, and
+	// ConnMgrGracePeriod should exceed the time it takes to crawl a peer.
+	// Defaults to zero, which disables the connection manager.
+	ConnMgrLow  uint `yaml:"conn_mgr_low"`
+	ConnMgrHigh uint `yaml:"conn_mgr_high"`
+	// Defaults to one minute.
+	ConnMgrGracePeriod *time.Duration `yaml:"conn_mgr_grace_period"`
+
+	// Whether to enforce the default limits of the libp2p resource
+	// manager, scaled to the memory and file descriptors available.
+	// Connections exceeding the limits fail to open with an error from
+	// the resource manager, rather than running out of file descriptors.
+	// Defaults to true. If false, all limits are disabled.
+	LimitResources *bool `yaml:"limit_resources"`
+
+	// The name of the network being crawled, to label metrics with.
+	// This is set by the CrawlManager, see CrawlManagerConfig.Network.
+	network string
+	// The tracer to record spans with, or nil if tracing is disabled.
+	// This is set by the CrawlManager, see CrawlManagerConfig.Tracing.
+	tracer trace.Tracer
 }
 
 func (c WorkerConfig) check() error {
@@ -38,32 +169,266 @@ func (c WorkerConfig) check() error {
 	if len(c.UserAgent) == 0 {
 		return fmt.Errorf("missing user agent")
 	}
+	switch c.BackoffStrategy {
+	case "", BackoffRandom, BackoffFixed, BackoffNone:
+	default:
+		return fmt.Errorf("invalid backoff strategy: %s", c.BackoffStrategy)
+	}
+	if c.MaxBackoff != nil && *c.MaxBackoff < time.Duration(0) {
+		return fmt.Errorf("invalid max backoff")
+	}
+	for _, t := range c.Transports {
+		switch t {
+		case TransportTCP, TransportWebsocket:
+		case TransportQUIC, TransportWebTransport:
+			if c.SwarmKeyPath != nil {
+				return fmt.Errorf("transport %s does not support private networks", t)
+			}
+		case TransportWebRTCDirect:
+			return fmt.Errorf("transport %s is not supported", t)
+		default:
+			return fmt.Errorf("invalid transport: %s", t)
+		}
+	}
+	if len(c.DialProxy) != 0 {
+		_, err := parseDialProxy(c.DialProxy)
+		if err != nil {
+			return fmt.Errorf("invalid dial_proxy: %w", err)
+		}
+		tcpEnabled := len(c.Transports) == 0
+		for _, t := range c.Transports {
+			if t == TransportTCP {
+				tcpEnabled = true
+			}
+		}
+		if !tcpEnabled {
+			return fmt.Errorf("dial_proxy requires the %s transport", TransportTCP)
+		}
+	}
+	if c.ConnMgrLow > c.ConnMgrHigh {
+		return fmt.Errorf("conn_mgr_low exceeds conn_mgr_high")
+	}
+	if c.ConnMgrGracePeriod != nil && *c.ConnMgrGracePeriod < time.Duration(0) {
+		return fmt.Errorf("invalid conn_mgr_grace_period")
+	}
 	return nil
 }
 
+// connManagerOptions returns the libp2p options to configure the connection
+// manager, if enabled.
+func (c WorkerConfig) connManagerOptions() ([]libp2p.Option, error) {
+	if c.ConnMgrHigh == 0 {
+		return nil, nil
+	}
+
+	opts := []connmgr.Option{connmgr.WithSilencePeriod(connMgrSilencePeriod)}
+	if c.ConnMgrGracePeriod != nil {
+		opts = append(opts, connmgr.WithGracePeriod(*c.ConnMgrGracePeriod))
+	}
+	cm, err := connmgr.NewConnManager(int(c.ConnMgrLow), int(c.ConnMgrHigh), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection manager: %w", err)
+	}
+
+	return []libp2p.Option{libp2p.ConnectionManager(cm)}, nil
+}
+
+// loadSwarmKey loads a pre-shared key for a private network from a swarm key
+// file, as used by IPFS.
+func loadSwarmKey(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open swarm key: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid swarm key: %w", err)
+	}
+
+	return psk, nil
+}
+
+// restrictToProxy disables all transports but TCP if a proxy is configured,
+// because they cannot be dialed through it.
+func (c *WorkerConfig) restrictToProxy() {
+	if len(c.DialProxy) == 0 {
+		return
+	}
+
+	var disabled []string
+	if len(c.Transports) == 0 {
+		disabled = []string{TransportQUIC, TransportWebsocket, TransportWebTransport}
+	}
+	for _, t := range c.Transports {
+		if t != TransportTCP {
+			disabled = append(disabled, t)
+		}
+	}
+	if len(disabled) != 0 {
+		log.WithField("transports", disabled).Warn("transports cannot be dialed through the proxy, disabling them")
+	}
+	c.Transports = []string{TransportTCP}
+}
+
+// transportOptions returns the libp2p options to enable the configured
+// transports.
+// We also need to restrict the listen addresses, because libp2p fails to start
+// if it can't listen on any of them.
+// If a proxy is configured, only TCP is enabled, and we don't listen at all.
+func (c WorkerConfig) transportOptions() ([]libp2p.Option, error) {
+	if len(c.DialProxy) != 0 {
+		dialer, err := parseDialProxy(c.DialProxy)
+		if err != nil {
+			return nil, err
+		}
+		return []libp2p.Option{libp2p.Transport(newProxyTransport(dialer)), libp2p.NoListenAddrs}, nil
+	}
+
+	var opts []libp2p.Option
+	var listenAddrs []string
+	for _, t := range c.Transports {
+		switch t {
+		case TransportTCP:
+			opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+			listenAddrs = append(listenAddrs, "/ip4/0.0.0.0/tcp/0", "/ip6/::/tcp/0")
+		case TransportQUIC:
+			opts = append(opts, libp2p.Transport(quic.NewTransport))
+			listenAddrs = append(listenAddrs, "/ip4/0.0.0.0/udp/0/quic", "/ip4/0.0.0.0/udp/0/quic-v1", "/ip6/::/udp/0/quic", "/ip6/::/udp/0/quic-v1")
+		case TransportWebsocket:
+			opts = append(opts, libp2p.Transport(ws.New))
+			listenAddrs = append(listenAddrs, "/ip4/0.0.0.0/tcp/0/ws", "/ip6/::/tcp/0/ws")
+		case TransportWebTransport:
+			opts = append(opts, libp2p.Transport(webtransport.New))
+			listenAddrs = append(listenAddrs, "/ip4/0.0.0.0/udp/0/quic-v1/webtransport", "/ip6/::/udp/0/quic-v1/webtransport")
+		}
+	}
+	if len(listenAddrs) != 0 {
+		opts = append(opts, libp2p.ListenAddrStrings(listenAddrs...))
+	}
+	return opts, nil
+}
+
+// canDial checks whether the peer has any address we can dial with the
+// configured transports.
+func (c WorkerConfig) canDial(p peer.AddrInfo) bool {
+	if len(c.Transports) == 0 {
+		return true
+	}
+
+	for _, addr := range p.Addrs {
+		if _, err := addr.ValueForProtocol(ma.P_DNSADDR); err == nil {
+			// We don't know the transports until we resolve it.
+			return true
+		}
+		t := addrTransport(addr)
+		for _, enabled := range c.Transports {
+			if t == enabled {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addrTransport determines the transport used to dial an address.
+// For relayed addresses, this is the transport used to dial the relay.
+func addrTransport(maddr ma.Multiaddr) string {
+	if isRelayAddr(maddr) {
+		maddr, _ = ma.SplitFunc(maddr, func(c ma.Component) bool {
+			return c.Protocol().Code == ma.P_CIRCUIT
+		})
+		if maddr == nil {
+			return ""
+		}
+	}
+
+	hasProtocol := func(code int) bool {
+		_, err := maddr.ValueForProtocol(code)
+		return err == nil
+	}
+	switch {
+	case hasProtocol(ma.P_WEBRTC_DIRECT) || hasProtocol(ma.P_P2P_WEBRTC_DIRECT):
+		return TransportWebRTCDirect
+	case hasProtocol(ma.P_WEBTRANSPORT):
+		return TransportWebTransport
+	case hasProtocol(ma.P_QUIC) || hasProtocol(ma.P_QUIC_V1):
+		return TransportQUIC
+	case hasProtocol(ma.P_WS) || hasProtocol(ma.P_WSS):
+		return TransportWebsocket
+	case hasProtocol(ma.P_TCP):
+		return TransportTCP
+	default:
+		return ""
+	}
+}
+
+// backoff returns the duration to wait for before a request, according to the
+// configured strategy.
+func (c WorkerConfig) backoff() time.Duration {
+	maxBackoff := time.Duration(DesyncMillisMax) * time.Millisecond
+	if c.MaxBackoff != nil {
+		maxBackoff = *c.MaxBackoff
+	}
+	if maxBackoff <= time.Duration(0) {
+		return 0
+	}
+
+	switch c.BackoffStrategy {
+	case BackoffNone:
+		return 0
+	case BackoffFixed:
+		return maxBackoff
+	default:
+		return time.Duration(rand.Int63n(int64(maxBackoff)))
+	}
+}
+
 // A Libp2pWorker implements the worker interface for a libp2p host.
 type Libp2pWorker struct {
 	host        *basichost.BasicHost
 	config      WorkerConfig
 	crawler     *crawler
 	plugins     []Plugin
+	bandwidth   *metrics.BandwidthCounter
+	resolver    *addrResolver
+	events      *EventManager
 	closed      chan struct{}
 	closingLock sync.Mutex
+
+	// The peers currently being crawled, mapped to whether all connections
+	// to them were closed in the meantime.
+	crawlingLock sync.Mutex
+	crawling     map[peer.ID]bool
 }
 
 // NewLibp2pWorker creates a new libp2p worker.
 // This initializes a new libp2p host with a unique keypair, configures the
-// libp2p resource manager to be disabled, and initializes all given plugins on
-// the host.
+// libp2p resource manager to be disabled if LimitResources is false, and
+// initializes all given plugins on the host.
+// The host acts purely as a DHT client: it does not register a handler for any
+// of the DHT protocols, so we never answer queries of other peers.
 func NewLibp2pWorker(config WorkerConfig, pluginConfigs []PluginConfig, preimageHandler *PreimageHandler, crawlerConfig CrawlerConfig) (*Libp2pWorker, error) {
+	return newLibp2pWorker(config, pluginConfigs, preimageHandler, crawlerConfig, nil)
+}
+
+// newLibp2pWorker creates a new libp2p worker, see NewLibp2pWorker, which
+// emits events to the given EventManager.
+// The EventManager is set before the host's notifications are registered,
+// which may fire right away.
+func newLibp2pWorker(config WorkerConfig, pluginConfigs []PluginConfig, preimageHandler *PreimageHandler, crawlerConfig CrawlerConfig, events *EventManager) (*Libp2pWorker, error) {
 	err := config.check()
 	if err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	config.restrictToProxy()
 
 	w := &Libp2pWorker{
-		config: config,
-		closed: make(chan struct{}),
+		config:    config,
+		bandwidth: metrics.NewBandwidthCounter(),
+		closed:    make(chan struct{}),
+		events:    events,
 	}
 
 	// Init the host, i.e., generate priv key and all that stuff
@@ -71,6 +436,11 @@ func NewLibp2pWorker(config WorkerConfig, pluginConfigs []PluginConfig, preimage
 
 	// The resource manager expects a limiter, se we create one from our limits.
 	limiter := rcmgr.NewFixedLimiter(rcmgr.InfiniteLimits)
+	if config.LimitResources == nil || *config.LimitResources {
+		limits := rcmgr.DefaultLimits
+		libp2p.SetDefaultServiceLimits(&limits)
+		limiter = rcmgr.NewFixedLimiter(limits.AutoScale())
+	}
 
 	// Initialize the resource manager
 	rm, err := rcmgr.NewResourceManager(limiter)
@@ -79,40 +449,218 @@ func NewLibp2pWorker(config WorkerConfig, pluginConfigs []PluginConfig, preimage
 	}
 
 	// Create libp2p host
-	opts := []libp2p.Option{libp2p.Identity(priv), libp2p.ResourceManager(rm), libp2p.UserAgent(config.UserAgent)}
+	opts := []libp2p.Option{libp2p.Identity(priv), libp2p.ResourceManager(rm), libp2p.UserAgent(config.UserAgent), libp2p.BandwidthReporter(w.bandwidth)}
+	transportOpts, err := config.transportOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, transportOpts...)
+	cmOpts, err := config.connManagerOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, cmOpts...)
+	if config.SwarmKeyPath != nil {
+		psk, err := loadSwarmKey(*config.SwarmKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
 	h, err := libp2p.New(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create libp2p host: %w", err)
 	}
-	// We have determined that we have a BasicHost through experimentation.
-	// If this ever fails, it'll panic, which is... fine, I guess.
-	w.host = h.(*basichost.BasicHost)
+
+	err = w.init(h, pluginConfigs, preimageHandler, crawlerConfig)
+	if err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// NewLibp2pWorkerWithHost creates a new libp2p worker using the given host,
+// which must be a *basichost.BasicHost, as created by libp2p.New.
+// This is useful to reuse a tuned host, or for testing with mock transports.
+// Options of the WorkerConfig which configure the host, i.e., Transports,
+// SwarmKeyPath, DialProxy, the connection manager, and LimitResources, are
+// ignored. Bytes transferred through the host are not tracked, so they do not
+// count towards a crawl budget.
+// The worker takes ownership of the host and closes it when stopped.
+func NewLibp2pWorkerWithHost(h host.Host, config WorkerConfig, pluginConfigs []PluginConfig, preimageHandler *PreimageHandler, crawlerConfig CrawlerConfig) (*Libp2pWorker, error) {
+	return newLibp2pWorkerWithHost(h, config, pluginConfigs, preimageHandler, crawlerConfig, nil)
+}
+
+// newLibp2pWorkerWithHost creates a new libp2p worker using the given host,
+// see NewLibp2pWorkerWithHost, which emits events to the given EventManager,
+// see newLibp2pWorker.
+func newLibp2pWorkerWithHost(h host.Host, config WorkerConfig, pluginConfigs []PluginConfig, preimageHandler *PreimageHandler, crawlerConfig CrawlerConfig, events *EventManager) (*Libp2pWorker, error) {
+	err := config.check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	w := &Libp2pWorker{
+		config:    config,
+		bandwidth: metrics.NewBandwidthCounter(),
+		closed:    make(chan struct{}),
+		events:    events,
+	}
+
+	err = w.init(h, pluginConfigs, preimageHandler, crawlerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// init sets up the crawler and plugins on the given host.
+func (w *Libp2pWorker) init(h host.Host, pluginConfigs []PluginConfig, preimageHandler *PreimageHandler, crawlerConfig CrawlerConfig) error {
+	// We need some functionality of the BasicHost, notably the identify
+	// service.
+	bh, ok := h.(*basichost.BasicHost)
+	if !ok {
+		return fmt.Errorf("host is a %T, not a *basichost.BasicHost", h)
+	}
+	w.host = bh
+	w.resolver = newAddrResolver(madns.DefaultResolver)
+	w.crawling = make(map[peer.ID]bool)
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF:    w.connected,
+		DisconnectedF: w.disconnected,
+	})
 
 	// Create crawler "plugin"
 	c, err := newCrawler(h, crawlerConfig, preimageHandler)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create crawler plugin: %w", err)
+		return fmt.Errorf("unable to create crawler plugin: %w", err)
 	}
 	w.crawler = c
 
 	// Create plugins
 	plugins, err := PluginsFromPluginConfigs(h, pluginConfigs)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create plugins: %w", err)
+		return fmt.Errorf("unable to create plugins: %w", err)
 	}
 	w.plugins = plugins
 
-	return w, nil
+	// Make sure no plugin registered a handler for one of the DHT protocols.
+	// Other peers could add us to their routing tables otherwise, which
+	// pollutes the network.
+	for _, p := range h.Mux().Protocols() {
+		for _, dhtProtocol := range crawlerConfig.ProtocolStrings {
+			if p == dhtProtocol {
+				return fmt.Errorf("handler registered for DHT protocol %s", p)
+			}
+		}
+	}
+
+	go w.reportMetrics()
+
+	return nil
 }
 
-func (w *Libp2pWorker) connect(p peer.AddrInfo) (network.Conn, error) {
+// connected is called by the host for every new connection.
+// It emits EventConnected for the first connection to a peer.
+func (w *Libp2pWorker) connected(n network.Network, c network.Conn) {
+	if len(n.ConnsToPeer(c.RemotePeer())) == 1 {
+		w.events.Emit(EventConnected, c.RemotePeer())
+	}
+}
+
+// disconnected is called by the host for every closed connection.
+// Once the last connection to a peer is closed, it emits EventDisconnected and
+// marks the peer as dropped, if we're crawling it.
+func (w *Libp2pWorker) disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	if n.Connectedness(p) == network.Connected {
+		return
+	}
+
+	w.crawlingLock.Lock()
+	if _, ok := w.crawling[p]; ok {
+		w.crawling[p] = true
+	}
+	w.crawlingLock.Unlock()
+
+	w.events.Emit(EventDisconnected, p)
+}
+
+// watchConnection starts tracking whether all connections to the given peer
+// are closed, until the returned function is called.
+// The returned function reports whether that happened.
+func (w *Libp2pWorker) watchConnection(p peer.ID) func() bool {
+	w.crawlingLock.Lock()
+	w.crawling[p] = false
+	w.crawlingLock.Unlock()
+
+	return func() bool {
+		w.crawlingLock.Lock()
+		dropped := w.crawling[p]
+		delete(w.crawling, p)
+		w.crawlingLock.Unlock()
+
+		// Notifications are delivered asynchronously, so we might not have
+		// received one yet.
+		return dropped || w.host.Network().Connectedness(p) != network.Connected
+	}
+}
+
+var (
+	workerConnectedPeers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipfs_crawler_worker_connected_peers",
+		Help: "The number of peers a worker has open connections to.",
+	}, []string{"network", "worker"})
+	workerOpenStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipfs_crawler_worker_open_streams",
+		Help: "The number of streams a worker has open, over all connections.",
+	}, []string{"network", "worker"})
+)
+
+// reportMetrics periodically updates the gauges of connected peers and open
+// streams of the worker, until it is stopped.
+func (w *Libp2pWorker) reportMetrics() {
+	network, label := w.config.network, w.host.ID().String()
+	defer workerConnectedPeers.DeleteLabelValues(network, label)
+	defer workerOpenStreams.DeleteLabelValues(network, label)
+
+	ticker := time.NewTicker(prometheusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			streams := 0
+			for _, c := range w.host.Network().Conns() {
+				streams += len(c.GetStreams())
+			}
+			workerConnectedPeers.WithLabelValues(network, label).Set(float64(len(w.host.Network().Peers())))
+			workerOpenStreams.WithLabelValues(network, label).Set(float64(streams))
+		case <-w.closed:
+			return
+		}
+	}
+}
+
+// connect connects to the peer.
+// If addresses are dialed sequentially, the outcome of each dialed address is
+// recorded in dialed, unless it is nil.
+func (w *Libp2pWorker) connect(ctx context.Context, p peer.AddrInfo, dialed map[string]bool) (network.Conn, error) {
+	if w.config.StopOnFirstAddr {
+		return w.connectSequentially(ctx, p, dialed)
+	}
+
 	// This is mostly taken from (*BasicHost).Connect()
-	// First, add the new addresses to the peerstore
-	w.host.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.TempAddrTTL)
+	ctx, cancel := context.WithTimeout(ctx, w.config.ConnectTimeout)
+	defer cancel()
+
+	// First, add the new addresses to the peerstore.
+	// The swarm can't dial DNS addresses, so we resolve them.
+	w.host.Peerstore().AddAddrs(p.ID, w.resolver.resolve(ctx, p), peerstore.TempAddrTTL)
 
 	// Then dial
-	ctx, cancel := context.WithTimeout(context.Background(), w.config.ConnectTimeout)
-	defer cancel()
 	c, err := w.host.Network().DialPeer(ctx, p.ID)
 	if err != nil {
 		return nil, fmt.Errorf("dial: %w", err)
@@ -121,8 +669,47 @@ func (w *Libp2pWorker) connect(p peer.AddrInfo) (network.Conn, error) {
 	return c, nil
 }
 
-func (w *Libp2pWorker) identifyConn(c network.Conn) {
-	ctx, cancel := context.WithTimeout(context.Background(), w.config.ConnectTimeout)
+// connectSequentially connects to the peer by dialing its addresses one at a
+// time, in order, until one of them works, see WorkerConfig.StopOnFirstAddr.
+// The swarm dials all addresses of a peer it knows, so only the address being
+// dialed is kept in the peerstore. All addresses are added back afterwards.
+// Whether the dial of each (resolved) address succeeded is recorded in dialed,
+// unless it is nil. Addresses after the first working one are not dialed, and
+// hence not recorded.
+func (w *Libp2pWorker) connectSequentially(ctx context.Context, p peer.AddrInfo, dialed map[string]bool) (network.Conn, error) {
+	resolveCtx, cancel := context.WithTimeout(ctx, w.config.ConnectTimeout)
+	addrs := w.resolver.resolve(resolveCtx, p)
+	cancel()
+
+	ps := w.host.Peerstore()
+	defer ps.AddAddrs(p.ID, addrs, peerstore.TempAddrTTL)
+
+	err := swarm.ErrNoAddresses
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("dial: %w", ctx.Err())
+		}
+		ps.ClearAddrs(p.ID)
+		ps.AddAddr(p.ID, addr, peerstore.TempAddrTTL)
+
+		var c network.Conn
+		dialCtx, cancel := context.WithTimeout(ctx, w.config.ConnectTimeout)
+		c, err = w.host.Network().DialPeer(dialCtx, p.ID)
+		cancel()
+		if dialed != nil {
+			dialed[addr.String()] = err == nil
+		}
+		if err == nil {
+			return c, nil
+		}
+		log.WithError(err).WithField("peer", p.ID).WithField("addr", addr).Debug("unable to dial address")
+	}
+
+	return nil, fmt.Errorf("dial: %w", err)
+}
+
+func (w *Libp2pWorker) identifyConn(ctx context.Context, c network.Conn) {
+	ctx, cancel := context.WithTimeout(ctx, w.config.ConnectTimeout)
 	defer cancel()
 
 	// Wait for identity protocol to finish
@@ -132,16 +719,174 @@ func (w *Libp2pWorker) identifyConn(c network.Conn) {
 	}
 }
 
-// CrawlPeer implements worker.
-func (w *Libp2pWorker) crawlPeer(remote peer.AddrInfo) (*rawNodeInformation, error) {
-	// Sleep to de-sync
-	time.Sleep(time.Duration(rand.Intn(DesyncMillisMax)) * time.Millisecond)
+// certifiedAddrs returns the addresses of the signed peer record the peer sent
+// via identify, if any.
+// The record is only used if its signature is valid, and it was signed with
+// the key the peer used for the connection and is about that peer.
+func (w *Libp2pWorker) certifiedAddrs(p peer.ID, key crypto.PubKey) ([]ma.Multiaddr, bool) {
+	cab, ok := peerstore.GetCertifiedAddrBook(w.host.Peerstore())
+	if !ok {
+		return nil, false
+	}
+	envelope := cab.GetPeerRecord(p)
+	if envelope == nil {
+		return nil, false
+	}
 
-	// Connect to peer
+	rec, err := verifyPeerRecord(envelope, p, key)
+	if err != nil {
+		log.WithError(err).WithField("peer", p).Debug("invalid signed peer record")
+		return nil, false
+	}
+
+	return rec.Addrs, true
+}
+
+// verifyPeerRecord verifies the signature of a signed peer record, and that it
+// was signed with the given key and is about the given peer.
+// The key may be nil, e.g., if it is not known.
+func verifyPeerRecord(envelope *record.Envelope, p peer.ID, key crypto.PubKey) (*peer.PeerRecord, error) {
+	buf, err := envelope.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal envelope: %w", err)
+	}
+	var rec peer.PeerRecord
+	envelope, err = record.ConsumeTypedEnvelope(buf, &rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil && !envelope.PublicKey.Equals(key) {
+		return nil, errors.New("record signed with a different key than the connection")
+	}
+	signer, err := peer.IDFromPublicKey(envelope.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive peer ID of signer: %w", err)
+	}
+	if signer != p || rec.PeerID != p {
+		return nil, fmt.Errorf("record of %s signed by %s for a different peer", rec.PeerID, signer)
+	}
+
+	return &rec, nil
+}
+
+// measureLatency measures the round-trip time to a connected peer as the
+// minimum of a few pings.
+// Returns zero if the peer does not respond to pings within latencyTimeout,
+// e.g., because it does not support the ping protocol.
+func (w *Libp2pWorker) measureLatency(ctx context.Context, p peer.ID) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, latencyTimeout)
+	defer cancel()
+
+	var rtt time.Duration
+	results := ping.Ping(ctx, w.host, p)
+	for i := 0; i < latencyPings; i++ {
+		res, ok := <-results
+		if !ok {
+			break
+		}
+		if res.Error != nil {
+			log.WithError(res.Error).WithField("peer", p).Debug("unable to ping peer")
+			break
+		}
+		if rtt == 0 || res.RTT < rtt {
+			rtt = res.RTT
+		}
+	}
+
+	return rtt
+}
+
+// probeAddrs dials each of the given addresses of the peer separately to
+// check which of them are reachable.
+// This dials via the transports directly, bypassing the swarm, so existing
+// connections to the peer are not reused. Each dial is closed right after the
+// security handshake and multiplexer negotiation.
+// DNS addresses are reachable if any of the addresses they resolve to is.
+// Relayed addresses and addresses without an enabled transport are not
+// probed, and not included in the result.
+// Also returns the public keys the peer used in the handshakes.
+func (w *Libp2pWorker) probeAddrs(ctx context.Context, p peer.AddrInfo) (map[string]bool, []crypto.PubKey) {
+	s, ok := w.host.Network().(*swarm.Swarm)
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, maxAddrProbeDuration)
+	defer cancel()
+
+	results := make(map[string]bool)
+	var keys []crypto.PubKey
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, addrProbeConcurrency)
+	for _, addr := range p.Addrs {
+		if isRelayAddr(addr) {
+			continue
+		}
+		wg.Add(1)
+		go func(addr ma.Multiaddr) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			reachable, probed, key := w.probeAddr(ctx, s, p.ID, addr)
+			if !probed {
+				return
+			}
+			m.Lock()
+			defer m.Unlock()
+			results[addr.String()] = reachable
+			if key != nil {
+				keys = append(keys, key)
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	return results, keys
+}
+
+// probeAddr dials a single address of the peer, see probeAddrs.
+// Returns whether the address is reachable, and whether we were able to dial
+// it at all.
+func (w *Libp2pWorker) probeAddr(ctx context.Context, s *swarm.Swarm, id peer.ID, addr ma.Multiaddr) (bool, bool, crypto.PubKey) {
+	probed := false
+	for _, resolved := range w.resolver.resolve(ctx, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{addr}}) {
+		t := s.TransportForDialing(resolved)
+		if t == nil {
+			continue
+		}
+		probed = true
+
+		dialCtx, cancel := context.WithTimeout(ctx, addrProbeTimeout)
+		conn, err := t.Dial(dialCtx, resolved, id)
+		cancel()
+		if err != nil {
+			log.WithError(err).WithField("peer", id).WithField("addr", resolved).Debug("unable to dial address")
+			continue
+		}
+		key := conn.RemotePublicKey()
+		_ = conn.Close()
+		return true, true, key
+	}
+
+	return false, probed, nil
+}
+
+// connectWithAttempts connects to the peer, making up to the configured number
+// of attempts.
+// No further attempts are made once the context is cancelled.
+// Dial outcomes of all attempts are recorded in dialed, see connect.
+func (w *Libp2pWorker) connectWithAttempts(ctx context.Context, remote peer.AddrInfo, dialed map[string]bool) (network.Conn, error) {
 	var conn network.Conn
 	var err error
-	for i := uint(0); i < w.config.ConnectionAttempts; i++ {
-		conn, err = w.connect(remote)
+	for i := uint(0); i < w.config.ConnectionAttempts && ctx.Err() == nil; i++ {
+		conn, err = w.connect(ctx, remote, dialed)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"err":      err,
@@ -152,24 +897,134 @@ func (w *Libp2pWorker) crawlPeer(remote peer.AddrInfo) (*rawNodeInformation, err
 			break
 		}
 	}
+	if conn == nil && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnectFailed, err)
+	}
+	return conn, nil
+}
+
+// ErrConnectFailed is returned if we were unable to connect to a peer.
+// It wraps the error of the last connection attempt.
+var ErrConnectFailed = errors.New("unable to connect")
+
+// FindProviders connects to the given peer and asks it for providers of the
+// content with the given key, i.e., the multihash of its CID.
+// This uses the same connection and DHT protocol settings as crawling.
+// Providers are returned with the addresses the peer knows for them, if any.
+func (w *Libp2pWorker) FindProviders(remote peer.AddrInfo, key []byte) ([]peer.AddrInfo, error) {
+	providers, _, err := w.findProviders(context.Background(), remote, key)
+	return providers, err
+}
+
+// findProviders implements worker.
+func (w *Libp2pWorker) findProviders(ctx context.Context, remote peer.AddrInfo, key []byte) ([]peer.AddrInfo, []peer.AddrInfo, error) {
+	_, err := w.connectWithAttempts(ctx, remote, nil)
 	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = w.host.Network().ClosePeer(remote.ID) }()
+
+	return w.crawler.findProviders(ctx, remote.ID, key)
+}
+
+// findNode implements worker.
+func (w *Libp2pWorker) findNode(ctx context.Context, remote peer.AddrInfo, key []byte) ([]peer.AddrInfo, error) {
+	_, err := w.connectWithAttempts(ctx, remote, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = w.host.Network().ClosePeer(remote.ID) }()
+
+	return w.crawler.findNode(ctx, remote.ID, key)
+}
+
+// CrawlPeer implements worker.
+func (w *Libp2pWorker) crawlPeer(ctx context.Context, remote peer.AddrInfo) (*rawNodeInformation, error) {
+	ctx, span := startSpan(ctx, w.config.tracer, "crawl_peer")
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("peer.id", remote.ID.String()))
+	}
+
+	// Sleep to de-sync, unless we're shutting down.
+	if d := w.config.backoff(); d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-w.closed:
+			t.Stop()
+			return nil, fmt.Errorf("worker stopped")
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	// Connect to peer
+	connectCtx, connectSpan := startSpan(ctx, w.config.tracer, "connect")
+	var dialedAddrs map[string]bool
+	if w.config.StopOnFirstAddr {
+		dialedAddrs = make(map[string]bool)
+	}
+	conn, err := w.connectWithAttempts(connectCtx, remote, dialedAddrs)
+	if conn != nil && connectSpan.IsRecording() {
+		connectSpan.SetAttributes(attribute.String("peer.addr", conn.RemoteMultiaddr().String()))
+	}
+	spanError(connectSpan, err)
+	connectSpan.End()
+	if err != nil {
+		spanError(span, err)
 		return nil, err
 	}
-	defer func() { _ = conn.Close() }()
+	// Plugins or the peer itself may open additional connections, so we close
+	// all of them, not just ours.
+	// This runs after we've read everything we need from the peerstore and
+	// the connection, including the results of identify.
+	defer func() { _ = w.host.Network().ClosePeer(remote.ID) }()
+
+	// Measure latency before crawling, so the connection is not busy.
+	var rtt time.Duration
+	if w.crawler.config.MeasureLatency {
+		rtt = w.measureLatency(ctx, remote.ID)
+	}
+
+	// Probing addresses uses separate connections, so we do it concurrently.
+	var addrReachability map[string]bool
+	var probeKeys []crypto.PubKey
+	probed := make(chan struct{})
+	if w.crawler.config.ProbeAllAddresses {
+		go func() {
+			defer close(probed)
+			addrReachability, probeKeys = w.probeAddrs(ctx, remote)
+		}()
+	} else {
+		close(probed)
+	}
 
 	// Execute crawler "plugin"
 	crawlBeginTs := time.Now()
-	crawlData, crawlErr := w.crawler.HandlePeer(remote)
+	connectionDropped := w.watchConnection(remote.ID)
+	crawlData, crawlErr := w.crawler.HandlePeer(ctx, remote)
+	dropped := connectionDropped()
 	crawlEndTs := time.Now()
 	if crawlErr != nil {
 		log.WithError(crawlErr).WithField("peer", remote.ID).Debug("unable to crawl peer")
+		spanError(span, crawlErr)
+	} else if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String("dht.protocol", string(crawlData.protocol)),
+			attribute.Int("dht.neighbors", len(crawlData.neighbors)),
+		)
 	}
 
 	// Execute plugins
 	pluginResults := make(map[string]pluginResult)
 	for _, p := range w.plugins {
 		log.WithField("remote", remote.ID).WithField("plugin", p.Name()).Debug("executing plugin")
-		res, err := p.HandlePeer(remote)
+		res, err := p.HandlePeer(ctx, remote)
 		if err != nil {
 			log.WithError(err).WithField("remote", remote.ID).WithField("plugin", p.Name()).Debug("plugin failed")
 		}
@@ -184,9 +1039,27 @@ func (w *Libp2pWorker) crawlPeer(remote peer.AddrInfo) (*rawNodeInformation, err
 	// It's not guaranteed that this actually works -- we just time out after a while...
 	// TODO figure out a way to actually _force_ identify a connection, potentially with retries.
 	// We could call (*idService).identifyConn(c network.Conn), which we need to get via reflection or so first...
-	w.identifyConn(conn)
+	w.identifyConn(ctx, conn)
+
+	<-probed
 
 	var infos peerMetadata
+	infos.publicKey = conn.RemotePublicKey()
+	infos.connectionKeys = probeKeys
+	for _, c := range w.host.Network().ConnsToPeer(remote.ID) {
+		if c != conn {
+			infos.connectionKeys = append(infos.connectionKeys, c.RemotePublicKey())
+		}
+	}
+	infos.ConnectionState = conn.ConnState()
+	infos.ConnectedAddr = conn.RemoteMultiaddr()
+	infos.RTT = rtt
+	infos.AddrReachability = addrReachability
+	infos.DialedAddrs = dialedAddrs
+	var unsupported *unsupportedProtocolsError
+	if errors.As(crawlErr, &unsupported) {
+		infos.ListedProtocols = unsupported.protocols
+	}
 	agentVersion, err := w.host.Peerstore().Get(remote.ID, "AgentVersion")
 	if err != nil {
 		log.WithError(err).WithField("peer", remote.ID).Debug("unable to get agent version")
@@ -199,6 +1072,7 @@ func (w *Libp2pWorker) crawlPeer(remote peer.AddrInfo) (*rawNodeInformation, err
 	} else {
 		infos.SupportedProtocols = protocols
 	}
+	infos.CertifiedAddrs, infos.Certified = w.certifiedAddrs(remote.ID, infos.publicKey)
 
 	return &rawNodeInformation{
 		info: infos,
@@ -207,11 +1081,24 @@ func (w *Libp2pWorker) crawlPeer(remote peer.AddrInfo) (*rawNodeInformation, err
 			endTimestamp:   crawlEndTs,
 			err:            crawlErr,
 			result:         crawlData,
+
+			connectionDropped: dropped,
 		},
 		pluginResults: pluginResults,
 	}, nil
 }
 
+// id implements worker.
+func (w *Libp2pWorker) id() peer.ID {
+	return w.host.ID()
+}
+
+// usage implements worker.
+func (w *Libp2pWorker) usage() (uint64, uint64) {
+	totals := w.bandwidth.GetBandwidthTotals()
+	return uint64(totals.TotalIn + totals.TotalOut), w.crawler.queries.Load()
+}
+
 // Stop stops the Libp2pWorker.
 // This shuts down any plugins and stops the libp2p host.
 func (w *Libp2pWorker) stop() error {
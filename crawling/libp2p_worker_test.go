@@ -0,0 +1,1041 @@
+package crawling
+
+import (
+	"bufio"
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-msgio"
+	"github.com/libp2p/go-msgio/protoio"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testDHTProtocol is the DHT protocol spoken by testDHTPeers.
+const testDHTProtocol = protocol.ID("/ipfs/kad/1.0.0")
+
+var (
+	testPreimagesOnce sync.Once
+	testPreimages     *PreimageHandler
+)
+
+// emptyPreimages returns a PreimageHandler without any preimages.
+// This is enough to crawl testDHTPeers, which do not look at the requested
+// keys.
+func emptyPreimages() *PreimageHandler {
+	testPreimagesOnce.Do(func() {
+		testPreimages = &PreimageHandler{}
+	})
+	return testPreimages
+}
+
+// testWorkerConfigs returns the configs of a worker which dials TCP only,
+// with short timeouts.
+func testWorkerConfigs() (WorkerConfig, CrawlerConfig) {
+	return WorkerConfig{
+		ConnectTimeout:     5 * time.Second,
+		ConnectionAttempts: 1,
+		UserAgent:          "ipfs-crawler-test",
+		BackoffStrategy:    BackoffNone,
+		Transports:         []string{TransportTCP},
+	}, CrawlerConfig{
+		ProtocolStrings:     []protocol.ID{testDHTProtocol},
+		InteractionTimeout:  5 * time.Second,
+		InteractionAttempts: 1,
+	}
+}
+
+// newTestWorker creates a Libp2pWorker with the given configs, which is
+// stopped when the test ends.
+func newTestWorker(t *testing.T, workerConfig WorkerConfig, crawlerConfig CrawlerConfig) *Libp2pWorker {
+	t.Helper()
+
+	w, err := NewLibp2pWorker(workerConfig, nil, emptyPreimages(), crawlerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.stop() })
+
+	return w
+}
+
+// A testDHTPeer is a libp2p host listening on the loopback interface, which
+// answers the n-th DHT request with the n-th programmed response, regardless
+// of the request.
+// Requests beyond the programmed responses are answered with no peers.
+type testDHTPeer struct {
+	host.Host
+
+	responses [][]peer.AddrInfo
+	// If positive, the first stream is reset on the request after this many
+	// requests were answered on it.
+	resetAfter int
+
+	m        sync.Mutex
+	requests int
+	streams  int
+}
+
+// newTestDHTPeer creates a new testDHTPeer, which is closed when the test
+// ends.
+func newTestDHTPeer(t *testing.T, responses ...[]peer.AddrInfo) *testDHTPeer {
+	t.Helper()
+
+	h, err := libp2p.New(
+		libp2p.NoTransports,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.UserAgent("test-dht-peer"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Close() })
+
+	p := &testDHTPeer{
+		Host:      h,
+		responses: responses,
+	}
+	h.SetStreamHandler(testDHTProtocol, p.handleStream)
+
+	return p
+}
+
+// addrInfo returns the ID and listen addresses of the peer.
+func (p *testDHTPeer) addrInfo() peer.AddrInfo {
+	return peer.AddrInfo{ID: p.ID(), Addrs: p.Addrs()}
+}
+
+// nextResponse returns the response to the next request.
+func (p *testDHTPeer) nextResponse() []peer.AddrInfo {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	n := p.requests
+	p.requests++
+	if n >= len(p.responses) {
+		return nil
+	}
+	return p.responses[n]
+}
+
+func (p *testDHTPeer) handleStream(s network.Stream) {
+	defer s.Close()
+
+	p.m.Lock()
+	p.streams++
+	first := p.streams == 1
+	p.m.Unlock()
+
+	r := msgio.NewVarintReaderSize(s, network.MessageSizeMax)
+	w := protoio.NewDelimitedWriter(s)
+	for answered := 0; ; answered++ {
+		buf, err := r.ReadMsg()
+		if err != nil {
+			return
+		}
+		var req pb.Message
+		err = req.Unmarshal(buf)
+		r.ReleaseMsg(buf)
+		if err != nil || (first && p.resetAfter > 0 && answered == p.resetAfter) {
+			_ = s.Reset()
+			return
+		}
+
+		resp := pb.NewMessage(req.GetType(), req.GetKey(), 0)
+		resp.CloserPeers = pb.RawPeerInfosToPBPeers(p.nextResponse())
+		err = w.WriteMsg(resp)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// testNeighbors returns n peers with random IDs and a public address each.
+func testNeighbors(t *testing.T, n int) []peer.AddrInfo {
+	t.Helper()
+
+	neighbors := make([]peer.AddrInfo, n)
+	for i := range neighbors {
+		id, _ := newTestPeer(t)
+		neighbors[i] = peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	}
+	return neighbors
+}
+
+func TestCrawlPeerNeighborCPLs(t *testing.T) {
+	neighbors := testNeighbors(t, 4)
+	a, b, c, d := neighbors[0], neighbors[1], neighbors[2], neighbors[3]
+	// a and b are returned again for higher CPLs, but were first returned at
+	// CPL 0 and 1.
+	dht := newTestDHTPeer(t, []peer.AddrInfo{a}, []peer.AddrInfo{a, b}, []peer.AddrInfo{b}, []peer.AddrInfo{a, c}, []peer.AddrInfo{d})
+
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	crawlerConfig.RecordNeighborCPL = true
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		t.Fatal(info.crawlData.err)
+	}
+
+	expected := map[peer.ID]int{a.ID: 0, b.ID: 1, c.ID: 3, d.ID: 4}
+	result := info.crawlData.result
+	if len(result.neighbors) != len(expected) || len(result.neighborCPLs) != len(expected) {
+		t.Fatalf("expected %d neighbors and CPLs, got %d and %d", len(expected), len(result.neighbors), len(result.neighborCPLs))
+	}
+	for i, n := range result.neighbors {
+		if cpl, ok := expected[n.ID]; !ok || cpl != result.neighborCPLs[i] {
+			t.Errorf("neighbor %s: expected CPL %d, got %d", n.ID, cpl, result.neighborCPLs[i])
+		}
+	}
+}
+
+func TestCrawlPeerBucketFill(t *testing.T) {
+	neighbors := testNeighbors(t, 4)
+	a, b, c, d := neighbors[0], neighbors[1], neighbors[2], neighbors[3]
+	// We ask for at least four buckets, and continue while we learn new
+	// peers, i.e., up to the sixth bucket, which is empty.
+	dht := newTestDHTPeer(t, []peer.AddrInfo{a}, []peer.AddrInfo{a, b}, []peer.AddrInfo{b}, []peer.AddrInfo{a, c}, []peer.AddrInfo{d})
+	expected := []int{1, 2, 1, 2, 1, 0}
+
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	crawlerConfig.RecordBucketFill = true
+	cm, err := NewCrawlManager(CrawlManagerConfig{
+		PreimageFilePath:   emptyPreimageFile(t),
+		NumWorkers:         1,
+		ConcurrentRequests: 1,
+		KeepLocalAddrs:     true,
+		DisableExpansion:   true,
+		BootstrapPeers:     []string{dht.Addrs()[0].String() + "/p2p/" + dht.ID().String()},
+		WorkerConfig:       workerConfig,
+		CrawlerConfig:      crawlerConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, ok := out.nodes[dht.ID()]
+	if !ok || status.err != nil {
+		t.Fatalf("expected %s to be crawled", dht.ID())
+	}
+	node := status.toCrawledNode(out.addrInfo, out.firstSeen, dht.ID(), 0)
+	j, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Result struct {
+			BucketFill []int `json:"bucket_fill"`
+		} `json:"result"`
+	}
+	err = json.Unmarshal(j, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Result.BucketFill, expected) {
+		t.Errorf("expected bucket fill %v, got %v", expected, decoded.Result.BucketFill)
+	}
+}
+
+func TestCrawlPeerExcludesSelfFromNeighbors(t *testing.T) {
+	a := testNeighbors(t, 1)[0]
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	// The peer echoes itself and the crawler alongside a real neighbor.
+	dht := newTestDHTPeer(t)
+	self := peer.AddrInfo{ID: w.id(), Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	dht.responses = [][]peer.AddrInfo{{dht.addrInfo(), self, a}, {a, dht.addrInfo()}}
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		t.Fatal(info.crawlData.err)
+	}
+
+	neighbors := info.crawlData.result.neighbors
+	if len(neighbors) != 1 || neighbors[0].ID != a.ID {
+		t.Errorf("expected only %s as neighbor, got %v", a.ID, neighbors)
+	}
+}
+
+func TestCrawlPeerCertifiedAddrs(t *testing.T) {
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+	// libp2p hosts send signed peer records via identify by default.
+	dht := newTestDHTPeer(t)
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.info.Certified {
+		t.Fatal("expected the signed peer record to be verified")
+	}
+	if !sameAddrs(info.info.CertifiedAddrs, dht.Addrs()) {
+		t.Errorf("expected certified addresses %v, got %v", dht.Addrs(), info.info.CertifiedAddrs)
+	}
+}
+
+func TestCrawlPeerStopOnFirstAddr(t *testing.T) {
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	workerConfig.StopOnFirstAddr = true
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+	dht := newTestDHTPeer(t)
+	// Nothing listens on these ports.
+	unreachable := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	undialed := ma.StringCast("/ip4/127.0.0.1/tcp/2")
+	working := dht.Addrs()[0]
+
+	info, err := w.crawlPeer(context.Background(), peer.AddrInfo{
+		ID:    dht.ID(),
+		Addrs: []ma.Multiaddr{unreachable, working, undialed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.info.ConnectedAddr.Equal(working) {
+		t.Errorf("expected connection via %s, got %s", working, info.info.ConnectedAddr)
+	}
+	// Dialing stops at the first working address.
+	expected := map[string]bool{unreachable.String(): false, working.String(): true}
+	if !reflect.DeepEqual(info.info.DialedAddrs, expected) {
+		t.Errorf("expected dialed addresses %v, got %v", expected, info.info.DialedAddrs)
+	}
+}
+
+func TestVerifyPeerRecord(t *testing.T) {
+	priv, pub, err := crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherPub := newTestPeer(t)
+	addrs := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	envelope, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: id, Addrs: addrs}), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := verifyPeerRecord(envelope, id, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameAddrs(rec.Addrs, addrs) {
+		t.Errorf("expected addresses %v, got %v", addrs, rec.Addrs)
+	}
+	// The key of the connection is not always known.
+	_, err = verifyPeerRecord(envelope, id, nil)
+	if err != nil {
+		t.Errorf("expected record to be valid without a key, got %v", err)
+	}
+
+	_, err = verifyPeerRecord(envelope, id, otherPub)
+	if err == nil {
+		t.Error("expected record signed with a different key to be rejected")
+	}
+	_, err = verifyPeerRecord(envelope, other, nil)
+	if err == nil {
+		t.Error("expected record of a different peer to be rejected")
+	}
+}
+
+func TestCrawlPeerRetriesAfterStreamReset(t *testing.T) {
+	neighbors := testNeighbors(t, 3)
+	a, b, c := neighbors[0], neighbors[1], neighbors[2]
+	dht := newTestDHTPeer(t, []peer.AddrInfo{a}, []peer.AddrInfo{b}, []peer.AddrInfo{c})
+	dht.resetAfter = 1
+
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	workerConfig.network = "test-stream-resets"
+	crawlerConfig.network = workerConfig.network
+	crawlerConfig.InteractionAttempts = 2
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		t.Fatal(info.crawlData.err)
+	}
+
+	// The request reset at CPL 1 is answered on a new stream.
+	if n := len(info.crawlData.result.neighbors); n != len(neighbors) {
+		t.Errorf("expected %d neighbors, got %d", len(neighbors), n)
+	}
+	if v := testutil.ToFloat64(streamResets.WithLabelValues(workerConfig.network)); v != 1 {
+		t.Errorf("expected one stream reset, got %v", v)
+	}
+	dht.m.Lock()
+	defer dht.m.Unlock()
+	if dht.streams != 2 {
+		t.Errorf("expected two streams, got %d", dht.streams)
+	}
+}
+
+func TestCrawlPeerSkipsOversizedResponse(t *testing.T) {
+	oversized := testNeighbors(t, 50)
+	neighbors := testNeighbors(t, 4)
+	dht := newTestDHTPeer(t, oversized, neighbors[:2], neighbors[2:])
+
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	workerConfig.network = "test-oversized-responses"
+	crawlerConfig.network = workerConfig.network
+	crawlerConfig.MaxMessageSize = 1024
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		t.Fatal(info.crawlData.err)
+	}
+
+	// The oversized response at CPL 0 is skipped, the remaining buckets are
+	// asked on a new stream.
+	if n := len(info.crawlData.result.neighbors); n != len(neighbors) {
+		t.Errorf("expected %d neighbors, got %d", len(neighbors), n)
+	}
+	for _, n := range info.crawlData.result.neighbors {
+		for _, o := range oversized {
+			if n.ID == o.ID {
+				t.Errorf("expected neighbors of the oversized response to be skipped, got %s", n.ID)
+			}
+		}
+	}
+	if v := testutil.ToFloat64(oversizedResponses.WithLabelValues(workerConfig.network)); v != 1 {
+		t.Errorf("expected one oversized response, got %v", v)
+	}
+	dht.m.Lock()
+	defer dht.m.Unlock()
+	if dht.streams != 2 {
+		t.Errorf("expected two streams, got %d", dht.streams)
+	}
+}
+
+func TestCrawlPeerClosesConnection(t *testing.T) {
+	dht := newTestDHTPeer(t, testNeighbors(t, 2))
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Identify has completed before we hung up.
+	if info.info.AgentVersion != "test-dht-peer" {
+		t.Errorf("expected agent version of peer, got %q", info.info.AgentVersion)
+	}
+
+	if conns := w.host.Network().ConnsToPeer(dht.ID()); len(conns) != 0 {
+		t.Errorf("expected no connections to crawled peer, got %d", len(conns))
+	}
+}
+
+// A blockingPlugin blocks in HandlePeer until its context is cancelled, and
+// signals when it starts blocking.
+type blockingPlugin struct {
+	started chan struct{}
+}
+
+// blockingPlugins are the plugins created by the blocking driver.
+var blockingPlugins = make(chan *blockingPlugin, 1)
+
+type blockingDriver struct{}
+
+func (blockingDriver) NewImpl(host.Host, []byte) (Plugin, error) {
+	p := &blockingPlugin{started: make(chan struct{})}
+	blockingPlugins <- p
+	return p, nil
+}
+
+func (*blockingPlugin) Name() string {
+	return "test-blocking"
+}
+
+func (p *blockingPlugin) HandlePeer(ctx context.Context, _ peer.AddrInfo) (interface{}, error) {
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (*blockingPlugin) Shutdown() error {
+	return nil
+}
+
+func init() {
+	RegisterPlugin("test-blocking", blockingDriver{})
+}
+
+func TestCrawlPeerCancelsPlugins(t *testing.T) {
+	dht := newTestDHTPeer(t)
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	w, err := NewLibp2pWorker(workerConfig, []PluginConfig{{Name: "test-blocking"}}, emptyPreimages(), crawlerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.stop() })
+	plugin := <-blockingPlugins
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-plugin.started
+		cancel()
+	}()
+
+	done := make(chan *rawNodeInformation)
+	go func() {
+		info, _ := w.crawlPeer(ctx, dht.addrInfo())
+		done <- info
+	}()
+	select {
+	case info := <-done:
+		if info == nil {
+			t.Fatal("expected result of crawl")
+		}
+		if !errors.Is(info.pluginResults["test-blocking"].err, context.Canceled) {
+			t.Errorf("expected plugin to be cancelled, got %v", info.pluginResults["test-blocking"].err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin was not cancelled")
+	}
+}
+
+func TestCrawlPeerCountsNegotiationFailures(t *testing.T) {
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	workerConfig.network = "test-negotiation-failures"
+	crawlerConfig.network = workerConfig.network
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+	failures := protocolNegotiationFailures.WithLabelValues(workerConfig.network)
+
+	// A peer speaking our DHT protocol is not counted.
+	dht := newTestDHTPeer(t, testNeighbors(t, 2))
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		t.Fatal(info.crawlData.err)
+	}
+	if v := testutil.ToFloat64(failures); v != 0 {
+		t.Errorf("expected no negotiation failures, got %v", v)
+	}
+
+	// A peer not speaking it is.
+	other := newTestDHTPeer(t)
+	other.RemoveStreamHandler(testDHTProtocol)
+	info, err = w.crawlPeer(context.Background(), other.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(info.crawlData.err, multistream.ErrNotSupported[protocol.ID]{}) {
+		t.Fatalf("expected unsupported protocol, got %v", info.crawlData.err)
+	}
+	if v := testutil.ToFloat64(failures); v != 1 {
+		t.Errorf("expected one negotiation failure, got %v", v)
+	}
+}
+
+// emptyPreimageFile writes a preimage file without any preimages, see
+// emptyPreimages, and returns its path.
+func emptyPreimageFile(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "preimages.csv")
+	err := os.WriteFile(path, []byte("target;preimage\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCrawlPeerListsUnsupportedProtocols(t *testing.T) {
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	crawlerConfig.ProbeUnsupportedProtocols = true
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	// Current libp2p hosts no longer support ls, so the peer answers
+	// multistream-select itself, supporting no protocols at all.
+	h, err := libp2p.New(
+		libp2p.NoTransports,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	listed := []protocol.ID{"/ipfs/id/1.0.0", "/test/other/1.0.0"}
+	h.Network().SetStreamHandler(func(s network.Stream) {
+		serveMultistreamLs(s, listed)
+	})
+
+	info, err := w.crawlPeer(context.Background(), peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(info.crawlData.err, multistream.ErrNotSupported[protocol.ID]{}) {
+		t.Fatalf("expected unsupported protocol, got %v", info.crawlData.err)
+	}
+	if !reflect.DeepEqual(info.info.ListedProtocols, listed) {
+		t.Errorf("expected listed protocols %v, got %v", listed, info.info.ListedProtocols)
+	}
+}
+
+// serveMultistreamLs answers multistream-select on the stream, rejecting all
+// protocols, but listing the given ones if asked via ls.
+func serveMultistreamLs(s network.Stream, protocols []protocol.ID) {
+	defer s.Close()
+
+	r := bufio.NewReader(s)
+	header, err := readDelimited(r)
+	if err != nil || string(header) != multistream.ProtocolID {
+		_ = s.Reset()
+		return
+	}
+	_, err = s.Write(appendDelimited(nil, []byte(multistream.ProtocolID)))
+	if err != nil {
+		return
+	}
+	for {
+		msg, err := readDelimited(r)
+		if err != nil {
+			return
+		}
+		response := appendDelimited(nil, []byte("na"))
+		if string(msg) == "ls" {
+			var list []byte
+			for _, p := range protocols {
+				list = appendDelimited(list, []byte(p))
+			}
+			response = appendDelimited(nil, list)
+		}
+		_, err = s.Write(response)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestNewCrawlManagerSharedHost(t *testing.T) {
+	dht := newTestDHTPeer(t)
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	workerConfig.ConnMgrLow = 10
+	workerConfig.ConnMgrHigh = 20
+	cm, err := NewCrawlManager(CrawlManagerConfig{
+		PreimageFilePath:   emptyPreimageFile(t),
+		NumWorkers:         3,
+		ConcurrentRequests: 2,
+		SharedHost:         true,
+		KeepLocalAddrs:     true,
+		BootstrapPeers:     []string{dht.Addrs()[0].String() + "/p2p/" + dht.ID().String()},
+		WorkerConfig:       workerConfig,
+		CrawlerConfig:      crawlerConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	if len(cm.workers) != 1 {
+		t.Fatalf("expected one shared worker, got %d", len(cm.workers))
+	}
+	if c := cm.config.WorkerConfig; c.ConnMgrLow != 30 || c.ConnMgrHigh != 60 {
+		t.Errorf("expected watermarks scaled to 30 and 60, got %d and %d", c.ConnMgrLow, c.ConnMgrHigh)
+	}
+
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, ok := out.nodes[dht.ID()]; !ok || state.err != nil {
+		t.Errorf("expected %s to be crawled through the shared host", dht.ID())
+	}
+}
+
+func TestNewCrawlManagerWithHost(t *testing.T) {
+	dht := newTestDHTPeer(t)
+	h, err := libp2p.New(
+		libp2p.NoTransports,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.NoListenAddrs,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	cm, err := NewCrawlManagerWithHost(CrawlManagerConfig{
+		PreimageFilePath:   emptyPreimageFile(t),
+		ConcurrentRequests: 4,
+		KeepLocalAddrs:     true,
+		BootstrapPeers:     []string{dht.Addrs()[0].String() + "/p2p/" + dht.ID().String()},
+		WorkerConfig:       workerConfig,
+		CrawlerConfig:      crawlerConfig,
+	}, h)
+	if err != nil {
+		_ = h.Close()
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	if len(cm.workers) != 1 || cm.workers[0].id() != h.ID() {
+		t.Fatalf("expected the given host to be used")
+	}
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, ok := out.nodes[dht.ID()]; !ok || state.err != nil {
+		t.Errorf("expected %s to be crawled", dht.ID())
+	}
+	if id := out.crawlerIDs; len(id) != 1 {
+		t.Errorf("expected one crawler identity, got %v", id)
+	}
+}
+
+func TestCrawlPeerDisconnectedMidCrawl(t *testing.T) {
+	// The peer answers the first request, then hangs up.
+	dht := newTestDHTPeer(t)
+	neighbors := testNeighbors(t, 2)
+	dht.SetStreamHandler(testDHTProtocol, func(s network.Stream) {
+		r := msgio.NewVarintReaderSize(s, network.MessageSizeMax)
+		buf, err := r.ReadMsg()
+		if err != nil {
+			_ = s.Reset()
+			return
+		}
+		var req pb.Message
+		err = req.Unmarshal(buf)
+		if err != nil {
+			_ = s.Reset()
+			return
+		}
+		resp := pb.NewMessage(req.GetType(), req.GetKey(), 0)
+		resp.CloserPeers = pb.RawPeerInfosToPBPeers(neighbors)
+		_ = protoio.NewDelimitedWriter(s).WriteMsg(resp)
+		_ = s.Conn().Close()
+	})
+
+	events := NewEventManager()
+	defer events.Close()
+	connected := make(chan peer.ID, 1)
+	disconnected := make(chan peer.ID, 1)
+	notify := func(ch chan peer.ID) func(args ...interface{}) {
+		return func(args ...interface{}) {
+			select {
+			case ch <- args[0].(peer.ID):
+			default:
+			}
+		}
+	}
+	events.On(EventConnected, notify(connected))
+	events.On(EventDisconnected, notify(disconnected))
+
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	w, err := newLibp2pWorker(workerConfig, nil, emptyPreimages(), crawlerConfig, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = w.stop() }()
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.crawlData.connectionDropped {
+		t.Error("expected the dropped connection to be recorded")
+	}
+
+	for name, ch := range map[string]chan peer.ID{EventConnected: connected, EventDisconnected: disconnected} {
+		select {
+		case id := <-ch:
+			if id != dht.ID() {
+				t.Errorf("expected %s event for %s, got %s", name, dht.ID(), id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("expected %s event", name)
+		}
+	}
+}
+
+func TestCrawlPeerDebugDump(t *testing.T) {
+	neighbors := testNeighbors(t, 2)
+	a, b := neighbors[0], neighbors[1]
+	dht := newTestDHTPeer(t, []peer.AddrInfo{a}, []peer.AddrInfo{a, b})
+
+	dir := t.TempDir()
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	crawlerConfig.DebugDumpPath = dir
+	w, err := NewLibp2pWorker(workerConfig, nil, emptyPreimages(), crawlerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := w.crawlPeer(context.Background(), dht.addrInfo())
+	if err != nil {
+		_ = w.stop()
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		_ = w.stop()
+		t.Fatal(info.crawlData.err)
+	}
+	// Stopping the worker closes the dump.
+	err = w.stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "findnode_"+w.id().String()+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var records []findNodeRecord
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var rec findNodeRecord
+		err = dec.Decode(&rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	if len(records) != 1 || records[0].Peer != dht.ID() {
+		t.Fatalf("expected one record for %s, got %+v", dht.ID(), records)
+	}
+	requests := records[0].Requests
+	if len(requests) < 3 {
+		t.Fatalf("expected at least three requests, got %+v", requests)
+	}
+	for i, expected := range []findNodeRecordCPL{
+		{CPL: 0, Peers: 1, NewPeers: 1, CumulativeNewPeers: 1},
+		{CPL: 1, Peers: 2, NewPeers: 1, CumulativeNewPeers: 2},
+		{CPL: 2, Peers: 0, NewPeers: 0, CumulativeNewPeers: 2},
+	} {
+		actual := requests[i]
+		if len(actual.Target) == 0 || actual.Error != nil {
+			t.Errorf("CPL %d: expected a target and no error, got %+v", i, actual)
+		}
+		actual.Target = ""
+		if actual != expected {
+			t.Errorf("CPL %d: expected %+v, got %+v", i, expected, actual)
+		}
+	}
+}
+
+func TestNewCrawlManagerDryRunCreatesNoHosts(t *testing.T) {
+	bootstrapID, _ := newTestPeer(t)
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	cm, err := NewCrawlManager(CrawlManagerConfig{
+		PreimageFilePath:   emptyPreimageFile(t),
+		NumWorkers:         2,
+		ConcurrentRequests: 2,
+		DryRun:             true,
+		BootstrapPeers:     []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + bootstrapID.String()},
+		WorkerConfig:       workerConfig,
+		CrawlerConfig:      crawlerConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cm.Stop() }()
+
+	if len(cm.workers) != 0 {
+		t.Fatalf("expected no workers in a dry run, got %d", len(cm.workers))
+	}
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.nodes) != 0 {
+		t.Errorf("expected an empty report, got %d nodes", len(out.nodes))
+	}
+}
+
+func TestWorkerConfigBackoff(t *testing.T) {
+	zero, second := time.Duration(0), time.Second
+	for name, test := range map[string]struct {
+		config   WorkerConfig
+		expected time.Duration
+	}{
+		"none":          {WorkerConfig{BackoffStrategy: BackoffNone, MaxBackoff: &second}, 0},
+		"zero fixed":    {WorkerConfig{BackoffStrategy: BackoffFixed, MaxBackoff: &zero}, 0},
+		"zero random":   {WorkerConfig{BackoffStrategy: BackoffRandom, MaxBackoff: &zero}, 0},
+		"fixed":         {WorkerConfig{BackoffStrategy: BackoffFixed, MaxBackoff: &second}, second},
+		"fixed default": {WorkerConfig{BackoffStrategy: BackoffFixed}, time.Duration(DesyncMillisMax) * time.Millisecond},
+	} {
+		if d := test.config.backoff(); d != test.expected {
+			t.Errorf("%s: expected backoff of %v, got %v", name, test.expected, d)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		d := WorkerConfig{MaxBackoff: &second}.backoff()
+		if d < 0 || d >= second {
+			t.Fatalf("expected random backoff below %v, got %v", second, d)
+		}
+	}
+}
+
+func TestCrawlPeerBackoff(t *testing.T) {
+	dht := newTestDHTPeer(t)
+	minute := time.Minute
+	unreachable, _ := newTestPeer(t)
+	remote := peer.AddrInfo{ID: unreachable, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/1")}}
+
+	t.Run("disabled", func(t *testing.T) {
+		zero := time.Duration(0)
+		workerConfig, crawlerConfig := testWorkerConfigs()
+		workerConfig.BackoffStrategy = BackoffFixed
+		workerConfig.MaxBackoff = &zero
+		w := newTestWorker(t, workerConfig, crawlerConfig)
+
+		start := time.Now()
+		_, err := w.crawlPeer(context.Background(), dht.addrInfo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("expected no backoff, took %v", d)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		workerConfig, crawlerConfig := testWorkerConfigs()
+		workerConfig.BackoffStrategy = BackoffFixed
+		workerConfig.MaxBackoff = &minute
+		w := newTestWorker(t, workerConfig, crawlerConfig)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := w.crawlPeer(context.Background(), remote)
+			errs <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		_ = w.stop()
+		select {
+		case err := <-errs:
+			if err == nil || err.Error() != "worker stopped" {
+				t.Errorf("expected the worker to be stopped, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("backoff not interrupted by stopping the worker")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		workerConfig, crawlerConfig := testWorkerConfigs()
+		workerConfig.BackoffStrategy = BackoffFixed
+		workerConfig.MaxBackoff = &minute
+		w := newTestWorker(t, workerConfig, crawlerConfig)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() {
+			_, err := w.crawlPeer(ctx, remote)
+			errs <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected the crawl to be cancelled, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("backoff not interrupted by cancelling the context")
+		}
+	})
+}
+
+func TestNewLibp2pWorkerServesNoDHTProtocols(t *testing.T) {
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	crawlerConfig.ProtocolStrings = []protocol.ID{testDHTProtocol, "/ipfs/lan/kad/1.0.0"}
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	protocols := w.host.Mux().Protocols()
+	if len(protocols) == 0 {
+		t.Fatal("expected the host to serve some protocols, e.g., identify")
+	}
+	for _, p := range protocols {
+		for _, dhtProtocol := range crawlerConfig.ProtocolStrings {
+			if p == dhtProtocol {
+				t.Errorf("expected no handler for DHT protocol %s", p)
+			}
+		}
+	}
+}
+
+func TestCrawlPeerWrapsErrors(t *testing.T) {
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	// A peer we can't connect to.
+	gone := newTestDHTPeer(t)
+	addr := gone.addrInfo()
+	err := gone.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.crawlPeer(context.Background(), addr)
+	if !errors.Is(err, ErrConnectFailed) {
+		t.Errorf("expected ErrConnectFailed, got %v", err)
+	}
+	var dialErr *swarm.DialError
+	if !errors.As(err, &dialErr) || dialErr.Peer != addr.ID {
+		t.Errorf("expected wrapped dial error for %s, got %v", addr.ID, err)
+	}
+
+	// A peer not speaking our DHT protocol.
+	other := newTestDHTPeer(t)
+	other.RemoveStreamHandler(testDHTProtocol)
+	info, err := w.crawlPeer(context.Background(), other.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(info.crawlData.err, ErrStreamFailed) {
+		t.Errorf("expected ErrStreamFailed, got %v", info.crawlData.err)
+	}
+	var notSupported multistream.ErrNotSupported[protocol.ID]
+	if !errors.As(info.crawlData.err, &notSupported) || len(notSupported.Protos) != 1 || notSupported.Protos[0] != testDHTProtocol {
+		t.Errorf("expected wrapped negotiation error for %s, got %v", testDHTProtocol, info.crawlData.err)
+	}
+}
@@ -0,0 +1,210 @@
+package crawling
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// lookupResultSize is the number of closest peers a lookup converges
+	// to, i.e., the bucket size of the DHT.
+	lookupResultSize = 20
+	// maxLookupRounds caps the number of rounds of a lookup.
+	maxLookupRounds = 20
+	// lookupConcurrency is the number of peers queried concurrently in each
+	// round of a lookup.
+	lookupConcurrency = 3
+)
+
+// LookupOutput is the result of walking the DHT toward a key, see
+// CrawlManager.LookupKey.
+type LookupOutput struct {
+	// The key looked up, as sent in FIND_NODE requests.
+	Key []byte `json:"key"`
+	// The peers closest to the key which responded, closest first, at most
+	// lookupResultSize.
+	Closest []peer.AddrInfo `json:"closest"`
+	// Whether the lookup converged, i.e., all of the closest peers known were
+	// queried, as opposed to being cut short by maxLookupRounds or the
+	// context.
+	Converged bool `json:"converged"`
+	// The number of rounds executed.
+	Rounds int `json:"rounds"`
+	// Every query sent, in the order of rounds.
+	Queries []LookupQuery `json:"queries"`
+	// When the lookup started and finished.
+	BeginTimestamp time.Time `json:"begin_timestamp"`
+	EndTimestamp   time.Time `json:"end_timestamp"`
+}
+
+// LookupQuery is a single FIND_NODE request sent during a lookup.
+// The fields Error and Closer are mutually exclusive.
+type LookupQuery struct {
+	Round int     `json:"round"`
+	Peer  peer.ID `json:"peer"`
+	// The length of the common prefix of the peer and the key, i.e., the
+	// bucket of the peer's routing table the key falls into.
+	CPL            int       `json:"cpl"`
+	BeginTimestamp time.Time `json:"begin_timestamp"`
+	EndTimestamp   time.Time `json:"end_timestamp"`
+	Error          *string   `json:"error"`
+	// The peers returned, in the order they were returned.
+	Closer []peer.ID `json:"closer"`
+}
+
+// LookupKey walks the DHT toward the given key, like an iterative lookup of a
+// DHT client, rather than crawling the routing tables of all peers.
+// Starting at the given peers, or the configured bootstrap peers if none are
+// given, this repeatedly asks the lookupResultSize closest peers known for the
+// peers closest to the key they know of, until all of them have been asked or
+// maxLookupRounds is reached. Each peer is asked at most once, and peers which
+// do not respond are disregarded. Addresses returned by peers are filtered like
+// those learned while crawling, so peers with only private or loopback
+// addresses are not asked, unless KeepLocalAddrs is set.
+// The key is sent as is, so it must be a peer ID or the multihash of a CID.
+// All queries are recorded in the output.
+// If DryRun is set, no peer is dialed, and ErrDryRun is returned.
+// This can be used independently of CrawlNetwork.
+func (cm *CrawlManager) LookupKey(ctx context.Context, key []byte, bootstraps []peer.AddrInfo) (*LookupOutput, error) {
+	if len(bootstraps) == 0 {
+		bootstraps = cm.bootstrapPeers
+	}
+	if cm.config.DryRun {
+		return nil, cm.dryRunOperation("lookup", bootstraps)
+	}
+	if len(cm.workers) < 1 {
+		return nil, ErrNoWorkers
+	}
+
+	target := kb.ConvertKey(string(key))
+	out := &LookupOutput{
+		Key:            key,
+		BeginTimestamp: time.Now(),
+	}
+
+	candidates := make(map[peer.ID]peer.AddrInfo)
+	for _, p := range bootstraps {
+		candidates[p.ID] = p
+	}
+	queried := make(map[peer.ID]struct{})
+	responded := make(map[peer.ID]struct{})
+
+	for ; out.Rounds < maxLookupRounds; out.Rounds++ {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Ask the closest peers we have not asked yet, among the closest
+		// peers we know which have not failed to respond.
+		closest := closestPeers(candidates, target)
+		var ids []peer.ID
+		for _, id := range closest {
+			if _, ok := queried[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			out.Converged = true
+			break
+		}
+		if len(ids) > lookupConcurrency {
+			ids = ids[:lookupConcurrency]
+		}
+
+		results := make([]LookupQuery, len(ids))
+		closer := make([][]peer.AddrInfo, len(ids))
+		errs := make([]error, len(ids))
+		var wg sync.WaitGroup
+		for i, id := range ids {
+			queried[id] = struct{}{}
+			wg.Add(1)
+			go func(i int, p peer.AddrInfo) {
+				defer wg.Done()
+				worker := cm.workers[rand.Intn(len(cm.workers))]
+				q := LookupQuery{
+					Round:          out.Rounds,
+					Peer:           p.ID,
+					CPL:            kb.CommonPrefixLen(kb.ConvertPeerID(p.ID), target),
+					BeginTimestamp: time.Now(),
+				}
+				closer[i], errs[i] = worker.findNode(ctx, p, key)
+				q.EndTimestamp = time.Now()
+				q.Error = errToString(errs[i])
+				results[i] = q
+			}(i, candidates[id])
+		}
+		wg.Wait()
+
+		for i, q := range results {
+			if errs[i] != nil {
+				log.WithError(errs[i]).WithField("peer", q.Peer).Debug("unable to find closer peers")
+				delete(candidates, q.Peer)
+				out.Queries = append(out.Queries, q)
+				continue
+			}
+			responded[q.Peer] = struct{}{}
+			for _, p := range closer[i] {
+				q.Closer = append(q.Closer, p.ID)
+				if _, ok := queried[p.ID]; ok {
+					continue
+				}
+				// Like addresses learned while crawling, see
+				// toCrawlQueue.push, addresses are canonicalized and
+				// filtered.
+				prev, known := candidates[p.ID]
+				addrs := filterOutOldAddresses(prev.Addrs, cm.toCrawl.filter.filter(canonicalAddrs(p.ID, p.Addrs)))
+				if !known && len(p.Addrs) != 0 && len(addrs) == 0 {
+					// Only private or loopback addresses, not worth
+					// dialing.
+					continue
+				}
+				candidates[p.ID] = peer.AddrInfo{ID: p.ID, Addrs: append(prev.Addrs, addrs...)}
+			}
+			out.Queries = append(out.Queries, q)
+		}
+		log.WithFields(log.Fields{
+			"round":     out.Rounds,
+			"responded": len(responded),
+			"known":     len(candidates),
+		}).Debug("lookup round finished")
+	}
+	out.EndTimestamp = time.Now()
+
+	if len(responded) == 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("no peer responded")
+	}
+
+	respondedInfos := make(map[peer.ID]peer.AddrInfo, len(responded))
+	for id := range responded {
+		respondedInfos[id] = candidates[id]
+	}
+	for _, id := range closestPeers(respondedInfos, target) {
+		out.Closest = append(out.Closest, respondedInfos[id])
+	}
+
+	return out, ctx.Err()
+}
+
+// closestPeers returns the IDs of the lookupResultSize peers closest to the
+// target, closest first.
+func closestPeers(peers map[peer.ID]peer.AddrInfo, target kb.ID) []peer.ID {
+	ids := make([]peer.ID, 0, len(peers))
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	ids = kb.SortClosestPeers(ids, target)
+	if len(ids) > lookupResultSize {
+		ids = ids[:lookupResultSize]
+	}
+	return ids
+}
@@ -0,0 +1,51 @@
+package crawling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestLookupKeyFiltersAddrs(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	addrB := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{
+				addrB,
+				ma.StringCast("/ip4/1.2.3.5/tcp/4001/p2p/" + b.String()),
+				ma.StringCast("/ip6/::ffff:1.2.3.5/tcp/4001"),
+				ma.StringCast("/ip4/10.0.0.1/tcp/4001"),
+			}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/10.0.0.2/tcp/4001"), ma.StringCast("/ip4/127.0.0.1/tcp/4001")}},
+		}},
+		b: {},
+		c: {},
+	}, a)
+
+	out, err := cm.LookupKey(context.Background(), []byte(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Requests(c) != 0 {
+		t.Errorf("expected %s with only local addresses not to be asked", c)
+	}
+	if w.Requests(b) != 1 {
+		t.Fatalf("expected %s to be asked once, got %d", b, w.Requests(b))
+	}
+	for _, p := range out.Closest {
+		if p.ID != b {
+			continue
+		}
+		if len(p.Addrs) != 1 || !p.Addrs[0].Equal(addrB) {
+			t.Errorf("expected only the canonical address %s of %s, got %v", addrB, b, p.Addrs)
+		}
+		return
+	}
+	t.Errorf("expected %s among the closest peers, got %v", b, out.Closest)
+}
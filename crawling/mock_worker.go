@@ -0,0 +1,316 @@
+package crawling
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// A MockResponse is the programmed response of a MockWorker to crawling a
+// peer.
+type MockResponse struct {
+	// If set, connecting to the peer fails with this error, and all other
+	// fields are ignored.
+	Err error
+	// If set, only the first FailFirst requests to the peer fail, with Err
+	// or a generic error.
+	FailFirst int
+
+	AgentVersion       string
+	SupportedProtocols []protocol.ID
+
+	// If set, crawling the peer's routing table fails with this error, and
+	// Neighbors is ignored.
+	CrawlErr error
+	// The peers in the peer's routing table.
+	Neighbors []peer.AddrInfo
+
+	// The providers returned for any key, if asked for providers.
+	// Neighbors are returned as closer peers.
+	// When asked for the peers closest to a key, the lookupResultSize
+	// Neighbors closest to it are returned.
+	Providers []peer.AddrInfo
+}
+
+// A MockWorker answers requests with programmed responses, without any
+// networking.
+// This makes it possible to test crawls deterministically, in this package
+// and elsewhere, see NewCrawlManagerWithMockWorkers.
+type MockWorker struct {
+	peerID    peer.ID
+	responses map[peer.ID]MockResponse
+	latency   time.Duration
+	capacity  uint
+
+	m           sync.Mutex
+	requests    map[peer.ID]int
+	crawlAddrs  map[peer.ID][][]ma.Multiaddr
+	queries     uint64
+	inFlight    int
+	maxInFlight int
+}
+
+var _ worker = (*MockWorker)(nil)
+
+// NewMockWorker creates a new MockWorker with a random peer ID.
+// Crawling a peer takes the given latency and returns the programmed response
+// for that peer. Connecting to peers without a response fails.
+// The responses must not be modified afterwards.
+func NewMockWorker(responses map[peer.ID]MockResponse, latency time.Duration) (*MockWorker, error) {
+	_, pub, err := crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate key: %w", err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive peer ID: %w", err)
+	}
+
+	return &MockWorker{
+		peerID:     id,
+		responses:  responses,
+		latency:    latency,
+		requests:   make(map[peer.ID]int),
+		crawlAddrs: make(map[peer.ID][][]ma.Multiaddr),
+	}, nil
+}
+
+// SetCapacity sets the number of concurrent requests the worker is given by
+// NewCrawlManagerWithMockWorkers, see CrawlManagerConfig.WorkerWeights.
+// This must be called before NewCrawlManagerWithMockWorkers.
+func (w *MockWorker) SetCapacity(n uint) {
+	w.capacity = n
+}
+
+// MaxInFlight returns the maximum number of concurrent requests the worker
+// made.
+func (w *MockWorker) MaxInFlight() int {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.maxInFlight
+}
+
+// Requests returns the number of requests the worker made to the given peer,
+// i.e., crawls and provider lookups.
+func (w *MockWorker) Requests(id peer.ID) int {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.requests[id]
+}
+
+// CrawlAddrs returns the addresses the worker was given for each crawl of the
+// given peer, in order.
+func (w *MockWorker) CrawlAddrs(id peer.ID) [][]ma.Multiaddr {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.crawlAddrs[id]
+}
+
+// request records a request to the given peer, waits for the configured
+// latency, and returns the programmed response.
+func (w *MockWorker) request(ctx context.Context, id peer.ID) (MockResponse, error) {
+	w.m.Lock()
+	w.requests[id]++
+	n := w.requests[id]
+	w.inFlight++
+	if w.inFlight > w.maxInFlight {
+		w.maxInFlight = w.inFlight
+	}
+	w.m.Unlock()
+	defer func() {
+		w.m.Lock()
+		w.inFlight--
+		w.m.Unlock()
+	}()
+
+	t := time.NewTimer(w.latency)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return MockResponse{}, ctx.Err()
+	}
+
+	res, ok := w.responses[id]
+	if !ok {
+		return MockResponse{}, fmt.Errorf("dial: no response programmed for %s", id)
+	}
+	if res.FailFirst != 0 && n <= res.FailFirst {
+		if res.Err != nil {
+			return MockResponse{}, res.Err
+		}
+		return MockResponse{}, fmt.Errorf("dial: attempt %d of %d programmed to fail", n, res.FailFirst)
+	}
+	if res.FailFirst == 0 && res.Err != nil {
+		return MockResponse{}, res.Err
+	}
+
+	w.m.Lock()
+	w.queries++
+	w.m.Unlock()
+
+	return res, nil
+}
+
+// crawlPeer implements worker.
+func (w *MockWorker) crawlPeer(ctx context.Context, remote peer.AddrInfo) (*rawNodeInformation, error) {
+	w.m.Lock()
+	w.crawlAddrs[remote.ID] = append(w.crawlAddrs[remote.ID], remote.Addrs)
+	w.m.Unlock()
+
+	begin := time.Now()
+	res, err := w.request(ctx, remote.ID)
+	if err != nil {
+		return nil, err
+	}
+	end := time.Now()
+
+	info := &rawNodeInformation{
+		info: peerMetadata{
+			AgentVersion:       res.AgentVersion,
+			SupportedProtocols: res.SupportedProtocols,
+		},
+		crawlData: crawlResult{
+			beginTimestamp: begin,
+			endTimestamp:   end,
+			err:            res.CrawlErr,
+		},
+		pluginResults: make(map[string]pluginResult),
+	}
+	if res.CrawlErr == nil {
+		info.crawlData.result = &crawlData{
+			neighbors:              res.Neighbors,
+			crawlStartedTimestamp:  begin,
+			crawlFinishedTimestamp: end,
+		}
+	}
+
+	return info, nil
+}
+
+// findProviders implements worker.
+func (w *MockWorker) findProviders(ctx context.Context, remote peer.AddrInfo, _ []byte) ([]peer.AddrInfo, []peer.AddrInfo, error) {
+	res, err := w.request(ctx, remote.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.CrawlErr != nil {
+		return nil, nil, res.CrawlErr
+	}
+
+	return res.Providers, res.Neighbors, nil
+}
+
+// findNode implements worker.
+func (w *MockWorker) findNode(ctx context.Context, remote peer.AddrInfo, key []byte) ([]peer.AddrInfo, error) {
+	res, err := w.request(ctx, remote.ID)
+	if err != nil {
+		return nil, err
+	}
+	if res.CrawlErr != nil {
+		return nil, res.CrawlErr
+	}
+
+	byID := make(map[peer.ID]peer.AddrInfo, len(res.Neighbors))
+	ids := make([]peer.ID, 0, len(res.Neighbors))
+	for _, p := range res.Neighbors {
+		byID[p.ID] = p
+		ids = append(ids, p.ID)
+	}
+	ids = kb.SortClosestPeers(ids, kb.ConvertKey(string(key)))
+	if len(ids) > lookupResultSize {
+		ids = ids[:lookupResultSize]
+	}
+	closer := make([]peer.AddrInfo, 0, len(ids))
+	for _, id := range ids {
+		closer = append(closer, byID[id])
+	}
+
+	return closer, nil
+}
+
+// stop implements worker.
+func (w *MockWorker) stop() error {
+	return nil
+}
+
+// id implements worker.
+func (w *MockWorker) id() peer.ID {
+	return w.peerID
+}
+
+// usage implements worker.
+// No bytes are transferred, each successful request counts as one query.
+func (w *MockWorker) usage() (uint64, uint64) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return 0, w.queries
+}
+
+// NewCrawlManagerWithMockWorkers creates a new CrawlManager which uses the
+// given MockWorkers instead of libp2p workers.
+// NumWorkers is set to the number of workers, settings for creating libp2p
+// workers are ignored.
+// If all workers have a capacity set, see MockWorker.SetCapacity,
+// ConcurrentRequests and WorkerWeights are set so that each worker is given
+// exactly that many concurrent requests.
+// For example, to test a crawl of two peers:
+//
+//	w, _ := NewMockWorker(map[peer.ID]MockResponse{
+//		a: {Neighbors: []peer.AddrInfo{{ID: b}}},
+//		b: {Err: errors.New("unreachable")},
+//	}, 0)
+//	cm, _ := NewCrawlManagerWithMockWorkers(CrawlManagerConfig{
+//		ConcurrentRequests: 1,
+//		BootstrapPeers:     []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + a.String()},
+//	}, w)
+//	report, err := cm.CrawlNetwork(context.Background())
+func NewCrawlManagerWithMockWorkers(config CrawlManagerConfig, workers ...*MockWorker) (*CrawlManager, error) {
+	config.NumWorkers = uint(len(workers))
+	var capacities []uint
+	var total uint
+	for _, w := range workers {
+		if w.capacity == 0 {
+			capacities = nil
+			break
+		}
+		capacities = append(capacities, w.capacity)
+		total += w.capacity
+	}
+	if capacities != nil {
+		config.ConcurrentRequests = total
+		config.WorkerWeights = capacities
+	}
+	err := config.checkManager()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	cm, err := newCrawlManager(config, func(*EventManager) ([]worker, error) {
+		ws := make([]worker, len(workers))
+		for i, w := range workers {
+			ws[i] = w
+		}
+		return ws, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cm.newSingleWorker = func() (worker, error) {
+		return workers[0], nil
+	}
+
+	return cm, nil
+}
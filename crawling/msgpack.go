@@ -0,0 +1,209 @@
+package crawling
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/ugorji/go/codec"
+)
+
+// msgpackNode is the msgpack form of a CrawledNode.
+// Multiaddresses are interfaces, which cannot be decoded directly, so we
+// carry them in their binary form instead. All other fields are encoded as
+// they are, using the field names of the JSON form.
+type msgpackNode struct {
+	CrawledNode
+	MultiAddrs     [][]byte         `json:"multiaddrs"`
+	CertifiedAddrs [][]byte         `json:"certified_multiaddrs,omitempty"`
+	Result         *msgpackNodeData `json:"result"`
+}
+
+// msgpackNodeData is the msgpack form of a CrawledNodeData.
+type msgpackNodeData struct {
+	CrawledNodeData
+	ConnectedVia []byte `json:"connected_via,omitempty"`
+}
+
+// newMsgpackHandle creates the msgpack handle used to encode and decode
+// CrawledNodes.
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+func toMsgpackNode(node CrawledNode) msgpackNode {
+	m := msgpackNode{CrawledNode: node}
+	for _, addr := range node.MultiAddrs {
+		m.MultiAddrs = append(m.MultiAddrs, addr.Bytes())
+	}
+	m.CrawledNode.MultiAddrs = nil
+	for _, addr := range node.CertifiedAddrs {
+		m.CertifiedAddrs = append(m.CertifiedAddrs, addr.Bytes())
+	}
+	m.CrawledNode.CertifiedAddrs = nil
+
+	if node.Result != nil {
+		m.Result = &msgpackNodeData{CrawledNodeData: *node.Result}
+		if node.Result.ConnectedVia != nil {
+			m.Result.ConnectedVia = node.Result.ConnectedVia.Bytes()
+		}
+		m.Result.CrawledNodeData.ConnectedVia = nil
+	}
+	m.CrawledNode.Result = nil
+
+	return m
+}
+
+func (m msgpackNode) toCrawledNode() (*CrawledNode, error) {
+	node := m.CrawledNode
+	for _, b := range m.MultiAddrs {
+		addr, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address of peer %s: %w", node.ID, err)
+		}
+		node.MultiAddrs = append(node.MultiAddrs, addr)
+	}
+	for _, b := range m.CertifiedAddrs {
+		addr, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certified address of peer %s: %w", node.ID, err)
+		}
+		node.CertifiedAddrs = append(node.CertifiedAddrs, addr)
+	}
+
+	if m.Result != nil {
+		data := m.Result.CrawledNodeData
+		if m.Result.ConnectedVia != nil {
+			addr, err := ma.NewMultiaddrBytes(m.Result.ConnectedVia)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address of peer %s: %w", node.ID, err)
+			}
+			data.ConnectedVia = addr
+		}
+		node.Result = &data
+	}
+
+	return &node, nil
+}
+
+// WriteMsgpackStream writes the result of probing each node to w as a stream
+// of msgpack records, one CrawledNode each.
+// Like WriteJSONLines, this omits the meta information written by
+// WriteMetadata and never holds the serialized form of all nodes in memory at
+// once, but is more compact and faster to parse.
+// Records use the field names of the JSON form, but peer IDs and
+// multiaddresses are encoded in their binary form.
+// Use ReadMsgpackStream to read the stream.
+func (report *CrawlOutput) WriteMsgpackStream(w io.Writer) error {
+	return report.writeMsgpackStream(w, false)
+}
+
+// writeMsgpackStream implements WriteMsgpackStream, optionally omitting nodes
+// we were unable to connect to.
+func (report *CrawlOutput) writeMsgpackStream(w io.Writer, reachableOnly bool) error {
+	bw := bufio.NewWriter(w)
+	enc := codec.NewEncoder(bw, newMsgpackHandle())
+	for id, node := range report.nodes {
+		if reachableOnly && node.err != nil {
+			continue
+		}
+		err := enc.Encode(toMsgpackNode(node.toCrawledNode(report.addrInfo, report.firstSeen, id, report.maxAddrs)))
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+
+	err := bw.Flush()
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	return nil
+}
+
+// ReadMsgpackStream reads a stream of nodes as written by WriteMsgpackStream.
+// The first record is decoded before returning, so that an error is returned
+// if r does not contain such a stream. The remaining records are decoded in
+// the background and sent on the first returned channel, which is closed at
+// the end of the stream. A decoding error after the first record ends the
+// stream early and is sent on the second returned channel, which is closed
+// after the first one. A truncated stream is reported as
+// io.ErrUnexpectedEOF.
+// The caller must drain the first channel before receiving from the second.
+func ReadMsgpackStream(r io.Reader) (<-chan *CrawledNode, <-chan error, error) {
+	br := bufio.NewReader(r)
+	dec := codec.NewDecoder(br, newMsgpackHandle())
+
+	next := func() (*CrawledNode, error) {
+		// The decoder reports the end of its input as io.EOF even within a
+		// record, so we check for the end of the stream between records.
+		_, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		var m msgpackNode
+		err = dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return m.toCrawledNode()
+	}
+
+	first, err := next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("unable to decode node: %w", err)
+	}
+
+	nodes := make(chan *CrawledNode)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(nodes)
+		node := first
+		for node != nil {
+			nodes <- node
+			var err error
+			node, err = next()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					errs <- fmt.Errorf("unable to decode node: %w", err)
+				}
+				return
+			}
+		}
+	}()
+
+	return nodes, errs, nil
+}
+
+// loadPriorCrawlMsgpack implements loadPriorCrawl for streams written by
+// WriteMsgpackStream.
+func loadPriorCrawlMsgpack(r io.Reader) ([]peer.AddrInfo, []bool, error) {
+	nodes, errs, err := ReadMsgpackStream(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to decode previous crawl: %w", err)
+	}
+
+	var peers []peer.AddrInfo
+	var connectable []bool
+	for node := range nodes {
+		peers = append(peers, peer.AddrInfo{ID: node.ID, Addrs: node.MultiAddrs})
+		connectable = append(connectable, node.ConnectionError == nil)
+	}
+	err = <-errs
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to decode previous crawl: %w", err)
+	}
+
+	return peers, connectable, nil
+}
@@ -0,0 +1,162 @@
+package crawling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMsgpackStreamRoundTrip(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}},
+			{ID: c, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.6/tcp/4001")}},
+		}},
+		b: {Neighbors: []peer.AddrInfo{{ID: c}}},
+		c: {Err: errors.New("unreachable")},
+	}, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = out.WriteMsgpackStream(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, errs, err := ReadMsgpackStream(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare the JSON forms, which cover all fields.
+	written := make(map[peer.ID]string)
+	for node := range nodes {
+		j, err := json.Marshal(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written[node.ID] = string(j)
+	}
+	err = <-errs
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(out.nodes) {
+		t.Fatalf("expected %d nodes, got %d", len(out.nodes), len(written))
+	}
+	for id, status := range out.nodes {
+		j, err := json.Marshal(status.toCrawledNode(out.addrInfo, out.firstSeen, id, out.maxAddrs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written[id] != string(j) {
+			t.Errorf("expected node %s, got %s", j, written[id])
+		}
+	}
+}
+
+func TestMsgpackStreamTruncated(t *testing.T) {
+	out := benchmarkOutput(t, 3)
+	var buf bytes.Buffer
+	err := out.WriteMsgpackStream(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := buf.Bytes()[:buf.Len()-10]
+
+	nodes, errs, err := ReadMsgpackStream(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range nodes {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("expected 2 complete nodes, got %d", n)
+	}
+	err = <-errs
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected truncation to be reported, got %v", err)
+	}
+
+	// Resuming from a truncated crawl must fail rather than resume from a
+	// partial set of peers.
+	_, _, err = loadPriorCrawlMsgpack(bytes.NewReader(truncated))
+	if err == nil {
+		t.Error("expected loading a truncated crawl to fail")
+	}
+}
+
+// benchmarkOutput creates the results of a crawl of n nodes, every other of
+// which was reachable.
+func benchmarkOutput(t testing.TB, n int) *CrawlOutput {
+	t.Helper()
+
+	out := &CrawlOutput{
+		nodes:     make(map[peer.ID]nodeCrawlStatus),
+		addrInfo:  make(map[peer.ID][]ma.Multiaddr),
+		firstSeen: make(map[peer.ID]time.Time),
+	}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		id, _ := newTestPeer(t)
+		out.addrInfo[id] = []ma.Multiaddr{
+			ma.StringCast(fmt.Sprintf("/ip4/1.2.%d.%d/tcp/4001", i/256%256, i%256)),
+			ma.StringCast(fmt.Sprintf("/ip4/1.2.%d.%d/udp/4001/quic-v1", i/256%256, i%256)),
+		}
+		out.firstSeen[id] = now
+		status := nodeCrawlStatus{startTs: now, endTs: now, attempts: 1}
+		if i%2 == 0 {
+			status.lastCrawled = now
+			status.result = &nodeInformation{info: peerMetadata{
+				AgentVersion:       "kubo/0.20.0",
+				SupportedProtocols: []protocol.ID{"/ipfs/kad/1.0.0", "/ipfs/id/1.0.0", "/ipfs/ping/1.0.0"},
+				ConnectedAddr:      out.addrInfo[id][0],
+			}}
+		} else {
+			status.err = errors.New("unreachable")
+		}
+		out.nodes[id] = status
+	}
+
+	return out
+}
+
+func BenchmarkWriteNodes(b *testing.B) {
+	out := benchmarkOutput(b, 1000)
+	for _, format := range []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"json_lines", out.WriteJSONLines},
+		{"msgpack", out.WriteMsgpackStream},
+	} {
+		format := format
+		b.Run(format.name, func(b *testing.B) {
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				err := format.write(&buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len())/float64(len(out.nodes)), "B/node")
+		})
+	}
+}
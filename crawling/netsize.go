@@ -0,0 +1,80 @@
+package crawling
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// netsizeNumClosest is the number of closest neighbors of each node used to
+// estimate the size of the network.
+const netsizeNumClosest = 20
+
+// EstimateNetworkSize estimates the size of the network from the routing
+// tables of crawlable nodes.
+// This follows the network size estimator of go-libp2p-kad-dht: In a network
+// of N peers with uniformly distributed IDs, the i-th closest peer to any key
+// is expected at a normalized XOR distance of i/(N+1).
+// For every crawlable node, we fit this model to the distances of its closest
+// neighbors, which gives one estimate per node.
+// The result is the mean over these estimates.
+func EstimateNetworkSize(report *CrawlOutput) (int, error) {
+	estimate, _, err := estimateNetworkSize(report)
+	return estimate, err
+}
+
+// estimateNetworkSize implements EstimateNetworkSize, additionally returning
+// the number of nodes the estimate is based on.
+func estimateNetworkSize(report *CrawlOutput) (int, int, error) {
+	var sum float64
+	samples := 0
+
+	for id, node := range report.nodes {
+		if node.err != nil || node.result.crawlDataError != nil {
+			continue
+		}
+		if len(node.result.crawlNeighbors) < netsizeNumClosest {
+			continue
+		}
+
+		self := kb.ConvertPeerID(id)
+		distances := make([]float64, 0, len(node.result.crawlNeighbors))
+		for _, n := range node.result.crawlNeighbors {
+			distances = append(distances, normalizedDistance(self, kb.ConvertPeerID(n)))
+		}
+		sort.Float64s(distances)
+
+		// Least-squares fit of d_i = i/(N+1) through the origin.
+		var sumSquares, sumProducts float64
+		for i := 0; i < netsizeNumClosest; i++ {
+			x := float64(i + 1)
+			sumSquares += x * x
+			sumProducts += x * distances[i]
+		}
+		if sumProducts == 0 {
+			continue
+		}
+
+		sum += sumSquares/sumProducts - 1
+		samples++
+	}
+
+	if samples == 0 {
+		return 0, 0, fmt.Errorf("no crawlable nodes with at least %d neighbors", netsizeNumClosest)
+	}
+
+	return int(math.Round(sum / float64(samples))), samples, nil
+}
+
+// normalizedDistance computes the XOR distance between two keys, normalized to
+// [0,1).
+// Only the first 64 bits of the keys are considered, which is plenty.
+func normalizedDistance(a, b kb.ID) float64 {
+	var d uint64
+	for i := 0; i < 8; i++ {
+		d = d<<8 | uint64(a[i]^b[i])
+	}
+	return float64(d) / math.Pow(2, 64)
+}
@@ -0,0 +1,129 @@
+package crawling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// NetworkConfig configures a named network, see
+// CrawlManagerConfig.WithNetwork.
+type NetworkConfig struct {
+	// The protocols to use for crawling.
+	// Defaults to CrawlerConfig.ProtocolStrings.
+	ProtocolStrings []protocol.ID `yaml:"protocol_strings"`
+
+	// The bootstrap peers of the network, which replace
+	// CrawlManagerConfig.BootstrapPeers and
+	// CrawlManagerConfig.BootstrapPeersFile.
+	BootstrapPeers     []string `yaml:"bootstrap_peers"`
+	BootstrapPeersFile string   `yaml:"bootstrap_peers_file"`
+	// Whether to also use the default IPFS bootstrap peers, which replaces
+	// CrawlManagerConfig.UseDefaultBootstrapPeers.
+	// At least one of BootstrapPeers, BootstrapPeersFile, and this must be
+	// set, unless CrawlManagerConfig.DisableExpansion is set.
+	UseDefaultBootstrapPeers bool `yaml:"use_default_bootstrap_peers"`
+
+	// Whether to keep private and loopback addresses of peers, e.g., for
+	// networks on a private overlay.
+	// Defaults to CrawlManagerConfig.KeepLocalAddrs.
+	KeepLocalAddrs *bool `yaml:"keep_local_addrs"`
+
+	// Path to the swarm key of the network, if it is private.
+	// This replaces WorkerConfig.SwarmKeyPath.
+	SwarmKeyPath *string `yaml:"swarm_key_path"`
+}
+
+// WithNetwork returns a copy of the config with the settings of the given
+// network from Networks applied.
+// The name of the network is recorded as Network, and output with the results
+// of crawls.
+func (c CrawlManagerConfig) WithNetwork(name string) (CrawlManagerConfig, error) {
+	network, ok := c.Networks[name]
+	if !ok {
+		return c, fmt.Errorf("unknown network: %s", name)
+	}
+
+	c.Network = name
+	if len(network.ProtocolStrings) != 0 {
+		c.CrawlerConfig.ProtocolStrings = network.ProtocolStrings
+	}
+	c.BootstrapPeers = network.BootstrapPeers
+	c.BootstrapPeersFile = network.BootstrapPeersFile
+	c.UseDefaultBootstrapPeers = network.UseDefaultBootstrapPeers
+	if network.KeepLocalAddrs != nil {
+		c.KeepLocalAddrs = *network.KeepLocalAddrs
+	}
+	c.WorkerConfig.SwarmKeyPath = network.SwarmKeyPath
+
+	return c, nil
+}
+
+// labelNetwork passes Network on to the worker and crawler configs, which label
+// their metrics with it.
+func (c *CrawlManagerConfig) labelNetwork() {
+	c.WorkerConfig.network = c.Network
+	c.CrawlerConfig.network = c.Network
+}
+
+// CrawlNetworks crawls multiple networks concurrently, one per CrawlManager,
+// and returns the results of each crawl by network.
+// Each manager must crawl a different network, see
+// CrawlManagerConfig.WithNetwork, and should have its own output sinks, to
+// which its results are written as usual. The name of each network is
+// recorded in its results and labels its metrics.
+// The managers are stopped once all crawls have finished, or if they are
+// misconfigured.
+// Errors of the crawls are joined, see CrawlManager.CrawlNetwork. The results
+// of all networks are returned regardless.
+func CrawlNetworks(ctx context.Context, managers ...*CrawlManager) (map[string]CrawlOutput, error) {
+	seen := make(map[string]struct{}, len(managers))
+	for _, cm := range managers {
+		var err error
+		if len(cm.config.Network) == 0 {
+			err = fmt.Errorf("crawl manager without network")
+		} else if _, ok := seen[cm.config.Network]; ok {
+			err = fmt.Errorf("multiple crawl managers for network %s", cm.config.Network)
+		}
+		if err != nil {
+			for _, cm := range managers {
+				_ = cm.Stop()
+			}
+			return nil, err
+		}
+		seen[cm.config.Network] = struct{}{}
+	}
+
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	reports := make(map[string]CrawlOutput, len(managers))
+	var errs []error
+	for _, cm := range managers {
+		wg.Add(1)
+		go func(cm *CrawlManager) {
+			defer wg.Done()
+
+			network := cm.config.Network
+			log.WithField("network", network).Info("crawling network")
+			report, err := cm.CrawlNetwork(ctx)
+			stopErr := cm.Stop()
+			if stopErr != nil {
+				log.WithError(stopErr).WithField("network", network).Warn("unable to gracefully shut down")
+			}
+
+			m.Lock()
+			defer m.Unlock()
+			reports[network] = report
+			if err != nil {
+				errs = append(errs, fmt.Errorf("network %s: %w", network, err))
+			}
+		}(cm)
+	}
+	wg.Wait()
+
+	return reports, errors.Join(errs...)
+}
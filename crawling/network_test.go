@@ -0,0 +1,56 @@
+package crawling
+
+import "testing"
+
+func TestWithNetwork(t *testing.T) {
+	keep := true
+	config := CrawlManagerConfig{
+		NumWorkers:               1,
+		ConcurrentRequests:       1,
+		UseDefaultBootstrapPeers: true,
+		Networks: map[string]NetworkConfig{
+			"empty": {},
+			"internal": {
+				BootstrapPeers: []string{"/ip4/10.0.0.1/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+				KeepLocalAddrs: &keep,
+			},
+			"ipfs": {UseDefaultBootstrapPeers: true},
+		},
+	}
+
+	// The global default bootstrap peers do not apply to named networks.
+	c, err := config.WithNetwork("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkManager(); err == nil {
+		t.Error("expected network without bootstrap peers to be rejected")
+	}
+
+	c, err = config.WithNetwork("internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkManager(); err != nil {
+		t.Error(err)
+	}
+	if c.Network != "internal" || c.UseDefaultBootstrapPeers || !c.KeepLocalAddrs {
+		t.Errorf("network settings not applied: %+v", c)
+	}
+
+	c, err = config.WithNetwork("ipfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkManager(); err != nil {
+		t.Error(err)
+	}
+	if !c.UseDefaultBootstrapPeers || c.KeepLocalAddrs {
+		t.Errorf("network settings not applied: %+v", c)
+	}
+
+	_, err = config.WithNetwork("unknown")
+	if err == nil {
+		t.Error("expected unknown network to be rejected")
+	}
+}
@@ -0,0 +1,196 @@
+package crawling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// A Scheduler crawls the network repeatedly, at the configured CrawlInterval.
+// Each crawl uses a new CrawlManager, which is seeded with the peers reachable
+// in the previous crawl.
+// The results of each crawl are written to the Scheduler's output sinks.
+// Crawls never overlap: if a crawl is still running when the next one is due,
+// that crawl is skipped.
+type Scheduler struct {
+	config     CrawlManagerConfig
+	sinks      []OutputSink
+	newManager func(CrawlManagerConfig) (*CrawlManager, error)
+
+	m       sync.Mutex
+	seeds   []peer.AddrInfo
+	running bool
+	stopped bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+	crawls  sync.WaitGroup
+}
+
+// NewScheduler creates a new Scheduler, which creates crawl managers using
+// the given config and writes the results of each crawl to the given sinks.
+// The sinks are closed by Stop.
+func NewScheduler(config CrawlManagerConfig, sinks ...OutputSink) (*Scheduler, error) {
+	if config.CrawlInterval <= time.Duration(0) {
+		return nil, fmt.Errorf("missing or invalid crawl_interval")
+	}
+
+	return &Scheduler{
+		config:     config,
+		sinks:      sinks,
+		newManager: NewCrawlManager,
+	}, nil
+}
+
+// AddPeersToCrawl adds peers to crawl during the first crawl, in addition to
+// the bootstrap peers, e.g., from a node cache.
+// This must be called before Start.
+func (s *Scheduler) AddPeersToCrawl(peers []peer.AddrInfo) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.seeds = append(s.seeds, peers...)
+}
+
+// Start starts crawling, immediately and then once per CrawlInterval, until
+// the context is cancelled or Stop is called.
+// It does not block.
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.stopped {
+		return errors.New("scheduler stopped")
+	}
+	if s.cancel != nil {
+		return errors.New("scheduler already started")
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.loop(ctx)
+
+	return nil
+}
+
+// Stop stops crawling and waits for a running crawl to finish.
+// The partial results of a running crawl are written to the output sinks,
+// then the sinks are closed.
+func (s *Scheduler) Stop() error {
+	s.m.Lock()
+	if s.stopped {
+		s.m.Unlock()
+		return nil
+	}
+	s.stopped = true
+	cancel, done := s.cancel, s.done
+	s.m.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	for _, sink := range s.sinks {
+		err := sink.Close()
+		if err != nil {
+			log.WithError(err).Warn("unable to close output sink")
+		}
+	}
+
+	return nil
+}
+
+// loop starts a crawl at every tick, until the context is cancelled.
+func (s *Scheduler) loop(ctx context.Context) {
+	defer close(s.done)
+	defer s.crawls.Wait()
+
+	ticker := time.NewTicker(s.config.CrawlInterval)
+	defer ticker.Stop()
+
+	s.tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.tick(ctx)
+		}
+	}
+}
+
+// tick starts a crawl, unless one is already running.
+func (s *Scheduler) tick(ctx context.Context) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.running {
+		log.WithField("interval", s.config.CrawlInterval).Warn("previous crawl still running, skipping this one")
+		return
+	}
+	s.running = true
+
+	s.crawls.Add(1)
+	go func() {
+		defer s.crawls.Done()
+		s.crawlOnce(ctx)
+
+		s.m.Lock()
+		s.running = false
+		s.m.Unlock()
+	}()
+}
+
+// crawlOnce performs a single crawl, writes the results to the output sinks,
+// and remembers the reachable peers as seeds for the next crawl.
+func (s *Scheduler) crawlOnce(ctx context.Context) {
+	cm, err := s.newManager(s.config)
+	if err != nil {
+		log.WithError(err).Error("unable to set up crawler")
+		return
+	}
+
+	s.m.Lock()
+	seeds := s.seeds
+	s.m.Unlock()
+	log.WithField("num", len(seeds)).Info("starting scheduled crawl, seeded with previously reachable peers")
+	cm.AddPeersToCrawl(seeds)
+
+	report, err := cm.CrawlNetwork(ctx)
+	stopErr := cm.Stop()
+	if stopErr != nil {
+		log.WithError(stopErr).Warn("unable to gracefully shut down")
+	}
+
+	keepSeeds := false
+	if errors.Is(err, ErrNoReachablePeers) {
+		log.WithError(err).Warn("crawl did not reach any peers")
+		keepSeeds = true
+	} else if errors.Is(err, context.Canceled) || errors.Is(err, ErrCrawlStopped) {
+		log.WithError(err).Warn("crawl interrupted, writing partial results")
+		keepSeeds = true
+	} else if err != nil {
+		log.WithError(err).Error("unable to crawl")
+		return
+	}
+
+	// Don't replace the seeds with nothing or with partial results.
+	if !keepSeeds {
+		s.m.Lock()
+		s.seeds = report.reachablePeers()
+		s.m.Unlock()
+	}
+
+	for _, sink := range s.sinks {
+		err = sink.Write(&report)
+		if err != nil {
+			log.WithError(err).Error("unable to write results to output sink")
+		}
+	}
+	log.WithField("crawl_id", report.CrawlID).Info("finished scheduled crawl")
+}
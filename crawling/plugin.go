@@ -1,6 +1,7 @@
 package crawling
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -55,10 +56,12 @@ type Plugin interface {
 
 	// HandlePeer measures the given peer.
 	// The underlying libp2p node should have an open connection to the peer.
+	// The context is cancelled if the crawl is stopped, in which case the
+	// plugin should return early.
 	// The success value returned must be serializable to JSON and will be
 	// copied verbose into the crawl output.
 	// TODO maybe this only needs peer ID? Or network.Conn?
-	HandlePeer(info peer.AddrInfo) (interface{}, error)
+	HandlePeer(ctx context.Context, info peer.AddrInfo) (interface{}, error)
 
 	// Shutdown ensures clean shutdown of this plugin.
 	Shutdown() error
@@ -81,6 +84,15 @@ func NewPlugin(name string, h host.Host, optionBytes []byte) (Plugin, error) {
 	return d.NewImpl(h, optionBytes)
 }
 
+// pluginRegistered checks whether a plugin with the given name is registered.
+func pluginRegistered(name string) bool {
+	driversM.RLock()
+	defer driversM.RUnlock()
+
+	_, ok := drivers[name]
+	return ok
+}
+
 // PluginConfig is the generic configuration format used for all registered
 // Plugins.
 type PluginConfig struct {
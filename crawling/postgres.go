@@ -0,0 +1,175 @@
+package crawling
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// postgresBatchSize is the number of edges or addresses inserted per
+// statement. Each row uses three parameters, and PostgreSQL allows at most
+// 65535 parameters per statement.
+const postgresBatchSize = 5000
+
+// postgresSchema creates the tables written by PostgresSink.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS nodes (
+		crawl_id TEXT NOT NULL,
+		peer_id TEXT NOT NULL,
+		reachable BOOLEAN NOT NULL,
+		crawlable BOOLEAN NOT NULL,
+		agent_version TEXT,
+		timestamp TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (crawl_id, peer_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS edges (
+		crawl_id TEXT NOT NULL,
+		from_id TEXT NOT NULL,
+		to_id TEXT NOT NULL,
+		PRIMARY KEY (crawl_id, from_id, to_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS addresses (
+		crawl_id TEXT NOT NULL,
+		peer_id TEXT NOT NULL,
+		maddr TEXT NOT NULL,
+		PRIMARY KEY (crawl_id, peer_id, maddr)
+	)`,
+}
+
+// A PostgresSink writes the results of crawls to a PostgreSQL database.
+// Nodes, edges, and addresses are stored per crawl, keyed by
+// CrawlOutput.CrawlID, so one sink can be used for many crawls.
+// Writing the same crawl twice updates the existing nodes and replaces the
+// edges and addresses.
+// We do not depend on a specific PostgreSQL driver, the database handle must
+// be opened by the caller.
+type PostgresSink struct {
+	db *sql.DB
+}
+
+var _ OutputSink = (*PostgresSink)(nil)
+
+// NewPostgresSink creates a new PostgresSink.
+// This creates the tables nodes, edges, and addresses, if they do not exist.
+func NewPostgresSink(db *sql.DB) (*PostgresSink, error) {
+	for _, stmt := range postgresSchema {
+		_, err := db.Exec(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create tables: %w", err)
+		}
+	}
+
+	return &PostgresSink{db: db}, nil
+}
+
+// Write implements OutputSink.
+// Everything is written in a single transaction, so a failed write leaves no
+// partial results.
+func (s *PostgresSink) Write(out *CrawlOutput) error {
+	crawlID := out.CrawlID
+	if len(crawlID) == 0 {
+		return fmt.Errorf("missing crawl ID")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	upsertNode, err := tx.Prepare(`INSERT INTO nodes (crawl_id, peer_id, reachable, crawlable, agent_version, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (crawl_id, peer_id) DO UPDATE SET
+			reachable = EXCLUDED.reachable,
+			crawlable = EXCLUDED.crawlable,
+			agent_version = EXCLUDED.agent_version,
+			timestamp = EXCLUDED.timestamp`)
+	if err != nil {
+		return fmt.Errorf("unable to prepare statement: %w", err)
+	}
+
+	var edges, addrs [][2]string
+	for id, node := range out.nodes {
+		var agentVersion *string
+		crawlable := false
+		if node.err == nil {
+			agentVersion = &node.result.info.AgentVersion
+			crawlable = node.result.crawlDataError == nil
+		}
+		_, err = upsertNode.Exec(crawlID, id.String(), node.err == nil, crawlable, agentVersion, node.endTs)
+		if err != nil {
+			return fmt.Errorf("unable to insert node: %w", err)
+		}
+
+		for _, addr := range out.addrInfo[id] {
+			addrs = append(addrs, [2]string{id.String(), addr.String()})
+		}
+
+		if !crawlable {
+			continue
+		}
+		for _, neighbor := range node.result.crawlNeighbors {
+			edges = append(edges, [2]string{id.String(), neighbor.String()})
+		}
+	}
+
+	// Edges and addresses are replaced, rather than upserted.
+	err = replacePostgresRows(tx, crawlID, "edges", "from_id, to_id", edges)
+	if err != nil {
+		return fmt.Errorf("unable to write edges: %w", err)
+	}
+	err = replacePostgresRows(tx, crawlID, "addresses", "peer_id, maddr", addrs)
+	if err != nil {
+		return fmt.Errorf("unable to write addresses: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Close implements OutputSink.
+// This does not close the database handle, which is owned by the caller.
+func (s *PostgresSink) Close() error {
+	return nil
+}
+
+// replacePostgresRows replaces the rows of the given crawl in the given table,
+// which has a crawl_id column and the two given columns, with multi-row
+// inserts of postgresBatchSize rows each.
+func replacePostgresRows(tx *sql.Tx, crawlID, table, columns string, rows [][2]string) error {
+	_, err := tx.Exec("DELETE FROM "+table+" WHERE crawl_id = $1", crawlID)
+	if err != nil {
+		return fmt.Errorf("unable to delete old rows: %w", err)
+	}
+
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > postgresBatchSize {
+			n = postgresBatchSize
+		}
+
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (crawl_id, %s) VALUES ", table, columns)
+		args := make([]interface{}, 0, 3*n)
+		for i, row := range rows[:n] {
+			if i != 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "($%d, $%d, $%d)", 3*i+1, 3*i+2, 3*i+3)
+			args = append(args, crawlID, row[0], row[1])
+		}
+		query.WriteString(" ON CONFLICT DO NOTHING")
+
+		_, err = tx.Exec(query.String(), args...)
+		if err != nil {
+			return fmt.Errorf("unable to insert rows: %w", err)
+		}
+		rows = rows[n:]
+	}
+
+	return nil
+}
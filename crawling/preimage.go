@@ -2,11 +2,15 @@ package crawling
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/DataDog/zstd"
 	kb "github.com/libp2p/go-libp2p-kbucket"
@@ -122,3 +126,193 @@ func (ph *PreimageHandler) findPreImageForCPL(targetPeer peer.ID, cpl uint8) []b
 
 	return preimage
 }
+
+// preimageCacheMagic identifies files written by PreimageHandler.writeCache,
+// including the version of the format.
+const preimageCacheMagic = "ipfs-crawler preimages v1\n"
+
+// preimageCacheHash is the hash function mapping preimages onto the Kademlia
+// keyspace, as recorded in preimage caches.
+const preimageCacheHash = "sha256"
+
+// NewPreimageHandlerFromFile loads preimages from a cache file written by a
+// previous call.
+// If the file does not exist or was written with different parameters, i.e.,
+// a different MaxCPL or hash function, the preimages are computed, which takes
+// a few minutes, and the cache file is (re-)written.
+func NewPreimageHandlerFromFile(path string) (*PreimageHandler, error) {
+	ph, err := loadPreimageCache(path)
+	if err == nil {
+		return ph, nil
+	}
+	log.WithError(err).WithField("path", path).Info("unable to load preimage cache, computing preimages")
+
+	ph = computePreimagesParallel(runtime.NumCPU())
+	err = ph.writeCache(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write preimage cache: %w", err)
+	}
+
+	return ph, nil
+}
+
+// preimageChunkSize is the number of counters each goroutine hashes at once
+// when computing preimages in parallel.
+const preimageChunkSize = 1 << 20
+
+// computePreimagesParallel computes a preimage for every prefix of length
+// MaxCPL, using the given number of goroutines.
+// Preimages are little-endian encoded counters, the same as computed by the
+// hash-precomputation tool.
+// The counter space is processed in rounds of one chunk per goroutine. Results
+// are merged in order of the chunks after each round, so that every prefix is
+// assigned the smallest counter hashing to it, regardless of which goroutine
+// finishes first.
+func computePreimagesParallel(workers int) *PreimageHandler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ph := &PreimageHandler{}
+	found := make([]bool, len(ph.preimages))
+	remaining := len(ph.preimages)
+
+	type candidate struct {
+		prefix uint32
+		nonce  uint64
+	}
+	results := make([][]candidate, workers)
+	for base := uint64(0); remaining > 0; base += uint64(workers) * preimageChunkSize {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+
+				// found is not modified during a round.
+				var candidates []candidate
+				seen := make(map[uint32]struct{})
+				preimage := make([]byte, 8)
+				start := base + uint64(w)*preimageChunkSize
+				for i := start; i < start+preimageChunkSize; i++ {
+					binary.LittleEndian.PutUint64(preimage, i)
+					hash := sha256.Sum256(preimage)
+					prefix := uint32(hash[0])<<16 |
+						uint32(hash[1])<<8 |
+						uint32(hash[2])<<0
+					prefix >>= 24 - MaxCPL
+
+					if found[prefix] {
+						continue
+					}
+					if _, ok := seen[prefix]; ok {
+						continue
+					}
+					seen[prefix] = struct{}{}
+					candidates = append(candidates, candidate{prefix: prefix, nonce: i})
+				}
+				results[w] = candidates
+			}(w)
+		}
+		wg.Wait()
+
+		for _, candidates := range results {
+			for _, c := range candidates {
+				if found[c.prefix] {
+					continue
+				}
+				found[c.prefix] = true
+				preimage := make([]byte, 8)
+				binary.LittleEndian.PutUint64(preimage, c.nonce)
+				ph.preimages[c.prefix] = binary.BigEndian.Uint64(preimage)
+				remaining--
+			}
+		}
+		log.WithField("remaining", remaining).Debug("computing preimages")
+	}
+
+	return ph
+}
+
+// loadPreimageCache reads preimages from a cache file.
+// The file consists of a header with the parameters used to compute the
+// preimages, followed by all 8-byte preimages in order of their prefix.
+func loadPreimageCache(path string) (*PreimageHandler, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	r := bufio.NewReader(f)
+
+	header := make([]byte, len(preimageCacheMagic))
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+	if string(header) != preimageCacheMagic {
+		return nil, fmt.Errorf("invalid header")
+	}
+	var maxCPL int
+	var hash string
+	_, err = fmt.Fscanf(r, "max_cpl=%d hash=%s\n", &maxCPL, &hash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read parameters: %w", err)
+	}
+	if maxCPL != MaxCPL || hash != preimageCacheHash {
+		return nil, fmt.Errorf("mismatched parameters max_cpl=%d hash=%s", maxCPL, hash)
+	}
+
+	ph := &PreimageHandler{}
+	buf := make([]byte, 8)
+	for i := range ph.preimages {
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read preimage: %w", err)
+		}
+		ph.preimages[i] = binary.BigEndian.Uint64(buf)
+	}
+	_, err = r.ReadByte()
+	if err != io.EOF {
+		return nil, fmt.Errorf("trailing data")
+	}
+
+	return ph, nil
+}
+
+// writeCache writes the preimages to a cache file, see loadPreimageCache.
+// The file is replaced atomically.
+func (ph *PreimageHandler) writeCache(path string) error {
+	tmpPath := path + ".tmp"
+	err := ph.writeCacheFile(tmpPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// writeCacheFile writes the preimages to the given file, see writeCache.
+func (ph *PreimageHandler) writeCacheFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	_, err = fmt.Fprintf(w, "%smax_cpl=%d hash=%s\n", preimageCacheMagic, MaxCPL, preimageCacheHash)
+	buf := make([]byte, 8)
+	for i := 0; err == nil && i < len(ph.preimages); i++ {
+		binary.BigEndian.PutUint64(buf, ph.preimages[i])
+		_, err = w.Write(buf)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,52 @@
+package crawling
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"runtime"
+	"testing"
+)
+
+// computePreimages is the serial equivalent of computePreimagesParallel.
+func computePreimages() *PreimageHandler {
+	ph := &PreimageHandler{}
+	found := make([]bool, len(ph.preimages))
+	remaining := len(ph.preimages)
+
+	preimage := make([]byte, 8)
+	for i := uint64(0); remaining > 0; i++ {
+		binary.LittleEndian.PutUint64(preimage, i)
+		hash := sha256.Sum256(preimage)
+		prefix := uint32(hash[0])<<16 |
+			uint32(hash[1])<<8 |
+			uint32(hash[2])<<0
+		prefix >>= 24 - MaxCPL
+
+		if !found[prefix] {
+			found[prefix] = true
+			ph.preimages[prefix] = binary.BigEndian.Uint64(preimage)
+			remaining--
+		}
+	}
+
+	return ph
+}
+
+func BenchmarkComputePreimages(b *testing.B) {
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			computePreimages()
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		var ph *PreimageHandler
+		for i := 0; i < b.N; i++ {
+			ph = computePreimagesParallel(runtime.NumCPU())
+		}
+
+		b.StopTimer()
+		if *ph != *computePreimages() {
+			b.Fatal("parallel and serial preimages differ")
+		}
+	})
+}
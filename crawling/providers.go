@@ -0,0 +1,146 @@
+package crawling
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxProviderLookupRounds caps the number of rounds of a provider lookup.
+	maxProviderLookupRounds = 20
+	// providerLookupConcurrency is the number of peers queried concurrently
+	// in each round of a provider lookup.
+	providerLookupConcurrency = 3
+)
+
+// providerLookupResult is the response of a single peer to GET_PROVIDERS.
+type providerLookupResult struct {
+	providers []peer.AddrInfo
+	closer    []peer.AddrInfo
+	err       error
+}
+
+// FindProvidersForCID looks up providers of the given content in the DHT.
+// Starting at the given peers, or the configured bootstrap peers if none are
+// given, this iteratively asks the peers closest to the content's key for
+// providers and closer peers, until no closer peers are learned or
+// maxProviderLookupRounds is reached. Each peer is asked at most once.
+// Providers are returned with all addresses learned for them, canonicalized
+// and deduplicated, see canonicalAddrs.
+// If DryRun is set, no peer is dialed, and ErrDryRun is returned.
+// This can be used independently of CrawlNetwork.
+func (cm *CrawlManager) FindProvidersForCID(ctx context.Context, c cid.Cid, bootstraps []peer.AddrInfo) ([]peer.AddrInfo, error) {
+	if len(bootstraps) == 0 {
+		bootstraps = cm.bootstrapPeers
+	}
+	if cm.config.DryRun {
+		return nil, cm.dryRunOperation("provider lookup", bootstraps)
+	}
+	if len(cm.workers) < 1 {
+		return nil, ErrNoWorkers
+	}
+
+	key := []byte(c.Hash())
+	target := kb.ConvertKey(string(key))
+
+	candidates := make(map[peer.ID]peer.AddrInfo)
+	for _, p := range bootstraps {
+		candidates[p.ID] = p
+	}
+	queried := make(map[peer.ID]struct{})
+	providers := make(map[peer.ID]peer.AddrInfo)
+	responded := 0
+
+	for round := 0; round < maxProviderLookupRounds; round++ {
+		if ctx.Err() != nil {
+			return addrInfoValues(providers), ctx.Err()
+		}
+
+		// Ask the closest peers we have not asked yet.
+		var ids []peer.ID
+		for id := range candidates {
+			if _, ok := queried[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+		ids = kb.SortClosestPeers(ids, target)
+		if len(ids) > providerLookupConcurrency {
+			ids = ids[:providerLookupConcurrency]
+		}
+		closest := ids[0]
+
+		results := make([]providerLookupResult, len(ids))
+		var wg sync.WaitGroup
+		for i, id := range ids {
+			queried[id] = struct{}{}
+			wg.Add(1)
+			go func(i int, p peer.AddrInfo) {
+				defer wg.Done()
+				worker := cm.workers[rand.Intn(len(cm.workers))]
+				var res providerLookupResult
+				res.providers, res.closer, res.err = worker.findProviders(ctx, p, key)
+				results[i] = res
+			}(i, candidates[id])
+		}
+		wg.Wait()
+
+		progress := false
+		roundResponded := 0
+		for i, res := range results {
+			if res.err != nil {
+				log.WithError(res.err).WithField("peer", ids[i]).Debug("unable to get providers")
+				continue
+			}
+			roundResponded++
+			for _, p := range res.providers {
+				prev := providers[p.ID]
+				providers[p.ID] = peer.AddrInfo{ID: p.ID, Addrs: canonicalAddrs(p.ID, append(prev.Addrs, p.Addrs...))}
+			}
+			for _, p := range res.closer {
+				if _, ok := candidates[p.ID]; ok {
+					continue
+				}
+				candidates[p.ID] = peer.AddrInfo{ID: p.ID, Addrs: canonicalAddrs(p.ID, p.Addrs)}
+				if kb.Closer(p.ID, closest, string(key)) {
+					progress = true
+				}
+			}
+		}
+		log.WithFields(log.Fields{
+			"round":     round,
+			"providers": len(providers),
+			"known":     len(candidates),
+		}).Debug("provider lookup round finished")
+
+		// If nobody responded, we try the next closest peers.
+		responded += roundResponded
+		if roundResponded != 0 && !progress {
+			break
+		}
+	}
+
+	if responded == 0 {
+		return nil, fmt.Errorf("no peer responded")
+	}
+
+	return addrInfoValues(providers), nil
+}
+
+// addrInfoValues returns the values of the given map.
+func addrInfoValues(m map[peer.ID]peer.AddrInfo) []peer.AddrInfo {
+	infos := make([]peer.AddrInfo, 0, len(m))
+	for _, p := range m {
+		infos = append(infos, p)
+	}
+	return infos
+}
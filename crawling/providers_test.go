@@ -0,0 +1,51 @@
+package crawling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestFindProvidersForCID(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	p, _ := newTestPeer(t)
+	addrB := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	addrP := ma.StringCast("/ip4/1.2.3.6/tcp/4001")
+	mappedP := ma.StringCast("/ip6/::ffff:1.2.3.6/tcp/4001")
+	withIDP := addrP.Encapsulate(ma.StringCast("/p2p/" + p.String()))
+
+	// Both peers are queried in the first round, independent of their
+	// distance to the key.
+	cm, w := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {
+			Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{addrB, addrB}}},
+			Providers: []peer.AddrInfo{{ID: p, Addrs: []ma.Multiaddr{addrP, withIDP}}},
+		},
+		b: {Providers: []peer.AddrInfo{{ID: p, Addrs: []ma.Multiaddr{mappedP}}}},
+	}, a, b)
+
+	c, err := cid.Decode("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers, err := cm.FindProvidersForCID(context.Background(), c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(providers) != 1 || providers[0].ID != p {
+		t.Fatalf("expected provider %s, got %v", p, providers)
+	}
+	if addrs := providers[0].Addrs; len(addrs) != 1 || !addrs[0].Equal(addrP) {
+		t.Errorf("expected canonical address %s, got %v", addrP, addrs)
+	}
+	for id, n := range map[peer.ID]int{a: 1, b: 1, p: 0} {
+		if w.Requests(id) != n {
+			t.Errorf("expected %d requests to %s, got %d", n, id, w.Requests(id))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package crawling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/net/proxy"
+)
+
+// parseDialProxy parses the URL of a SOCKS5 proxy, see
+// WorkerConfig.DialProxy.
+// socks5h is rejected: the swarm resolves DNS addresses before handing them to
+// the transport, so names would never reach the proxy.
+func parseDialProxy(proxyURL string) (proxy.ContextDialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create proxy dialer: %w", err)
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("proxy dialer does not support contexts")
+	}
+
+	return cd, nil
+}
+
+// A proxyTransport is a TCP transport dialing all connections through a SOCKS5
+// proxy. It cannot listen.
+type proxyTransport struct {
+	upgrader transport.Upgrader
+	rcmgr    network.ResourceManager
+	dialer   proxy.ContextDialer
+}
+
+var _ transport.Transport = (*proxyTransport)(nil)
+
+// newProxyTransport returns a constructor for a proxyTransport using the
+// given dialer, to be passed to libp2p.Transport.
+func newProxyTransport(dialer proxy.ContextDialer) func(transport.Upgrader, network.ResourceManager) *proxyTransport {
+	return func(upgrader transport.Upgrader, rcmgr network.ResourceManager) *proxyTransport {
+		if rcmgr == nil {
+			rcmgr = &network.NullResourceManager{}
+		}
+		return &proxyTransport{
+			upgrader: upgrader,
+			rcmgr:    rcmgr,
+			dialer:   dialer,
+		}
+	}
+}
+
+// Dial implements transport.Transport.
+func (t *proxyTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	connScope, err := t.rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := t.dialWithScope(ctx, raddr, p, connScope)
+	if err != nil {
+		connScope.Done()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *proxyTransport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p peer.ID, connScope network.ConnManagementScope) (transport.CapableConn, error) {
+	err := connScope.SetPeer(p)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := manet.ToNetAddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := t.dialer.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial through proxy: %w", err)
+	}
+	laddr, err := manet.FromNetAddr(conn.LocalAddr())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// The connection's remote address is that of the proxy, but we report
+	// the address we dialed.
+	return t.upgrader.Upgrade(ctx, t, &proxiedConn{Conn: conn, laddr: laddr, raddr: raddr}, network.DirOutbound, p, connScope)
+}
+
+// CanDial implements transport.Transport.
+// Only IP addresses are supported, DNS addresses are resolved by the swarm
+// before dialing.
+func (t *proxyTransport) CanDial(addr ma.Multiaddr) bool {
+	ps := addr.Protocols()
+	return len(ps) == 2 && (ps[0].Code == ma.P_IP4 || ps[0].Code == ma.P_IP6) && ps[1].Code == ma.P_TCP
+}
+
+// Listen implements transport.Transport.
+// This always fails.
+func (t *proxyTransport) Listen(ma.Multiaddr) (transport.Listener, error) {
+	return nil, fmt.Errorf("cannot listen through a proxy")
+}
+
+// Protocols implements transport.Transport.
+func (t *proxyTransport) Protocols() []int {
+	return []int{ma.P_TCP}
+}
+
+// Proxy implements transport.Transport.
+// This is unrelated to SOCKS proxies, it denotes transports which dial through
+// other libp2p peers.
+func (t *proxyTransport) Proxy() bool {
+	return false
+}
+
+func (t *proxyTransport) String() string {
+	return "TCP via SOCKS5"
+}
+
+// A proxiedConn is a connection through a proxy, which reports the address
+// dialed through the proxy as its remote address.
+type proxiedConn struct {
+	net.Conn
+	laddr ma.Multiaddr
+	raddr ma.Multiaddr
+}
+
+var _ manet.Conn = (*proxiedConn)(nil)
+
+func (c *proxiedConn) LocalMultiaddr() ma.Multiaddr {
+	return c.laddr
+}
+
+func (c *proxiedConn) RemoteMultiaddr() ma.Multiaddr {
+	return c.raddr
+}
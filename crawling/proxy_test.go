@@ -0,0 +1,170 @@
+package crawling
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// A testSOCKS5Server is a minimal SOCKS5 server without authentication,
+// which only supports CONNECT, and records the addresses it connected to.
+type testSOCKS5Server struct {
+	net.Listener
+
+	m       sync.Mutex
+	targets []string
+}
+
+// newTestSOCKS5Server starts a new testSOCKS5Server on the loopback interface,
+// which is closed when the test ends.
+func newTestSOCKS5Server(t *testing.T) *testSOCKS5Server {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSOCKS5Server{Listener: l}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// connected returns the addresses the server connected to.
+func (s *testSOCKS5Server) connected() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]string(nil), s.targets...)
+}
+
+func (s *testSOCKS5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, number of methods, methods.
+	buf := make([]byte, 258)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// Request: version, command, reserved, address type, address, port.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:net.IPv4len]); err != nil {
+			return
+		}
+		host = net.IP(buf[:net.IPv4len]).String()
+	case 4:
+		if _, err := io.ReadFull(conn, buf[:net.IPv6len]); err != nil {
+			return
+		}
+		host = net.IP(buf[:net.IPv6len]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer remote.Close()
+	s.m.Lock()
+	s.targets = append(s.targets, target)
+	s.m.Unlock()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(remote, conn)
+		_ = remote.Close()
+	}()
+	_, _ = io.Copy(conn, remote)
+}
+
+func TestCrawlPeerThroughProxy(t *testing.T) {
+	proxy := newTestSOCKS5Server(t)
+	target := newTestDHTPeer(t, testNeighbors(t, 2))
+
+	workerConfig, crawlerConfig := testWorkerConfigs()
+	workerConfig.DialProxy = "socks5://" + proxy.Addr().String()
+	w := newTestWorker(t, workerConfig, crawlerConfig)
+
+	info, err := w.crawlPeer(context.Background(), target.addrInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.crawlData.err != nil {
+		t.Fatal(info.crawlData.err)
+	}
+
+	connected := proxy.connected()
+	if len(connected) == 0 {
+		t.Fatal("expected the dial to go through the proxy")
+	}
+	targetAddr, err := target.Addrs()[0].ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range connected {
+		if _, port, _ := net.SplitHostPort(addr); port != targetAddr {
+			t.Errorf("expected proxy to connect to port %s, got %s", targetAddr, addr)
+		}
+	}
+}
+
+func TestParseDialProxy(t *testing.T) {
+	for _, test := range []struct {
+		url   string
+		valid bool
+	}{
+		{"socks5://localhost:1080", true},
+		{"socks5h://localhost:1080", false},
+		{"http://localhost:8080", false},
+	} {
+		_, err := parseDialProxy(test.url)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%s: expected valid %t, got error %v", test.url, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package crawling
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// reachableSetMagic identifies files written by ExportReachableSet, including
+// the version of the format.
+const reachableSetMagic = "ipfs-crawler reachable set v1\n"
+
+// maxPeerIDLength is the maximum length of a binary peer ID we accept.
+// Peer IDs are multihashes, which are either identity hashes of small keys or
+// SHA-256 hashes, so this is plenty.
+const maxPeerIDLength = 128
+
+// A ReachableSet is a set of peers which were reachable during a crawl.
+type ReachableSet struct {
+	// Sorted.
+	ids []peer.ID
+}
+
+// ExportReachableSet writes the set of reachable peers to w in a compact
+// binary format, which can be read with LoadReachableSet.
+// The format consists of a header, the number of peers, and their sorted
+// binary IDs, each prefixed with its length. Numbers are unsigned varints.
+func (report *CrawlOutput) ExportReachableSet(w io.Writer) error {
+	var ids []peer.ID
+	for id, node := range report.nodes {
+		if node.err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString(reachableSetMagic)
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(ids)))
+	_, err = bw.Write(buf)
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	for _, id := range ids {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(id)))
+		buf = append(buf, id...)
+		_, err = bw.Write(buf)
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+
+	return nil
+}
+
+// LoadReachableSet reads a set of reachable peers written by
+// ExportReachableSet.
+func LoadReachableSet(r io.Reader) (*ReachableSet, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(reachableSetMagic))
+	_, err := io.ReadFull(br, magic)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+	if string(magic) != reachableSetMagic {
+		return nil, fmt.Errorf("invalid header")
+	}
+
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read number of peers: %w", err)
+	}
+
+	set := &ReachableSet{}
+	for i := uint64(0); i < n; i++ {
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read peer ID: %w", err)
+		}
+		if length > maxPeerIDLength {
+			return nil, fmt.Errorf("peer ID too long: %d bytes", length)
+		}
+		id := make([]byte, length)
+		_, err = io.ReadFull(br, id)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read peer ID: %w", err)
+		}
+		set.ids = append(set.ids, peer.ID(id))
+	}
+
+	if !sort.SliceIsSorted(set.ids, func(i, j int) bool { return set.ids[i] < set.ids[j] }) {
+		return nil, fmt.Errorf("peer IDs not sorted")
+	}
+
+	return set, nil
+}
+
+// Contains checks whether the peer is in the set.
+func (s *ReachableSet) Contains(id peer.ID) bool {
+	i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+	return i < len(s.ids) && s.ids[i] == id
+}
+
+// Len returns the number of peers in the set.
+func (s *ReachableSet) Len() int {
+	return len(s.ids)
+}
@@ -0,0 +1,68 @@
+package crawling
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestReachableSetRoundTrip(t *testing.T) {
+	nodes := make(map[peer.ID]diffTestNode)
+	var reachable, unreachable []peer.ID
+	for i := 0; i < 20; i++ {
+		id, _ := newTestPeer(t)
+		nodes[id] = diffTestNode{reachable: i%2 == 0}
+		if i%2 == 0 {
+			reachable = append(reachable, id)
+		} else {
+			unreachable = append(unreachable, id)
+		}
+	}
+	unknown, _ := newTestPeer(t)
+
+	var buf bytes.Buffer
+	err := diffTestOutput(nodes).ExportReachableSet(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadReachableSet(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.Len() != len(reachable) {
+		t.Errorf("expected %d peers, got %d", len(reachable), set.Len())
+	}
+	for _, id := range reachable {
+		if !set.Contains(id) {
+			t.Errorf("expected reachable peer %s in the set", id)
+		}
+	}
+	for _, id := range append(unreachable, unknown) {
+		if set.Contains(id) {
+			t.Errorf("expected peer %s not in the set", id)
+		}
+	}
+}
+
+func TestLoadReachableSetInvalid(t *testing.T) {
+	id, _ := newTestPeer(t)
+	var buf bytes.Buffer
+	err := diffTestOutput(map[peer.ID]diffTestNode{id: {reachable: true}}).ExportReachableSet(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for name, input := range map[string][]byte{
+		"empty":     nil,
+		"header":    []byte("not a reachable set\n"),
+		"truncated": data[:len(data)-1],
+	} {
+		_, err := LoadReachableSet(bytes.NewReader(input))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
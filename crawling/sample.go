@@ -0,0 +1,24 @@
+package crawling
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// inSample decides whether a peer is part of a sample of the given rate.
+// The decision is derived from the SHA-256 hash of the seed and the peer's
+// ID, so that it is the same every time we learn about the peer, and
+// reproducible across crawls with the same seed.
+func inSample(id peer.ID, rate float64, seed int64) bool {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(seed))
+	h := sha256.New()
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(id))
+	hash := h.Sum(nil)
+
+	return float64(binary.BigEndian.Uint64(hash[:8]))/math.MaxUint64 < rate
+}
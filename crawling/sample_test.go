@@ -0,0 +1,52 @@
+package crawling
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestCrawlNetworkSampleRate(t *testing.T) {
+	const n = 400
+	a, _ := newTestPeer(t)
+	responses := make(map[peer.ID]MockResponse, n+1)
+	var neighbors []peer.AddrInfo
+	for i := 0; i < n; i++ {
+		id, _ := newTestPeer(t)
+		addr := ma.StringCast(fmt.Sprintf("/ip4/1.2.%d.%d/tcp/4001", i/256, i%256))
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{addr}})
+		responses[id] = MockResponse{}
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+
+	for _, rate := range []float64{0, 0.25, 1} {
+		rate := rate
+		cm, w := newTestCrawlManager(t, CrawlManagerConfig{SampleRate: &rate, ConcurrentRequests: 8}, responses, a)
+		out, err := cm.CrawlNetwork(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Requests(a) != 1 {
+			t.Errorf("rate %v: expected bootstrap peer to be crawled", rate)
+		}
+		crawled := 0
+		for _, p := range neighbors {
+			crawled += w.Requests(p.ID)
+		}
+		expected := rate * n
+		if d := float64(crawled) - expected; d < -0.1*n || d > 0.1*n {
+			t.Errorf("rate %v: expected about %v peers to be crawled, got %d", rate, expected, crawled)
+		}
+		// All peers are still known.
+		if len(out.addrInfo) != n+1 {
+			t.Errorf("rate %v: expected %d known peers, got %d", rate, n+1, len(out.addrInfo))
+		}
+		if header := out.metadata(false); header.SampleRate == nil || *header.SampleRate != rate {
+			t.Errorf("rate %v: sample rate not recorded, got %v", rate, header.SampleRate)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package crawling
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// defaultBootstrapPeers are the public IPFS bootstrap nodes.
+var defaultBootstrapPeers = []string{
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+	"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+}
+
+// DefaultBootstrapPeers returns the public IPFS bootstrap nodes.
+func DefaultBootstrapPeers() []peer.AddrInfo {
+	peers, err := parseSeedPeers(defaultBootstrapPeers)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default bootstrap peers: %s", err))
+	}
+	return peers
+}
+
+// LoadSeedPeers reads peers from a file, which contains one multiaddress with
+// a /p2p/ component per line.
+// Addresses of the same peer are merged. Empty lines and lines starting with #
+// are ignored.
+func LoadSeedPeers(path string) ([]peer.AddrInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open seed file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read seed file: %w", err)
+	}
+
+	return parseSeedPeers(lines)
+}
+
+// parseSeedPeers parses multiaddresses with a /p2p/ component, merging
+// addresses of the same peer.
+// Peers are returned in the order they first appear.
+func parseSeedPeers(maddrs []string) ([]peer.AddrInfo, error) {
+	var peers []peer.AddrInfo
+	index := make(map[peer.ID]int)
+	for _, maddr := range maddrs {
+		pinfo, err := parsePeerString(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse peer address %q: %w", maddr, err)
+		}
+		if i, ok := index[pinfo.ID]; ok {
+			peers[i].Addrs = append(peers[i].Addrs, filterOutOldAddresses(peers[i].Addrs, pinfo.Addrs)...)
+			continue
+		}
+		index[pinfo.ID] = len(peers)
+		peers = append(peers, *pinfo)
+	}
+
+	return peers, nil
+}
+
+// SeedPeersFromOutput returns the peers which were reachable during a previous
+// crawl, with their addresses, to start a new crawl from, see
+// CrawlManager.AddPeersToCrawl.
+// Private and loopback addresses are removed, and peers without any other
+// address are skipped. Peers are sorted by ID.
+func SeedPeersFromOutput(out *CrawlOutput) []peer.AddrInfo {
+	var peers []peer.AddrInfo
+	for id, node := range out.nodes {
+		if node.err != nil {
+			continue
+		}
+		peers = append(peers, peer.AddrInfo{
+			ID:    id,
+			Addrs: out.addrInfo[id],
+		})
+	}
+
+	return seedPeers(peers)
+}
+
+// LoadSeedPeersFromOutput is like SeedPeersFromOutput, but reads the output of
+// a previous crawl from a file, see LoadUnreachablePeers.
+func LoadSeedPeersFromOutput(path string) ([]peer.AddrInfo, error) {
+	peers, connectable, err := loadPriorCrawl(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reachable []peer.AddrInfo
+	for i, p := range peers {
+		if connectable[i] {
+			reachable = append(reachable, p)
+		}
+	}
+
+	return seedPeers(reachable), nil
+}
+
+// seedPeers removes private and loopback addresses of the given peers, skips
+// peers without any other address, and sorts the rest by ID.
+func seedPeers(peers []peer.AddrInfo) []peer.AddrInfo {
+	var filter addrFilter
+	var seeds []peer.AddrInfo
+	for _, p := range peers {
+		addrs := filter.filter(p.Addrs)
+		if len(addrs) == 0 {
+			continue
+		}
+		seeds = append(seeds, peer.AddrInfo{
+			ID:    p.ID,
+			Addrs: addrs,
+		})
+	}
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i].ID < seeds[j].ID })
+
+	return seeds
+}
@@ -0,0 +1,97 @@
+package crawling
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestLoadSeedPeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bootstrappeers.txt")
+	err := os.WriteFile(path, []byte(`# comment
+
+/ip4/1.2.3.4/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+/ip4/1.2.3.5/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := LoadSeedPeers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %v", peers)
+	}
+	if peers[0].ID.String() != "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ" || len(peers[0].Addrs) != 2 {
+		t.Errorf("expected addresses of first peer to be merged, got %v", peers[0])
+	}
+}
+
+func TestSeedPeersFromOutput(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	c, _ := newTestPeer(t)
+	addrB := ma.StringCast("/ip4/1.2.3.5/tcp/4001")
+	addrC := ma.StringCast("/ip4/1.2.3.6/tcp/4001")
+
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{
+			{ID: b, Addrs: []ma.Multiaddr{addrB}},
+			{ID: c, Addrs: []ma.Multiaddr{addrC}},
+		}},
+		b: {},
+		c: {Err: errors.New("unreachable")},
+	}, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []peer.AddrInfo{
+		{ID: a, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}},
+		{ID: b, Addrs: []ma.Multiaddr{addrB}},
+	}
+	if a > b {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	seeds := SeedPeersFromOutput(&out)
+	if !reflect.DeepEqual(seeds, expected) {
+		t.Errorf("expected %v, got %v", expected, seeds)
+	}
+
+	path := filepath.Join(t.TempDir(), "visitedPeers.json")
+	err = out.WriteMetadata(path, OutputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds, err = LoadSeedPeersFromOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seeds, expected) {
+		t.Errorf("expected %v from file, got %v", expected, seeds)
+	}
+}
+
+func TestSeedPeersSkipsLocalAddrs(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	public := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	seeds := seedPeers([]peer.AddrInfo{
+		{ID: a, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/4001"), public}},
+		{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/10.0.0.1/tcp/4001")}},
+	})
+	if len(seeds) != 1 || seeds[0].ID != a || len(seeds[0].Addrs) != 1 || !seeds[0].Addrs[0].Equal(public) {
+		t.Errorf("expected only %s at %s, got %v", a, public, seeds)
+	}
+}
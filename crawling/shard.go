@@ -0,0 +1,36 @@
+package crawling
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ShardPeers returns the peers belonging to the shard with the given index,
+// out of the given number of shards.
+// Peers are assigned to shards by the SHA-256 hash of their ID, which makes
+// the assignment deterministic across machines. Shards are disjoint, and their
+// union is the set of all given peers.
+// This panics if shards is not positive or index is not in [0, shards).
+func ShardPeers(peers []peer.AddrInfo, shards, index int) []peer.AddrInfo {
+	if shards <= 0 || index < 0 || index >= shards {
+		panic(fmt.Sprintf("invalid shard %d of %d", index, shards))
+	}
+
+	var shard []peer.AddrInfo
+	for _, p := range peers {
+		if shardOf(p.ID, shards) == index {
+			shard = append(shard, p)
+		}
+	}
+
+	return shard
+}
+
+// shardOf computes the shard a peer belongs to.
+func shardOf(id peer.ID, shards int) int {
+	hash := kb.ConvertPeerID(id)
+	return int(binary.BigEndian.Uint64(hash[:8]) % uint64(shards))
+}
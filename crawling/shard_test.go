@@ -0,0 +1,56 @@
+package crawling
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestShardPeers(t *testing.T) {
+	var peers []peer.AddrInfo
+	for i := 0; i < 200; i++ {
+		id, _ := newTestPeer(t)
+		peers = append(peers, peer.AddrInfo{ID: id})
+	}
+
+	for _, shards := range []int{1, 2, 3, 7, 16} {
+		seen := make(map[peer.ID]int)
+		for index := 0; index < shards; index++ {
+			shard := ShardPeers(peers, shards, index)
+			for _, p := range shard {
+				if other, ok := seen[p.ID]; ok {
+					t.Errorf("%d shards: %s in shards %d and %d", shards, p.ID, other, index)
+				}
+				seen[p.ID] = index
+			}
+
+			// The split is deterministic.
+			again := ShardPeers(peers, shards, index)
+			if len(again) != len(shard) {
+				t.Fatalf("%d shards: expected %d peers in shard %d, got %d", shards, len(shard), index, len(again))
+			}
+			for i := range shard {
+				if again[i].ID != shard[i].ID {
+					t.Errorf("%d shards: shard %d differs between calls", shards, index)
+					break
+				}
+			}
+		}
+		if len(seen) != len(peers) {
+			t.Errorf("%d shards: expected %d peers in total, got %d", shards, len(peers), len(seen))
+		}
+	}
+}
+
+func TestShardPeersInvalid(t *testing.T) {
+	for _, test := range []struct{ shards, index int }{{0, 0}, {-1, 0}, {2, 2}, {2, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected shard %d of %d to panic", test.index, test.shards)
+				}
+			}()
+			ShardPeers(nil, test.shards, test.index)
+		}()
+	}
+}
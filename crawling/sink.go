@@ -0,0 +1,216 @@
+package crawling
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// An OutputSink persists the results of a crawl.
+type OutputSink interface {
+	// Write writes the results of a crawl.
+	Write(*CrawlOutput) error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// A FileSink writes the results of each crawl to files in a directory.
+// For a crawl started at <start_of_crawl_datetime>, it writes
+//   - visitedPeers_<start_of_crawl_datetime>.json, see WriteMetadata, or
+//     visitedPeers_<start_of_crawl_datetime>.jsonl, see WriteJSONLines, if
+//     configured,
+//   - peerGraph_<start_of_crawl_datetime>.csv, see WritePeergraph,
+//   - peerGraph_<start_of_crawl_datetime>.graphml, see WriteGraphML, if
+//     configured,
+//   - visitedPeers_<start_of_crawl_datetime>.cbor, see WriteCBOR, if
+//     configured,
+//   - visitedPeers_<start_of_crawl_datetime>.msgpack, see
+//     WriteMsgpackStream, if configured, and
+//   - crawl_<start_of_crawl_datetime>.sqlite, see WriteSQLite, if
+//     configured.
+type FileSink struct {
+	dir    string
+	config OutputConfig
+}
+
+var _ OutputSink = (*FileSink)(nil)
+
+// NewFileSink creates a new FileSink writing to the given directory, which
+// must exist.
+func NewFileSink(dir string, config OutputConfig) *FileSink {
+	return &FileSink{
+		dir:    dir,
+		config: config,
+	}
+}
+
+// Write implements OutputSink.
+func (s *FileSink) Write(report *CrawlOutput) error {
+	ts := report.startTs.UTC().Format("2006-01-02_15-04-05_UTC")
+
+	var err error
+	if s.config.JSONLines {
+		err = s.writeFile(path.Join(s.dir, fmt.Sprintf("visitedPeers_%s.jsonl", ts)), func(w io.Writer) error {
+			return report.writeJSONLines(w, s.config.ReachableOnly)
+		})
+	} else {
+		err = report.WriteMetadata(path.Join(s.dir, fmt.Sprintf("visitedPeers_%s.json", ts)), s.config)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = report.WritePeergraph(path.Join(s.dir, fmt.Sprintf("peerGraph_%s.csv", ts)), s.config)
+	if err != nil {
+		return err
+	}
+
+	if s.config.GraphML {
+		err = s.writeFile(path.Join(s.dir, fmt.Sprintf("peerGraph_%s.graphml", ts)), report.WriteGraphML)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.config.CBOR {
+		err = s.writeFile(path.Join(s.dir, fmt.Sprintf("visitedPeers_%s.cbor", ts)), func(w io.Writer) error {
+			return report.writeCBOR(w, s.config.ReachableOnly)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.config.Msgpack {
+		err = s.writeFile(path.Join(s.dir, fmt.Sprintf("visitedPeers_%s.msgpack", ts)), func(w io.Writer) error {
+			return report.writeMsgpackStream(w, s.config.ReachableOnly)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.config.SQLite {
+		err = WriteSQLite(report, path.Join(s.dir, fmt.Sprintf("crawl_%s.sqlite", ts)))
+		if err != nil {
+			return fmt.Errorf("unable to write SQLite database: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeFile creates an output file at the given path and writes to it using
+// the given function.
+func (s *FileSink) writeFile(path string, write func(io.Writer) error) error {
+	f, err := CreateOutputFile(path, s.config)
+	if err != nil {
+		return fmt.Errorf("unable to open output file: %w", err)
+	}
+
+	err = write(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Close implements OutputSink.
+// This is a no-op.
+func (s *FileSink) Close() error {
+	return nil
+}
+
+// A MultiSink writes the results of each crawl to multiple sinks.
+// Writes are attempted on all sinks, even if some of them fail.
+// Note that this does not roll back successful writes if other sinks fail.
+type MultiSink struct {
+	sinks      []OutputSink
+	requireAll bool
+}
+
+var _ OutputSink = (*MultiSink)(nil)
+
+// NewMultiSink creates a new MultiSink writing to the given sinks.
+// If requireAll is set, a write fails if any of the sinks fail. Otherwise, it
+// fails only if all sinks fail, and other failures are logged.
+func NewMultiSink(requireAll bool, sinks ...OutputSink) *MultiSink {
+	return &MultiSink{
+		sinks:      sinks,
+		requireAll: requireAll,
+	}
+}
+
+// MultiSinkError is returned by MultiSink.Write if writing failed.
+type MultiSinkError struct {
+	// The error returned by each sink, in the order they were given to
+	// NewMultiSink, or nil if writing to the sink succeeded.
+	Errors []error
+}
+
+func (e *MultiSinkError) Error() string {
+	var failed []string
+	for i, err := range e.Errors {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("sink %d: %s", i, err))
+		}
+	}
+	return fmt.Sprintf("unable to write to %d of %d output sinks: %s", len(failed), len(e.Errors), strings.Join(failed, "; "))
+}
+
+// Unwrap returns the errors of all failed sinks.
+func (e *MultiSinkError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Write implements OutputSink.
+// If writing fails, the error is a *MultiSinkError.
+func (s *MultiSink) Write(report *CrawlOutput) error {
+	errs := make([]error, len(s.sinks))
+	failed := 0
+	for i, sink := range s.sinks {
+		errs[i] = sink.Write(report)
+		if errs[i] != nil {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	if s.requireAll || failed == len(s.sinks) {
+		return &MultiSinkError{Errors: errs}
+	}
+	for i, err := range errs {
+		if err != nil {
+			log.WithError(err).WithField("sink", i).Warn("unable to write results to output sink")
+		}
+	}
+	return nil
+}
+
+// Close implements OutputSink.
+// This closes all sinks, even if some of them fail.
+func (s *MultiSink) Close() error {
+	var errs []error
+	for _, sink := range s.sinks {
+		err := sink.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
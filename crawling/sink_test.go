@@ -0,0 +1,84 @@
+package crawling
+
+import (
+	"errors"
+	"testing"
+)
+
+// A testSink records the reports written to it, or fails with err, if set.
+type testSink struct {
+	err     error
+	written []*CrawlOutput
+	closed  bool
+}
+
+func (s *testSink) Write(report *CrawlOutput) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.written = append(s.written, report)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestMultiSinkPartialFailure(t *testing.T) {
+	errUpload := errors.New("upload failed")
+	for _, requireAll := range []bool{true, false} {
+		ok, failing := &testSink{}, &testSink{err: errUpload}
+		s := NewMultiSink(requireAll, ok, failing)
+
+		report := &CrawlOutput{}
+		err := s.Write(report)
+		if len(ok.written) != 1 || ok.written[0] != report {
+			t.Errorf("requireAll=%t: succeeding sink did not write the report", requireAll)
+		}
+
+		if !requireAll {
+			if err != nil {
+				t.Errorf("requireAll=%t: expected no error, got %v", requireAll, err)
+			}
+			continue
+		}
+		var multiErr *MultiSinkError
+		if !errors.As(err, &multiErr) {
+			t.Fatalf("requireAll=%t: expected *MultiSinkError, got %v", requireAll, err)
+		}
+		if len(multiErr.Errors) != 2 || multiErr.Errors[0] != nil || multiErr.Errors[1] != errUpload {
+			t.Errorf("requireAll=%t: expected only second sink to fail, got %v", requireAll, multiErr.Errors)
+		}
+		if !errors.Is(err, errUpload) {
+			t.Errorf("requireAll=%t: error does not wrap the error of the failed sink", requireAll)
+		}
+	}
+}
+
+func TestMultiSinkAllFail(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	a, b := &testSink{err: errA}, &testSink{err: errB}
+	s := NewMultiSink(false, a, b)
+
+	err := s.Write(&CrawlOutput{})
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("expected errors of both sinks, got %v", err)
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.closed || !b.closed {
+		t.Error("not all sinks closed")
+	}
+}
+
+func TestPostgresSinkMissingCrawlID(t *testing.T) {
+	// The crawl ID is checked before the database is used.
+	err := (&PostgresSink{}).Write(&CrawlOutput{})
+	if err == nil {
+		t.Error("expected results without crawl ID to be rejected")
+	}
+}
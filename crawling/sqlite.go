@@ -0,0 +1,116 @@
+package crawling
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLiteDriverName is the name of the database/sql driver used by WriteSQLite.
+// We do not depend on a specific SQLite driver. Programs using WriteSQLite
+// need to register one under this name, for example by importing
+// github.com/mattn/go-sqlite3.
+const SQLiteDriverName = "sqlite3"
+
+// sqliteSchema creates the tables written by WriteSQLite.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS nodes (
+		id TEXT PRIMARY KEY,
+		reachable BOOLEAN NOT NULL,
+		agent_version TEXT,
+		timestamp TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS edges (
+		from_id TEXT NOT NULL,
+		to_id TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS addresses (
+		node_id TEXT NOT NULL,
+		maddr TEXT NOT NULL
+	)`,
+}
+
+// WriteSQLite writes the results of a crawl to the SQLite database at the
+// given path, which is created if it does not exist.
+// This creates the tables nodes(id, reachable, agent_version, timestamp),
+// edges(from_id, to_id), and addresses(node_id, maddr). Peer IDs are
+// base58-encoded, timestamps are RFC 3339.
+// Everything is written in a single transaction.
+// See SQLiteDriverName on how to provide a driver.
+func WriteSQLite(out *CrawlOutput, path string) error {
+	db, err := sql.Open(SQLiteDriverName, path)
+	if err != nil {
+		return fmt.Errorf("unable to open database: %w", err)
+	}
+
+	err = writeSQLite(out, db)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	return db.Close()
+}
+
+func writeSQLite(out *CrawlOutput, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, stmt := range sqliteSchema {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("unable to create tables: %w", err)
+		}
+	}
+
+	insertNode, err := tx.Prepare("INSERT INTO nodes (id, reachable, agent_version, timestamp) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	insertEdge, err := tx.Prepare("INSERT INTO edges (from_id, to_id) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	insertAddr, err := tx.Prepare("INSERT INTO addresses (node_id, maddr) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("unable to prepare statement: %w", err)
+	}
+
+	for id, node := range out.nodes {
+		var agentVersion *string
+		if node.err == nil {
+			agentVersion = &node.result.info.AgentVersion
+		}
+		_, err = insertNode.Exec(id.String(), node.err == nil, agentVersion, node.endTs.UTC().Format(time.RFC3339))
+		if err != nil {
+			return fmt.Errorf("unable to insert node: %w", err)
+		}
+
+		for _, addr := range out.addrInfo[id] {
+			_, err = insertAddr.Exec(id.String(), addr.String())
+			if err != nil {
+				return fmt.Errorf("unable to insert address: %w", err)
+			}
+		}
+
+		if node.err != nil || node.result.crawlDataError != nil {
+			continue
+		}
+		for _, neighbor := range node.result.crawlNeighbors {
+			_, err = insertEdge.Exec(id.String(), neighbor.String())
+			if err != nil {
+				return fmt.Errorf("unable to insert edge: %w", err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package crawling
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {
+			AgentVersion: "kubo/0.20.0",
+			Neighbors:    []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}}},
+		},
+		b: {Err: errors.New("unreachable")},
+	}, a)
+	out, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "crawl.sqlite")
+	err = WriteSQLite(&out, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open(SQLiteDriverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var reachable bool
+	var agentVersion string
+	err = db.QueryRow("SELECT reachable, agent_version FROM nodes WHERE id = ?", a.String()).Scan(&reachable, &agentVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reachable || agentVersion != "kubo/0.20.0" {
+		t.Errorf("got reachable %t, agent version %q for reachable node", reachable, agentVersion)
+	}
+
+	var unreachableAgent sql.NullString
+	err = db.QueryRow("SELECT reachable, agent_version FROM nodes WHERE id = ?", b.String()).Scan(&reachable, &unreachableAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reachable || unreachableAgent.Valid {
+		t.Errorf("got reachable %t, agent version %v for unreachable node", reachable, unreachableAgent)
+	}
+
+	var to string
+	err = db.QueryRow("SELECT to_id FROM edges WHERE from_id = ?", a.String()).Scan(&to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to != b.String() {
+		t.Errorf("got edge to %s, expected %s", to, b)
+	}
+
+	var maddr string
+	err = db.QueryRow("SELECT maddr FROM addresses WHERE node_id = ?", b.String()).Scan(&maddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maddr != "/ip4/1.2.3.5/tcp/4001" {
+		t.Errorf("got address %s", maddr)
+	}
+}
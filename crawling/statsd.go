@@ -0,0 +1,92 @@
+package crawling
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// StatsDConfig configures reporting of crawl statistics to StatsD.
+type StatsDConfig struct {
+	// The address of the StatsD server, as host:port.
+	Address string `yaml:"address"`
+
+	// A prefix for all metric names, e.g., "ipfs_crawler.".
+	Prefix string `yaml:"prefix"`
+}
+
+func (c StatsDConfig) check() error {
+	if len(c.Address) == 0 {
+		return fmt.Errorf("missing StatsD address")
+	}
+	return nil
+}
+
+// A StatsDReporter reports summary statistics about a crawl to a StatsD
+// server via UDP.
+// It can be used as an OutputSink, e.g., to report every crawl of a
+// Scheduler.
+type StatsDReporter struct {
+	conn   net.Conn
+	prefix string
+}
+
+var _ OutputSink = (*StatsDReporter)(nil)
+
+// NewStatsDReporter creates a new StatsDReporter.
+func NewStatsDReporter(config StatsDConfig) (*StatsDReporter, error) {
+	err := config.check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial StatsD server: %w", err)
+	}
+
+	return &StatsDReporter{
+		conn:   conn,
+		prefix: config.Prefix,
+	}, nil
+}
+
+// Report sends summary statistics about the given crawl, which took the given
+// duration.
+// The number of discovered, connectable, and crawlable nodes are sent as
+// gauges, the number of connection and crawl errors as counters, and the
+// duration as a timer.
+func (r *StatsDReporter) Report(report *CrawlOutput, duration time.Duration) error {
+	summary := summarize(report.nodes)
+
+	lines := []string{
+		fmt.Sprintf("%sdiscovered:%d|g", r.prefix, summary.numNodes),
+		fmt.Sprintf("%sconnectable:%d|g", r.prefix, summary.numConnectable),
+		fmt.Sprintf("%scrawlable:%d|g", r.prefix, summary.numCrawlable),
+		fmt.Sprintf("%sconnection_errors:%d|c", r.prefix, summary.numNodes-summary.numConnectable),
+		fmt.Sprintf("%scrawl_errors:%d|c", r.prefix, summary.numConnectable-summary.numCrawlable),
+		fmt.Sprintf("%sduration:%d|ms", r.prefix, duration.Milliseconds()),
+	}
+
+	// We send one packet per metric, which keeps us well below any MTU.
+	for _, line := range lines {
+		_, err := r.conn.Write([]byte(line))
+		if err != nil {
+			return fmt.Errorf("unable to send metric: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Write implements OutputSink.
+// This reports the crawl with the duration from its start to its end, see
+// Report.
+func (r *StatsDReporter) Write(report *CrawlOutput) error {
+	return r.Report(report, report.endTs.Sub(report.startTs))
+}
+
+// Close closes the underlying connection.
+func (r *StatsDReporter) Close() error {
+	return r.conn.Close()
+}
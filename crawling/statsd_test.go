@@ -0,0 +1,59 @@
+package crawling
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestStatsDReporterSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	a, _ := newTestPeer(t)
+	b, _ := newTestPeer(t)
+	cm, _ := newTestCrawlManager(t, CrawlManagerConfig{}, map[peer.ID]MockResponse{
+		a: {Neighbors: []peer.AddrInfo{{ID: b, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.5/tcp/4001")}}}},
+		b: {Err: errors.New("unreachable")},
+	}, a)
+	reporter, err := NewStatsDReporter(StatsDConfig{Address: conn.LocalAddr().String(), Prefix: "test."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.AddOutputSinks(reporter)
+
+	_, err = cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(map[string]bool)
+	buf := make([]byte, 1500)
+	for i := 0; i < 6; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received[string(buf[:n])] = true
+	}
+	for _, line := range []string{"test.discovered:2|g", "test.connectable:1|g", "test.connection_errors:1|c"} {
+		if !received[line] {
+			t.Errorf("expected %q, got %v", line, received)
+		}
+	}
+	for line := range received {
+		if strings.HasPrefix(line, "test.duration:") && !strings.HasSuffix(line, "|ms") {
+			t.Errorf("expected duration as a timer, got %q", line)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package crawling
+
+import "sort"
+
+// weightedRoundRobin assigns n work tokens to workers in proportion to their
+// weights, using smooth weighted round-robin, which interleaves the workers
+// as evenly as possible.
+// With equal weights, this is plain round-robin.
+func weightedRoundRobin(n uint, weights []uint) []int {
+	var total int
+	for _, w := range weights {
+		total += int(w)
+	}
+
+	current := make([]int, len(weights))
+	tokens := make([]int, n)
+	for i := range tokens {
+		best := 0
+		for j, w := range weights {
+			current[j] += int(w)
+			if current[j] > current[best] {
+				best = j
+			}
+		}
+		current[best] -= total
+		tokens[i] = best
+	}
+
+	return tokens
+}
+
+// returnToken returns a work token of the worker with the given ID to the
+// token bucket.
+// With configured weights, the token is kept in the worker's own channel, and
+// the token bucket only signals that a token is available, see takeToken.
+func (cm *CrawlManager) returnToken(id int) {
+	if cm.workerTokens != nil {
+		cm.workerTokens[id] <- struct{}{}
+	}
+	cm.tokenBucket <- id
+}
+
+// takeToken turns a value received from the token bucket into the work token
+// of a worker.
+// Without configured weights, this returns the given token. Otherwise, the
+// token of the idle worker with the highest weight is taken, regardless of the
+// worker which returned the token we received.
+// Every value received from the token bucket must be passed to this exactly
+// once, before the token is used or returned via returnToken.
+func (cm *CrawlManager) takeToken(id int) int {
+	if cm.workerTokens == nil {
+		return id
+	}
+
+	// Every value in the token bucket is preceded by a token in the channel of
+	// a worker, and each value received takes exactly one of those tokens, so
+	// one is always available.
+	for _, id := range cm.workersByWeight {
+		select {
+		case <-cm.workerTokens[id]:
+			return id
+		default:
+		}
+	}
+	panic("no work token available")
+}
+
+// workersByWeight returns the indices of the workers with the given weights,
+// ordered by descending weight.
+func workersByWeight(weights []uint) []int {
+	ids := make([]int, len(weights))
+	for i := range ids {
+		ids[i] = i
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return weights[ids[i]] > weights[ids[j]]
+	})
+	return ids
+}
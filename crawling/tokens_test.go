@@ -0,0 +1,116 @@
+package crawling
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestWeightedRoundRobin(t *testing.T) {
+	tokens := weightedRoundRobin(8, []uint{1, 3})
+	expected := []int{1, 0, 1, 1, 1, 0, 1, 1}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected %v, got %v", expected, tokens)
+	}
+}
+
+// newWeightedTestCrawlManager creates a CrawlManager with two MockWorkers with
+// the given capacities, which are used as their weights.
+func newWeightedTestCrawlManager(t *testing.T, responses map[peer.ID]MockResponse, latency time.Duration, bootstrap peer.ID) (*CrawlManager, *MockWorker, *MockWorker) {
+	t.Helper()
+
+	light, err := NewMockWorker(responses, latency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	light.SetCapacity(1)
+	heavy, err := NewMockWorker(responses, latency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heavy.SetCapacity(4)
+	cm, err := NewCrawlManagerWithMockWorkers(CrawlManagerConfig{
+		BootstrapPeers: []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + bootstrap.String()},
+	}, light, heavy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cm.Stop() })
+
+	return cm, light, heavy
+}
+
+// totalRequests returns the number of requests the worker made to any of the
+// given peers.
+func totalRequests(w *MockWorker, ids []peer.ID) int {
+	n := 0
+	for _, id := range ids {
+		n += w.Requests(id)
+	}
+	return n
+}
+
+func TestCrawlNetworkPrefersHeavierWorker(t *testing.T) {
+	// Peers are found one at a time, so there is always an idle token of the
+	// heavier worker.
+	ids := make([]peer.ID, 10)
+	for i := range ids {
+		ids[i], _ = newTestPeer(t)
+	}
+	responses := make(map[peer.ID]MockResponse, len(ids))
+	for i, id := range ids {
+		var res MockResponse
+		if i+1 < len(ids) {
+			addr := ma.StringCast(fmt.Sprintf("/ip4/1.2.3.%d/tcp/4001", i+1))
+			res.Neighbors = []peer.AddrInfo{{ID: ids[i+1], Addrs: []ma.Multiaddr{addr}}}
+		}
+		responses[id] = res
+	}
+	cm, light, heavy := newWeightedTestCrawlManager(t, responses, 0, ids[0])
+
+	_, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := totalRequests(light, ids); n != 0 {
+		t.Errorf("expected no requests to the lighter worker, got %d", n)
+	}
+	if n := totalRequests(heavy, ids); n != len(ids) {
+		t.Errorf("expected %d requests to the heavier worker, got %d", len(ids), n)
+	}
+}
+
+func TestCrawlNetworkWeightedDistribution(t *testing.T) {
+	// Under load, all tokens are busy, so the requests are distributed in
+	// proportion to the weights.
+	a, _ := newTestPeer(t)
+	responses := make(map[peer.ID]MockResponse)
+	ids := []peer.ID{a}
+	var neighbors []peer.AddrInfo
+	for i := 0; i < 100; i++ {
+		id, _ := newTestPeer(t)
+		addr := ma.StringCast(fmt.Sprintf("/ip4/1.2.4.%d/tcp/4001", i))
+		neighbors = append(neighbors, peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{addr}})
+		responses[id] = MockResponse{}
+		ids = append(ids, id)
+	}
+	responses[a] = MockResponse{Neighbors: neighbors}
+	cm, light, heavy := newWeightedTestCrawlManager(t, responses, 5*time.Millisecond, a)
+
+	_, err := cm.CrawlNetwork(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, h := totalRequests(light, ids), totalRequests(heavy, ids)
+	if l+h != len(ids) {
+		t.Fatalf("expected %d requests, got %d", len(ids), l+h)
+	}
+	if l == 0 || h < 2*l {
+		t.Errorf("expected about four times as many requests to the heavier worker, got %d and %d", h, l)
+	}
+}
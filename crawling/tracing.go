@@ -0,0 +1,50 @@
+package crawling
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer we record spans with, see
+// CrawlManagerConfig.Tracing.
+const tracerName = "ipfs-crawler/crawling"
+
+// setUpTracing passes the tracer to record spans with on to the worker and
+// crawler configs, if tracing is enabled.
+func (c *CrawlManagerConfig) setUpTracing() {
+	if !c.Tracing {
+		return
+	}
+
+	tp := c.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(tracerName)
+	c.WorkerConfig.tracer = tracer
+	c.CrawlerConfig.tracer = tracer
+}
+
+// startSpan starts a span with the given tracer.
+// If tracing is disabled, i.e., the tracer is nil, this returns the context
+// unchanged and a span which does not record anything, so that callers need
+// not distinguish the two cases.
+// Attributes should only be computed if the span is recording.
+func startSpan(ctx context.Context, tracer trace.Tracer, name string) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return tracer.Start(ctx, name)
+}
+
+// spanError marks the span as failed with the given error, if any.
+func spanError(span trace.Span, err error) {
+	if err == nil || !span.IsRecording() {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
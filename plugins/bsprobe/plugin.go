@@ -141,15 +141,15 @@ func (*bitswapProbe) Name() string {
 	return pluginName
 }
 
-func (w *bitswapProbe) HandlePeer(remote peer.AddrInfo) (interface{}, error) {
+func (w *bitswapProbe) HandlePeer(ctx context.Context, remote peer.AddrInfo) (interface{}, error) {
 	log.WithField("remote", remote).Debug("querying via Bitswap")
 
 	// TODO does this context apply to sending messages, too? Probably not...
-	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.RequestTimeout)
+	streamCtx, cancel := context.WithTimeout(ctx, w.cfg.RequestTimeout)
 	defer cancel()
 
 	// Open a new Bitswap stream to send the request on.
-	stream, err := w.h.NewStream(ctx, remote.ID, protocolStrings...)
+	stream, err := w.h.NewStream(streamCtx, remote.ID, protocolStrings...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open stream: %w", err)
 	}
@@ -166,7 +166,7 @@ func (w *bitswapProbe) HandlePeer(remote peer.AddrInfo) (interface{}, error) {
 
 	// TODO do we need to handle responses on the same stream?
 
-	responses := w.collectResponses(remote.ID, channel)
+	responses := w.collectResponses(ctx, remote.ID, channel)
 	if responses.Error != nil {
 		log.WithError(responses.Error).WithField("remote", remote).Warn("unable to receive responses")
 	}
@@ -193,7 +193,7 @@ func (w *bitswapProbe) Shutdown() error {
 	return nil
 }
 
-func (w *bitswapProbe) collectResponses(remote peer.ID, responses <-chan bitswapMessageResult) ProbeResult {
+func (w *bitswapProbe) collectResponses(ctx context.Context, remote peer.ID, responses <-chan bitswapMessageResult) ProbeResult {
 	outstanding := make(map[cid.Cid]struct{})
 	for _, c := range w.cfg.Cids {
 		outstanding[c] = struct{}{}
@@ -211,6 +211,9 @@ outer:
 		select {
 		case _ = <-timeout:
 			break outer
+		case <-ctx.Done():
+			err = ctx.Err()
+			break outer
 		case res, ok := <-responses:
 			if !ok {
 				// Channel closed because peer connection was closed.